@@ -4,19 +4,29 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
 	envconfig "github.com/incheat/go-production-backend/services/auth/internal/config/env"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation"
 	usergateway "github.com/incheat/go-production-backend/services/auth/internal/gateway/user/grpc"
 	authhandler "github.com/incheat/go-production-backend/services/auth/internal/handler/http"
+	oauthhandler "github.com/incheat/go-production-backend/services/auth/internal/handler/oauth"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
 	chimiddleware "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	"github.com/incheat/go-production-backend/services/auth/internal/passkey"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
 	redisrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/redis"
 	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
 	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
 	nethttpmiddleware "github.com/oapi-codegen/nethttp-middleware"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
@@ -62,8 +72,57 @@ func main() {
 
 	// Auth components
 	refreshTokenRepository := redisrepo.NewRefreshTokenRepository(redisClient)
+	accessTokenDenylist := redisrepo.NewAccessTokenDenylist(redisClient)
+	emailLoginPolicy, ipLoginPolicy := loginThrottlePolicies(cfg.Login)
+	loginLimiter := redisrepo.NewLoginLimiter(redisClient, emailLoginPolicy, ipLoginPolicy)
+	mfaRepository := redisrepo.NewMFARepository(redisClient)
+	passkeyRepository := redisrepo.NewPasskeyRepository(redisClient)
+	oauthRepository := redisrepo.NewOAuthRepository(redisClient)
+	clientRegistry := memoryrepo.NewClientRegistry(toOAuthClients(cfg.OAuth.Clients)...)
+	passwordResetRepository := redisrepo.NewPasswordResetRepository(redisClient)
+	emailVerificationRepository := redisrepo.NewEmailVerificationRepository(redisClient)
+	passwordlessRepository := redisrepo.NewPasswordlessRepository(redisClient)
+	federatedLoginRepository := redisrepo.NewFederatedLoginRepository(redisClient)
+	identityProviders := federation.NewRegistry(toIdentityProviders(cfg.Federation.Providers), nil)
+	mailTransport, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Error creating mailer: %v", err)
+	}
+	logger.Info("Sending emails", zap.String("transport", string(cfg.Mail.Transport)))
+	accountMailer := mailer.NewQueue(mailTransport, cfg.Mail.QueueSize, logger)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := accountMailer.Close(ctx); err != nil {
+			logger.Warn("Failed to send queued emails", zap.Error(err))
+		}
+	}()
+	loginNotifier := notifier.NewMailNotifier(accountMailer)
+
+	auditSink, err := newAuditSink(cfg.Audit, logger, redisClient)
+	if err != nil {
+		log.Fatalf("Error creating audit sink: %v", err)
+	}
+	logger.Info("Writing audit events", zap.String("sink", string(cfg.Audit.Sink)))
+	auditBuffer := audit.NewBuffer(auditSink, cfg.Audit.BufferSize, logger)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := auditBuffer.Close(ctx); err != nil {
+			logger.Warn("Failed to flush audit events", zap.Error(err))
+		}
+		if closer, ok := auditSink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Warn("Failed to close audit sink", zap.Error(err))
+			}
+		}
+	}()
 
-	jwtTokenMaker, err := token.New(cfg.JWT.PrivateKeyPEM, cfg.JWT.KeyID, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.Expire)
+	jwtKeyring, err := newJWTKeyring(cfg.JWT)
+	if err != nil {
+		log.Fatalf("Error loading JWT keyring: %v", err)
+	}
+	jwtTokenMaker, err := token.New(jwtKeyring, cfg.JWT.Issuer, cfg.JWT.Audience, cfg.JWT.Expire)
 	if err != nil {
 		log.Fatalf("Error creating JWT token maker: %v", err)
 	}
@@ -72,14 +131,69 @@ func main() {
 		cfg.Refresh.MaxAge,
 		cfg.Refresh.EndPoint,
 	)
+	refreshTokenHasher, err := token.NewRefreshTokenHasher(toPepper(cfg.Refresh.Pepper), toPeppers(cfg.Refresh.PreviousPeppers)...)
+	if err != nil {
+		log.Fatalf("Error creating refresh token hasher: %v", err)
+	}
+	totpMaker := token.NewTOTPMaker(cfg.MFA.Issuer)
+	secretCipher, err := token.NewSecretCipher(toEncryptionKey(cfg.MFA.EncryptionKey), toEncryptionKeys(cfg.MFA.PreviousEncryptionKeys)...)
+	if err != nil {
+		log.Fatalf("Error creating MFA secret cipher: %v", err)
+	}
+	relyingParty, err := passkey.NewRelyingParty(cfg.WebAuthn.RPID, cfg.WebAuthn.RPName, cfg.WebAuthn.Origins)
+	if err != nil {
+		log.Fatalf("Error creating WebAuthn relying party: %v", err)
+	}
+	linkSigner, err := token.NewLinkSigner(cfg.Links.SigningKey)
+	if err != nil {
+		log.Fatalf("Error creating link signer: %v", err)
+	}
 
 	logger.Info("Creating user gateway", zap.String("address", cfg.UserGateway.InternalAddress))
 	userGateway, err := usergateway.New(cfg.UserGateway.InternalAddress)
 	if err != nil {
 		log.Fatalf("Error creating user gateway: %v", err)
 	}
-	authService := authservice.New(jwtTokenMaker, opaqueTokenMaker, refreshTokenRepository, userGateway)
+	links := authservice.Links{PasswordReset: cfg.Links.PasswordReset, VerifyEmail: cfg.Links.VerifyEmail, PasswordlessLogin: cfg.Links.PasswordlessLogin}
+	authService := authservice.New(authservice.Deps{
+		AccessToken:       jwtTokenMaker,
+		RefreshToken:      opaqueTokenMaker,
+		RefreshHasher:     refreshTokenHasher,
+		RefreshTokenRepo:  refreshTokenRepository,
+		Denylist:          accessTokenDenylist,
+		LoginLimiter:      loginLimiter,
+		MFARepo:           mfaRepository,
+		TOTP:              totpMaker,
+		SecretCipher:      secretCipher,
+		PasskeyRepo:       passkeyRepository,
+		Passkeys:          relyingParty,
+		Clients:           clientRegistry,
+		OAuthRepo:         oauthRepository,
+		IDToken:           jwtTokenMaker,
+		UserGateway:       userGateway,
+		PasswordResets:    passwordResetRepository,
+		Mailer:            accountMailer,
+		Links:             links,
+		Verifications:     emailVerificationRepository,
+		LinkSigner:        linkSigner,
+		Passwordless:      passwordlessRepository,
+		Notifier:          loginNotifier,
+		FederatedLogins:   federatedLoginRepository,
+		IdentityProviders: identityProviders,
+		Audit:             auditBuffer,
+	})
 	authImpl := authhandler.New(authService)
+	dpopValidator := dpop.NewValidator(dpop.NewRedisReplayCache(redisClient))
+
+	jwksPath := cfg.JWT.JWKSPath
+	if jwksPath == "" {
+		jwksPath = "/.well-known/jwks.json"
+	}
+	oauthImpl := oauthhandler.New(authService, oauthhandler.Provider{
+		Issuer:            cfg.JWT.Issuer,
+		JWKSPath:          jwksPath,
+		SigningAlgorithms: jwtTokenMaker.SigningAlgorithms,
+	})
 
 	strict := servergen.NewStrictHandler(authImpl, nil)
 
@@ -106,22 +220,33 @@ func main() {
 		}
 	})
 
-	// ✅ JWKS endpoint (NOT behind OpenAPI validator)
-	jwksPath := cfg.JWT.JWKSPath
-	if jwksPath == "" {
-		jwksPath = "/.well-known/jwks.json"
-	}
+	// ✅ JWKS and OpenID provider metadata endpoints (NOT behind OpenAPI validator)
 	rootRouter.Get(jwksPath, jwtTokenMaker.JWKSHandler)
+	rootRouter.Get(oauthhandler.DiscoveryPath, oauthImpl.Discovery)
+
+	// OAuth 2.0 endpoints (NOT behind OpenAPI validator: HTML login form and form encoded token requests)
+	oauthRouter := chi.NewRouter()
+	oauthRouter.Use(chimiddleware.RequestMeta())
+	oauthRouter.Use(chimiddleware.BearerToken())
+	oauthRouter.Use(chimiddleware.DPoPProof(dpopValidator, cfg.Server.TrustForwardedHeaders))
+	oauthRouter.Use(chimiddleware.ZapLogger(logger))
+	oauthRouter.Use(chimiddleware.ZapRecovery(logger))
+	oauthRouter.Mount("/", oauthImpl.Routes())
+	rootRouter.Mount(oauthhandler.PathPrefix, oauthRouter)
 
 	// HTTP API router
 	apiRouter := chi.NewRouter()
+	// The validator verifies access tokens, which needs the DPoP proof of the request in the context.
+	apiRouter.Use(chimiddleware.RequestMeta())
+	apiRouter.Use(chimiddleware.BearerToken())
+	apiRouter.Use(chimiddleware.DPoPProof(dpopValidator, cfg.Server.TrustForwardedHeaders))
 	apiRouter.Use(nethttpmiddleware.OapiRequestValidatorWithOptions(
 		openAPISpec,
 		chimiddleware.NewValidatorOptions(chimiddleware.ValidatorConfig{
-			ProdMode: cfg.Env == envconfig.EnvProd,
+			ProdMode:           cfg.Env == envconfig.EnvProd,
+			AuthenticationFunc: chimiddleware.NewBearerAuthenticationFunc(authService),
 		}),
 	))
-	apiRouter.Use(chimiddleware.RequestMeta())
 	apiRouter.Use(chimiddleware.ZapLogger(logger))
 	apiRouter.Use(chimiddleware.ZapRecovery(logger))
 
@@ -131,6 +256,30 @@ func main() {
 
 	var g errgroup.Group
 
+	if cfg.JWT.Keys.Dir != "" {
+		g.Go(func() error {
+			activeKeyID := jwtKeyring.ActiveKeyID()
+			ticker := time.NewTicker(cfg.JWT.Keys.ReloadInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := jwtKeyring.Reload(); err != nil {
+					logger.Error("Failed to reload JWT keyring", zap.Error(err))
+				}
+				// The active key changes when a new key is done pre-publishing, not only on reloads.
+				if kid := jwtKeyring.ActiveKeyID(); kid != activeKeyID {
+					logger.Info("JWT signing key rotated", zap.String("from", activeKeyID), zap.String("to", kid))
+					_ = auditBuffer.WriteAuditEvent(ctx, model.AuditEvent{
+						Type:       model.AuditEventKeyRotated,
+						OccurredAt: time.Now().UTC(),
+						KeyID:      kid,
+					})
+					activeKeyID = kid
+				}
+			}
+			return nil
+		})
+	}
+
 	g.Go(func() error {
 		return http.ListenAndServe(fmt.Sprintf(":%d", int(cfg.Server.PublicPort)), rootRouter)
 	})
@@ -149,3 +298,104 @@ func initLogger(env envconfig.EnvName) *zap.Logger {
 		return zap.Must(zap.NewProduction())
 	}
 }
+
+// newJWTKeyring loads the JWT keyring from the key directory, or from the single configured key when none is set.
+func newJWTKeyring(cfg envconfig.JWT) (*token.Keyring, error) {
+	if cfg.Keys.Dir == "" {
+		return token.NewStaticKeyring(cfg.PrivateKeyPEM, cfg.KeyID)
+	}
+	return token.LoadKeyring(cfg.Keys.Dir, cfg.Keys.PrePublish, cfg.Keys.Retire)
+}
+
+// newMailer returns the mailer of the configured mail transport.
+func newMailer(cfg envconfig.Mail) (authservice.Mailer, error) {
+	switch cfg.Transport {
+	case envconfig.MailTransportSMTP:
+		return mailer.NewSMTPMailer(cfg.SMTP.Addr, cfg.SMTP.Username, cfg.SMTP.Password, cfg.From, cfg.SMTP.RequireTLS)
+	case envconfig.MailTransportFile:
+		return mailer.NewFileOutbox(cfg.OutboxPath)
+	default:
+		return mailer.NewOutbox(), nil
+	}
+}
+
+// newAuditSink returns the sink of the configured audit trail.
+func newAuditSink(cfg envconfig.Audit, logger *zap.Logger, redisClient *redis.Client) (audit.Sink, error) {
+	switch cfg.Sink {
+	case envconfig.AuditSinkFile:
+		return audit.NewFileSink(cfg.FilePath)
+	case envconfig.AuditSinkRedis:
+		return audit.NewRedisStreamSink(redisClient, int64(cfg.StreamMaxLen)), nil
+	default:
+		return audit.NewZapSink(logger), nil
+	}
+}
+
+// loginThrottlePolicies returns the throttling policies for failed logins per email and per IP.
+// Past lockouts are remembered for a day so that repeated attacks are locked out for longer.
+func loginThrottlePolicies(cfg envconfig.Login) (email, ip model.LoginThrottlePolicy) {
+	policy := model.LoginThrottlePolicy{
+		Window:        cfg.Window,
+		LockoutBase:   cfg.LockoutBase,
+		LockoutMax:    cfg.LockoutMax,
+		LockoutMemory: max(24*time.Hour, cfg.LockoutMax),
+	}
+	email, ip = policy, policy
+	email.MaxFailures = cfg.MaxEmailFailures
+	ip.MaxFailures = cfg.MaxIPFailures
+	return email, ip
+}
+
+func toPepper(p envconfig.Pepper) token.Pepper {
+	return token.Pepper{Version: p.Version, Secret: []byte(p.Secret)}
+}
+
+func toPeppers(ps []envconfig.Pepper) []token.Pepper {
+	peppers := make([]token.Pepper, 0, len(ps))
+	for _, p := range ps {
+		peppers = append(peppers, toPepper(p))
+	}
+	return peppers
+}
+
+func toEncryptionKey(k envconfig.EncryptionKey) token.EncryptionKey {
+	return token.EncryptionKey{Version: k.Version, Key: k.Key}
+}
+
+func toEncryptionKeys(ks []envconfig.EncryptionKey) []token.EncryptionKey {
+	keys := make([]token.EncryptionKey, 0, len(ks))
+	for _, k := range ks {
+		keys = append(keys, toEncryptionKey(k))
+	}
+	return keys
+}
+
+func toOAuthClients(cs []envconfig.OAuthClient) []model.OAuthClient {
+	clients := make([]model.OAuthClient, 0, len(cs))
+	for _, c := range cs {
+		clients = append(clients, model.OAuthClient{
+			ID:           c.ID,
+			Name:         c.Name,
+			SecretHash:   c.SecretHash,
+			RedirectURIs: c.RedirectURIs,
+			Scopes:       c.Scopes,
+			Audiences:    c.Audiences,
+		})
+	}
+	return clients
+}
+
+func toIdentityProviders(ps []envconfig.IdentityProvider) []federation.Provider {
+	providers := make([]federation.Provider, 0, len(ps))
+	for _, p := range ps {
+		providers = append(providers, federation.Provider{
+			ID:           p.ID,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+			RedirectURI:  p.RedirectURI,
+		})
+	}
+	return providers
+}
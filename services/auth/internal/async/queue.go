@@ -0,0 +1,108 @@
+// Package async handles work in the background of the requests that queue it, such as writing audit events and
+// sending emails.
+package async
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned when an item is dropped because the handler is not keeping up.
+	ErrQueueFull = errors.New("queue full")
+	// ErrQueueClosed is returned when an item is pushed after the queue was closed.
+	ErrQueueClosed = errors.New("queue closed")
+)
+
+// Handler handles one item of a Queue.
+type Handler[T any] func(ctx context.Context, item T) error
+
+// Options are the options of a Queue.
+type Options[T any] struct {
+	Size      int                         // items waiting for the handler before new ones are dropped
+	Timeout   time.Duration               // bounds how long the queue waits for the handler to handle one item
+	OnDropped func(item T, dropped int64) // called with the number of items dropped so far when an item is dropped
+	OnFailed  func(item T, err error)     // called when the handler fails to handle an item
+}
+
+// Queue queues items in memory and hands them to its handler in the background, in the order they were pushed.
+// Pushing never blocks: when the queue is full, the item is dropped.
+type Queue[T any] struct {
+	handle  Handler[T]
+	opts    Options[T]
+	items   chan T
+	done    chan struct{}
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewQueue creates a new Queue handing its items to handle. Close it to handle the items still queued.
+func NewQueue[T any](handle Handler[T], opts Options[T]) *Queue[T] {
+	q := &Queue[T]{
+		handle: handle,
+		opts:   opts,
+		items:  make(chan T, opts.Size),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Push queues an item for the handler.
+func (q *Queue[T]) Push(item T) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.items <- item:
+		return nil
+	default:
+		dropped := q.dropped.Add(1)
+		if q.opts.OnDropped != nil {
+			q.opts.OnDropped(item, dropped)
+		}
+		return ErrQueueFull
+	}
+}
+
+// Dropped returns how many items were dropped since the queue was created.
+func (q *Queue[T]) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Close stops accepting items and waits until the queued ones are handled, or ctx is done.
+func (q *Queue[T]) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run hands the queued items to the handler until the queue is closed.
+func (q *Queue[T]) run() {
+	defer close(q.done)
+	for item := range q.items {
+		ctx, cancel := context.WithTimeout(context.Background(), q.opts.Timeout)
+		if err := q.handle(ctx, item); err != nil && q.opts.OnFailed != nil {
+			q.opts.OnFailed(item, err)
+		}
+		cancel()
+	}
+}
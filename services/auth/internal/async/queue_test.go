@@ -0,0 +1,94 @@
+package async_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/async"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler is a handler that records its items, waiting for each until it is released.
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+	items   chan string
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{}), items: make(chan string, 10)}
+}
+
+func (h *blockingHandler) handle(_ context.Context, item string) error {
+	h.started <- struct{}{}
+	<-h.release
+	h.items <- item
+	return nil
+}
+
+// TestUnitQueue tests that queued items are handled in order, and that items are dropped rather than blocking when
+// the handler does not keep up.
+func TestUnitQueue(t *testing.T) {
+	h := newBlockingHandler()
+	var dropped []string
+	q := async.NewQueue(h.handle, async.Options[string]{
+		Size:      2,
+		Timeout:   time.Second,
+		OnDropped: func(item string, _ int64) { dropped = append(dropped, item) },
+	})
+
+	// The first item is being handled while the next two fill the queue.
+	require.NoError(t, q.Push("a"))
+	<-h.started
+	require.NoError(t, q.Push("b"))
+	require.NoError(t, q.Push("c"))
+	start := time.Now()
+	assert.ErrorIs(t, q.Push("d"), async.ErrQueueFull)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "pushing must not block on the handler")
+	assert.Equal(t, int64(1), q.Dropped())
+	assert.Equal(t, []string{"d"}, dropped)
+
+	close(h.release)
+	require.NoError(t, q.Close(context.Background()))
+	close(h.items)
+	var handled []string
+	for item := range h.items {
+		handled = append(handled, item)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, handled)
+	assert.ErrorIs(t, q.Push("e"), async.ErrQueueClosed)
+}
+
+// TestUnitQueue_HandlerFailure tests that the items the handler fails to handle are reported with their error.
+func TestUnitQueue_HandlerFailure(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	var failed []string
+	q := async.NewQueue(func(context.Context, string) error { return errHandler }, async.Options[string]{
+		Size:    1,
+		Timeout: time.Second,
+		OnFailed: func(item string, err error) {
+			assert.ErrorIs(t, err, errHandler)
+			failed = append(failed, item)
+		},
+	})
+	require.NoError(t, q.Push("a"))
+	require.NoError(t, q.Close(context.Background()))
+
+	assert.Equal(t, []string{"a"}, failed)
+}
+
+// TestUnitQueue_CloseTimeout tests that closing a queue gives up waiting for a stuck handler when its context is
+// done.
+func TestUnitQueue_CloseTimeout(t *testing.T) {
+	h := newBlockingHandler()
+	defer close(h.release)
+	q := async.NewQueue(h.handle, async.Options[string]{Size: 1, Timeout: time.Second})
+	require.NoError(t, q.Push("a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Close(ctx), context.DeadlineExceeded)
+}
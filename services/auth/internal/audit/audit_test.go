@@ -0,0 +1,116 @@
+package audit_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var testEvent = model.AuditEvent{
+	Type:        model.AuditEventLoginSucceeded,
+	OccurredAt:  time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+	MemberID:    "user@example.com",
+	SessionID:   "session-1",
+	FamilyID:    "family-1",
+	AuthMethods: []string{model.AuthMethodPassword},
+	IPAddress:   "1.2.3.4",
+	UserAgent:   "ua",
+	RequestID:   "request-1",
+}
+
+// blockingSink is a sink whose writes wait until it is released.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+	*audit.MemorySink
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{started: make(chan struct{}, 10), release: make(chan struct{}), MemorySink: audit.NewMemorySink()}
+}
+
+func (s *blockingSink) WriteAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	s.started <- struct{}{}
+	<-s.release
+	return s.MemorySink.WriteAuditEvent(ctx, event)
+}
+
+// TestUnitBuffer tests that buffered events are written in order, and that events are dropped rather than
+// blocking when the sink does not keep up.
+func TestUnitBuffer(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.ErrorLevel)
+	sink := newBlockingSink()
+	b := audit.NewBuffer(sink, 2, zap.New(core))
+
+	// The first event is being written while the next two fill the buffer.
+	require.NoError(t, b.WriteAuditEvent(ctx, model.AuditEvent{Type: model.AuditEventLoginFailed, MemberID: "a"}))
+	<-sink.started
+	require.NoError(t, b.WriteAuditEvent(ctx, testEvent))
+	require.NoError(t, b.WriteAuditEvent(ctx, model.AuditEvent{Type: model.AuditEventLoginFailed, MemberID: "c"}))
+	start := time.Now()
+	assert.ErrorIs(t, b.WriteAuditEvent(ctx, model.AuditEvent{Type: model.AuditEventLoginFailed, MemberID: "d"}), audit.ErrBufferFull)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "recording must not block on the sink")
+	assert.Equal(t, int64(1), b.Dropped())
+	assert.Equal(t, 1, logs.FilterMessageSnippet("Dropped audit event").Len())
+
+	close(sink.release)
+	require.NoError(t, b.Close(ctx))
+	var memberIDs []string
+	for _, event := range sink.Events() {
+		memberIDs = append(memberIDs, event.MemberID)
+	}
+	assert.Equal(t, []string{"a", testEvent.MemberID, "c"}, memberIDs)
+	assert.ErrorIs(t, b.WriteAuditEvent(ctx, testEvent), audit.ErrBufferClosed)
+}
+
+// TestUnitZapSink tests that events are logged under the audit logger with their fields.
+func TestUnitZapSink(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := audit.NewZapSink(zap.New(core))
+
+	require.NoError(t, s.WriteAuditEvent(context.Background(), testEvent))
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, "audit", entry.LoggerName)
+	assert.Equal(t, string(model.AuditEventLoginSucceeded), entry.Message)
+	fields := entry.ContextMap()
+	assert.Equal(t, "user@example.com", fields["member_id"])
+	assert.Equal(t, "session-1", fields["session_id"])
+	assert.Equal(t, "request-1", fields["request_id"])
+	assert.Equal(t, []any{model.AuthMethodPassword}, fields["auth_methods"])
+	assert.NotContains(t, fields, "reason", "empty fields are left out")
+}
+
+// TestUnitFileSink tests that events are appended to the file as JSON lines.
+func TestUnitFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o600))
+	s, err := audit.NewFileSink(path)
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteAuditEvent(context.Background(), testEvent))
+	require.NoError(t, s.WriteAuditEvent(context.Background(), model.AuditEvent{Type: model.AuditEventKeyRotated, KeyID: "key-2"}))
+	require.NoError(t, s.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3, "the file must only be appended to")
+
+	var event model.AuditEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	assert.Equal(t, testEvent, event)
+	assert.JSONEq(t, `{"type":"key_rotated","occurred_at":"0001-01-01T00:00:00Z","key_id":"key-2"}`, lines[2])
+}
@@ -0,0 +1,82 @@
+// Package audit defines the sinks the audit events of the auth service are written to, and the buffer that writes
+// them without blocking the requests that record them.
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/async"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"go.uber.org/zap"
+)
+
+// writeTimeout bounds how long the buffer waits for its sink to write one event.
+const writeTimeout = 5 * time.Second
+
+var (
+	// ErrBufferFull is returned when an event is dropped because the sink is not keeping up.
+	ErrBufferFull = errors.New("audit buffer full")
+	// ErrBufferClosed is returned when an event is recorded after the buffer was closed.
+	ErrBufferClosed = errors.New("audit buffer closed")
+)
+
+// Sink is the interface for the destination of audit events.
+type Sink interface {
+	WriteAuditEvent(ctx context.Context, event model.AuditEvent) error
+}
+
+// Buffer queues audit events in memory and writes them to its sink in the background, in the order they were
+// recorded. Recording never blocks: when the queue is full, the event is dropped and logged.
+type Buffer struct {
+	queue *async.Queue[model.AuditEvent]
+}
+
+// NewBuffer creates a new Buffer of size events writing to sink. Events that are dropped or cannot be written are
+// logged to logger. Close it to write the events still queued.
+func NewBuffer(sink Sink, size int, logger *zap.Logger) *Buffer {
+	return &Buffer{queue: async.NewQueue(sink.WriteAuditEvent, async.Options[model.AuditEvent]{
+		Size:    size,
+		Timeout: writeTimeout,
+		OnDropped: func(event model.AuditEvent, dropped int64) {
+			logger.Error("Dropped audit event, the audit sink is not keeping up",
+				zap.String("type", string(event.Type)),
+				zap.String("member_id", event.MemberID),
+				zap.String("request_id", event.RequestID),
+				zap.Int64("dropped", dropped),
+			)
+		},
+		OnFailed: func(event model.AuditEvent, err error) {
+			logger.Error("Failed to write audit event",
+				zap.String("type", string(event.Type)),
+				zap.String("member_id", event.MemberID),
+				zap.String("request_id", event.RequestID),
+				zap.Error(err),
+			)
+		},
+	})}
+}
+
+// WriteAuditEvent queues an event for the sink. The context of the request is not used, as the event is written
+// after the request is done.
+func (b *Buffer) WriteAuditEvent(_ context.Context, event model.AuditEvent) error {
+	err := b.queue.Push(event)
+	switch {
+	case errors.Is(err, async.ErrQueueFull):
+		return ErrBufferFull
+	case errors.Is(err, async.ErrQueueClosed):
+		return ErrBufferClosed
+	}
+	return err
+}
+
+// Dropped returns how many events were dropped since the buffer was created.
+func (b *Buffer) Dropped() int64 {
+	return b.queue.Dropped()
+}
+
+// Close stops accepting events and waits until the queued ones are written, or ctx is done.
+func (b *Buffer) Close(ctx context.Context) error {
+	return b.queue.Close(ctx)
+}
@@ -0,0 +1,50 @@
+// Package audit defines the sink appending audit events to a file as JSON lines.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// FileSink appends audit events to a file as JSON lines. The file is only ever appended to.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink creates a new FileSink appending to the file at path, which is created if needed.
+// Close it when done.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- operator-provided audit file
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &FileSink{file: f}, nil
+}
+
+// WriteAuditEvent appends an event to the file.
+func (s *FileSink) WriteAuditEvent(_ context.Context, event model.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,36 @@
+// Package audit defines the sink that keeps audit events in memory, for tests.
+package audit
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// MemorySink keeps audit events in memory.
+type MemorySink struct {
+	sync.Mutex
+	events []model.AuditEvent
+}
+
+// NewMemorySink creates a new empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// WriteAuditEvent keeps an event in memory.
+func (s *MemorySink) WriteAuditEvent(_ context.Context, event model.AuditEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns the events written so far, oldest first.
+func (s *MemorySink) Events() []model.AuditEvent {
+	s.Lock()
+	defer s.Unlock()
+	return slices.Clone(s.events)
+}
@@ -0,0 +1,48 @@
+// Package audit defines the sink appending audit events to a Redis Stream.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamSink appends audit events to a Redis Stream, for consumers such as a SIEM forwarder to read with
+// XREAD or a consumer group. Each entry has the event type and the event as JSON.
+type RedisStreamSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisStreamSink creates a new RedisStreamSink. The stream is trimmed to about maxLen entries, the oldest
+// first, or never when maxLen is 0.
+func NewRedisStreamSink(rdb *redis.Client, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{
+		rdb:    rdb,
+		stream: constant.RedisAuditStream,
+		maxLen: maxLen,
+	}
+}
+
+// WriteAuditEvent appends an event to the stream.
+func (s *RedisStreamSink) WriteAuditEvent(ctx context.Context, event model.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	err = s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]any{"type": string(event.Type), "event": data},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("redis XADD error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package audit defines the sink writing audit events to a zap logger.
+package audit
+
+import (
+	"context"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"go.uber.org/zap"
+)
+
+// ZapSink writes audit events as log entries, for the log pipeline to ship with the other logs.
+type ZapSink struct {
+	logger *zap.Logger
+}
+
+// NewZapSink creates a new ZapSink writing to the "audit" logger under logger.
+func NewZapSink(logger *zap.Logger) *ZapSink {
+	return &ZapSink{logger: logger.Named("audit")}
+}
+
+// WriteAuditEvent logs an event at info level, with its type as message.
+func (s *ZapSink) WriteAuditEvent(_ context.Context, event model.AuditEvent) error {
+	fields := []zap.Field{zap.Time("occurred_at", event.OccurredAt)}
+	for _, f := range []struct{ key, value string }{
+		{"member_id", event.MemberID},
+		{"session_id", event.SessionID},
+		{"family_id", event.FamilyID},
+		{"client_id", event.ClientID},
+		{"reason", event.Reason},
+		{"key_id", event.KeyID},
+		{"ip_address", event.IPAddress},
+		{"user_agent", event.UserAgent},
+		{"request_id", event.RequestID},
+	} {
+		if f.value != "" {
+			fields = append(fields, zap.String(f.key, f.value))
+		}
+	}
+	if len(event.AuthMethods) > 0 {
+		fields = append(fields, zap.Strings("auth_methods", event.AuthMethods))
+	}
+	s.logger.Info(string(event.Type), fields...)
+	return nil
+}
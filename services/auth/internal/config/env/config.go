@@ -22,12 +22,23 @@ type Config struct {
 	Redis       Redis
 	JWT         JWT
 	Refresh     Refresh
+	Login       Login
+	MFA         MFA
+	WebAuthn    WebAuthn
+	OAuth       OAuth
+	Federation  Federation
+	Mail        Mail
+	Links       Links
+	Audit       Audit
 	UserGateway UserGateway
 }
 
 // Server is the configuration for the server.
 type Server struct {
 	PublicPort Port
+	// TrustForwardedHeaders takes the scheme and host DPoP proofs are checked against from the X-Forwarded-Proto
+	// and X-Forwarded-Host headers, for a server behind a proxy that terminates TLS and overwrites them.
+	TrustForwardedHeaders bool
 }
 
 // UserGateway is the configuration for the user gateway.
@@ -53,11 +64,145 @@ type JWT struct {
 	Audience      string
 	Expire        time.Duration
 	JWKSPath      string
+	Keys          JWTKeys
+}
+
+// JWTKeys is the configuration for the JWT signing keyring.
+// When Dir is empty, the single PrivateKeyPEM/KeyID pair is used instead.
+type JWTKeys struct {
+	Dir            string
+	PrePublish     time.Duration
+	Retire         time.Duration
+	ReloadInterval time.Duration
 }
 
 // Refresh is the configuration for the refresh.
 type Refresh struct {
-	NumBytes int
-	EndPoint string
-	MaxAge   int
+	NumBytes        int
+	EndPoint        string
+	MaxAge          int
+	Pepper          Pepper
+	PreviousPeppers []Pepper
+}
+
+// Pepper is a versioned secret used to hash refresh tokens.
+type Pepper struct {
+	Version string
+	Secret  string
+}
+
+// Login is the configuration for the throttling of failed logins.
+type Login struct {
+	Window           time.Duration
+	MaxEmailFailures int
+	MaxIPFailures    int
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+}
+
+// MFA is the configuration for multi-factor authentication.
+type MFA struct {
+	Issuer                 string // shown in authenticator apps
+	EncryptionKey          EncryptionKey
+	PreviousEncryptionKeys []EncryptionKey
+}
+
+// WebAuthn is the configuration of the WebAuthn relying party for passkeys.
+type WebAuthn struct {
+	RPID    string   // registrable domain passkeys are scoped to
+	RPName  string   // shown by authenticators
+	Origins []string // origins ceremonies may be run from
+}
+
+// OAuth is the configuration of the OAuth 2.0 authorization server.
+type OAuth struct {
+	Clients []OAuthClient
+}
+
+// OAuthClient is a client application registered with the authorization server.
+type OAuthClient struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	SecretHash   string   `json:"secret_hash"` // hex SHA-256 of the secret of a confidential client
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Audiences    []string `json:"audiences"`
+}
+
+// Federation is the configuration of the upstream OpenID Connect identity providers members can log in with.
+type Federation struct {
+	Providers []IdentityProvider
+}
+
+// IdentityProvider is an upstream OpenID Connect identity provider the auth service is registered with as a client.
+type IdentityProvider struct {
+	ID           string   `json:"id"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`       // openid is always asked for
+	RedirectURI  string   `json:"redirect_uri"` // page of the web app completing the login
+}
+
+// MailTransport is how emails to members are delivered.
+type MailTransport string
+
+const (
+	// MailTransportSMTP sends emails through an SMTP relay.
+	MailTransportSMTP MailTransport = "smtp"
+	// MailTransportFile appends emails to a local file instead of sending them.
+	MailTransportFile MailTransport = "file"
+	// MailTransportMemory keeps emails in memory instead of sending them.
+	MailTransportMemory MailTransport = "memory"
+)
+
+// Mail is the configuration for the emails sent to members.
+type Mail struct {
+	Transport  MailTransport
+	From       string // sender address, e.g. "Example <no-reply@example.com>"
+	SMTP       SMTP
+	OutboxPath string // file the file transport appends emails to, as JSON lines
+	QueueSize  int    // emails waiting for the transport before new ones are dropped
+}
+
+// SMTP is the configuration of the SMTP relay of the smtp mail transport.
+type SMTP struct {
+	Addr       string // host:port
+	Username   string // empty for relays without authentication
+	Password   string
+	RequireTLS bool // refuse to send through a relay that does not offer STARTTLS
+}
+
+// AuditSink is where the audit events of the auth service are written.
+type AuditSink string
+
+const (
+	// AuditSinkZap writes audit events to the service logs.
+	AuditSinkZap AuditSink = "zap"
+	// AuditSinkFile appends audit events to a local file as JSON lines.
+	AuditSinkFile AuditSink = "file"
+	// AuditSinkRedis appends audit events to a Redis Stream.
+	AuditSinkRedis AuditSink = "redis"
+)
+
+// Audit is the configuration of the audit trail.
+type Audit struct {
+	Sink         AuditSink
+	FilePath     string // file the file sink appends events to, as JSON lines
+	StreamMaxLen int    // about how many events the redis sink keeps in the stream, 0 to keep them all
+	BufferSize   int    // events waiting for the sink before new ones are dropped
+}
+
+// Links is the configuration of the pages of the web app that emails to members link to.
+type Links struct {
+	PasswordReset     string
+	VerifyEmail       string
+	PasswordlessLogin string
+	SigningKey        []byte // HMAC key the tokens of the links are signed with
+}
+
+// EncryptionKey is a versioned AES-256 key used to encrypt secrets at rest.
+type EncryptionKey struct {
+	Version string
+	Key     []byte
 }
@@ -1,8 +1,13 @@
 package envconfig
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -21,6 +26,11 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	authTrustForwardedHeaders, err := getBoolDefault("AUTH_TRUST_FORWARDED_HEADERS", false)
+	if err != nil {
+		return nil, err
+	}
+
 	authRedisHost := getString("AUTH_REDIS_HOST")
 	authRedisPassword := getString("AUTH_REDIS_PASSWORD")
 	authRedisDB, err := getIntRequired("AUTH_REDIS_DB")
@@ -39,6 +49,20 @@ func Load() (*Config, error) {
 	}
 	authJWTExpire := time.Duration(authJWTExpireRaw) * time.Minute
 
+	authJWTKeysDir := getString("AUTH_JWT_KEYS_DIR")
+	authJWTKeysPrePublishRaw, err := getIntDefault("AUTH_JWT_KEYS_PREPUBLISH", 10)
+	if err != nil {
+		return nil, err
+	}
+	authJWTKeysRetireRaw, err := getIntDefault("AUTH_JWT_KEYS_RETIRE", authJWTExpireRaw+10)
+	if err != nil {
+		return nil, err
+	}
+	authJWTKeysReloadIntervalRaw, err := getIntDefault("AUTH_JWT_KEYS_RELOAD_INTERVAL", 60)
+	if err != nil {
+		return nil, err
+	}
+
 	authRefreshNumBytes, err := getIntRequired("AUTH_REFRESH_NUM_BYTES")
 	if err != nil {
 		return nil, err
@@ -49,12 +73,115 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	authRefreshPepper := getString("AUTH_REFRESH_PEPPER")
+	authRefreshPepperVersion := getString("AUTH_REFRESH_PEPPER_VERSION")
+	authRefreshPreviousPeppers, err := getPeppers("AUTH_REFRESH_PREVIOUS_PEPPERS")
+	if err != nil {
+		return nil, err
+	}
+
+	authLoginWindowRaw, err := getIntDefault("AUTH_LOGIN_FAILURE_WINDOW", 15)
+	if err != nil {
+		return nil, err
+	}
+	authLoginMaxEmailFailures, err := getIntDefault("AUTH_LOGIN_MAX_EMAIL_FAILURES", 5)
+	if err != nil {
+		return nil, err
+	}
+	authLoginMaxIPFailures, err := getIntDefault("AUTH_LOGIN_MAX_IP_FAILURES", 50)
+	if err != nil {
+		return nil, err
+	}
+	authLoginLockoutBaseRaw, err := getIntDefault("AUTH_LOGIN_LOCKOUT_BASE", 60)
+	if err != nil {
+		return nil, err
+	}
+	authLoginLockoutMaxRaw, err := getIntDefault("AUTH_LOGIN_LOCKOUT_MAX", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	authMFAIssuer := getString("AUTH_MFA_ISSUER")
+	if authMFAIssuer == "" {
+		authMFAIssuer = authJWTIssuer
+	}
+	authMFAEncryptionKey, err := getBase64("AUTH_MFA_ENCRYPTION_KEY")
+	if err != nil {
+		return nil, err
+	}
+	authMFAEncryptionKeyVersion := getString("AUTH_MFA_ENCRYPTION_KEY_VERSION")
+	authMFAPreviousEncryptionKeys, err := getEncryptionKeys("AUTH_MFA_PREVIOUS_ENCRYPTION_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	authWebAuthnRPID := getString("AUTH_WEBAUTHN_RP_ID")
+	authWebAuthnRPName := getString("AUTH_WEBAUTHN_RP_NAME")
+	if authWebAuthnRPName == "" {
+		authWebAuthnRPName = authWebAuthnRPID
+	}
+	authWebAuthnOrigins := getList("AUTH_WEBAUTHN_RP_ORIGINS")
+
+	authOAuthClients, err := getOAuthClients("AUTH_OAUTH_CLIENTS")
+	if err != nil {
+		return nil, err
+	}
+
+	authOIDCProviders, err := getIdentityProviders("AUTH_OIDC_PROVIDERS")
+	if err != nil {
+		return nil, err
+	}
+
+	authMailTransport := getString("AUTH_MAIL_TRANSPORT")
+	if authMailTransport == "" {
+		authMailTransport = string(MailTransportFile)
+	}
+	authMailOutboxPath := getString("AUTH_MAIL_OUTBOX_PATH")
+	if authMailOutboxPath == "" {
+		authMailOutboxPath = "mail-outbox.jsonl"
+	}
+	// Only local development may send emails in clear text, e.g. to a relay that catches them.
+	authSMTPRequireTLS, err := getBoolDefault("AUTH_SMTP_REQUIRE_TLS", EnvName(env) != EnvDev)
+	if err != nil {
+		return nil, err
+	}
+	authMailQueueSize, err := getIntDefault("AUTH_MAIL_QUEUE_SIZE", 1024)
+	if err != nil {
+		return nil, err
+	}
+
+	authPasswordResetURL := getString("AUTH_PASSWORD_RESET_URL")
+	authVerifyEmailURL := getString("AUTH_VERIFY_EMAIL_URL")
+	authLoginLinkURL := getString("AUTH_LOGIN_LINK_URL")
+	authLinkSigningKey, err := getBase64("AUTH_LINK_SIGNING_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	authAuditSink := getString("AUTH_AUDIT_SINK")
+	if authAuditSink == "" {
+		authAuditSink = string(AuditSinkZap)
+	}
+	authAuditFilePath := getString("AUTH_AUDIT_FILE_PATH")
+	if authAuditFilePath == "" {
+		authAuditFilePath = "audit.jsonl"
+	}
+	authAuditStreamMaxLen, err := getIntDefault("AUTH_AUDIT_STREAM_MAX_LEN", 1000000)
+	if err != nil {
+		return nil, err
+	}
+	authAuditBufferSize, err := getIntDefault("AUTH_AUDIT_BUFFER_SIZE", 4096)
+	if err != nil {
+		return nil, err
+	}
+
 	authUserGatewayInternalAddress := getString("USER_GRPC_ADDR")
 
 	cfg := &Config{
 		Env: EnvName(env),
 		Server: Server{
-			PublicPort: Port(authPublicPort),
+			PublicPort:            Port(authPublicPort),
+			TrustForwardedHeaders: authTrustForwardedHeaders,
 		},
 		UserGateway: UserGateway{
 			InternalAddress: authUserGatewayInternalAddress,
@@ -71,11 +198,72 @@ func Load() (*Config, error) {
 			Audience:      authJWTAudience,
 			Expire:        authJWTExpire,
 			JWKSPath:      authJWKSPath,
+			Keys: JWTKeys{
+				Dir:            authJWTKeysDir,
+				PrePublish:     time.Duration(authJWTKeysPrePublishRaw) * time.Minute,
+				Retire:         time.Duration(authJWTKeysRetireRaw) * time.Minute,
+				ReloadInterval: time.Duration(authJWTKeysReloadIntervalRaw) * time.Second,
+			},
 		},
 		Refresh: Refresh{
 			NumBytes: authRefreshNumBytes,
 			EndPoint: authRefreshEndPoint,
 			MaxAge:   authRefreshMaxAge,
+			Pepper: Pepper{
+				Version: authRefreshPepperVersion,
+				Secret:  authRefreshPepper,
+			},
+			PreviousPeppers: authRefreshPreviousPeppers,
+		},
+		Login: Login{
+			Window:           time.Duration(authLoginWindowRaw) * time.Minute,
+			MaxEmailFailures: authLoginMaxEmailFailures,
+			MaxIPFailures:    authLoginMaxIPFailures,
+			LockoutBase:      time.Duration(authLoginLockoutBaseRaw) * time.Second,
+			LockoutMax:       time.Duration(authLoginLockoutMaxRaw) * time.Minute,
+		},
+		MFA: MFA{
+			Issuer: authMFAIssuer,
+			EncryptionKey: EncryptionKey{
+				Version: authMFAEncryptionKeyVersion,
+				Key:     authMFAEncryptionKey,
+			},
+			PreviousEncryptionKeys: authMFAPreviousEncryptionKeys,
+		},
+		WebAuthn: WebAuthn{
+			RPID:    authWebAuthnRPID,
+			RPName:  authWebAuthnRPName,
+			Origins: authWebAuthnOrigins,
+		},
+		OAuth: OAuth{
+			Clients: authOAuthClients,
+		},
+		Federation: Federation{
+			Providers: authOIDCProviders,
+		},
+		Mail: Mail{
+			Transport: MailTransport(authMailTransport),
+			From:      getString("AUTH_MAIL_FROM"),
+			SMTP: SMTP{
+				Addr:       getString("AUTH_SMTP_ADDR"),
+				Username:   getString("AUTH_SMTP_USERNAME"),
+				Password:   getString("AUTH_SMTP_PASSWORD"),
+				RequireTLS: authSMTPRequireTLS,
+			},
+			OutboxPath: authMailOutboxPath,
+			QueueSize:  authMailQueueSize,
+		},
+		Links: Links{
+			PasswordReset:     authPasswordResetURL,
+			VerifyEmail:       authVerifyEmailURL,
+			PasswordlessLogin: authLoginLinkURL,
+			SigningKey:        authLinkSigningKey,
+		},
+		Audit: Audit{
+			Sink:         AuditSink(authAuditSink),
+			FilePath:     authAuditFilePath,
+			StreamMaxLen: authAuditStreamMaxLen,
+			BufferSize:   authAuditBufferSize,
 		},
 	}
 
@@ -103,16 +291,136 @@ func getIntRequired(name string) (int, error) {
 	return v, nil
 }
 
+func getIntDefault(name string, def int) (int, error) {
+	raw := getString(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+func getBoolDefault(name string, def bool) (bool, error) {
+	raw := getString(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// getList parses a comma separated list, skipping empty entries.
+func getList(name string) []string {
+	var values []string
+	for _, v := range strings.Split(getString(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getPeppers parses a comma separated list of "version=secret" pairs.
+func getPeppers(name string) ([]Pepper, error) {
+	raw := getString(name)
+	if raw == "" {
+		return nil, nil
+	}
+	var peppers []Pepper
+	for _, pair := range strings.Split(raw, ",") {
+		version, secret, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || version == "" || secret == "" {
+			return nil, fmt.Errorf("%s: expected comma separated version=secret pairs", name)
+		}
+		peppers = append(peppers, Pepper{Version: version, Secret: secret})
+	}
+	return peppers, nil
+}
+
+// getBase64 decodes a standard base64 value; a missing value decodes to nil.
+func getBase64(name string) ([]byte, error) {
+	raw := getString(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// getEncryptionKeys parses a comma separated list of "version=base64 key" pairs.
+func getEncryptionKeys(name string) ([]EncryptionKey, error) {
+	pairs, err := getPeppers(name)
+	if err != nil {
+		return nil, err
+	}
+	var keys []EncryptionKey
+	for _, pair := range pairs {
+		key, err := base64.StdEncoding.DecodeString(pair.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %s: %w", name, pair.Version, err)
+		}
+		keys = append(keys, EncryptionKey{Version: pair.Version, Key: key})
+	}
+	return keys, nil
+}
+
+// getOAuthClients parses a JSON array of OAuth clients; a missing value registers none.
+func getOAuthClients(name string) ([]OAuthClient, error) {
+	raw := getString(name)
+	if raw == "" {
+		return nil, nil
+	}
+	var clients []OAuthClient
+	if err := json.Unmarshal([]byte(raw), &clients); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return clients, nil
+}
+
+// getIdentityProviders parses a JSON array of identity providers; a missing value configures none.
+func getIdentityProviders(name string) ([]IdentityProvider, error) {
+	raw := getString(name)
+	if raw == "" {
+		return nil, nil
+	}
+	var providers []IdentityProvider
+	if err := json.Unmarshal([]byte(raw), &providers); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return providers, nil
+}
+
 func validate(cfg *Config) error {
 	if cfg.Server.PublicPort <= 0 || cfg.Server.PublicPort > 65535 {
 		return fmt.Errorf("AUTH_HTTP_PORT: must be between 1 and 65535")
 	}
 
-	if cfg.JWT.PrivateKeyPEM == "" {
-		return fmt.Errorf("AUTH_JWT_PRIVATE_KEY_PEM is empty")
-	}
-	if cfg.JWT.KeyID == "" {
-		return fmt.Errorf("AUTH_JWT_KEY_ID is empty")
+	if cfg.JWT.Keys.Dir == "" {
+		if cfg.JWT.PrivateKeyPEM == "" {
+			return fmt.Errorf("AUTH_JWT_PRIVATE_KEY_PEM is empty")
+		}
+		if cfg.JWT.KeyID == "" {
+			return fmt.Errorf("AUTH_JWT_KEY_ID is empty")
+		}
+	} else {
+		if cfg.JWT.Keys.PrePublish < 0 {
+			return fmt.Errorf("AUTH_JWT_KEYS_PREPUBLISH: must not be negative")
+		}
+		if cfg.JWT.Keys.Retire < cfg.JWT.Expire {
+			return fmt.Errorf("AUTH_JWT_KEYS_RETIRE: must be at least AUTH_JWT_EXPIRE")
+		}
+		if cfg.JWT.Keys.ReloadInterval <= 0 {
+			return fmt.Errorf("AUTH_JWT_KEYS_RELOAD_INTERVAL: must be positive")
+		}
 	}
 	if cfg.JWT.Issuer == "" {
 		return fmt.Errorf("AUTH_JWT_ISSUER is empty")
@@ -120,5 +428,157 @@ func validate(cfg *Config) error {
 	if cfg.JWT.Audience == "" {
 		return fmt.Errorf("AUTH_JWT_AUDIENCE is empty")
 	}
+	if cfg.Refresh.Pepper.Secret == "" {
+		return fmt.Errorf("AUTH_REFRESH_PEPPER is empty")
+	}
+	if cfg.Refresh.Pepper.Version == "" {
+		return fmt.Errorf("AUTH_REFRESH_PEPPER_VERSION is empty")
+	}
+	if cfg.Login.Window <= 0 {
+		return fmt.Errorf("AUTH_LOGIN_FAILURE_WINDOW: must be positive")
+	}
+	if cfg.Login.MaxEmailFailures <= 0 {
+		return fmt.Errorf("AUTH_LOGIN_MAX_EMAIL_FAILURES: must be positive")
+	}
+	if cfg.Login.MaxIPFailures <= 0 {
+		return fmt.Errorf("AUTH_LOGIN_MAX_IP_FAILURES: must be positive")
+	}
+	if cfg.Login.LockoutBase <= 0 {
+		return fmt.Errorf("AUTH_LOGIN_LOCKOUT_BASE: must be positive")
+	}
+	if cfg.Login.LockoutMax < cfg.Login.LockoutBase {
+		return fmt.Errorf("AUTH_LOGIN_LOCKOUT_MAX: must be at least AUTH_LOGIN_LOCKOUT_BASE")
+	}
+	if len(cfg.MFA.EncryptionKey.Key) != 32 {
+		return fmt.Errorf("AUTH_MFA_ENCRYPTION_KEY: must be 32 base64 encoded bytes")
+	}
+	if cfg.MFA.EncryptionKey.Version == "" {
+		return fmt.Errorf("AUTH_MFA_ENCRYPTION_KEY_VERSION is empty")
+	}
+	if cfg.WebAuthn.RPID == "" {
+		return fmt.Errorf("AUTH_WEBAUTHN_RP_ID is empty")
+	}
+	if len(cfg.WebAuthn.Origins) == 0 {
+		return fmt.Errorf("AUTH_WEBAUTHN_RP_ORIGINS is empty")
+	}
+	if err := validateMail(cfg.Env, cfg.Mail); err != nil {
+		return err
+	}
+	if u, err := url.Parse(cfg.Links.PasswordReset); err != nil || !u.IsAbs() {
+		return fmt.Errorf("AUTH_PASSWORD_RESET_URL: must be an absolute URL")
+	}
+	if u, err := url.Parse(cfg.Links.VerifyEmail); err != nil || !u.IsAbs() {
+		return fmt.Errorf("AUTH_VERIFY_EMAIL_URL: must be an absolute URL")
+	}
+	if u, err := url.Parse(cfg.Links.PasswordlessLogin); err != nil || !u.IsAbs() {
+		return fmt.Errorf("AUTH_LOGIN_LINK_URL: must be an absolute URL")
+	}
+	if len(cfg.Links.SigningKey) < 32 {
+		return fmt.Errorf("AUTH_LINK_SIGNING_KEY: must be at least 32 base64 encoded bytes")
+	}
+	if err := validateIdentityProviders(cfg.Federation.Providers); err != nil {
+		return err
+	}
+	if err := validateAudit(cfg.Audit); err != nil {
+		return err
+	}
+	return validateOAuthClients(cfg.OAuth.Clients)
+}
+
+// validateMail checks that the mail transport is known and configured and that emails can be queued. Production
+// must send real emails, over TLS.
+func validateMail(env EnvName, cfg Mail) error {
+	switch cfg.Transport {
+	case MailTransportSMTP:
+		if cfg.SMTP.Addr == "" {
+			return fmt.Errorf("AUTH_SMTP_ADDR is empty")
+		}
+		if cfg.From == "" {
+			return fmt.Errorf("AUTH_MAIL_FROM is empty")
+		}
+		if env == EnvProd && !cfg.SMTP.RequireTLS {
+			return fmt.Errorf("AUTH_SMTP_REQUIRE_TLS: must be true in prod")
+		}
+	case MailTransportFile, MailTransportMemory:
+		if env == EnvProd {
+			return fmt.Errorf("AUTH_MAIL_TRANSPORT: must be smtp in prod")
+		}
+	default:
+		return fmt.Errorf("AUTH_MAIL_TRANSPORT: must be smtp, file or memory")
+	}
+	if cfg.QueueSize <= 0 {
+		return fmt.Errorf("AUTH_MAIL_QUEUE_SIZE: must be positive")
+	}
+	return nil
+}
+
+// validateAudit checks that the audit sink is known and that events can be buffered.
+func validateAudit(cfg Audit) error {
+	switch cfg.Sink {
+	case AuditSinkZap, AuditSinkFile, AuditSinkRedis:
+	default:
+		return fmt.Errorf("AUTH_AUDIT_SINK: must be zap, file or redis")
+	}
+	if cfg.StreamMaxLen < 0 {
+		return fmt.Errorf("AUTH_AUDIT_STREAM_MAX_LEN: must not be negative")
+	}
+	if cfg.BufferSize <= 0 {
+		return fmt.Errorf("AUTH_AUDIT_BUFFER_SIZE: must be positive")
+	}
+	return nil
+}
+
+// validateOAuthClients checks that clients have unique IDs and absolute redirect URIs without fragment (RFC 6749
+// section 3.1.2). Client IDs cannot contain "@", so that the subject of a client token never matches a member.
+// Public clients need a redirect URI; confidential clients may only get tokens for themselves.
+func validateOAuthClients(clients []OAuthClient) error {
+	seen := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		if client.ID == "" || strings.Contains(client.ID, "@") {
+			return fmt.Errorf("AUTH_OAUTH_CLIENTS: client id %q must be non-empty without @", client.ID)
+		}
+		if seen[client.ID] {
+			return fmt.Errorf("AUTH_OAUTH_CLIENTS: duplicate client %s", client.ID)
+		}
+		seen[client.ID] = true
+		if client.SecretHash != "" {
+			if b, err := hex.DecodeString(client.SecretHash); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("AUTH_OAUTH_CLIENTS: client %s: secret_hash must be a hex encoded SHA-256", client.ID)
+			}
+		} else if len(client.RedirectURIs) == 0 {
+			return fmt.Errorf("AUTH_OAUTH_CLIENTS: public client %s has no redirect_uris", client.ID)
+		}
+		for _, redirectURI := range client.RedirectURIs {
+			u, err := url.Parse(redirectURI)
+			if err != nil || !u.IsAbs() || u.Fragment != "" {
+				return fmt.Errorf("AUTH_OAUTH_CLIENTS: client %s: redirect URI %q must be absolute without fragment", client.ID, redirectURI)
+			}
+		}
+	}
+	return nil
+}
+
+// validateIdentityProviders checks that identity providers have unique IDs, an absolute issuer, a client ID and an
+// absolute redirect URI without fragment.
+func validateIdentityProviders(providers []IdentityProvider) error {
+	seen := make(map[string]bool, len(providers))
+	for _, provider := range providers {
+		if provider.ID == "" {
+			return fmt.Errorf("AUTH_OIDC_PROVIDERS: provider id is empty")
+		}
+		if seen[provider.ID] {
+			return fmt.Errorf("AUTH_OIDC_PROVIDERS: duplicate provider %s", provider.ID)
+		}
+		seen[provider.ID] = true
+		if u, err := url.Parse(provider.Issuer); err != nil || !u.IsAbs() {
+			return fmt.Errorf("AUTH_OIDC_PROVIDERS: provider %s: issuer must be an absolute URL", provider.ID)
+		}
+		if provider.ClientID == "" {
+			return fmt.Errorf("AUTH_OIDC_PROVIDERS: provider %s: client_id is empty", provider.ID)
+		}
+		if u, err := url.Parse(provider.RedirectURI); err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("AUTH_OIDC_PROVIDERS: provider %s: redirect_uri must be absolute without fragment", provider.ID)
+		}
+	}
 	return nil
 }
@@ -1,9 +1,74 @@
 // Package constant defines the constants for the auth service.
 package constant
 
+import (
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/verifier"
+)
+
 const (
 	// APIResponseVersionV1 is the version of the API response.
 	APIResponseVersionV1 = "v1"
 	// RedisRefreshTokenPrefix is the prefix for the refresh token in Redis.
 	RedisRefreshTokenPrefix = "refresh_token:"
+	// RedisRefreshTokenFamilyPrefix is the prefix for the refresh token family index in Redis.
+	RedisRefreshTokenFamilyPrefix = "refresh_token_family:"
+	// RedisRefreshTokenMemberPrefix is the prefix for the per-member refresh token index in Redis.
+	RedisRefreshTokenMemberPrefix = "refresh_token_member:"
+	// RedisAccessTokenDenylistPrefix is the prefix for denied access token IDs (jti) in Redis.
+	RedisAccessTokenDenylistPrefix = verifier.RedisAccessTokenDenylistPrefix
+	// RedisAccessTokenMemberPrefix is the prefix for the per-member access token index in Redis.
+	RedisAccessTokenMemberPrefix = "access_token_member:"
+	// RedisLoginFailuresPrefix is the prefix for the sliding window of failed logins in Redis.
+	RedisLoginFailuresPrefix = "login_failures:"
+	// RedisLoginLockoutPrefix is the prefix for active login lockouts in Redis.
+	RedisLoginLockoutPrefix = "login_lockout:"
+	// RedisLoginLockoutCountPrefix is the prefix for the number of recent login lockouts in Redis.
+	RedisLoginLockoutCountPrefix = "login_lockouts:"
+	// RedisTOTPCredentialPrefix is the prefix for the TOTP credential of a member in Redis.
+	RedisTOTPCredentialPrefix = "mfa_totp:"
+	// RedisTOTPUsedStepPrefix is the prefix for the TOTP time steps a member already used in Redis.
+	RedisTOTPUsedStepPrefix = "mfa_totp_used:"
+	// RedisMFAChallengePrefix is the prefix for pending MFA challenges in Redis.
+	RedisMFAChallengePrefix = "mfa_challenge:"
+	// RedisMFAChallengeFailuresPrefix is the prefix for the failed attempts of an MFA challenge in Redis.
+	RedisMFAChallengeFailuresPrefix = "mfa_challenge_failures:"
+	// RedisPasskeyCredentialPrefix is the prefix for passkey credentials by credential ID in Redis.
+	RedisPasskeyCredentialPrefix = "passkey:"
+	// RedisMemberPasskeysPrefix is the prefix for the set of passkey credential IDs of a member in Redis.
+	RedisMemberPasskeysPrefix = "passkey_member:"
+	// RedisWebAuthnSessionPrefix is the prefix for pending WebAuthn ceremonies in Redis.
+	RedisWebAuthnSessionPrefix = "webauthn_session:"
+	// RedisAuthorizationCodePrefix is the prefix for OAuth authorization codes waiting to be exchanged in Redis.
+	RedisAuthorizationCodePrefix = "oauth_code:"
+	// RedisDeviceAuthorizationPrefix is the prefix for device authorizations by device code hash in Redis.
+	RedisDeviceAuthorizationPrefix = "oauth_device:"
+	// RedisDeviceUserCodePrefix is the prefix for the device code hash of a user code in Redis.
+	RedisDeviceUserCodePrefix = "oauth_device_user_code:"
+	// RedisDevicePollPrefix is the prefix for the last poll of a device code in Redis.
+	RedisDevicePollPrefix = "oauth_device_poll:"
+	// RedisPasswordResetPrefix is the prefix for pending password resets by token hash in Redis.
+	RedisPasswordResetPrefix = "password_reset:"
+	// RedisPasswordResetEmailSentPrefix is the prefix for the last password reset asked for an email in Redis.
+	RedisPasswordResetEmailSentPrefix = "password_reset_email_sent:"
+	// RedisEmailVerificationPrefix is the prefix for pending email verifications by token hash in Redis.
+	RedisEmailVerificationPrefix = "email_verification:"
+	// RedisEmailVerificationSentPrefix is the prefix for the last verification email sent to an email in Redis.
+	RedisEmailVerificationSentPrefix = "email_verification_sent:"
+	// RedisPasswordlessChallengePrefix is the prefix for pending passwordless logins by challenge ID hash in Redis.
+	RedisPasswordlessChallengePrefix = "passwordless_challenge:"
+	// RedisPasswordlessChallengeFailuresPrefix is the prefix for the failed attempts of a passwordless login in Redis.
+	RedisPasswordlessChallengeFailuresPrefix = "passwordless_challenge_failures:"
+	// RedisPasswordlessEmailSentPrefix is the prefix for the last passwordless login email sent to an email in Redis.
+	RedisPasswordlessEmailSentPrefix = "passwordless_email_sent:"
+	// RedisFederatedLoginPrefix is the prefix for pending federated logins by state hash in Redis.
+	RedisFederatedLoginPrefix = "federated_login:"
+	// RedisDPoPProofPrefix is the prefix for the DPoP proofs already presented, by key thumbprint and jti hash, in Redis.
+	RedisDPoPProofPrefix = dpop.RedisProofPrefix
+	// RedisAuditStream is the Redis Stream audit events are appended to.
+	RedisAuditStream = "audit_events"
+	// RefreshTokenCookieName is the name of the cookie carrying the refresh token.
+	RefreshTokenCookieName = "refresh_token"
+	// FederatedLoginCookieName is the name of the cookie binding a federated login to the browser that started it.
+	FederatedLoginCookieName = "federated_login"
 )
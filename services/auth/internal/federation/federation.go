@@ -0,0 +1,321 @@
+// Package federation defines the client of the upstream OpenID Connect identity providers members can log in with.
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// discoveryTTL is how long the metadata of an identity provider is used before it is fetched again.
+	discoveryTTL = time.Hour
+	// clockSkew is the leeway applied to the exp and iat claims of ID tokens.
+	clockSkew = 30 * time.Second
+	// maxResponseBytes bounds the size of the responses of identity providers.
+	maxResponseBytes = 1 << 20
+)
+
+var (
+	// ErrUnknownProvider is returned when no identity provider is configured with an ID.
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrInvalidGrant is returned when the identity provider rejects an authorization code, e.g. because it was
+	// already used, has expired or was issued for another code challenge.
+	ErrInvalidGrant = errors.New("authorization code rejected by identity provider")
+	// ErrInvalidIDToken is returned when the ID token of an identity provider fails validation.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	// ErrUnavailable is returned when an identity provider cannot be reached or answers with a server error.
+	ErrUnavailable = errors.New("identity provider unavailable")
+)
+
+// validMethods are the algorithms ID tokens are accepted with. OpenID Connect requires providers to support RS256.
+var validMethods = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+}
+
+// Provider is the configuration of an upstream OpenID Connect identity provider.
+type Provider struct {
+	ID           string   // identifies the provider in the API, e.g. "corp"
+	Issuer       string   // issuer identifier, which serves /.well-known/openid-configuration
+	ClientID     string   // client ID the auth service is registered with at the provider
+	ClientSecret string   // client secret, sent with HTTP basic authentication
+	Scopes       []string // scopes asked for; openid is always added
+	RedirectURI  string   // page of the web app the provider redirects members back to
+}
+
+// Registry runs the authorization code flow, with PKCE, against the configured identity providers.
+// The metadata and keys of each provider are discovered on first use and cached.
+type Registry struct {
+	client    *http.Client
+	providers map[string]*provider
+}
+
+// NewRegistry creates a new Registry of the identity providers. A nil client uses a client with a 5 second timeout.
+func NewRegistry(providers []Provider, client *http.Client) *Registry {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	r := &Registry{client: client, providers: make(map[string]*provider, len(providers))}
+	for _, p := range providers {
+		r.providers[p.ID] = &provider{config: p, keys: newKeySet(client)}
+	}
+	return r
+}
+
+// AuthorizationURL returns the URL of the identity provider to send the member to, asking for an authorization
+// code for the state, the nonce of the ID token and the S256 PKCE code challenge.
+func (r *Registry) AuthorizationURL(ctx context.Context, providerID, state, nonce, codeChallenge string) (string, error) {
+	p, ok := r.providers[providerID]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	m, err := p.discover(ctx, r.client)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURI)
+	q.Set("scope", strings.Join(p.scopes(), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code of the identity provider with its PKCE code verifier, validates the ID
+// token it returns against the keys of the provider and the nonce, and returns the identity it asserts.
+func (r *Registry) Exchange(ctx context.Context, providerID, code, codeVerifier, nonce string) (*model.ExternalIdentity, error) {
+	p, ok := r.providers[providerID]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	m, err := p.discover(ctx, r.client)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := p.redeem(ctx, r.client, m.TokenEndpoint, code, codeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.validateIDToken(ctx, m.JWKSURI, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ExternalIdentity{
+		Provider:      providerID,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+// metadata is the part of the OpenID Provider Metadata the flow needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// provider is a configured identity provider with its cached metadata and keys.
+type provider struct {
+	config Provider
+	keys   *keySet
+
+	mu           sync.Mutex
+	metadata     *metadata
+	discoveredAt time.Time
+}
+
+// scopes returns the configured scopes with openid.
+func (p *provider) scopes() []string {
+	if slices.Contains(p.config.Scopes, "openid") {
+		return p.config.Scopes
+	}
+	return append([]string{"openid"}, p.config.Scopes...)
+}
+
+// discover returns the metadata of the provider, fetching it when it is not cached or stale.
+// A stale copy is kept serving while the provider cannot be reached.
+func (p *provider) discover(ctx context.Context, client *http.Client) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil && time.Since(p.discoveredAt) < discoveryTTL {
+		return p.metadata, nil
+	}
+
+	var m metadata
+	err := getJSON(ctx, client, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		if p.metadata != nil {
+			return p.metadata, nil
+		}
+		return nil, fmt.Errorf("discover %s: %w", p.config.ID, err)
+	}
+	// The metadata must be of the configured issuer, so that ID tokens of another issuer are not accepted
+	// (OpenID Connect Discovery section 4.3).
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discover %s: issuer %q does not match %q", p.config.ID, m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("discover %s: metadata lacks an endpoint", p.config.ID)
+	}
+	p.metadata = &m
+	p.discoveredAt = time.Now()
+	return p.metadata, nil
+}
+
+// tokenResponse is the response of the token endpoint, successful or not.
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// redeem exchanges an authorization code at the token endpoint and returns the ID token.
+func (p *provider) redeem(ctx context.Context, client *http.Client, tokenEndpoint, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// RFC 6749 section 2.3.1: the client credentials are form-encoded before basic authentication.
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: token request: %w", ErrUnavailable, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("%w: token request: status %d", ErrUnavailable, resp.StatusCode)
+	}
+
+	var body tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode token response: %w", err)
+	}
+	switch {
+	case body.Error == "invalid_grant":
+		return "", fmt.Errorf("%w: %s", ErrInvalidGrant, body.ErrorDescription)
+	case resp.StatusCode != http.StatusOK:
+		// Other errors, such as invalid_client, are errors of the configuration rather than of the login.
+		return "", fmt.Errorf("token request: status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	case body.IDToken == "":
+		return "", fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+	return body.IDToken, nil
+}
+
+// idTokenClaims are the claims of an ID token the flow needs.
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string       `json:"nonce"`
+	AuthorizedParty string       `json:"azp,omitempty"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+}
+
+// validateIDToken validates the signature of an ID token against the keys of the provider and its claims
+// (OpenID Connect Core section 3.1.3.7).
+func (p *provider) validateIDToken(ctx context.Context, jwksURI, rawIDToken, nonce string) (*idTokenClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	claims := &idTokenClaims{}
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.keys.get(ctx, jwksURI, kid, t.Method.Alg())
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnavailable) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub is empty", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: azp %q is not the client", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+	return claims, nil
+}
+
+// flexibleBool is a boolean claim that some providers send as the string "true" or "false".
+type flexibleBool bool
+
+// UnmarshalJSON decodes a JSON boolean or a string of one.
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// getJSON fetches and decodes a JSON document, treating network and server errors as ErrUnavailable.
+func getJSON(ctx context.Context, client *http.Client, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: GET %s: status %d", ErrUnavailable, u, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v); err != nil {
+		return fmt.Errorf("decode %s: %w", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package federation_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation/federationtest"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRedirectURI  = "https://app.example.com/login/federated"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testNonce        = "n-0S6_WzA2Mj"
+)
+
+var testIdentity = federationtest.Identity{Subject: "248289761001", Email: "user@example.com", EmailVerified: true}
+
+// newIdentityProvider starts an identity provider and a registry knowing it as "corp".
+func newIdentityProvider(t *testing.T) (*federationtest.IdentityProvider, *federation.Registry) {
+	t.Helper()
+	idp, err := federationtest.NewIdentityProvider(testRedirectURI)
+	require.NoError(t, err)
+	t.Cleanup(idp.Close)
+	return idp, federation.NewRegistry([]federation.Provider{idp.Provider("corp")}, nil)
+}
+
+// authorize logs the test identity in at the identity provider and returns the authorization code.
+func authorize(t *testing.T, idp *federationtest.IdentityProvider, registry *federation.Registry) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(testCodeVerifier))
+	authorizationURL, err := registry.AuthorizationURL(context.Background(), "corp", "state", testNonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	redirect, err := idp.Authorize(authorizationURL, testIdentity)
+	require.NoError(t, err)
+	assert.Equal(t, "state", redirect.Query().Get("state"))
+	return redirect.Query().Get("code")
+}
+
+// TestUnitAuthorizationURL tests that members are sent to the authorization endpoint of the provider with a
+// PKCE code challenge.
+func TestUnitAuthorizationURL(t *testing.T) {
+	idp, registry := newIdentityProvider(t)
+
+	authorizationURL, err := registry.AuthorizationURL(context.Background(), "corp", "state", testNonce, "challenge")
+	require.NoError(t, err)
+	u, err := url.Parse(authorizationURL)
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {idp.ClientID},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {"state"},
+		"nonce":                 {testNonce},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {"S256"},
+	}, u.Query())
+
+	_, err = registry.AuthorizationURL(context.Background(), "other", "state", testNonce, "challenge")
+	assert.ErrorIs(t, err, federation.ErrUnknownProvider)
+}
+
+// TestUnitExchange tests that authorization codes are redeemed once, with their code verifier, for the identity
+// of a valid ID token.
+func TestUnitExchange(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		idp, registry := newIdentityProvider(t)
+		code := authorize(t, idp, registry)
+
+		identity, err := registry.Exchange(ctx, "corp", code, testCodeVerifier, testNonce)
+		require.NoError(t, err)
+		assert.Equal(t, &model.ExternalIdentity{
+			Provider:      "corp",
+			Subject:       testIdentity.Subject,
+			Email:         testIdentity.Email,
+			EmailVerified: true,
+		}, identity)
+
+		_, err = registry.Exchange(ctx, "corp", code, testCodeVerifier, testNonce)
+		assert.ErrorIs(t, err, federation.ErrInvalidGrant, "authorization codes are single-use")
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		idp, registry := newIdentityProvider(t)
+		code := authorize(t, idp, registry)
+
+		_, err := registry.Exchange(ctx, "corp", code, "wrong-code-verifier", testNonce)
+		assert.ErrorIs(t, err, federation.ErrInvalidGrant)
+	})
+
+	t.Run("email verified as a string", func(t *testing.T) {
+		idp, registry := newIdentityProvider(t)
+		idp.ModifyClaims = func(claims jwt.MapClaims) { claims["email_verified"] = "true" }
+		code := authorize(t, idp, registry)
+
+		identity, err := registry.Exchange(ctx, "corp", code, testCodeVerifier, testNonce)
+		require.NoError(t, err)
+		assert.True(t, identity.EmailVerified)
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		idp, _ := newIdentityProvider(t)
+		provider := idp.Provider("corp")
+		provider.ClientSecret = "wrong"
+		registry := federation.NewRegistry([]federation.Provider{provider}, nil)
+		code := authorize(t, idp, registry)
+
+		_, err := registry.Exchange(ctx, "corp", code, testCodeVerifier, testNonce)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, federation.ErrInvalidGrant, "a misconfigured client is not the member's fault")
+	})
+
+	t.Run("provider unreachable", func(t *testing.T) {
+		idp, registry := newIdentityProvider(t)
+		idp.Close()
+
+		_, err := registry.Exchange(ctx, "corp", "code", testCodeVerifier, testNonce)
+		assert.ErrorIs(t, err, federation.ErrUnavailable)
+	})
+}
+
+// TestUnitExchangeInvalidIDToken tests that ID tokens of another issuer, client or login are rejected.
+func TestUnitExchangeInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+		nonce  string
+	}{
+		{name: "other issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "other audience", modify: func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{name: "other authorized party", modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{c["aud"].(string), "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "issued in the future", modify: func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "other nonce", nonce: "other-nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, registry := newIdentityProvider(t)
+			idp.ModifyClaims = tt.modify
+			code := authorize(t, idp, registry)
+			nonce := testNonce
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err := registry.Exchange(context.Background(), "corp", code, testCodeVerifier, nonce)
+			assert.ErrorIs(t, err, federation.ErrInvalidIDToken)
+		})
+	}
+}
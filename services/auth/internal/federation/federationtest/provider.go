@@ -0,0 +1,226 @@
+// Package federationtest defines an in-process OpenID Connect identity provider for testing federated logins.
+package federationtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation"
+)
+
+// Identity is the member a test logs in as at the identity provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// IdentityProvider is an identity provider serving discovery, JWKS and token endpoints over HTTP.
+// Instead of an authorization endpoint, Authorize answers the authorization URL of a login as the provider would
+// redirect a member who logged in.
+type IdentityProvider struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	// ModifyClaims, when set, is applied to the claims of ID tokens before they are signed.
+	ModifyClaims func(claims jwt.MapClaims)
+
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is an authorization code waiting to be redeemed.
+type grant struct {
+	identity      Identity
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// NewIdentityProvider starts an identity provider with a client registered for redirectURI.
+// Close it when done.
+func NewIdentityProvider(redirectURI string) (*IdentityProvider, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	p := &IdentityProvider{
+		ClientID:     "auth-service",
+		ClientSecret: "client-secret",
+		RedirectURI:  redirectURI,
+		key:          key,
+		kid:          "idp-key-1",
+		codes:        make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.serveDiscovery)
+	mux.HandleFunc("GET /jwks", p.serveJWKS)
+	mux.HandleFunc("POST /token", p.serveToken)
+	p.server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// Close shuts the identity provider down.
+func (p *IdentityProvider) Close() {
+	p.server.Close()
+}
+
+// Issuer returns the issuer identifier of the identity provider.
+func (p *IdentityProvider) Issuer() string {
+	return p.server.URL
+}
+
+// Provider returns the configuration of the identity provider under an ID.
+func (p *IdentityProvider) Provider(id string) federation.Provider {
+	return federation.Provider{
+		ID:           id,
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Scopes:       []string{"openid", "email"},
+		RedirectURI:  p.RedirectURI,
+	}
+}
+
+// Authorize logs identity in for an authorization URL and returns the URL the member is redirected back to, with
+// the authorization code and the state.
+func (p *IdentityProvider) Authorize(authorizationURL string, identity Identity) (*url.URL, error) {
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		return nil, errors.New("response_type is not code")
+	case q.Get("client_id") != p.ClientID:
+		return nil, fmt.Errorf("unknown client %q", q.Get("client_id"))
+	case q.Get("redirect_uri") != p.RedirectURI:
+		return nil, fmt.Errorf("redirect URI %q is not registered", q.Get("redirect_uri"))
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		return nil, errors.New("no S256 code challenge")
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+	p.mu.Lock()
+	p.codes[code] = grant{
+		identity:      identity,
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(p.RedirectURI)
+	if err != nil {
+		return nil, err
+	}
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	return redirect, nil
+}
+
+func (p *IdentityProvider) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *IdentityProvider) serveJWKS(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"use": "sig",
+			"kid": p.kid,
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(p.key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(p.key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+}
+
+func (p *IdentityProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || g.redirectURI != r.PostFormValue("redirect_uri") || base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"sub":            g.identity.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+	}
+	if p.ModifyClaims != nil {
+		p.ModifyClaims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = p.kid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "upstream-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
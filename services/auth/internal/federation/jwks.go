@@ -0,0 +1,156 @@
+package federation
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// keysCacheTTL is how long the keys of an identity provider are used before they are fetched again.
+	keysCacheTTL = time.Hour
+	// keysMinRefreshInterval is the minimum time between two fetches triggered by unknown key IDs.
+	keysMinRefreshInterval = 30 * time.Second
+)
+
+// errUnknownKeyID is returned when an ID token's kid is not in the keys of the provider, even after a refresh.
+var errUnknownKeyID = errors.New("unknown key ID")
+
+// keySet caches the JWKS of an identity provider and refreshes it when it is stale or a key ID is unknown.
+// Providers rotate their keys on their own schedule, so unknown key IDs are expected.
+type keySet struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]jwk
+	fetchedAt time.Time
+}
+
+func newKeySet(client *http.Client) *keySet {
+	return &keySet{client: client}
+}
+
+// get returns the public key with the given kid for a token signed with alg. Without a kid, the only key of the
+// set is used.
+func (s *keySet) get(ctx context.Context, jwksURI, kid, alg string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.lookup(kid)
+	age := time.Since(s.fetchedAt)
+	if !ok && age >= keysMinRefreshInterval || ok && age >= keysCacheTTL {
+		err := s.refresh(ctx, jwksURI)
+		switch {
+		case err != nil && !ok:
+			return nil, err
+		case err == nil:
+			key, ok = s.lookup(kid)
+		}
+		// Otherwise a stale but known key keeps serving while the provider cannot be reached.
+	}
+	if !ok {
+		return nil, errUnknownKeyID
+	}
+	return key.publicKey(alg)
+}
+
+func (s *keySet) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// refresh fetches the JWKS. A failed fetch also counts towards the refresh interval, so that an unreachable
+// provider is not asked again by every login.
+func (s *keySet) refresh(ctx context.Context, jwksURI string) error {
+	s.fetchedAt = time.Now()
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(ctx, s.client, jwksURI, &set); err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	s.keys = make(map[string]jwk, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		s.keys[k.Kid] = k
+	}
+	return nil
+}
+
+// jwk is a JSON Web Key as published by an identity provider. Unlike the keys of the auth service, the alg
+// member is optional, and inferred from the key type.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the public key for a token signed with alg, which must be the algorithm of the key.
+func (k jwk) publicKey(alg string) (crypto.PublicKey, error) {
+	if k.Alg != "" && k.Alg != alg {
+		return nil, fmt.Errorf("alg %s does not match kid %q", alg, k.Kid)
+	}
+	switch {
+	case k.Kty == "RSA" && alg == "RS256":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("kid %q: invalid RSA exponent", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case k.Kty == "EC" && k.Crv == "P-256" && alg == "ES256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := pub.ECDH(); err != nil {
+			return nil, fmt.Errorf("kid %q: invalid EC point: %w", k.Kid, err)
+		}
+		return pub, nil
+
+	default:
+		return nil, fmt.Errorf("kid %q: unsupported key %s for %s", k.Kid, k.Kty, alg)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
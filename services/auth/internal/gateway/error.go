@@ -0,0 +1,21 @@
+// Package gateway defines the errors for the auth service gateways.
+package gateway
+
+import "errors"
+
+var (
+	// ErrInvalidCredentials is the error for when the user service rejects an email and password.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrUserNotFound is the error for when the user service knows no user with an email.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrAccountDisabled is the error for when the credentials are valid but the account is disabled.
+	ErrAccountDisabled = errors.New("account disabled")
+	// ErrAccountLocked is the error for when the credentials are valid but the account is locked.
+	ErrAccountLocked = errors.New("account locked")
+	// ErrEmailNotVerified is the error for when the credentials are valid but the user has not verified their email.
+	ErrEmailNotVerified = errors.New("email not verified")
+	// ErrUserAlreadyExists is the error for when the user service already has a user with an email.
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrUnavailable is the error for when a dependency cannot be reached or does not answer in time.
+	ErrUnavailable = errors.New("dependency unavailable")
+)
@@ -3,12 +3,16 @@ package usergateway
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	userpb "github.com/incheat/go-production-backend/api/user/grpc/gen"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
 	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 // UserGateway is the gateway for the user service.
@@ -49,12 +53,145 @@ func (g *UserGateway) VerifyCredentials(ctx context.Context, email string, passw
 		Password: password,
 	})
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
+	}
+	switch resp.GetStatus() {
+	case usermodel.StatusDisabled:
+		return nil, gateway.ErrAccountDisabled
+	case usermodel.StatusLocked:
+		return nil, gateway.ErrAccountLocked
+	case usermodel.StatusPendingVerification:
+		return nil, gateway.ErrEmailNotVerified
+	}
+
+	return &usermodel.User{
+		ID:            resp.GetId(),
+		Email:         resp.GetEmail(),
+		EmailVerified: resp.GetEmailVerified(),
+		Status:        resp.GetStatus(),
+		Roles:         resp.GetRoles(),
+		Scopes:        resp.GetScopes(),
+		Audiences:     resp.GetAudiences(),
+	}, nil
+}
+
+// GetUser gets a user by email, for logins that do not involve a password.
+// Like VerifyCredentials, it rejects disabled and locked users; users pending verification are returned, so that
+// their verification email can be sent again.
+func (g *UserGateway) GetUser(ctx context.Context, email string) (*usermodel.User, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	resp, err := g.client.GetUser(ctx, &userpb.GetUserRequest{Email: email})
+	if status.Code(err) == codes.NotFound {
+		return nil, gateway.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	switch resp.GetStatus() {
+	case usermodel.StatusDisabled:
+		return nil, gateway.ErrAccountDisabled
+	case usermodel.StatusLocked:
+		return nil, gateway.ErrAccountLocked
 	}
 
 	return &usermodel.User{
-		ID:     resp.GetId(),
-		Email:  resp.GetEmail(),
-		Status: resp.GetStatus(),
+		ID:            resp.GetId(),
+		Email:         resp.GetEmail(),
+		EmailVerified: resp.GetEmailVerified(),
+		Status:        resp.GetStatus(),
+		Roles:         resp.GetRoles(),
+		Scopes:        resp.GetScopes(),
+		Audiences:     resp.GetAudiences(),
 	}, nil
 }
+
+// CreateUser creates a user with a password, which the user service hashes.
+func (g *UserGateway) CreateUser(ctx context.Context, email string, password string) (*usermodel.User, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	resp, err := g.client.CreateUser(ctx, &userpb.CreateUserRequest{
+		Email:    email,
+		Password: password,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return nil, gateway.ErrUserAlreadyExists
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &usermodel.User{
+		ID:            resp.GetId(),
+		Email:         resp.GetEmail(),
+		EmailVerified: resp.GetEmailVerified(),
+		Status:        resp.GetStatus(),
+		Roles:         resp.GetRoles(),
+		Scopes:        resp.GetScopes(),
+		Audiences:     resp.GetAudiences(),
+	}, nil
+}
+
+// SetPassword replaces the password of a user, which the user service hashes.
+func (g *UserGateway) SetPassword(ctx context.Context, email string, password string) error {
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	_, err := g.client.SetPassword(ctx, &userpb.SetPasswordRequest{
+		Email:    email,
+		Password: password,
+	})
+	if status.Code(err) == codes.NotFound {
+		return gateway.ErrUserNotFound
+	}
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of a user as verified, which activates users pending verification.
+func (g *UserGateway) VerifyEmail(ctx context.Context, email string) (*usermodel.User, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	resp, err := g.client.VerifyEmail(ctx, &userpb.VerifyEmailRequest{Email: email})
+	if status.Code(err) == codes.NotFound {
+		return nil, gateway.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &usermodel.User{
+		ID:            resp.GetId(),
+		Email:         resp.GetEmail(),
+		EmailVerified: resp.GetEmailVerified(),
+		Status:        resp.GetStatus(),
+		Roles:         resp.GetRoles(),
+		Scopes:        resp.GetScopes(),
+		Audiences:     resp.GetAudiences(),
+	}, nil
+}
+
+// translateError translates the gRPC status of a failed call into a gateway error.
+func translateError(err error) error {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.NotFound:
+		return gateway.ErrInvalidCredentials
+	case codes.PermissionDenied:
+		return gateway.ErrAccountDisabled
+	case codes.FailedPrecondition:
+		return gateway.ErrAccountLocked
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return fmt.Errorf("%w: user service: %w", gateway.ErrUnavailable, err)
+	default:
+		return fmt.Errorf("user service: %w", err)
+	}
+}
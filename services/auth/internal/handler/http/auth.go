@@ -5,11 +5,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"time"
 
 	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
 	"github.com/incheat/go-production-backend/services/auth/internal/constant"
 	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
 	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"go.uber.org/zap"
 )
 
 // _ is a placeholder to ensure that Server implements the StrictServerInterface interface.
@@ -25,33 +31,105 @@ func New(service *authservice.Service) *Server {
 	return &Server{service: service}
 }
 
+// Register is the server for the Register endpoint.
+func (h *Server) Register(ctx context.Context, request servergen.RegisterRequestObject) (servergen.RegisterResponseObject, error) {
+	email := string(request.Body.Email)
+	password := request.Body.Password
+
+	res, err := h.service.Register(ctx, email, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidEmail):
+			return servergen.Register400JSONResponse(errorBody(ErrorCodeInvalidEmail, "email is not a valid address")), nil
+		case errors.Is(err, authservice.ErrInvalidPassword):
+			return servergen.Register400JSONResponse(errorBody(ErrorCodeInvalidPassword, "password must be at least 8 characters and at most 72 bytes")), nil
+		case errors.Is(err, authservice.ErrUserAlreadyExists):
+			return servergen.Register409JSONResponse(errorBody(ErrorCodeUserAlreadyExists, "a member with this email already exists")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.Register503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.Register500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	if res.VerificationEmailErr != nil {
+		chimiddlewareutils.GetLogger(ctx).Warn("verification email not sent", zap.Error(res.VerificationEmailErr))
+	}
+	return servergen.Register201JSONResponse{
+		Body: servergen.RegisterResponse{
+			Email:                 res.Email,
+			VerificationEmailSent: res.VerificationEmailErr == nil,
+		},
+		Headers: servergen.Register201ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
 // Login is the server for the Login endpoint.
 func (h *Server) Login(ctx context.Context, request servergen.LoginRequestObject) (servergen.LoginResponseObject, error) {
 	email := string(request.Body.Email)
 	password := request.Body.Password
+	var scopes []string
+	if request.Body.Scope != nil {
+		scopes = strings.Fields(*request.Body.Scope)
+	}
 
-	requestMeta, ok := chimiddlewareutils.GetRequestMeta(ctx)
+	requestMeta, ok := requestmeta.Get(ctx)
 	if !ok {
-		return servergen.Login500JSONResponse{
-			Error: "request metadata not found",
-		}, errors.New("request metadata not found")
+		return servergen.Login500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
 	}
 	userAgent := requestMeta.UserAgent
 	ipAddress := requestMeta.IPAddress
 
-	res, err := h.service.LoginWithEmailAndPassword(ctx, email, password, userAgent, ipAddress)
+	res, err := h.service.LoginWithEmailAndPassword(ctx, email, password, scopes, userAgent, ipAddress)
 	if err != nil {
-		return servergen.Login500JSONResponse{
-			Error: err.Error(),
-		}, err
+		var throttled *authservice.LoginThrottledError
+		switch {
+		case errors.Is(err, authservice.ErrInvalidScope):
+			return servergen.Login400JSONResponse(errorBody(ErrorCodeInvalidScope, "none of the requested scopes are allowed")), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.Login400JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")), nil
+		case errors.Is(err, authservice.ErrInvalidCredentials):
+			return servergen.Login401JSONResponse(errorBody(ErrorCodeInvalidCredentials, "invalid email or password")), nil
+		case errors.Is(err, authservice.ErrAccountDisabled):
+			return servergen.Login403JSONResponse(errorBody(ErrorCodeAccountDisabled, "account is disabled")), nil
+		case errors.Is(err, authservice.ErrAccountLocked):
+			return servergen.Login403JSONResponse(errorBody(ErrorCodeAccountLocked, "account is locked")), nil
+		case errors.Is(err, authservice.ErrEmailNotVerified):
+			return servergen.Login403JSONResponse(errorBody(ErrorCodeEmailNotVerified, "email is not verified yet, follow the link sent to it")), nil
+		case errors.As(err, &throttled):
+			return servergen.Login429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "too many failed login attempts, please retry later"),
+				Headers: servergen.Login429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.Login503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.Login500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	if res.MFAChallenge != nil {
+		return servergen.Login202JSONResponse{
+			Body: servergen.MFAChallengeResponse{
+				MfaToken:   res.MFAChallenge.Token,
+				MfaMethods: res.MFAChallenge.Methods,
+				ExpiresIn:  retryAfterSeconds(time.Until(res.MFAChallenge.ExpiresAt)),
+			},
+			Headers: servergen.Login202ResponseHeaders{
+				VersionId: constant.APIResponseVersionV1,
+			},
+		}, nil
 	}
 
 	accessToken := string(res.AccessToken)
-	setCookie := fmt.Sprintf("refresh_token=%s; HttpOnly; Secure; SameSite=Lax; Path=/%s/%s; Max-Age=%d", res.RefreshToken, constant.APIResponseVersionV1, res.RefreshEndPoint, res.RefreshMaxAgeSec)
+	setCookie := refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec)
 
 	return servergen.Login200JSONResponse{
 		Body: servergen.AuthResponse{
 			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
 		},
 		Headers: servergen.Login200ResponseHeaders{
 			VersionId: constant.APIResponseVersionV1,
@@ -60,11 +138,161 @@ func (h *Server) Login(ctx context.Context, request servergen.LoginRequestObject
 	}, nil
 }
 
+// Refresh is the server for the Refresh endpoint.
+func (h *Server) Refresh(ctx context.Context, request servergen.RefreshRequestObject) (servergen.RefreshResponseObject, error) {
+	if request.Params.RefreshToken == nil || *request.Params.RefreshToken == "" {
+		return servergen.Refresh401JSONResponse(errorBody(ErrorCodeInvalidRefreshToken, "refresh token not found")), nil
+	}
+	refreshToken := model.RefreshToken(*request.Params.RefreshToken)
+
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.Refresh500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+
+	res, err := h.service.Refresh(ctx, refreshToken, requestMeta.UserAgent, requestMeta.IPAddress)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidRefreshToken), errors.Is(err, authservice.ErrRefreshTokenReused):
+			return servergen.Refresh401JSONResponse(errorBody(ErrorCodeInvalidRefreshToken, err.Error())), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.Refresh401JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid or not made with the key of the refresh token")), nil
+		}
+		return servergen.Refresh500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	accessToken := string(res.AccessToken)
+
+	return servergen.Refresh200JSONResponse{
+		Body: servergen.AuthResponse{
+			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
+		},
+		Headers: servergen.Refresh200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec),
+		},
+	}, nil
+}
+
 // Logout is the server for the Logout endpoint.
-func (h *Server) Logout(_ context.Context, _ servergen.LogoutRequestObject) (servergen.LogoutResponseObject, error) {
+func (h *Server) Logout(ctx context.Context, request servergen.LogoutRequestObject) (servergen.LogoutResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.Logout401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	var refreshToken model.RefreshToken
+	if request.Params.RefreshToken != nil {
+		refreshToken = model.RefreshToken(*request.Params.RefreshToken)
+	}
+	allDevices := request.Params.AllDevices != nil && *request.Params.AllDevices
+
+	err := h.service.Logout(ctx, model.AccessToken(accessToken), refreshToken, allDevices)
+	if err != nil {
+		if errors.Is(err, authservice.ErrInvalidAccessToken) {
+			return servergen.Logout401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		}
+		return servergen.Logout500JSONResponse(internalError(ctx, err)), nil
+	}
+
 	return servergen.Logout204Response{
 		Headers: servergen.Logout204ResponseHeaders{
 			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie("", 0),
+		},
+	}, nil
+}
+
+// ListSessions is the server for the ListSessions endpoint.
+func (h *Server) ListSessions(ctx context.Context, request servergen.ListSessionsRequestObject) (servergen.ListSessionsResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.ListSessions401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	var refreshToken model.RefreshToken
+	if request.Params.RefreshToken != nil {
+		refreshToken = model.RefreshToken(*request.Params.RefreshToken)
+	}
+
+	sessions, err := h.service.ListSessions(ctx, model.AccessToken(accessToken), refreshToken)
+	if err != nil {
+		if errors.Is(err, authservice.ErrInvalidAccessToken) {
+			return servergen.ListSessions401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		}
+		return servergen.ListSessions500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	body := servergen.SessionListResponse{
+		Sessions: make([]servergen.Session, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		body.Sessions = append(body.Sessions, servergen.Session{
+			Id:        session.ID,
+			UserAgent: session.UserAgent,
+			IpAddress: session.IPAddress,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   session.Current,
+		})
+	}
+
+	return servergen.ListSessions200JSONResponse{
+		Body: body,
+		Headers: servergen.ListSessions200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
 		},
 	}, nil
 }
+
+// RevokeSession is the server for the RevokeSession endpoint.
+func (h *Server) RevokeSession(ctx context.Context, request servergen.RevokeSessionRequestObject) (servergen.RevokeSessionResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.RevokeSession401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	err := h.service.RevokeSession(ctx, model.AccessToken(accessToken), request.SessionId)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidAccessToken):
+			return servergen.RevokeSession401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		case errors.Is(err, authservice.ErrSessionNotFound):
+			return servergen.RevokeSession404JSONResponse(errorBody(ErrorCodeSessionNotFound, authservice.ErrSessionNotFound.Error())), nil
+		}
+		return servergen.RevokeSession500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.RevokeSession204Response{
+		Headers: servergen.RevokeSession204ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// refreshCookie builds the Set-Cookie value carrying a refresh token; a zero maxAge expires the cookie.
+func refreshCookie(refreshToken model.RefreshToken, maxAge int) string {
+	return fmt.Sprintf("%s=%s; HttpOnly; Secure; SameSite=Lax; Path=%s; Max-Age=%d",
+		constant.RefreshTokenCookieName, refreshToken, refreshCookiePath(), maxAge)
+}
+
+// refreshCookiePath returns the path the refresh token cookie is scoped to.
+// It covers the versioned auth API so that both refresh and logout receive the cookie.
+func refreshCookiePath() string {
+	return "/" + constant.APIResponseVersionV1
+}
+
+// scopeOf encodes granted scopes as the space separated scope of an AuthResponse.
+func scopeOf(scopes []string) *string {
+	if len(scopes) == 0 {
+		return nil
+	}
+	scope := strings.Join(scopes, " ")
+	return &scope
+}
+
+// retryAfterSeconds rounds a duration up to whole seconds, e.g. for a Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
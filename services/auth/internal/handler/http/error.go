@@ -0,0 +1,61 @@
+// Package authhandler defines the error responses for the Auth API.
+package authhandler
+
+import (
+	"context"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"go.uber.org/zap"
+)
+
+// Error codes of ErrorResponse. They are part of the API contract and must not change.
+const (
+	ErrorCodeInvalidRequest            = "invalid_request"
+	ErrorCodeInvalidScope              = "invalid_scope"
+	ErrorCodeInvalidEmail              = "invalid_email"
+	ErrorCodeInvalidPassword           = "invalid_password"
+	ErrorCodeUserAlreadyExists         = "user_already_exists"
+	ErrorCodeInvalidResetToken         = "invalid_reset_token"
+	ErrorCodeInvalidVerificationToken  = "invalid_verification_token"
+	ErrorCodeInvalidCredentials        = "invalid_credentials"
+	ErrorCodeInvalidDPoPProof          = "invalid_dpop_proof"
+	ErrorCodeInvalidToken              = "invalid_token"
+	ErrorCodeInvalidRefreshToken       = "invalid_refresh_token"
+	ErrorCodeInvalidMFAToken           = "invalid_mfa_token"
+	ErrorCodeInvalidOTP                = "invalid_otp"
+	ErrorCodeInvalidLoginChallenge     = "invalid_login_challenge"
+	ErrorCodeInvalidLoginCode          = "invalid_login_code"
+	ErrorCodeInvalidFederatedLogin     = "invalid_federated_login"
+	ErrorCodeUnknownIdentityProvider   = "unknown_identity_provider"
+	ErrorCodeInvalidPasskey            = "invalid_passkey"
+	ErrorCodeInvalidPasskeyCeremony    = "invalid_passkey_ceremony"
+	ErrorCodeAccountDisabled           = "account_disabled"
+	ErrorCodeAccountLocked             = "account_locked"
+	ErrorCodeEmailNotVerified          = "email_not_verified"
+	ErrorCodeFederatedEmailNotVerified = "federated_email_not_verified"
+	ErrorCodeSessionNotFound           = "session_not_found"
+	ErrorCodeTOTPNotEnrolled           = "totp_not_enrolled"
+	ErrorCodeTOTPAlreadyEnabled        = "totp_already_enabled"
+	ErrorCodePasskeyAlreadyRegistered  = "passkey_already_registered"
+	ErrorCodeTooManyAttempts           = "too_many_attempts"
+	ErrorCodeServiceUnavailable        = "service_unavailable"
+	ErrorCodeInternal                  = "internal_error"
+)
+
+// errorBody builds the body of an error response.
+func errorBody(code, message string) servergen.ErrorResponse {
+	return servergen.ErrorResponse{ErrorCode: code, Message: message}
+}
+
+// internalError logs err and builds the body of a 500 response that does not leak it to the client.
+func internalError(ctx context.Context, err error) servergen.ErrorResponse {
+	chimiddlewareutils.GetLogger(ctx).Error("request failed", zap.Error(err))
+	return errorBody(ErrorCodeInternal, "internal server error")
+}
+
+// unavailableError logs err and builds the body of a 503 response.
+func unavailableError(ctx context.Context, err error) servergen.ErrorResponse {
+	chimiddlewareutils.GetLogger(ctx).Warn("dependency unavailable", zap.Error(err))
+	return errorBody(ErrorCodeServiceUnavailable, "service temporarily unavailable, please retry later")
+}
@@ -0,0 +1,112 @@
+// Package authhandler defines the federated login endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// StartFederatedLogin is the server for the StartFederatedLogin endpoint.
+func (h *Server) StartFederatedLogin(ctx context.Context, request servergen.StartFederatedLoginRequestObject) (servergen.StartFederatedLoginResponseObject, error) {
+	res, err := h.service.StartFederatedLogin(ctx, request.Body.Provider)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrUnknownIdentityProvider):
+			return servergen.StartFederatedLogin400JSONResponse(errorBody(ErrorCodeUnknownIdentityProvider, "unknown identity provider")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.StartFederatedLogin503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.StartFederatedLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	expiresIn := retryAfterSeconds(time.Until(res.ExpiresAt))
+	return servergen.StartFederatedLogin200JSONResponse{
+		Body: servergen.FederatedLoginResponse{
+			AuthorizationUrl: res.AuthorizationURL,
+			ExpiresIn:        expiresIn,
+		},
+		Headers: servergen.StartFederatedLogin200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: federatedLoginCookie(res.Binding, expiresIn),
+		},
+	}, nil
+}
+
+// CompleteFederatedLogin is the server for the CompleteFederatedLogin endpoint.
+func (h *Server) CompleteFederatedLogin(ctx context.Context, request servergen.CompleteFederatedLoginRequestObject) (servergen.CompleteFederatedLoginResponseObject, error) {
+	var scopes []string
+	if request.Body.Scope != nil {
+		scopes = strings.Fields(*request.Body.Scope)
+	}
+	var binding string
+	if request.Params.FederatedLogin != nil {
+		binding = *request.Params.FederatedLogin
+	}
+
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.CompleteFederatedLogin500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+
+	res, err := h.service.CompleteFederatedLogin(ctx, request.Body.State, binding, request.Body.Code, scopes, requestMeta.UserAgent, requestMeta.IPAddress)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidScope):
+			return servergen.CompleteFederatedLogin400JSONResponse(errorBody(ErrorCodeInvalidScope, "none of the requested scopes are allowed")), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.CompleteFederatedLogin400JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")), nil
+		case errors.Is(err, authservice.ErrInvalidFederatedLogin):
+			return servergen.CompleteFederatedLogin401JSONResponse(errorBody(ErrorCodeInvalidFederatedLogin, "login is invalid, expired or already completed, please log in again")), nil
+		case errors.Is(err, authservice.ErrFederatedEmailNotVerified):
+			return servergen.CompleteFederatedLogin403JSONResponse(errorBody(ErrorCodeFederatedEmailNotVerified, "the identity provider has not verified your email")), nil
+		case errors.Is(err, authservice.ErrAccountDisabled):
+			return servergen.CompleteFederatedLogin403JSONResponse(errorBody(ErrorCodeAccountDisabled, "account is disabled")), nil
+		case errors.Is(err, authservice.ErrAccountLocked):
+			return servergen.CompleteFederatedLogin403JSONResponse(errorBody(ErrorCodeAccountLocked, "account is locked")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.CompleteFederatedLogin503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.CompleteFederatedLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	if res.MFAChallenge != nil {
+		return servergen.CompleteFederatedLogin202JSONResponse{
+			Body: servergen.MFAChallengeResponse{
+				MfaToken:   res.MFAChallenge.Token,
+				MfaMethods: res.MFAChallenge.Methods,
+				ExpiresIn:  retryAfterSeconds(time.Until(res.MFAChallenge.ExpiresAt)),
+			},
+			Headers: servergen.CompleteFederatedLogin202ResponseHeaders{
+				VersionId: constant.APIResponseVersionV1,
+			},
+		}, nil
+	}
+
+	accessToken := string(res.AccessToken)
+
+	return servergen.CompleteFederatedLogin200JSONResponse{
+		Body: servergen.AuthResponse{
+			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
+		},
+		Headers: servergen.CompleteFederatedLogin200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec),
+		},
+	}, nil
+}
+
+// federatedLoginCookie builds the Set-Cookie value binding a federated login to the browser. It is scoped to the
+// federated login endpoints, and expires with the login.
+func federatedLoginCookie(binding string, maxAge int) string {
+	return fmt.Sprintf("%s=%s; HttpOnly; Secure; SameSite=Lax; Path=/%s/login/federated; Max-Age=%d",
+		constant.FederatedLoginCookieName, binding, constant.APIResponseVersionV1, maxAge)
+}
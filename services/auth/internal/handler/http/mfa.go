@@ -0,0 +1,114 @@
+// Package authhandler defines the multi-factor authentication endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"errors"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// LoginMFA is the server for the LoginMFA endpoint.
+func (h *Server) LoginMFA(ctx context.Context, request servergen.LoginMFARequestObject) (servergen.LoginMFAResponseObject, error) {
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.LoginMFA500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+
+	res, err := h.service.LoginWithMFA(ctx, request.Body.MfaToken, request.Body.Code, requestMeta.UserAgent, requestMeta.IPAddress)
+	if err != nil {
+		var throttled *authservice.LoginThrottledError
+		switch {
+		case errors.Is(err, authservice.ErrInvalidMFAToken):
+			return servergen.LoginMFA401JSONResponse(errorBody(ErrorCodeInvalidMFAToken, "MFA token is invalid, expired or already used, please log in again")), nil
+		case errors.Is(err, authservice.ErrInvalidOTP):
+			return servergen.LoginMFA401JSONResponse(errorBody(ErrorCodeInvalidOTP, "invalid code")), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.LoginMFA400JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")), nil
+		case errors.As(err, &throttled):
+			return servergen.LoginMFA429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "too many failed login attempts, please retry later"),
+				Headers: servergen.LoginMFA429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		}
+		return servergen.LoginMFA500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	accessToken := string(res.AccessToken)
+
+	return servergen.LoginMFA200JSONResponse{
+		Body: servergen.AuthResponse{
+			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
+		},
+		Headers: servergen.LoginMFA200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec),
+		},
+	}, nil
+}
+
+// EnrollTOTP is the server for the EnrollTOTP endpoint.
+func (h *Server) EnrollTOTP(ctx context.Context, _ servergen.EnrollTOTPRequestObject) (servergen.EnrollTOTPResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.EnrollTOTP401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	res, err := h.service.EnrollTOTP(ctx, model.AccessToken(accessToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidAccessToken):
+			return servergen.EnrollTOTP401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		case errors.Is(err, authservice.ErrTOTPAlreadyEnabled):
+			return servergen.EnrollTOTP409JSONResponse(errorBody(ErrorCodeTOTPAlreadyEnabled, authservice.ErrTOTPAlreadyEnabled.Error())), nil
+		}
+		return servergen.EnrollTOTP500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.EnrollTOTP200JSONResponse{
+		Body: servergen.TOTPEnrollmentResponse{
+			Secret:     res.Secret,
+			OtpauthUri: res.URI,
+		},
+		Headers: servergen.EnrollTOTP200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// ConfirmTOTP is the server for the ConfirmTOTP endpoint.
+func (h *Server) ConfirmTOTP(ctx context.Context, request servergen.ConfirmTOTPRequestObject) (servergen.ConfirmTOTPResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.ConfirmTOTP401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	err := h.service.ConfirmTOTP(ctx, model.AccessToken(accessToken), request.Body.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidAccessToken):
+			return servergen.ConfirmTOTP401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		case errors.Is(err, authservice.ErrInvalidOTP):
+			return servergen.ConfirmTOTP400JSONResponse(errorBody(ErrorCodeInvalidOTP, "invalid code")), nil
+		case errors.Is(err, authservice.ErrTOTPNotEnrolled):
+			return servergen.ConfirmTOTP404JSONResponse(errorBody(ErrorCodeTOTPNotEnrolled, authservice.ErrTOTPNotEnrolled.Error())), nil
+		case errors.Is(err, authservice.ErrTOTPAlreadyEnabled):
+			return servergen.ConfirmTOTP409JSONResponse(errorBody(ErrorCodeTOTPAlreadyEnabled, authservice.ErrTOTPAlreadyEnabled.Error())), nil
+		}
+		return servergen.ConfirmTOTP500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.ConfirmTOTP204Response{
+		Headers: servergen.ConfirmTOTP204ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
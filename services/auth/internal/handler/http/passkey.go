@@ -0,0 +1,163 @@
+// Package authhandler defines the passkey (WebAuthn) endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"go.uber.org/zap"
+)
+
+// BeginPasskeyRegistration is the server for the BeginPasskeyRegistration endpoint.
+func (h *Server) BeginPasskeyRegistration(ctx context.Context, _ servergen.BeginPasskeyRegistrationRequestObject) (servergen.BeginPasskeyRegistrationResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.BeginPasskeyRegistration401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+
+	res, err := h.service.BeginPasskeyRegistration(ctx, model.AccessToken(accessToken))
+	if err != nil {
+		if errors.Is(err, authservice.ErrInvalidAccessToken) {
+			return servergen.BeginPasskeyRegistration401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		}
+		return servergen.BeginPasskeyRegistration500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	body, err := passkeyCeremonyResponse(res)
+	if err != nil {
+		return servergen.BeginPasskeyRegistration500JSONResponse(internalError(ctx, err)), nil
+	}
+	return servergen.BeginPasskeyRegistration200JSONResponse{
+		Body: body,
+		Headers: servergen.BeginPasskeyRegistration200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// FinishPasskeyRegistration is the server for the FinishPasskeyRegistration endpoint.
+func (h *Server) FinishPasskeyRegistration(ctx context.Context, request servergen.FinishPasskeyRegistrationRequestObject) (servergen.FinishPasskeyRegistrationResponseObject, error) {
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		return servergen.FinishPasskeyRegistration401JSONResponse(errorBody(ErrorCodeInvalidToken, "access token not found")), nil
+	}
+	credential, err := json.Marshal(request.Body.Credential)
+	if err != nil {
+		return servergen.FinishPasskeyRegistration400JSONResponse(errorBody(ErrorCodeInvalidRequest, "invalid credential")), nil
+	}
+
+	err = h.service.FinishPasskeyRegistration(ctx, model.AccessToken(accessToken), request.Body.CeremonyId, credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidAccessToken):
+			return servergen.FinishPasskeyRegistration401JSONResponse(errorBody(ErrorCodeInvalidToken, authservice.ErrInvalidAccessToken.Error())), nil
+		case errors.Is(err, authservice.ErrInvalidPasskeyCeremony):
+			return servergen.FinishPasskeyRegistration400JSONResponse(errorBody(ErrorCodeInvalidPasskeyCeremony, "passkey ceremony is invalid, expired or already used, please start again")), nil
+		case errors.Is(err, authservice.ErrInvalidPasskey):
+			passkeyRejected(ctx, err)
+			return servergen.FinishPasskeyRegistration400JSONResponse(errorBody(ErrorCodeInvalidPasskey, "passkey could not be verified")), nil
+		case errors.Is(err, authservice.ErrPasskeyAlreadyRegistered):
+			return servergen.FinishPasskeyRegistration409JSONResponse(errorBody(ErrorCodePasskeyAlreadyRegistered, authservice.ErrPasskeyAlreadyRegistered.Error())), nil
+		}
+		return servergen.FinishPasskeyRegistration500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.FinishPasskeyRegistration204Response{
+		Headers: servergen.FinishPasskeyRegistration204ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// BeginPasskeyLogin is the server for the BeginPasskeyLogin endpoint.
+func (h *Server) BeginPasskeyLogin(ctx context.Context, _ servergen.BeginPasskeyLoginRequestObject) (servergen.BeginPasskeyLoginResponseObject, error) {
+	res, err := h.service.BeginPasskeyLogin(ctx)
+	if err != nil {
+		return servergen.BeginPasskeyLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	body, err := passkeyCeremonyResponse(res)
+	if err != nil {
+		return servergen.BeginPasskeyLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+	return servergen.BeginPasskeyLogin200JSONResponse{
+		Body: body,
+		Headers: servergen.BeginPasskeyLogin200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// FinishPasskeyLogin is the server for the FinishPasskeyLogin endpoint.
+func (h *Server) FinishPasskeyLogin(ctx context.Context, request servergen.FinishPasskeyLoginRequestObject) (servergen.FinishPasskeyLoginResponseObject, error) {
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.FinishPasskeyLogin500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+	credential, err := json.Marshal(request.Body.Credential)
+	if err != nil {
+		return servergen.FinishPasskeyLogin400JSONResponse(errorBody(ErrorCodeInvalidRequest, "invalid credential")), nil
+	}
+
+	res, err := h.service.FinishPasskeyLogin(ctx, request.Body.CeremonyId, credential, requestMeta.UserAgent, requestMeta.IPAddress)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidPasskeyCeremony):
+			return servergen.FinishPasskeyLogin401JSONResponse(errorBody(ErrorCodeInvalidPasskeyCeremony, "passkey ceremony is invalid, expired or already used, please start again")), nil
+		case errors.Is(err, authservice.ErrInvalidPasskey):
+			passkeyRejected(ctx, err)
+			return servergen.FinishPasskeyLogin401JSONResponse(errorBody(ErrorCodeInvalidPasskey, "passkey could not be verified")), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.FinishPasskeyLogin400JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")), nil
+		case errors.Is(err, authservice.ErrAccountDisabled):
+			return servergen.FinishPasskeyLogin403JSONResponse(errorBody(ErrorCodeAccountDisabled, "account is disabled")), nil
+		case errors.Is(err, authservice.ErrAccountLocked):
+			return servergen.FinishPasskeyLogin403JSONResponse(errorBody(ErrorCodeAccountLocked, "account is locked")), nil
+		case errors.Is(err, authservice.ErrEmailNotVerified):
+			return servergen.FinishPasskeyLogin403JSONResponse(errorBody(ErrorCodeEmailNotVerified, "email is not verified yet, follow the link sent to it")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.FinishPasskeyLogin503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.FinishPasskeyLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	accessToken := string(res.AccessToken)
+
+	return servergen.FinishPasskeyLogin200JSONResponse{
+		Body: servergen.AuthResponse{
+			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
+		},
+		Headers: servergen.FinishPasskeyLogin200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec),
+		},
+	}, nil
+}
+
+// passkeyCeremonyResponse builds the body of a started passkey ceremony.
+func passkeyCeremonyResponse(res *authservice.PasskeyCeremonyResult) (servergen.PasskeyCeremonyResponse, error) {
+	var options map[string]interface{}
+	if err := json.Unmarshal(res.Options, &options); err != nil {
+		return servergen.PasskeyCeremonyResponse{}, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return servergen.PasskeyCeremonyResponse{
+		CeremonyId: res.CeremonyID,
+		Options:    options,
+		ExpiresIn:  retryAfterSeconds(time.Until(res.ExpiresAt)),
+	}, nil
+}
+
+// passkeyRejected logs why a passkey was rejected; the client is only told that it could not be verified.
+func passkeyRejected(ctx context.Context, err error) {
+	chimiddlewareutils.GetLogger(ctx).Info("passkey rejected", zap.Error(err))
+}
@@ -0,0 +1,59 @@
+// Package authhandler defines the password reset endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"errors"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// ForgotPassword is the server for the ForgotPassword endpoint.
+func (h *Server) ForgotPassword(ctx context.Context, request servergen.ForgotPasswordRequestObject) (servergen.ForgotPasswordResponseObject, error) {
+	err := h.service.ForgotPassword(ctx, string(request.Body.Email))
+	if err != nil {
+		var throttled *authservice.PasswordResetThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			return servergen.ForgotPassword429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "a password reset was asked for recently, please retry later"),
+				Headers: servergen.ForgotPassword429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.ForgotPassword503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.ForgotPassword500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.ForgotPassword202Response{
+		Headers: servergen.ForgotPassword202ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// ResetPassword is the server for the ResetPassword endpoint.
+func (h *Server) ResetPassword(ctx context.Context, request servergen.ResetPasswordRequestObject) (servergen.ResetPasswordResponseObject, error) {
+	err := h.service.ResetPassword(ctx, request.Body.Token, request.Body.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidPassword):
+			return servergen.ResetPassword400JSONResponse(errorBody(ErrorCodeInvalidPassword, "password must be at least 8 characters and at most 72 bytes")), nil
+		case errors.Is(err, authservice.ErrInvalidResetToken):
+			return servergen.ResetPassword400JSONResponse(errorBody(ErrorCodeInvalidResetToken, "reset link is invalid, expired or already used, please ask for a new one")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.ResetPassword503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.ResetPassword500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.ResetPassword204Response{
+		Headers: servergen.ResetPassword204ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
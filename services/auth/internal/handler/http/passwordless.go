@@ -0,0 +1,120 @@
+// Package authhandler defines the passwordless login endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// StartPasswordlessLogin is the server for the StartPasswordlessLogin endpoint.
+func (h *Server) StartPasswordlessLogin(ctx context.Context, request servergen.StartPasswordlessLoginRequestObject) (servergen.StartPasswordlessLoginResponseObject, error) {
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.StartPasswordlessLogin500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+
+	res, err := h.service.StartPasswordlessLogin(ctx, string(request.Body.Email), string(request.Body.Method), requestMeta.IPAddress)
+	if err != nil {
+		var throttled *authservice.LoginThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			return servergen.StartPasswordlessLogin429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "a login email was sent recently or too many logins failed, please retry later"),
+				Headers: servergen.StartPasswordlessLogin429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.StartPasswordlessLogin503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.StartPasswordlessLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.StartPasswordlessLogin202JSONResponse{
+		Body: servergen.PasswordlessChallengeResponse{
+			ChallengeId: res.ChallengeID,
+			Method:      servergen.PasswordlessChallengeResponseMethod(res.Method),
+			ExpiresIn:   retryAfterSeconds(time.Until(res.ExpiresAt)),
+		},
+		Headers: servergen.StartPasswordlessLogin202ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// CompletePasswordlessLogin is the server for the CompletePasswordlessLogin endpoint.
+func (h *Server) CompletePasswordlessLogin(ctx context.Context, request servergen.CompletePasswordlessLoginRequestObject) (servergen.CompletePasswordlessLoginResponseObject, error) {
+	var scopes []string
+	if request.Body.Scope != nil {
+		scopes = strings.Fields(*request.Body.Scope)
+	}
+
+	requestMeta, ok := requestmeta.Get(ctx)
+	if !ok {
+		return servergen.CompletePasswordlessLogin500JSONResponse(internalError(ctx, errors.New("request metadata not found"))), nil
+	}
+
+	res, err := h.service.CompletePasswordlessLogin(ctx, request.Body.ChallengeId, request.Body.Code, scopes, requestMeta.UserAgent, requestMeta.IPAddress)
+	if err != nil {
+		var throttled *authservice.LoginThrottledError
+		switch {
+		case errors.Is(err, authservice.ErrInvalidScope):
+			return servergen.CompletePasswordlessLogin400JSONResponse(errorBody(ErrorCodeInvalidScope, "none of the requested scopes are allowed")), nil
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			return servergen.CompletePasswordlessLogin400JSONResponse(errorBody(ErrorCodeInvalidDPoPProof, "DPoP proof is invalid")), nil
+		case errors.Is(err, authservice.ErrInvalidLoginChallenge):
+			return servergen.CompletePasswordlessLogin401JSONResponse(errorBody(ErrorCodeInvalidLoginChallenge, "login is invalid, expired or already completed, please ask for a new code or link")), nil
+		case errors.Is(err, authservice.ErrInvalidLoginCode):
+			return servergen.CompletePasswordlessLogin401JSONResponse(errorBody(ErrorCodeInvalidLoginCode, "invalid code")), nil
+		case errors.Is(err, authservice.ErrAccountDisabled):
+			return servergen.CompletePasswordlessLogin403JSONResponse(errorBody(ErrorCodeAccountDisabled, "account is disabled")), nil
+		case errors.Is(err, authservice.ErrAccountLocked):
+			return servergen.CompletePasswordlessLogin403JSONResponse(errorBody(ErrorCodeAccountLocked, "account is locked")), nil
+		case errors.Is(err, authservice.ErrEmailNotVerified):
+			return servergen.CompletePasswordlessLogin403JSONResponse(errorBody(ErrorCodeEmailNotVerified, "email is not verified yet, follow the link sent to it")), nil
+		case errors.As(err, &throttled):
+			return servergen.CompletePasswordlessLogin429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "too many failed login attempts, please retry later"),
+				Headers: servergen.CompletePasswordlessLogin429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.CompletePasswordlessLogin503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.CompletePasswordlessLogin500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	if res.MFAChallenge != nil {
+		return servergen.CompletePasswordlessLogin202JSONResponse{
+			Body: servergen.MFAChallengeResponse{
+				MfaToken:   res.MFAChallenge.Token,
+				MfaMethods: res.MFAChallenge.Methods,
+				ExpiresIn:  retryAfterSeconds(time.Until(res.MFAChallenge.ExpiresAt)),
+			},
+			Headers: servergen.CompletePasswordlessLogin202ResponseHeaders{
+				VersionId: constant.APIResponseVersionV1,
+			},
+		}, nil
+	}
+
+	accessToken := string(res.AccessToken)
+
+	return servergen.CompletePasswordlessLogin200JSONResponse{
+		Body: servergen.AuthResponse{
+			AccessToken: &accessToken,
+			Scope:       scopeOf(res.Scopes),
+		},
+		Headers: servergen.CompletePasswordlessLogin200ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+			SetCookie: refreshCookie(res.RefreshToken, res.RefreshMaxAgeSec),
+		},
+	}, nil
+}
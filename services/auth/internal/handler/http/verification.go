@@ -0,0 +1,57 @@
+// Package authhandler defines the email verification endpoints of the Auth API.
+package authhandler
+
+import (
+	"context"
+	"errors"
+
+	servergen "github.com/incheat/go-production-backend/services/auth/internal/api/oapi/gen/public/server"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// VerifyEmail is the server for the VerifyEmail endpoint.
+func (h *Server) VerifyEmail(ctx context.Context, request servergen.VerifyEmailRequestObject) (servergen.VerifyEmailResponseObject, error) {
+	err := h.service.VerifyEmail(ctx, request.Body.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidVerificationToken):
+			return servergen.VerifyEmail400JSONResponse(errorBody(ErrorCodeInvalidVerificationToken, "verification link is invalid, expired or already used, please ask for a new one")), nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.VerifyEmail503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.VerifyEmail500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.VerifyEmail204Response{
+		Headers: servergen.VerifyEmail204ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
+
+// ResendVerificationEmail is the server for the ResendVerificationEmail endpoint.
+func (h *Server) ResendVerificationEmail(ctx context.Context, request servergen.ResendVerificationEmailRequestObject) (servergen.ResendVerificationEmailResponseObject, error) {
+	err := h.service.ResendVerificationEmail(ctx, string(request.Body.Email))
+	if err != nil {
+		var throttled *authservice.VerificationEmailThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			return servergen.ResendVerificationEmail429JSONResponse{
+				Body: errorBody(ErrorCodeTooManyAttempts, "a verification email was sent recently, please retry later"),
+				Headers: servergen.ResendVerificationEmail429ResponseHeaders{
+					RetryAfter: retryAfterSeconds(throttled.RetryAfter),
+				},
+			}, nil
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			return servergen.ResendVerificationEmail503JSONResponse(unavailableError(ctx, err)), nil
+		}
+		return servergen.ResendVerificationEmail500JSONResponse(internalError(ctx, err)), nil
+	}
+
+	return servergen.ResendVerificationEmail202Response{
+		Headers: servergen.ResendVerificationEmail202ResponseHeaders{
+			VersionId: constant.APIResponseVersionV1,
+		},
+	}, nil
+}
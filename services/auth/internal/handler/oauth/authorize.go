@@ -0,0 +1,195 @@
+// Package oauthhandler defines the authorization endpoint (RFC 6749 section 3.1).
+package oauthhandler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Authorize serves the login form of an authorization request.
+func (h *Server) Authorize(w http.ResponseWriter, r *http.Request) {
+	req := authorizationRequestOf(r.URL.Query())
+	client, err := h.service.ValidateAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		h.authorizationError(w, r, req, err)
+		return
+	}
+
+	token, err := csrfToken(w, r)
+	if err != nil {
+		logError(r.Context(), "create CSRF token", err)
+		renderPage(w, r, http.StatusInternalServerError, page{Error: "Something went wrong, please try again."})
+		return
+	}
+	renderPage(w, r, http.StatusOK, page{
+		Step:       stepPassword,
+		ClientName: clientName(client),
+		Scopes:     req.Scopes,
+		Request:    req,
+		CSRFToken:  token,
+	})
+}
+
+// SubmitAuthorize logs the member in with the submitted login form, either with email and password or
+// with the second factor of a login that passed its password, and redirects back to the client with
+// an authorization code.
+func (h *Server) SubmitAuthorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := parseForm(w, r); err != nil {
+		renderPage(w, r, http.StatusBadRequest, page{Error: "The login form is invalid."})
+		return
+	}
+	if !validCSRFToken(r) {
+		renderPage(w, r, http.StatusForbidden, page{Error: "The login form has expired, please go back to the application and try again."})
+		return
+	}
+
+	req := authorizationRequestOf(r.PostForm)
+	client, err := h.service.ValidateAuthorizationRequest(ctx, req)
+	if err != nil {
+		h.authorizationError(w, r, req, err)
+		return
+	}
+
+	p := page{
+		Step:       stepPassword,
+		ClientName: clientName(client),
+		Scopes:     req.Scopes,
+		Request:    req,
+		CSRFToken:  r.PostForm.Get(csrfFieldName),
+		Email:      r.PostForm.Get("email"),
+	}
+	ipAddress := ipAddressOf(r)
+
+	var res *authservice.AuthorizationResult
+	if mfaToken := r.PostForm.Get("mfa_token"); mfaToken != "" {
+		p.Step = stepMFA
+		p.MFAToken = mfaToken
+		res, err = h.service.AuthorizeWithMFA(ctx, req, mfaToken, r.PostForm.Get("code"), ipAddress)
+	} else {
+		res, err = h.service.AuthorizeWithPassword(ctx, req, p.Email, r.PostForm.Get("password"), ipAddress)
+	}
+	if err != nil {
+		h.loginError(w, r, req, p, err)
+		return
+	}
+
+	if res.MFAChallenge != nil {
+		p.Step = stepMFA
+		p.MFAToken = res.MFAChallenge.Token
+		renderPage(w, r, http.StatusOK, p)
+		return
+	}
+	redirectToClient(w, r, req, url.Values{"code": {res.Code}})
+}
+
+// loginError shows why a login failed on its form, or returns the error to the client when the request is invalid.
+func (h *Server) loginError(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, p page, err error) {
+	var throttled *authservice.LoginThrottledError
+	switch {
+	case errors.Is(err, authservice.ErrInvalidCredentials):
+		p.Error = "Invalid email or password."
+		renderPage(w, r, http.StatusUnauthorized, p)
+	case errors.Is(err, authservice.ErrInvalidOTP):
+		p.Error = "Invalid authentication code."
+		renderPage(w, r, http.StatusUnauthorized, p)
+	case errors.Is(err, authservice.ErrInvalidMFAToken):
+		p.Step, p.MFAToken = stepPassword, ""
+		p.Error = "Your login has expired, please sign in again."
+		renderPage(w, r, http.StatusUnauthorized, p)
+	case errors.As(err, &throttled):
+		p.Error = fmt.Sprintf("Too many failed logins, please try again in %d seconds.", int(math.Ceil(throttled.RetryAfter.Seconds())))
+		renderPage(w, r, http.StatusTooManyRequests, p)
+	case errors.Is(err, authservice.ErrAccountDisabled):
+		p.Error = "This account is disabled."
+		renderPage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrAccountLocked):
+		p.Error = "This account is locked."
+		renderPage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrEmailNotVerified):
+		p.Error = "Please verify your email with the link we sent you first."
+		renderPage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrDependencyUnavailable):
+		logError(r.Context(), "dependency unavailable", err)
+		p.Error = "Sign in is temporarily unavailable, please try again later."
+		renderPage(w, r, http.StatusServiceUnavailable, p)
+	default:
+		h.authorizationError(w, r, req, err)
+	}
+}
+
+// authorizationError reports a failed authorization request. Without a valid client and redirect URI the error is
+// shown to the user, as redirecting would make the endpoint an open redirector; otherwise it is returned to the client.
+func (h *Server) authorizationError(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, err error) {
+	var code string
+	switch {
+	case errors.Is(err, authservice.ErrInvalidClient):
+		renderPage(w, r, http.StatusBadRequest, page{Error: "The application is not registered."})
+		return
+	case errors.Is(err, authservice.ErrInvalidRedirectURI):
+		renderPage(w, r, http.StatusBadRequest, page{Error: "The application's redirect URI is not registered."})
+		return
+	case errors.Is(err, authservice.ErrUnsupportedResponseType):
+		code = ErrorCodeUnsupportedResponseType
+	case errors.Is(err, authservice.ErrInvalidAuthorizationRequest):
+		code = ErrorCodeInvalidRequest
+	case errors.Is(err, authservice.ErrInvalidScope):
+		code = ErrorCodeInvalidScope
+	default:
+		logError(r.Context(), "authorization failed", err)
+		code = ErrorCodeServerError
+	}
+	redirectToClient(w, r, req, url.Values{"error": {code}, "error_description": {err.Error()}})
+}
+
+// redirectToClient redirects the browser to the redirect URI of a validated request with the response parameters
+// and the state of the request.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req *model.AuthorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		logError(r.Context(), "parse redirect URI", err)
+		renderPage(w, r, http.StatusInternalServerError, page{Error: "Something went wrong, please try again."})
+		return
+	}
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// authorizationRequestOf reads the parameters of an authorization request.
+func authorizationRequestOf(values url.Values) *model.AuthorizationRequest {
+	return &model.AuthorizationRequest{
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		ResponseType:        values.Get("response_type"),
+		Scopes:              strings.Fields(values.Get("scope")),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+		Nonce:               values.Get("nonce"),
+	}
+}
+
+// clientName returns the name the login form shows for a client.
+func clientName(client *model.OAuthClient) string {
+	if client.Name != "" {
+		return client.Name
+	}
+	return client.ID
+}
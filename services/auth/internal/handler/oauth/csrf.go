@@ -0,0 +1,53 @@
+// Package oauthhandler defines the CSRF protection of the login form.
+package oauthhandler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// csrfCookieName is the name of the cookie holding the CSRF token of the login form.
+	// The __Host- prefix pins it to this origin, so that no subdomain can plant a known token.
+	csrfCookieName = "__Host-oauth_csrf"
+	// csrfFieldName is the name of the form field echoing the CSRF token.
+	csrfFieldName = "csrf_token"
+	// csrfTokenLength is the length of an encoded CSRF token, 32 random bytes.
+	csrfTokenLength = 43
+)
+
+// csrfToken returns the CSRF token of the browser, setting a new cookie when it has none.
+// The token is reused across page loads so that logins opened in several tabs keep working.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == csrfTokenLength {
+		return cookie.Value, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// validCSRFToken reports whether a submitted form echoes the CSRF token of its cookie (double submit).
+// A cross-site form cannot read the cookie, and SameSite=Strict keeps the cookie off cross-site posts.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil || len(cookie.Value) != csrfTokenLength {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get(csrfFieldName))) == 1
+}
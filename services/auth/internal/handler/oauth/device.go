@@ -0,0 +1,226 @@
+// Package oauthhandler defines the device authorization endpoint and the device verification page (RFC 8628).
+package oauthhandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// grantTypeDeviceCode is the grant type of the token endpoint that polls for the tokens of a device authorization.
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceAuthorizationResponse is the body of a device authorization response (RFC 8628 section 3.2).
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceAuthorization starts a device authorization for a client, which shows the user code and verification URI
+// to the member and then polls the token endpoint with the device code.
+func (h *Server) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := parseForm(w, r); err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "request body must be a form")
+		return
+	}
+	clientID, clientSecret, err := clientCredentialsOf(r)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if clientID == "" {
+		writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client_id is required")
+		return
+	}
+
+	res, err := h.service.StartDeviceAuthorization(ctx, clientID, clientSecret, strings.Fields(r.PostForm.Get("scope")))
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidClient):
+			writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+		case errors.Is(err, authservice.ErrInvalidScope):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidScope, "none of the requested scopes are allowed")
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			logError(ctx, "dependency unavailable", err)
+			writeTokenError(w, http.StatusServiceUnavailable, ErrorCodeTemporarilyUnavailable, "")
+		default:
+			logError(ctx, "device authorization request failed", err)
+			writeTokenError(w, http.StatusInternalServerError, ErrorCodeServerError, "")
+		}
+		return
+	}
+
+	verificationURI := strings.TrimSuffix(h.provider.Issuer, "/") + PathPrefix + "/device"
+	writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              res.DeviceCode,
+		UserCode:                res.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {res.UserCode}}.Encode(),
+		ExpiresIn:               int(math.Ceil(time.Until(res.ExpiresAt).Seconds())),
+		Interval:                int(res.Interval / time.Second),
+	})
+}
+
+// Device serves the device verification page. A member enters the user code shown on their device, or follows the
+// complete verification URI that carries it, and then signs in to approve or deny the device.
+func (h *Server) Device(w http.ResponseWriter, r *http.Request) {
+	token, err := csrfToken(w, r)
+	if err != nil {
+		logError(r.Context(), "create CSRF token", err)
+		renderDevicePage(w, r, http.StatusInternalServerError, devicePage{Error: "Something went wrong, please try again."})
+		return
+	}
+
+	p := devicePage{Step: stepUserCode, UserCode: r.URL.Query().Get("user_code"), CSRFToken: token}
+	if p.UserCode == "" {
+		renderDevicePage(w, r, http.StatusOK, p)
+		return
+	}
+	h.verifyUserCode(w, r, p)
+}
+
+// SubmitDevice handles the forms of the device verification page: the user code, and the login that approves or
+// denies the device, either with email and password or with the second factor of a login that passed its password.
+func (h *Server) SubmitDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := parseForm(w, r); err != nil {
+		renderDevicePage(w, r, http.StatusBadRequest, devicePage{Error: "The form is invalid."})
+		return
+	}
+	if !validCSRFToken(r) {
+		renderDevicePage(w, r, http.StatusForbidden, devicePage{Error: "The form has expired, please reload the page and try again."})
+		return
+	}
+
+	p := devicePage{
+		Step:      stepUserCode,
+		UserCode:  r.PostForm.Get("user_code"),
+		CSRFToken: r.PostForm.Get(csrfFieldName),
+		Email:     r.PostForm.Get("email"),
+	}
+	ipAddress := ipAddressOf(r)
+
+	// Denying takes the same login as approving, so that a user code alone cannot cancel a device login.
+	var withPassword func(ctx context.Context, userCode, email, password, ipAddress string) (*authservice.MFAChallengeResult, error)
+	var withMFA func(ctx context.Context, userCode, mfaToken, code, ipAddress string) error
+	var decided string
+	switch r.PostForm.Get("action") {
+	case "approve":
+		withPassword, withMFA, decided = h.service.ApproveDeviceWithPassword, h.service.ApproveDeviceWithMFA, stepDeviceApproved
+	case "deny":
+		withPassword, withMFA, decided = h.service.DenyDeviceWithPassword, h.service.DenyDeviceWithMFA, stepDeviceDenied
+	default:
+		h.verifyUserCode(w, r, p)
+		return
+	}
+	if !h.reviewDevice(w, r, &p) {
+		return
+	}
+
+	var challenge *authservice.MFAChallengeResult
+	var err error
+	if mfaToken := r.PostForm.Get("mfa_token"); mfaToken != "" {
+		p.Step = stepMFA
+		p.MFAToken = mfaToken
+		err = withMFA(ctx, p.UserCode, mfaToken, r.PostForm.Get("code"), ipAddress)
+	} else {
+		challenge, err = withPassword(ctx, p.UserCode, p.Email, r.PostForm.Get("password"), ipAddress)
+	}
+	if err != nil {
+		h.deviceError(w, r, p, err)
+		return
+	}
+	if challenge != nil {
+		p.Step = stepMFA
+		p.MFAToken = challenge.Token
+	} else {
+		p.Step = decided
+	}
+	renderDevicePage(w, r, http.StatusOK, p)
+}
+
+// verifyUserCode shows the login that approves the device of a user code, or asks for the code again when it is
+// invalid.
+func (h *Server) verifyUserCode(w http.ResponseWriter, r *http.Request, p devicePage) {
+	if h.reviewDevice(w, r, &p) {
+		renderDevicePage(w, r, http.StatusOK, p)
+	}
+}
+
+// reviewDevice fills the page with the client and scopes of the device authorization of its user code, for the
+// member to review them. When the user code is invalid, it shows why and returns false.
+func (h *Server) reviewDevice(w http.ResponseWriter, r *http.Request, p *devicePage) bool {
+	res, err := h.service.VerifyUserCode(r.Context(), p.UserCode, ipAddressOf(r))
+	if err != nil {
+		h.deviceError(w, r, *p, err)
+		return false
+	}
+	if p.Step == stepUserCode {
+		p.Step = stepPassword
+	}
+	p.UserCode = res.UserCode
+	p.ClientName = clientName(res.Client)
+	p.Scopes = res.Scopes
+	return true
+}
+
+// deviceError shows why a step of the device verification page failed.
+func (h *Server) deviceError(w http.ResponseWriter, r *http.Request, p devicePage, err error) {
+	var throttled *authservice.LoginThrottledError
+	var userCodesThrottled *authservice.UserCodeThrottledError
+	switch {
+	case errors.Is(err, authservice.ErrInvalidUserCode):
+		p.Step, p.MFAToken = stepUserCode, ""
+		p.Error = "The code is invalid or has expired, please check the code shown on your device."
+		renderDevicePage(w, r, http.StatusBadRequest, p)
+	case errors.Is(err, authservice.ErrInvalidCredentials):
+		p.Error = "Invalid email or password."
+		renderDevicePage(w, r, http.StatusUnauthorized, p)
+	case errors.Is(err, authservice.ErrInvalidOTP):
+		p.Error = "Invalid authentication code."
+		renderDevicePage(w, r, http.StatusUnauthorized, p)
+	case errors.Is(err, authservice.ErrInvalidMFAToken):
+		p.Step, p.MFAToken = stepPassword, ""
+		p.Error = "Your login has expired, please sign in again."
+		renderDevicePage(w, r, http.StatusUnauthorized, p)
+	case errors.As(err, &userCodesThrottled):
+		p.Step, p.MFAToken = stepUserCode, ""
+		p.Error = fmt.Sprintf("Too many invalid codes, please try again in %d seconds.", int(math.Ceil(userCodesThrottled.RetryAfter.Seconds())))
+		renderDevicePage(w, r, http.StatusTooManyRequests, p)
+	case errors.As(err, &throttled):
+		p.Error = fmt.Sprintf("Too many failed logins, please try again in %d seconds.", int(math.Ceil(throttled.RetryAfter.Seconds())))
+		renderDevicePage(w, r, http.StatusTooManyRequests, p)
+	case errors.Is(err, authservice.ErrAccountDisabled):
+		p.Error = "This account is disabled."
+		renderDevicePage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrAccountLocked):
+		p.Error = "This account is locked."
+		renderDevicePage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrEmailNotVerified):
+		p.Error = "Please verify your email with the link we sent you first."
+		renderDevicePage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrInvalidScope):
+		p.Step = ""
+		p.Error = "None of the access the device asks for is allowed."
+		renderDevicePage(w, r, http.StatusForbidden, p)
+	case errors.Is(err, authservice.ErrDependencyUnavailable):
+		logError(r.Context(), "dependency unavailable", err)
+		p.Error = "Sign in is temporarily unavailable, please try again later."
+		renderDevicePage(w, r, http.StatusServiceUnavailable, p)
+	default:
+		logError(r.Context(), "device verification failed", err)
+		renderDevicePage(w, r, http.StatusInternalServerError, devicePage{Error: "Something went wrong, please try again."})
+	}
+}
@@ -0,0 +1,65 @@
+// Package oauthhandler defines the errors of the OAuth 2.0 endpoints.
+package oauthhandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"go.uber.org/zap"
+)
+
+// Error codes of OAuth 2.0 error responses (RFC 6749 sections 4.1.2.1 and 5.2).
+const (
+	ErrorCodeInvalidRequest          = "invalid_request"
+	ErrorCodeInvalidClient           = "invalid_client"
+	ErrorCodeInvalidGrant            = "invalid_grant"
+	ErrorCodeUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorCodeUnsupportedResponseType = "unsupported_response_type"
+	ErrorCodeInvalidScope            = "invalid_scope"
+	ErrorCodeUnauthorizedClient      = "unauthorized_client"
+	ErrorCodeInvalidTarget           = "invalid_target"        // RFC 8707
+	ErrorCodeAuthorizationPending    = "authorization_pending" // RFC 8628
+	ErrorCodeSlowDown                = "slow_down"             // RFC 8628
+	ErrorCodeAccessDenied            = "access_denied"
+	ErrorCodeExpiredToken            = "expired_token"      // RFC 8628
+	ErrorCodeInvalidDPoPProof        = "invalid_dpop_proof" // RFC 9449
+	ErrorCodeServerError             = "server_error"
+	ErrorCodeTemporarilyUnavailable  = "temporarily_unavailable"
+)
+
+// errorResponse is the body of an OAuth 2.0 error response of the token endpoint.
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// writeTokenError writes an error response of the token endpoint.
+// A failed client authentication asks for HTTP Basic credentials (RFC 6749 section 5.2).
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	writeJSON(w, status, errorResponse{Error: code, ErrorDescription: description})
+}
+
+// writeBearerError writes an error response of a resource protected by a bearer access token (RFC 6750 section 3).
+func writeBearerError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="oauth2", error=%q, error_description=%q`, code, description))
+	w.WriteHeader(status)
+}
+
+// writeDPoPError writes an error response of a resource protected by a DPoP bound access token
+// (RFC 9449 section 7.1).
+func writeDPoPError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DPoP algs=%q, error=%q, error_description=%q`, strings.Join(dpop.SigningAlgorithms, " "), code, description))
+	w.WriteHeader(status)
+}
+
+// logError logs an unexpected error of an OAuth 2.0 endpoint; the client only gets a generic error.
+func logError(ctx context.Context, msg string, err error) {
+	chimiddlewareutils.GetLogger(ctx).Error(msg, zap.Error(err))
+}
@@ -0,0 +1,115 @@
+// Package oauthhandler defines the token introspection (RFC 7662) and revocation (RFC 7009) endpoints.
+package oauthhandler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+// introspectionResponse is the body of an introspection response (RFC 7662 section 2.2). Only active is set for
+// an inactive token.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	// Confirmation is the key a DPoP bound token is bound to (RFC 9449 section 6.2).
+	Confirmation *confirmation `json:"cnf,omitempty"`
+}
+
+// confirmation is the cnf member of the introspection of a DPoP bound token.
+type confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// Introspect reports whether an access or refresh token is active to a confidential client, such as a resource
+// server that cannot verify tokens itself.
+func (h *Server) Introspect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	clientID, clientSecret, token, ok := tokenRequestOf(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := h.service.IntrospectToken(ctx, clientID, clientSecret, token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidClient):
+			writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+		case errors.Is(err, authservice.ErrUnauthorizedClient):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeUnauthorizedClient, "only confidential clients may introspect tokens")
+		default:
+			logError(ctx, "introspection request failed", err)
+			writeTokenError(w, http.StatusInternalServerError, ErrorCodeServerError, "")
+		}
+		return
+	}
+
+	body := introspectionResponse{Active: res.Active}
+	if res.Active {
+		body.TokenType = res.TokenType
+		body.Subject = res.Subject
+		body.ClientID = res.ClientID
+		body.Scope = strings.Join(res.Scopes, " ")
+		body.IssuedAt = res.IssuedAt.Unix()
+		body.ExpiresAt = res.ExpiresAt.Unix()
+		if res.JKT != "" {
+			body.Confirmation = &confirmation{JKT: res.JKT}
+		}
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// Revoke revokes an access or refresh token of a client. Unknown tokens are also answered with 200, so that the
+// client can forget the token either way (RFC 7009 section 2.2).
+func (h *Server) Revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	clientID, clientSecret, token, ok := tokenRequestOf(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeToken(ctx, clientID, clientSecret, token, r.PostForm.Get("token_type_hint")); err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidClient):
+			writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+		default:
+			logError(ctx, "revocation request failed", err)
+			writeTokenError(w, http.StatusInternalServerError, ErrorCodeServerError, "")
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// tokenRequestOf reads the client credentials and the token of an introspection or revocation request, or writes
+// why the request is invalid.
+func tokenRequestOf(w http.ResponseWriter, r *http.Request) (clientID, clientSecret, token string, ok bool) {
+	if err := parseForm(w, r); err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "request body must be a form")
+		return "", "", "", false
+	}
+	clientID, clientSecret, err := clientCredentialsOf(r)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return "", "", "", false
+	}
+	if clientID == "" {
+		writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client_id is required")
+		return "", "", "", false
+	}
+	token = r.PostForm.Get("token")
+	if token == "" {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "token is required")
+		return "", "", "", false
+	}
+	return clientID, clientSecret, token, true
+}
@@ -0,0 +1,60 @@
+// Package oauthhandler defines the server for the OAuth 2.0 endpoints of the auth service.
+// They are plain HTML and form endpoints (RFC 6749), served outside of the OpenAPI validated API.
+package oauthhandler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+)
+
+const (
+	// PathPrefix is the path the router of the OAuth 2.0 endpoints is mounted at.
+	PathPrefix = "/oauth2"
+	// maxFormBytes is the largest form body the OAuth endpoints accept.
+	maxFormBytes = 64 << 10
+)
+
+// Server is the server for the OAuth 2.0 endpoints.
+type Server struct {
+	service  *authservice.Service
+	provider Provider
+}
+
+// New creates a new Server.
+func New(service *authservice.Service, provider Provider) *Server {
+	return &Server{service: service, provider: provider}
+}
+
+// Routes returns the router of the OAuth 2.0 endpoints.
+func (h *Server) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/authorize", h.Authorize)
+	r.Post("/authorize", h.SubmitAuthorize)
+	r.Post("/token", h.Token)
+	r.Post("/introspect", h.Introspect)
+	r.Post("/revoke", h.Revoke)
+	r.Post("/device_authorization", h.DeviceAuthorization)
+	r.Get("/device", h.Device)
+	r.Post("/device", h.SubmitDevice)
+	r.Get("/userinfo", h.UserInfo)
+	r.Post("/userinfo", h.UserInfo)
+	return r
+}
+
+// parseForm parses a form body of at most maxFormBytes.
+func parseForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormBytes)
+	return r.ParseForm()
+}
+
+// writeJSON writes a JSON response that must not be cached, as it carries tokens (RFC 6749 section 5.1).
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,116 @@
+// Package oauthhandler defines the OpenID Connect discovery and user info endpoints.
+package oauthhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// DiscoveryPath is the path of the OpenID provider metadata (OpenID Connect Discovery 1.0 section 4).
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// Provider describes the auth service as an OpenID provider.
+type Provider struct {
+	Issuer            string // public base URL of the auth service; the iss claim of its tokens
+	JWKSPath          string
+	SigningAlgorithms func() []string // of the published keys, which change as keys rotate
+}
+
+// providerMetadata is the OpenID provider metadata (OpenID Connect Discovery 1.0 section 3).
+type providerMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"` // RFC 9449
+}
+
+// userInfoResponse is the body of a user info response (OpenID Connect Core 1.0 section 5.3.2).
+type userInfoResponse struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+}
+
+// Discovery serves the OpenID provider metadata. Endpoint URLs are relative to the issuer.
+func (h *Server) Discovery(w http.ResponseWriter, _ *http.Request) {
+	issuer := strings.TrimSuffix(h.provider.Issuer, "/")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_ = json.NewEncoder(w).Encode(providerMetadata{
+		Issuer:                            h.provider.Issuer,
+		AuthorizationEndpoint:             issuer + PathPrefix + "/authorize",
+		TokenEndpoint:                     issuer + PathPrefix + "/token",
+		DeviceAuthorizationEndpoint:       issuer + PathPrefix + "/device_authorization",
+		UserInfoEndpoint:                  issuer + PathPrefix + "/userinfo",
+		IntrospectionEndpoint:             issuer + PathPrefix + "/introspect",
+		RevocationEndpoint:                issuer + PathPrefix + "/revoke",
+		JWKSURI:                           issuer + h.provider.JWKSPath,
+		ScopesSupported:                   []string{model.ScopeOpenID, model.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{grantTypeAuthorizationCode, grantTypeRefreshToken, grantTypeClientCredentials, grantTypeDeviceCode},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  h.provider.SigningAlgorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{model.CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "email", "email_verified"},
+		DPoPSigningAlgValuesSupported:     dpop.SigningAlgorithms,
+	})
+}
+
+// UserInfo returns the claims about the member of the bearer or DPoP access token, which must carry the openid
+// scope. Errors are reported in the WWW-Authenticate header (RFC 6750 section 3 and RFC 9449 section 7.1).
+func (h *Server) UserInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	accessToken, ok := chimiddlewareutils.GetAccessToken(ctx)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oauth2"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	res, err := h.service.UserInfo(ctx, model.AccessToken(accessToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			writeDPoPError(w, http.StatusUnauthorized, ErrorCodeInvalidDPoPProof, "DPoP proof is invalid or not made with the key of the access token")
+		case errors.Is(err, authservice.ErrInvalidAccessToken):
+			writeBearerError(w, http.StatusUnauthorized, "invalid_token", "access token is invalid, expired or revoked")
+		case errors.Is(err, authservice.ErrInsufficientScope):
+			writeBearerError(w, http.StatusForbidden, "insufficient_scope", "access token lacks the openid scope")
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			logError(ctx, "dependency unavailable", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			logError(ctx, "user info request failed", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body := userInfoResponse{Subject: res.Subject}
+	if res.Email != "" {
+		body.Email = res.Email
+		body.EmailVerified = &res.EmailVerified
+	}
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,177 @@
+// Package oauthhandler defines the pages of the authorization endpoint and the device verification page.
+package oauthhandler
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Steps of the login form, and of the device verification page around it.
+const (
+	stepPassword       = "password"
+	stepMFA            = "mfa"
+	stepUserCode       = "code"
+	stepDeviceApproved = "approved"
+	stepDeviceDenied   = "denied"
+)
+
+// page is the data of a page of the authorization endpoint.
+type page struct {
+	Step       string // empty for an error page
+	ClientName string
+	Scopes     []string
+	Request    *model.AuthorizationRequest
+	CSRFToken  string
+	Email      string
+	MFAToken   string
+	Error      string
+}
+
+// devicePage is the data of the device verification page.
+type devicePage struct {
+	Step       string
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	CSRFToken  string
+	Email      string
+	MFAToken   string
+	Error      string
+}
+
+// Scope returns the space separated scopes of the request, carried between the steps of the form.
+func (p page) Scope() string {
+	if p.Request == nil {
+		return ""
+	}
+	return strings.Join(p.Request.Scopes, " ")
+}
+
+// pageTemplates are the pages of the authorization endpoint ("authorize") and the device verification page
+// ("device"), which share their head.
+var pageTemplates = template.Must(template.New("").Parse(`{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:24rem;margin:4rem auto;padding:0 1rem}
+label,input,button{display:block;width:100%;box-sizing:border-box}
+input{margin:.25rem 0 1rem;padding:.5rem}
+button{padding:.5rem;margin-bottom:.5rem}
+.error{color:#b00020}
+.code{font-family:monospace;font-size:1.5rem;letter-spacing:.1em}
+</style>
+</head>
+{{end}}
+
+{{define "authorize"}}{{template "head" "Sign in"}}<body>
+{{- if .Step}}
+<h1>Sign in to {{.ClientName}}</h1>
+{{- with .Scopes}}
+<p>{{$.ClientName}} is asking for access to: {{range $i, $s := .}}{{if $i}}, {{end}}<code>{{$s}}</code>{{end}}</p>
+{{- end}}
+{{- with .Error}}
+<p class="error" role="alert">{{.}}</p>
+{{- end}}
+<form method="post" action="authorize">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+{{- if eq .Step "mfa"}}
+<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label for="code">Authentication code</label>
+<input id="code" name="code" inputmode="numeric" autocomplete="one-time-code" required autofocus>
+<button type="submit">Verify</button>
+{{- else}}
+<label for="email">Email</label>
+<input id="email" name="email" type="email" autocomplete="username" value="{{.Email}}" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+{{- end}}
+</form>
+{{- else}}
+<h1>Sign in failed</h1>
+<p class="error" role="alert">{{.Error}}</p>
+{{- end}}
+</body>
+</html>
+{{end}}
+
+{{define "device"}}{{template "head" "Connect a device"}}<body>
+<h1>Connect a device</h1>
+{{- with .Error}}
+<p class="error" role="alert">{{.}}</p>
+{{- end}}
+{{- if eq .Step "code"}}
+<form method="post" action="device">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label for="user_code">Enter the code shown on your device</label>
+<input id="user_code" name="user_code" class="code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" required autofocus>
+<button type="submit">Continue</button>
+</form>
+{{- else if or (eq .Step "password") (eq .Step "mfa")}}
+<p>{{.ClientName}} on the device showing <span class="code">{{.UserCode}}</span> is asking to sign in to your account.</p>
+{{- with .Scopes}}
+<p>It is asking for access to: {{range $i, $s := .}}{{if $i}}, {{end}}<code>{{$s}}</code>{{end}}</p>
+{{- end}}
+<form method="post" action="device">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="user_code" value="{{.UserCode}}">
+{{- if eq .Step "mfa"}}
+<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+<label for="code">Authentication code</label>
+<input id="code" name="code" inputmode="numeric" autocomplete="one-time-code" required autofocus>
+<button type="submit" name="action" value="approve">Verify and approve</button>
+<button type="submit" name="action" value="deny">Verify and deny</button>
+{{- else}}
+<label for="email">Email</label>
+<input id="email" name="email" type="email" autocomplete="username" value="{{.Email}}" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit" name="action" value="approve">Sign in and approve</button>
+<button type="submit" name="action" value="deny">Sign in and deny</button>
+{{- end}}
+</form>
+{{- else if eq .Step "approved"}}
+<p>{{.ClientName}} is now signed in. You can return to your device.</p>
+{{- else if eq .Step "denied"}}
+<p>The request was denied. You can close this page.</p>
+{{- end}}
+</body>
+</html>
+{{end}}`))
+
+// renderPage renders a page of the authorization endpoint.
+func renderPage(w http.ResponseWriter, r *http.Request, status int, p page) {
+	render(w, r, status, "authorize", p)
+}
+
+// renderDevicePage renders the device verification page.
+func renderDevicePage(w http.ResponseWriter, r *http.Request, status int, p devicePage) {
+	render(w, r, status, "device", p)
+}
+
+// render renders a page. Pages must never be framed, cached or leak the request through the referrer.
+func render(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	if err := pageTemplates.ExecuteTemplate(w, name, data); err != nil {
+		logError(r.Context(), "render OAuth page", err)
+	}
+}
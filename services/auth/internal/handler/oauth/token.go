@@ -0,0 +1,146 @@
+// Package oauthhandler defines the token endpoint (RFC 6749 section 3.2).
+package oauthhandler
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Grant types of the token endpoint.
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	grantTypeClientCredentials = "client_credentials"
+)
+
+// tokenResponse is the body of a successful token response (RFC 6749 section 5.1).
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Token exchanges an authorization code, a refresh token or the device code of an approved device authorization
+// of a client for tokens, or issues a confidential client a token of its own.
+func (h *Server) Token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := parseForm(w, r); err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "request body must be a form")
+		return
+	}
+	form := r.PostForm
+	clientID, clientSecret, err := clientCredentialsOf(r)
+	if err != nil {
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, err.Error())
+		return
+	}
+	if clientID == "" {
+		writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client_id is required")
+		return
+	}
+	userAgent := r.UserAgent()
+	ipAddress := ipAddressOf(r)
+
+	var res *authservice.LoginResult
+	switch grantType := form.Get("grant_type"); grantType {
+	case grantTypeAuthorizationCode:
+		res, err = h.service.ExchangeAuthorizationCode(ctx, clientID, clientSecret, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"), userAgent, ipAddress)
+	case grantTypeRefreshToken:
+		res, err = h.service.RefreshClientToken(ctx, clientID, clientSecret, model.RefreshToken(form.Get("refresh_token")), userAgent, ipAddress)
+	case grantTypeClientCredentials:
+		res, err = h.service.IssueClientToken(ctx, clientID, clientSecret, strings.Fields(form.Get("scope")), form["audience"])
+	case grantTypeDeviceCode:
+		res, err = h.service.PollDeviceAuthorization(ctx, clientID, clientSecret, form.Get("device_code"), userAgent, ipAddress)
+	case "":
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidRequest, "grant_type is required")
+		return
+	default:
+		writeTokenError(w, http.StatusBadRequest, ErrorCodeUnsupportedGrantType, "grant_type "+grantType+" is not supported")
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, authservice.ErrInvalidClient):
+			writeTokenError(w, http.StatusUnauthorized, ErrorCodeInvalidClient, "client authentication failed")
+		case errors.Is(err, authservice.ErrUnauthorizedClient):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeUnauthorizedClient, "client is not allowed the client_credentials grant")
+		case errors.Is(err, authservice.ErrInvalidScope):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidScope, "none of the requested scopes are allowed")
+		case errors.Is(err, authservice.ErrInvalidTarget):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidTarget, "none of the requested audiences are allowed")
+		case errors.Is(err, authservice.ErrInvalidGrant):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidGrant, err.Error())
+		case errors.Is(err, authservice.ErrAuthorizationPending):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeAuthorizationPending, "")
+		case errors.Is(err, authservice.ErrSlowDown):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeSlowDown, "")
+		case errors.Is(err, authservice.ErrAccessDenied):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeAccessDenied, "the device authorization was denied")
+		case errors.Is(err, authservice.ErrExpiredToken):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeExpiredToken, "the device code has expired")
+		case errors.Is(err, authservice.ErrInvalidDPoPProof):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidDPoPProof, "DPoP proof is invalid or not made with the key of the refresh token")
+		case errors.Is(err, authservice.ErrInvalidRefreshToken), errors.Is(err, authservice.ErrRefreshTokenReused):
+			writeTokenError(w, http.StatusBadRequest, ErrorCodeInvalidGrant, "refresh token is invalid, expired or revoked")
+		case errors.Is(err, authservice.ErrDependencyUnavailable):
+			logError(ctx, "dependency unavailable", err)
+			writeTokenError(w, http.StatusServiceUnavailable, ErrorCodeTemporarilyUnavailable, "")
+		default:
+			logError(ctx, "token request failed", err)
+			writeTokenError(w, http.StatusInternalServerError, ErrorCodeServerError, "")
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  string(res.AccessToken),
+		TokenType:    res.TokenType,
+		ExpiresIn:    int(math.Ceil(time.Until(res.AccessExpiresAt).Seconds())),
+		RefreshToken: string(res.RefreshToken),
+		Scope:        strings.Join(res.Scopes, " "),
+		IDToken:      string(res.IDToken),
+	})
+}
+
+// clientCredentialsOf returns the client ID and secret of a request, sent with HTTP Basic authentication
+// (client_secret_basic) or in the form (client_secret_post). Public clients only send client_id in the form.
+// Using both methods at once is an invalid request (RFC 6749 section 2.3).
+func clientCredentialsOf(r *http.Request) (clientID, clientSecret string, err error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), nil
+	}
+	if r.PostForm.Has("client_secret") {
+		return "", "", errors.New("client must use only one authentication method")
+	}
+	// The credentials are form-urlencoded before being put in the header (RFC 6749 section 2.3.1).
+	if clientID, err = url.QueryUnescape(username); err != nil {
+		return "", "", errors.New("client_id in the Authorization header is malformed")
+	}
+	if clientSecret, err = url.QueryUnescape(password); err != nil {
+		return "", "", errors.New("client_secret in the Authorization header is malformed")
+	}
+	if formClientID := r.PostForm.Get("client_id"); formClientID != "" && formClientID != clientID {
+		return "", "", errors.New("client_id does not match the Authorization header")
+	}
+	return clientID, clientSecret, nil
+}
+
+// ipAddressOf returns the client IP of a request, as found by the RequestMeta middleware.
+func ipAddressOf(r *http.Request) string {
+	if meta, ok := requestmeta.Get(r.Context()); ok {
+		return meta.IPAddress
+	}
+	return ""
+}
@@ -0,0 +1,241 @@
+package mailer_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var testMail = model.Mail{
+	To:      "user@example.com",
+	Subject: "Réinitialisez votre mot de passe",
+	Body:    "Follow this link:\n\nhttps://app.example.com/reset-password?token=abc\n",
+}
+
+// fakeSMTPServer accepts one SMTP session without extensions and returns the envelope and message it received.
+func fakeSMTPServer(t *testing.T) (addr string, received <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	ch := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+
+		var lines []string
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.Fields(line + " ")[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				lines = append(lines, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(line, "\r\n"))
+				}
+				reply("250 OK")
+			case "QUIT":
+				reply("221 bye")
+				ch <- lines
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return ln.Addr().String(), ch
+}
+
+// TestUnitSMTPMailer tests that mails are sent as UTF-8 plain text to the relay.
+func TestUnitSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	m, err := mailer.NewSMTPMailer(addr, "", "", "Example <no-reply@example.com>", false)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, m.Send(ctx, testMail))
+
+	lines := <-received
+	require.GreaterOrEqual(t, len(lines), 2)
+	assert.Equal(t, "MAIL FROM:<no-reply@example.com>", lines[0])
+	assert.Equal(t, "RCPT TO:<user@example.com>", lines[1])
+
+	msg, err := mail.ReadMessage(strings.NewReader(strings.Join(lines[2:], "\r\n")))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, testMail.Subject, subject)
+	assert.Equal(t, "<user@example.com>", msg.Header.Get("To"))
+	assert.Contains(t, msg.Header.Get("Message-Id"), "@example.com>")
+	body, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "token=3Dabc", "the body must be quoted-printable")
+}
+
+// TestUnitSMTPMailer_RequireTLS tests that mails are not sent in clear text through a relay that does not offer
+// STARTTLS when TLS is required.
+func TestUnitSMTPMailer_RequireTLS(t *testing.T) {
+	addr, _ := fakeSMTPServer(t)
+	m, err := mailer.NewSMTPMailer(addr, "", "", "no-reply@example.com", true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.ErrorIs(t, m.Send(ctx, testMail), mailer.ErrSTARTTLSNotOffered)
+}
+
+// TestUnitSMTPMailer_Invalid tests that mails that could inject headers or recipients are refused before sending.
+func TestUnitSMTPMailer_Invalid(t *testing.T) {
+	m, err := mailer.NewSMTPMailer("127.0.0.1:1", "", "", "no-reply@example.com", false)
+	require.NoError(t, err)
+
+	err = m.Send(context.Background(), model.Mail{To: "user@example.com", Subject: "Hi\r\nBcc: other@example.com"})
+	assert.Error(t, err)
+	err = m.Send(context.Background(), model.Mail{To: "user@example.com\r\nRCPT TO:<other@example.com>", Subject: "Hi"})
+	assert.Error(t, err)
+
+	_, err = mailer.NewSMTPMailer("localhost", "", "", "no-reply@example.com", false)
+	assert.Error(t, err, "the relay address needs a port")
+}
+
+// TestUnitFileOutbox tests that mails are appended to the outbox file as JSON lines.
+func TestUnitFileOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.jsonl")
+	o, err := mailer.NewFileOutbox(path)
+	require.NoError(t, err)
+
+	require.NoError(t, o.Send(context.Background(), testMail))
+	require.NoError(t, o.Send(context.Background(), model.Mail{To: "other@example.com", Subject: "Hi"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var entry struct {
+		SentAt  time.Time `json:"sent_at"`
+		To      string    `json:"to"`
+		Subject string    `json:"subject"`
+		Body    string    `json:"body"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, testMail.To, entry.To)
+	assert.Equal(t, testMail.Subject, entry.Subject)
+	assert.Equal(t, testMail.Body, entry.Body)
+	assert.WithinDuration(t, time.Now(), entry.SentAt, time.Minute)
+}
+
+// TestUnitOutbox tests that mails are kept in memory in the order they were sent.
+func TestUnitOutbox(t *testing.T) {
+	o := mailer.NewOutbox()
+	require.NoError(t, o.Send(context.Background(), testMail))
+	require.NoError(t, o.Send(context.Background(), model.Mail{To: "other@example.com"}))
+
+	mails := o.Mails()
+	require.Len(t, mails, 2)
+	assert.Equal(t, testMail, mails[0])
+	assert.Equal(t, "other@example.com", mails[1].To)
+}
+
+// blockingMailer is a mailer whose sends wait until it is released.
+type blockingMailer struct {
+	started chan struct{}
+	release chan struct{}
+	*mailer.Outbox
+}
+
+func newBlockingMailer() *blockingMailer {
+	return &blockingMailer{started: make(chan struct{}, 10), release: make(chan struct{}), Outbox: mailer.NewOutbox()}
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg model.Mail) error {
+	m.started <- struct{}{}
+	<-m.release
+	return m.Outbox.Send(ctx, msg)
+}
+
+// TestUnitQueue tests that queued mails are sent in order, and that mails are dropped rather than blocking when the
+// mailer does not keep up.
+func TestUnitQueue(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zap.ErrorLevel)
+	m := newBlockingMailer()
+	q := mailer.NewQueue(m, 2, zap.New(core))
+
+	// The first mail is being sent while the next two fill the queue.
+	require.NoError(t, q.Send(ctx, model.Mail{To: "a@example.com"}))
+	<-m.started
+	require.NoError(t, q.Send(ctx, testMail))
+	require.NoError(t, q.Send(ctx, model.Mail{To: "c@example.com"}))
+	start := time.Now()
+	assert.ErrorIs(t, q.Send(ctx, model.Mail{To: "d@example.com"}), mailer.ErrQueueFull)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "queueing must not block on the mailer")
+	assert.Equal(t, int64(1), q.Dropped())
+	assert.Equal(t, 1, logs.FilterMessageSnippet("Dropped email").Len())
+
+	close(m.release)
+	require.NoError(t, q.Close(ctx))
+	var to []string
+	for _, mail := range m.Mails() {
+		to = append(to, mail.To)
+	}
+	assert.Equal(t, []string{"a@example.com", testMail.To, "c@example.com"}, to)
+	assert.ErrorIs(t, q.Send(ctx, testMail), mailer.ErrQueueClosed)
+}
+
+// TestUnitQueue_SendFailure tests that mails the mailer fails to send are logged.
+func TestUnitQueue_SendFailure(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	q := mailer.NewQueue(failingMailer{}, 1, zap.New(core))
+	require.NoError(t, q.Send(context.Background(), testMail))
+	require.NoError(t, q.Close(context.Background()))
+
+	require.Equal(t, 1, logs.FilterMessageSnippet("Failed to send email").Len())
+}
+
+// failingMailer is a mailer that cannot send anything.
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, model.Mail) error {
+	return errors.New("relay unavailable")
+}
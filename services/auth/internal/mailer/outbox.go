@@ -0,0 +1,86 @@
+// Package mailer defines the outboxes that keep emails instead of sending them, for local development and tests.
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Outbox keeps sent emails in memory.
+type Outbox struct {
+	sync.Mutex
+	mails []model.Mail
+}
+
+// NewOutbox creates a new empty Outbox.
+func NewOutbox() *Outbox {
+	return &Outbox{}
+}
+
+// Send keeps a mail in the outbox.
+func (o *Outbox) Send(_ context.Context, msg model.Mail) error {
+	o.Lock()
+	defer o.Unlock()
+	o.mails = append(o.mails, msg)
+	return nil
+}
+
+// Mails returns the mails sent so far, oldest first.
+func (o *Outbox) Mails() []model.Mail {
+	o.Lock()
+	defer o.Unlock()
+	return slices.Clone(o.mails)
+}
+
+// FileOutbox appends sent emails to a file as JSON lines, e.g. for developers to follow with tail -f.
+type FileOutbox struct {
+	mu   sync.Mutex
+	path string
+}
+
+// outboxEntry is a line of the file of a FileOutbox.
+type outboxEntry struct {
+	SentAt  time.Time `json:"sent_at"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+}
+
+// NewFileOutbox creates a new FileOutbox appending to the file at path, which is created if needed.
+func NewFileOutbox(path string) (*FileOutbox, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open outbox: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("close outbox: %w", err)
+	}
+	return &FileOutbox{path: path}, nil
+}
+
+// Send appends a mail to the file of the outbox.
+func (o *FileOutbox) Send(_ context.Context, msg model.Mail) error {
+	line, err := json.Marshal(outboxEntry{SentAt: time.Now().UTC(), To: msg.To, Subject: msg.Subject, Body: msg.Body})
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f, err := os.OpenFile(o.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open outbox: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write outbox: %w", err)
+	}
+	return f.Close()
+}
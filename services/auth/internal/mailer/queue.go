@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/async"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"go.uber.org/zap"
+)
+
+// sendTimeout bounds how long the queue waits for its mailer to send one email.
+const sendTimeout = 30 * time.Second
+
+var (
+	// ErrQueueFull is returned when an email is dropped because the mailer is not keeping up.
+	ErrQueueFull = errors.New("mail queue full")
+	// ErrQueueClosed is returned when an email is sent after the queue was closed.
+	ErrQueueClosed = errors.New("mail queue closed")
+)
+
+// Mailer is the interface for the sender of emails.
+type Mailer interface {
+	Send(ctx context.Context, mail model.Mail) error
+}
+
+// Queue queues emails in memory and sends them through its mailer in the background, in the order they were
+// queued, so that requests never wait for the mail relay and take as long whether they send an email or not.
+// Queueing never blocks: when the queue is full, the email is dropped and logged.
+type Queue struct {
+	queue *async.Queue[model.Mail]
+}
+
+// NewQueue creates a new Queue of size emails sending through mailer. Emails that are dropped or cannot be sent
+// are logged to logger. Close it to send the emails still queued.
+func NewQueue(mailer Mailer, size int, logger *zap.Logger) *Queue {
+	return &Queue{queue: async.NewQueue(mailer.Send, async.Options[model.Mail]{
+		Size:    size,
+		Timeout: sendTimeout,
+		OnDropped: func(mail model.Mail, dropped int64) {
+			logger.Error("Dropped email, the mailer is not keeping up",
+				zap.String("subject", mail.Subject),
+				zap.Int64("dropped", dropped),
+			)
+		},
+		OnFailed: func(mail model.Mail, err error) {
+			logger.Error("Failed to send email", zap.String("subject", mail.Subject), zap.Error(err))
+		},
+	})}
+}
+
+// Send queues a mail for the mailer. The context of the request is not used, as the mail is sent after the
+// request is done.
+func (q *Queue) Send(_ context.Context, mail model.Mail) error {
+	err := q.queue.Push(mail)
+	switch {
+	case errors.Is(err, async.ErrQueueFull):
+		return ErrQueueFull
+	case errors.Is(err, async.ErrQueueClosed):
+		return ErrQueueClosed
+	}
+	return err
+}
+
+// Dropped returns how many emails were dropped since the queue was created.
+func (q *Queue) Dropped() int64 {
+	return q.queue.Dropped()
+}
+
+// Close stops accepting emails and waits until the queued ones are sent, or ctx is done.
+func (q *Queue) Close(ctx context.Context) error {
+	return q.queue.Close(ctx)
+}
@@ -0,0 +1,155 @@
+// Package mailer defines the SMTP mailer for the auth service.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// ErrSTARTTLSNotOffered is returned when TLS is required but the relay does not offer STARTTLS.
+var ErrSTARTTLSNotOffered = errors.New("SMTP relay does not offer STARTTLS")
+
+// SMTPMailer sends emails through an SMTP relay, upgrading the connection with STARTTLS when the relay offers it.
+type SMTPMailer struct {
+	addr       string
+	host       string
+	username   string
+	password   string
+	from       mail.Address
+	requireTLS bool
+}
+
+// NewSMTPMailer creates a new SMTPMailer sending from the from address through the relay at addr (host:port).
+// Without a username, mails are sent without authentication. With requireTLS, mails are not sent through a relay
+// that does not offer STARTTLS, rather than in clear text.
+func NewSMTPMailer(addr, username, password, from string, requireTLS bool) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP address %q: %w", addr, err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("sender %q: %w", from, err)
+	}
+	return &SMTPMailer{
+		addr:       addr,
+		host:       host,
+		username:   username,
+		password:   password,
+		from:       *sender,
+		requireTLS: requireTLS,
+	}, nil
+}
+
+// Send sends a mail, giving up when ctx is done.
+func (m *SMTPMailer) Send(ctx context.Context, msg model.Mail) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("recipient %q: %w", msg.To, err)
+	}
+	data, err := m.message(to, msg)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return fmt.Errorf("dial SMTP relay: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("set SMTP deadline: %w", err)
+		}
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return fmt.Errorf("SMTP handshake: %w", err)
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return fmt.Errorf("SMTP STARTTLS: %w", err)
+		}
+	} else if m.requireTLS {
+		return ErrSTARTTLSNotOffered
+	}
+	if m.username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection to a remote host.
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("SMTP AUTH: %w", err)
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP RCPT: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP DATA: %w", err)
+	}
+	return c.Quit()
+}
+
+// message formats a mail as a quoted-printable UTF-8 plain text message (RFC 5322).
+func (m *SMTPMailer) message(to *mail.Address, msg model.Mail) ([]byte, error) {
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+	messageID, err := m.messageID()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: %s\r\n", messageID)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	if _, err := w.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("encode mail body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encode mail body: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// messageID returns a random Message-ID in the domain of the sender.
+func (m *SMTPMailer) messageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	domain := m.from.Address[strings.LastIndex(m.from.Address, "@")+1:]
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
@@ -0,0 +1,80 @@
+// Package chimiddleware defines the bearer token middleware for the auth service.
+package chimiddleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// HeaderAuthorization is the header name for the bearer access token.
+	HeaderAuthorization = "Authorization"
+	// bearerPrefix is the scheme prefix of the Authorization header.
+	bearerPrefix = "Bearer "
+	// dpopPrefix is the scheme prefix of the Authorization header for DPoP bound access tokens.
+	dpopPrefix = "DPoP "
+)
+
+var (
+	// errMissingBearerToken is returned when a secured operation is called without a bearer token.
+	errMissingBearerToken = errors.New("missing bearer token")
+	// errUnsupportedSecurityScheme is returned for security schemes other than HTTP bearer.
+	errUnsupportedSecurityScheme = errors.New("unsupported security scheme")
+)
+
+// AccessTokenVerifier verifies an access token, including whether it has been revoked.
+type AccessTokenVerifier interface {
+	VerifyAccessToken(ctx context.Context, accessToken model.AccessToken) (*model.AccessTokenClaims, error)
+}
+
+// BearerToken adds the bearer or DPoP access token from the Authorization header to the context.
+// It does not verify the token; verification happens in the OpenAPI validator
+// (see NewBearerAuthenticationFunc) for the operations that declare bearerAuth.
+func BearerToken() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := bearerTokenFromRequest(r); ok {
+				r = r.WithContext(chimiddlewareutils.WithAccessToken(r.Context(), token))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NewBearerAuthenticationFunc creates an OpenAPI authentication function
+// that verifies the bearer access token of secured operations.
+func NewBearerAuthenticationFunc(verifier AccessTokenVerifier) openapi3filter.AuthenticationFunc {
+	return func(ctx context.Context, input *openapi3filter.AuthenticationInput) error {
+		scheme := input.SecurityScheme
+		if scheme == nil || scheme.Type != "http" || !strings.EqualFold(scheme.Scheme, "bearer") {
+			return errUnsupportedSecurityScheme
+		}
+
+		token, ok := bearerTokenFromRequest(input.RequestValidationInput.Request)
+		if !ok {
+			return errMissingBearerToken
+		}
+
+		_, err := verifier.VerifyAccessToken(ctx, model.AccessToken(token))
+		return err
+	}
+}
+
+// bearerTokenFromRequest returns the access token of the Authorization header. DPoP bound tokens are sent with
+// the DPoP scheme; whether they come with a matching proof is checked when they are verified.
+func bearerTokenFromRequest(r *http.Request) (string, bool) {
+	header := r.Header.Get(HeaderAuthorization)
+	for _, prefix := range []string{bearerPrefix, dpopPrefix} {
+		if len(header) >= len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+			token := strings.TrimSpace(header[len(prefix):])
+			return token, token != ""
+		}
+	}
+	return "", false
+}
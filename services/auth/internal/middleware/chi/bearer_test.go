@@ -0,0 +1,96 @@
+package chimiddleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	middleware "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi"
+	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+type fakeVerifier struct {
+	valid string
+}
+
+func (v fakeVerifier) VerifyAccessToken(_ context.Context, accessToken model.AccessToken) (*model.AccessTokenClaims, error) {
+	if string(accessToken) != v.valid {
+		return nil, errors.New("invalid token")
+	}
+	return &model.AccessTokenClaims{Subject: "member-1"}, nil
+}
+
+func TestUnitBearerToken_AddsTokenToContext(t *testing.T) {
+	var got string
+	var found bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, found = chimiddlewareutils.GetAccessToken(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logout", nil)
+	req.Header.Set(middleware.HeaderAuthorization, "Bearer abc.def.ghi")
+
+	middleware.BearerToken()(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if !found || got != "abc.def.ghi" {
+		t.Fatalf("expected token %q in context, got %q (found=%v)", "abc.def.ghi", got, found)
+	}
+}
+
+func TestUnitBearerToken_IgnoresOtherSchemes(t *testing.T) {
+	var found bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, found = chimiddlewareutils.GetAccessToken(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logout", nil)
+	req.Header.Set(middleware.HeaderAuthorization, "Basic dXNlcjpwYXNz")
+
+	middleware.BearerToken()(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if found {
+		t.Fatal("expected no access token in context for a non-bearer scheme")
+	}
+}
+
+func TestUnitNewBearerAuthenticationFunc(t *testing.T) {
+	auth := middleware.NewBearerAuthenticationFunc(fakeVerifier{valid: "good-token"})
+	scheme := &openapi3.SecurityScheme{Type: "http", Scheme: "bearer"}
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{name: "valid token", header: "Bearer good-token", wantErr: false},
+		{name: "lowercase scheme", header: "bearer good-token", wantErr: false},
+		{name: "DPoP scheme", header: "DPoP good-token", wantErr: false},
+		{name: "invalid token", header: "Bearer bad-token", wantErr: true},
+		{name: "missing header", header: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/logout", nil)
+			if tt.header != "" {
+				req.Header.Set(middleware.HeaderAuthorization, tt.header)
+			}
+
+			err := auth(context.Background(), &openapi3filter.AuthenticationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req},
+				SecuritySchemeName:     "bearerAuth",
+				SecurityScheme:         scheme,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
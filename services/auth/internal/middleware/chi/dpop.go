@@ -0,0 +1,46 @@
+// Package chimiddleware defines the DPoP proof middleware for the auth service.
+package chimiddleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+)
+
+// errMultipleDPoPProofs is returned for requests with more than one DPoP header (RFC 9449 section 4.3).
+var errMultipleDPoPProofs = fmt.Errorf("%w: multiple DPoP headers", dpop.ErrInvalidProof)
+
+// DPoPProofValidator validates the DPoP proof of a request.
+type DPoPProofValidator interface {
+	Validate(ctx context.Context, proof, method, requestURL string) (*dpop.Proof, error)
+}
+
+// DPoPProof validates the DPoP proof (RFC 9449) of the DPoP header, if any, and adds it to the context.
+// Like BearerToken, it does not reject the request: an invalid proof is added to the context with its error,
+// and the service decides whether the endpoint needs a proof. A proof is validated only once per request, since
+// its jti is recorded against replays. With trustForwardedHeaders, proofs are checked against the scheme and host
+// of the X-Forwarded-Proto and X-Forwarded-Host headers, which only a proxy that overwrites them may be trusted for.
+func DPoPProof(validator DPoPProofValidator, trustForwardedHeaders bool) func(next http.Handler) http.Handler {
+	requestURL := dpop.RequestURL
+	if trustForwardedHeaders {
+		requestURL = dpop.ForwardedRequestURL
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proofs := r.Header.Values(dpop.HeaderName)
+			if len(proofs) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var proof *dpop.Proof
+			err := errMultipleDPoPProofs
+			if len(proofs) == 1 {
+				proof, err = validator.Validate(r.Context(), proofs[0], r.Method, requestURL(r))
+			}
+			next.ServeHTTP(w, r.WithContext(dpop.WithProof(r.Context(), proof, err)))
+		})
+	}
+}
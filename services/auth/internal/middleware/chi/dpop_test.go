@@ -0,0 +1,107 @@
+package chimiddleware_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	middleware "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+)
+
+func TestUnitDPoPProof(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := dpop.Thumbprint(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProof := func(url string) string {
+		proof, err := dpop.NewProof(key, http.MethodPost, url, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+
+	tests := []struct {
+		name      string
+		proofs    []string
+		untrusted bool
+		wantJKT   string
+		wantErr   error
+	}{
+		{name: "no proof"},
+		{name: "valid proof", proofs: []string{newProof("https://auth.example.com/v1/login")}, wantJKT: jkt},
+		{name: "proof for another URL", proofs: []string{newProof("https://auth.example.com/v1/refresh")}, wantErr: dpop.ErrInvalidProof},
+		{name: "several proofs", proofs: []string{newProof("https://auth.example.com/v1/login"), newProof("https://auth.example.com/v1/login")}, wantErr: dpop.ErrInvalidProof},
+		{name: "forwarded headers not trusted", proofs: []string{newProof("https://auth.example.com/v1/login")}, untrusted: true, wantErr: dpop.ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotProof *dpop.Proof
+			var gotErr error
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotProof, gotErr = dpop.GetProof(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+			req.Host = "auth.example.com"
+			req.Header.Set("X-Forwarded-Proto", "https")
+			for _, proof := range tt.proofs {
+				req.Header.Add(dpop.HeaderName, proof)
+			}
+
+			middleware.DPoPProof(dpop.NewValidator(dpop.NewMemoryReplayCache()), !tt.untrusted)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+			if tt.wantErr != nil {
+				if !errors.Is(gotErr, tt.wantErr) || gotProof != nil {
+					t.Fatalf("expected error %v and no proof, got %v and %v", tt.wantErr, gotErr, gotProof)
+				}
+				return
+			}
+			if gotErr != nil {
+				t.Fatalf("expected no error, got %v", gotErr)
+			}
+			switch {
+			case tt.wantJKT == "" && gotProof != nil:
+				t.Fatalf("expected no proof in context, got %+v", gotProof)
+			case tt.wantJKT != "" && (gotProof == nil || gotProof.JKT != tt.wantJKT):
+				t.Fatalf("expected proof with jkt %q in context, got %+v", tt.wantJKT, gotProof)
+			}
+		})
+	}
+}
+
+// unavailableValidator fails like a validator whose replay cache cannot be reached.
+type unavailableValidator struct{}
+
+func (unavailableValidator) Validate(context.Context, string, string, string) (*dpop.Proof, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUnitDPoPProof_ReplayCacheUnavailable(t *testing.T) {
+	var gotErr error
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, gotErr = dpop.GetProof(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/login", nil)
+	req.Header.Set(dpop.HeaderName, "proof")
+
+	middleware.DPoPProof(unavailableValidator{}, false)(next).ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotErr == nil || errors.Is(gotErr, dpop.ErrInvalidProof) {
+		t.Fatalf("expected an error other than an invalid proof, got %v", gotErr)
+	}
+}
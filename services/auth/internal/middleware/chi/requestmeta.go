@@ -5,7 +5,7 @@ import (
 	"net"
 	"net/http"
 
-	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
 )
 
 const (
@@ -17,12 +17,12 @@ const (
 func RequestMeta() func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			meta := chimiddlewareutils.RequestMeta{
+			meta := requestmeta.Meta{
 				RequestID: r.Header.Get(HeaderRequestID), // Envoy generated/inherited
 				UserAgent: r.UserAgent(),
 				IPAddress: getClientIP(r),
 			}
-			ctx := chimiddlewareutils.WithRequestMeta(r.Context(), meta)
+			ctx := requestmeta.With(r.Context(), meta)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
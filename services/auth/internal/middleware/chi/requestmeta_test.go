@@ -5,12 +5,12 @@ import (
 	"net/http/httptest"
 	"testing"
 
-	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
 )
 
 func TestRequestMeta_PopulatesContextFromHeaderAndRequest(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		meta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+		meta, ok := requestmeta.Get(r.Context())
 		if !ok {
 			t.Fatal("expected request meta in context")
 		}
@@ -45,7 +45,7 @@ func TestRequestMeta_PopulatesContextFromHeaderAndRequest(t *testing.T) {
 
 func TestRequestMeta_IPPrefersXForwardedForOverXRealIPAndRemoteAddr(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		meta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+		meta, ok := requestmeta.Get(r.Context())
 		if !ok {
 			t.Fatal("expected request meta in context")
 		}
@@ -72,7 +72,7 @@ func TestRequestMeta_IPPrefersXForwardedForOverXRealIPAndRemoteAddr(t *testing.T
 
 func TestRequestMeta_IPFallsBackToXRealIPWhenNoXForwardedFor(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		meta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+		meta, ok := requestmeta.Get(r.Context())
 		if !ok {
 			t.Fatal("expected request meta in context")
 		}
@@ -98,7 +98,7 @@ func TestRequestMeta_IPFallsBackToXRealIPWhenNoXForwardedFor(t *testing.T) {
 
 func TestRequestMeta_IPFallsBackToRemoteAddrAndStripsPort(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		meta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+		meta, ok := requestmeta.Get(r.Context())
 		if !ok {
 			t.Fatal("expected request meta in context")
 		}
@@ -123,7 +123,7 @@ func TestRequestMeta_IPFallsBackToRemoteAddrAndStripsPort(t *testing.T) {
 
 func TestRequestMeta_IPUsesRemoteAddrAsIsWhenSplitHostPortFails(t *testing.T) {
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		meta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+		meta, ok := requestmeta.Get(r.Context())
 		if !ok {
 			t.Fatal("expected request meta in context")
 		}
@@ -0,0 +1,16 @@
+package chimiddlewareutils
+
+import "context"
+
+type accessTokenKey struct{}
+
+// WithAccessToken adds the raw bearer access token to the context.
+func WithAccessToken(ctx context.Context, accessToken string) context.Context {
+	return context.WithValue(ctx, accessTokenKey{}, accessToken)
+}
+
+// GetAccessToken gets the raw bearer access token from the context.
+func GetAccessToken(ctx context.Context) (string, bool) {
+	accessToken, ok := ctx.Value(accessTokenKey{}).(string)
+	return accessToken, ok && accessToken != ""
+}
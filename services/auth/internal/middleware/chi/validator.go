@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/getkin/kin-openapi/openapi3filter"
 	nethttpmiddleware "github.com/oapi-codegen/nethttp-middleware"
 )
 
@@ -14,6 +15,8 @@ type ValidatorConfig struct {
 	ProdMode  bool
 	ProdError string
 	Logger    func(format string, args ...any)
+	// AuthenticationFunc verifies the security requirements of secured operations.
+	AuthenticationFunc openapi3filter.AuthenticationFunc
 }
 
 // NewValidatorOptions creates a new validator options.
@@ -28,6 +31,9 @@ func NewValidatorOptions(cfg ValidatorConfig) *nethttpmiddleware.Options {
 	}
 
 	return &nethttpmiddleware.Options{
+		Options: openapi3filter.Options{
+			AuthenticationFunc: cfg.AuthenticationFunc,
+		},
 		ErrorHandler: func(w http.ResponseWriter, message string, statusCode int) {
 			cfg.Logger("validation error (%d): %s", statusCode, message)
 
@@ -39,10 +45,20 @@ func NewValidatorOptions(cfg ValidatorConfig) *nethttpmiddleware.Options {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(statusCode)
 
-			// Best-effort JSON response; fall back to plain text on error.
-			if err := json.NewEncoder(w).Encode(map[string]string{"error": errMsg}); err != nil {
+			// Best-effort JSON response in the ErrorResponse format; fall back to plain text on error.
+			body := map[string]string{"error_code": validationErrorCode(statusCode), "message": errMsg}
+			if err := json.NewEncoder(w).Encode(body); err != nil {
 				http.Error(w, errMsg, statusCode)
 			}
 		},
 	}
 }
+
+// validationErrorCode returns the error_code of a request rejected by the validator.
+func validationErrorCode(statusCode int) string {
+	if statusCode == http.StatusUnauthorized {
+		// The security requirements of the operation were not met.
+		return "invalid_token"
+	}
+	return "invalid_request"
+}
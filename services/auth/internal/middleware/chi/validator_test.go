@@ -45,8 +45,11 @@ func TestUnitNewValidatorOptions_ProdMode(t *testing.T) {
 		t.Fatalf("failed to unmarshal response body: %v", err)
 	}
 
-	if body["error"] != "invalid request (prod)" {
-		t.Fatalf("expected error %q, got %q", "invalid request (prod)", body["error"])
+	if body["message"] != "invalid request (prod)" {
+		t.Fatalf("expected message %q, got %q", "invalid request (prod)", body["message"])
+	}
+	if body["error_code"] != "invalid_request" {
+		t.Fatalf("expected error_code %q, got %q", "invalid_request", body["error_code"])
 	}
 
 	if !strings.Contains(logged, "validation error (400): detailed dev error message") {
@@ -85,8 +88,8 @@ func TestUnitNewValidatorOptions_DevMode(t *testing.T) {
 		t.Fatalf("failed to unmarshal response body: %v", err)
 	}
 
-	if body["error"] != msg {
-		t.Fatalf("expected error %q, got %q", msg, body["error"])
+	if body["message"] != msg {
+		t.Fatalf("expected message %q, got %q", msg, body["message"])
 	}
 
 	if !strings.Contains(logged, "validation error (422): some detailed validation error") {
@@ -120,7 +123,30 @@ func TestUnitNewValidatorOptions_Defaults(t *testing.T) {
 	}
 
 	// Default ProdError should be "invalid request"
-	if body["error"] != "invalid request" {
-		t.Fatalf("expected default error %q, got %q", "invalid request", body["error"])
+	if body["message"] != "invalid request" {
+		t.Fatalf("expected default message %q, got %q", "invalid request", body["message"])
+	}
+}
+
+func TestUnitNewValidatorOptions_Unauthorized(t *testing.T) {
+	opts := middleware.NewValidatorOptions(middleware.ValidatorConfig{
+		ProdMode: true,
+		Logger:   func(string, ...any) {},
+	})
+
+	rr := httptest.NewRecorder()
+	opts.ErrorHandler(rr, "security requirements failed", http.StatusUnauthorized)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to unmarshal response body: %v", err)
+	}
+
+	if body["error_code"] != "invalid_token" {
+		t.Fatalf("expected error_code %q, got %q", "invalid_token", body["error_code"])
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	chimiddlewareutils "github.com/incheat/go-production-backend/services/auth/internal/middleware/chi/utils"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
 	"go.uber.org/zap"
 )
 
@@ -21,7 +22,7 @@ func ZapLogger(logger *zap.Logger) func(next http.Handler) http.Handler {
 
 			// Create a logger with the request ID
 			var reqID string
-			requestMeta, ok := chimiddlewareutils.GetRequestMeta(r.Context())
+			requestMeta, ok := requestmeta.Get(r.Context())
 			if !ok || requestMeta.RequestID == "" {
 				reqID = "-"
 			} else {
@@ -0,0 +1,58 @@
+// Package notifier defines the notifiers that deliver passwordless login codes and links to members.
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Mailer is the interface for the sender of emails to members.
+type Mailer interface {
+	Send(ctx context.Context, mail model.Mail) error
+}
+
+// MailNotifier delivers passwordless logins by email.
+type MailNotifier struct {
+	mailer Mailer
+}
+
+// NewMailNotifier creates a new MailNotifier sending through mailer.
+func NewMailNotifier(mailer Mailer) *MailNotifier {
+	return &MailNotifier{mailer: mailer}
+}
+
+// NotifyLogin emails the code or link of a passwordless login to the member.
+func (n *MailNotifier) NotifyLogin(ctx context.Context, notification model.LoginNotification) error {
+	minutes := max(1, int(time.Until(notification.ExpiresAt).Round(time.Minute)/time.Minute))
+
+	var mail model.Mail
+	switch {
+	case notification.Code != "":
+		mail = model.Mail{
+			To:      notification.To,
+			Subject: "Your login code",
+			Body: fmt.Sprintf("Enter this code within %d minutes to log in:\n\n%s\n\n"+
+				"If you did not try to log in, ignore this email; nobody can log in without the code.\n",
+				minutes, notification.Code),
+		}
+	case notification.Link != "":
+		mail = model.Mail{
+			To:      notification.To,
+			Subject: "Your login link",
+			Body: fmt.Sprintf("Follow this link within %d minutes to log in:\n\n%s\n\n"+
+				"If you did not try to log in, ignore this email; nobody can log in without the link.\n",
+				minutes, notification.Link),
+		}
+	default:
+		return errors.New("login notification without code or link")
+	}
+
+	if err := n.mailer.Send(ctx, mail); err != nil {
+		return fmt.Errorf("send login email: %w", err)
+	}
+	return nil
+}
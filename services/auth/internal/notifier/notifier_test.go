@@ -0,0 +1,54 @@
+package notifier_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitMailNotifier tests that login codes and links are emailed to the member.
+func TestUnitMailNotifier(t *testing.T) {
+	ctx := context.Background()
+	outbox := mailer.NewOutbox()
+	n := notifier.NewMailNotifier(outbox)
+	expiresAt := time.Now().Add(10 * time.Minute)
+
+	require.NoError(t, n.NotifyLogin(ctx, model.LoginNotification{To: "user@example.com", Code: "012345", ExpiresAt: expiresAt}))
+	require.NoError(t, n.NotifyLogin(ctx, model.LoginNotification{
+		To:        "user@example.com",
+		Link:      "https://app.example.com/login/email?challenge=abc&code=def",
+		ExpiresAt: expiresAt,
+	}))
+
+	mails := outbox.Mails()
+	require.Len(t, mails, 2)
+	assert.Equal(t, "user@example.com", mails[0].To)
+	assert.Equal(t, "Your login code", mails[0].Subject)
+	assert.NotContains(t, mails[0].Subject, "012345", "codes must not show in notification previews")
+	assert.Contains(t, mails[0].Body, "012345")
+	assert.Contains(t, mails[0].Body, "within 10 minutes")
+	assert.Equal(t, "Your login link", mails[1].Subject)
+	assert.Contains(t, mails[1].Body, "https://app.example.com/login/email?challenge=abc&code=def")
+
+	err := n.NotifyLogin(ctx, model.LoginNotification{To: "user@example.com", ExpiresAt: expiresAt})
+	assert.Error(t, err)
+	assert.Len(t, outbox.Mails(), 2)
+}
+
+// TestUnitOutbox tests that notifications are kept in memory in the order they were delivered.
+func TestUnitOutbox(t *testing.T) {
+	o := notifier.NewOutbox()
+	require.NoError(t, o.NotifyLogin(context.Background(), model.LoginNotification{To: "user@example.com", Code: "012345"}))
+	require.NoError(t, o.NotifyLogin(context.Background(), model.LoginNotification{To: "other@example.com"}))
+
+	notifications := o.Notifications()
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "012345", notifications[0].Code)
+	assert.Equal(t, "other@example.com", notifications[1].To)
+}
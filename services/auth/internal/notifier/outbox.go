@@ -0,0 +1,36 @@
+// Package notifier defines the outbox that keeps passwordless logins instead of delivering them, for tests.
+package notifier
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Outbox keeps login notifications in memory.
+type Outbox struct {
+	sync.Mutex
+	notifications []model.LoginNotification
+}
+
+// NewOutbox creates a new empty Outbox.
+func NewOutbox() *Outbox {
+	return &Outbox{}
+}
+
+// NotifyLogin keeps a login notification in the outbox.
+func (o *Outbox) NotifyLogin(_ context.Context, notification model.LoginNotification) error {
+	o.Lock()
+	defer o.Unlock()
+	o.notifications = append(o.notifications, notification)
+	return nil
+}
+
+// Notifications returns the notifications delivered so far, oldest first.
+func (o *Outbox) Notifications() []model.LoginNotification {
+	o.Lock()
+	defer o.Unlock()
+	return slices.Clone(o.notifications)
+}
@@ -0,0 +1,232 @@
+// Package passkey defines the WebAuthn relying party for the auth service.
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// ceremonyTimeout is how long the authenticator, and the relying party, wait for a ceremony to complete.
+const ceremonyTimeout = 5 * time.Minute
+
+var (
+	// ErrUnsupportedAttestation is returned when a credential is registered with an attestation format other than
+	// "none" or "packed".
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	// ErrSignCountRegression is returned when the signature counter of an assertion did not increase,
+	// a sign that the credential private key may have been cloned.
+	ErrSignCountRegression = errors.New("signature counter did not increase")
+)
+
+// supportedAttestationFormats are the attestation formats credentials are accepted with.
+var supportedAttestationFormats = []protocol.AttestationFormat{
+	protocol.AttestationFormatNone,
+	protocol.AttestationFormatPacked,
+}
+
+// RelyingParty runs the server side of WebAuthn registration and authentication ceremonies.
+// Ceremony state and options are exchanged as opaque JSON so that callers do not depend on the WebAuthn library.
+type RelyingParty struct {
+	webauthn *webauthn.WebAuthn
+}
+
+// NewRelyingParty creates a new RelyingParty for the relying party ID (a registrable domain),
+// its display name and the origins ceremonies may be run from.
+func NewRelyingParty(rpID, rpName string, origins []string) (*RelyingParty, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:                  rpID,
+		RPDisplayName:         rpName,
+		RPOrigins:             origins,
+		AttestationPreference: protocol.PreferNoAttestation,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout, TimeoutUVD: ceremonyTimeout},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: ceremonyTimeout, TimeoutUVD: ceremonyTimeout},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RelyingParty{webauthn: w}, nil
+}
+
+// BeginRegistration starts the registration of a discoverable credential for a member,
+// excluding the credentials the member already registered.
+// It returns the PublicKeyCredentialCreationOptions for the client and the ceremony state to keep until it finishes.
+func (rp *RelyingParty) BeginRegistration(memberID string, userHandle []byte, existing []*model.PasskeyCredential) (options, session []byte, err error) {
+	u := &user{memberID: memberID, handle: userHandle}
+	exclusions := make([]protocol.CredentialDescriptor, len(existing))
+	for i, c := range existing {
+		exclusions[i] = toCredential(c).Descriptor()
+	}
+
+	creation, data, err := rp.webauthn.BeginRegistration(u,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithAttestationFormats(supportedAttestationFormats),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("begin registration: %w", err)
+	}
+	return marshalCeremony(creation, data)
+}
+
+// FinishRegistration verifies the attestation of a new credential against the ceremony state
+// and returns the credential to store.
+func (rp *RelyingParty) FinishRegistration(memberID string, session, response []byte) (*model.PasskeyCredential, error) {
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session, &data); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("parse attestation: %w", err)
+	}
+	if !slices.Contains(supportedAttestationFormats, protocol.AttestationFormat(parsed.Response.AttestationObject.Format)) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAttestation, parsed.Response.AttestationObject.Format)
+	}
+
+	credential, err := rp.webauthn.CreateCredential(&user{memberID: memberID, handle: data.UserID}, data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("verify attestation: %w", describe(err))
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+	return &model.PasskeyCredential{
+		ID:              credential.ID,
+		MemberID:        memberID,
+		UserHandle:      data.UserID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}, nil
+}
+
+// BeginLogin starts a login with a discoverable credential, whose member is only known once the client answers.
+// It returns the PublicKeyCredentialRequestOptions for the client and the ceremony state to keep until it finishes.
+func (rp *RelyingParty) BeginLogin() (options, session []byte, err error) {
+	assertion, data, err := rp.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("begin login: %w", err)
+	}
+	return marshalCeremony(assertion, data)
+}
+
+// AssertionCredentialID returns the ID of the credential an assertion claims to be signed with,
+// so that the credential can be looked up before the assertion is verified.
+func (rp *RelyingParty) AssertionCredentialID(response []byte) ([]byte, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("parse assertion: %w", err)
+	}
+	return parsed.RawID, nil
+}
+
+// FinishLogin verifies an assertion against the ceremony state and the stored credential it was signed with,
+// and returns the credential updated with the new signature counter and backup state.
+// An assertion whose signature counter did not increase is rejected with ErrSignCountRegression.
+func (rp *RelyingParty) FinishLogin(session, response []byte, credential *model.PasskeyCredential) (*model.PasskeyCredential, error) {
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session, &data); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("parse assertion: %w", err)
+	}
+
+	u := &user{memberID: credential.MemberID, handle: credential.UserHandle, credentials: []webauthn.Credential{toCredential(credential)}}
+	_, verified, err := rp.webauthn.ValidatePasskeyLogin(func(_, _ []byte) (webauthn.User, error) {
+		return u, nil
+	}, data, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("verify assertion: %w", describe(err))
+	}
+	if verified.Authenticator.CloneWarning {
+		return nil, ErrSignCountRegression
+	}
+
+	updated := *credential
+	updated.SignCount = verified.Authenticator.SignCount
+	updated.BackupState = verified.Flags.BackupState
+	return &updated, nil
+}
+
+// marshalCeremony marshals the client options and the state of a ceremony.
+func marshalCeremony(options any, session *webauthn.SessionData) ([]byte, []byte, error) {
+	o, err := json.Marshal(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("json.Marshal error: %w", err)
+	}
+	s, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, fmt.Errorf("json.Marshal error: %w", err)
+	}
+	return o, s, nil
+}
+
+// describe adds the details of a WebAuthn protocol error, which its message leaves out, for logging.
+func describe(err error) error {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.DevInfo != "" {
+		return fmt.Errorf("%w: %s", err, perr.DevInfo)
+	}
+	return err
+}
+
+// toCredential converts a stored credential to the credential record of the WebAuthn library.
+func toCredential(c *model.PasskeyCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+	for i, t := range c.Transports {
+		transports[i] = protocol.AuthenticatorTransport(t)
+	}
+	return webauthn.Credential{
+		ID:              c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
+
+// user is a member as a WebAuthn user entity.
+type user struct {
+	memberID    string
+	handle      []byte
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID returns the user handle.
+func (u *user) WebAuthnID() []byte { return u.handle }
+
+// WebAuthnName returns the member ID.
+func (u *user) WebAuthnName() string { return u.memberID }
+
+// WebAuthnDisplayName returns the member ID.
+func (u *user) WebAuthnDisplayName() string { return u.memberID }
+
+// WebAuthnCredentials returns the credentials the user may log in with.
+func (u *user) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
@@ -0,0 +1,202 @@
+package passkey_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/passkey"
+	"github.com/incheat/go-production-backend/services/auth/internal/passkey/passkeytest"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOrigin = "https://example.com"
+
+func newRelyingParty(t *testing.T) *passkey.RelyingParty {
+	t.Helper()
+	rp, err := passkey.NewRelyingParty("example.com", "Example", []string{testOrigin})
+	require.NoError(t, err)
+	return rp
+}
+
+// register registers the passkey of authenticator for memberID.
+func register(t *testing.T, rp *passkey.RelyingParty, authenticator *passkeytest.Authenticator, memberID string) *model.PasskeyCredential {
+	t.Helper()
+	options, session, err := rp.BeginRegistration(memberID, []byte("user-handle"), nil)
+	require.NoError(t, err)
+	response, err := authenticator.Register(options)
+	require.NoError(t, err)
+	credential, err := rp.FinishRegistration(memberID, session, response)
+	require.NoError(t, err)
+	return credential
+}
+
+// login runs a login ceremony with the passkey of authenticator against a stored credential.
+func login(t *testing.T, rp *passkey.RelyingParty, authenticator *passkeytest.Authenticator, credential *model.PasskeyCredential) (*model.PasskeyCredential, error) {
+	t.Helper()
+	options, session, err := rp.BeginLogin()
+	require.NoError(t, err)
+	response, err := authenticator.Login(options)
+	require.NoError(t, err)
+	return rp.FinishLogin(session, response, credential)
+}
+
+// TestUnitRegistration tests the registration ceremony with the supported attestation formats.
+func TestUnitRegistration(t *testing.T) {
+	for _, format := range []string{"none", "packed"} {
+		t.Run(format, func(t *testing.T) {
+			rp := newRelyingParty(t)
+			authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+			require.NoError(t, err)
+			authenticator.AttestationFormat = format
+
+			credential := register(t, rp, authenticator, "user@example.com")
+			assert.Equal(t, authenticator.CredentialID(), credential.ID)
+			assert.Equal(t, "user@example.com", credential.MemberID)
+			assert.Equal(t, []byte("user-handle"), credential.UserHandle)
+			assert.Equal(t, format, credential.AttestationType)
+			assert.NotEmpty(t, credential.PublicKey)
+		})
+	}
+}
+
+// TestUnitRegistration_Options tests that registrations ask for a discoverable, user verified credential
+// and exclude the credentials already registered.
+func TestUnitRegistration_Options(t *testing.T) {
+	rp := newRelyingParty(t)
+	existing := []*model.PasskeyCredential{{ID: []byte("existing")}}
+
+	options, _, err := rp.BeginRegistration("user@example.com", []byte("user-handle"), existing)
+	require.NoError(t, err)
+
+	var o struct {
+		PublicKey struct {
+			RP struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			AuthenticatorSelection struct {
+				ResidentKey      string `json:"residentKey"`
+				UserVerification string `json:"userVerification"`
+			} `json:"authenticatorSelection"`
+			ExcludeCredentials []struct {
+				ID string `json:"id"`
+			} `json:"excludeCredentials"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(options, &o))
+	assert.Equal(t, "example.com", o.PublicKey.RP.ID)
+	assert.Equal(t, "required", o.PublicKey.AuthenticatorSelection.ResidentKey)
+	assert.Equal(t, "required", o.PublicKey.AuthenticatorSelection.UserVerification)
+	require.Len(t, o.PublicKey.ExcludeCredentials, 1)
+	assert.Equal(t, "ZXhpc3Rpbmc", o.PublicKey.ExcludeCredentials[0].ID)
+}
+
+// TestUnitRegistration_Errors tests that attestations not answering the ceremony are rejected.
+func TestUnitRegistration_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(a *passkeytest.Authenticator)
+		wantErr error
+	}{
+		{
+			name:   "wrong origin",
+			modify: func(a *passkeytest.Authenticator) { a.Origin = "https://evil.example" },
+		},
+		{
+			name:    "unsupported attestation format",
+			modify:  func(a *passkeytest.Authenticator) { a.AttestationFormat = "fido-u2f" },
+			wantErr: passkey.ErrUnsupportedAttestation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newRelyingParty(t)
+			authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+			require.NoError(t, err)
+			tt.modify(authenticator)
+
+			options, session, err := rp.BeginRegistration("user@example.com", []byte("user-handle"), nil)
+			require.NoError(t, err)
+			response, err := authenticator.Register(options)
+			require.NoError(t, err)
+
+			_, err = rp.FinishRegistration("user@example.com", session, response)
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestUnitLogin tests the login ceremony and its signature counter checks.
+func TestUnitLogin(t *testing.T) {
+	t.Run("success updates the sign count", func(t *testing.T) {
+		rp := newRelyingParty(t)
+		authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		authenticator.SignCount = 1
+		credential := register(t, rp, authenticator, "user@example.com")
+
+		updated, err := login(t, rp, authenticator, credential)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), updated.SignCount)
+		assert.Equal(t, uint32(1), credential.SignCount, "stored credential is not modified")
+	})
+
+	t.Run("authenticator without counter", func(t *testing.T) {
+		rp := newRelyingParty(t)
+		authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		credential := register(t, rp, authenticator, "user@example.com")
+
+		updated, err := login(t, rp, authenticator, credential)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0), updated.SignCount)
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		rp := newRelyingParty(t)
+		authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		authenticator.SignCount = 1
+		credential := register(t, rp, authenticator, "user@example.com")
+		credential.SignCount = 10 // a clone has been used more often
+
+		_, err = login(t, rp, authenticator, credential)
+		assert.ErrorIs(t, err, passkey.ErrSignCountRegression)
+	})
+
+	t.Run("other passkey", func(t *testing.T) {
+		rp := newRelyingParty(t)
+		authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		credential := register(t, rp, authenticator, "user@example.com")
+
+		other, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		register(t, rp, other, "user@example.com")
+
+		_, err = login(t, rp, other, credential)
+		assert.Error(t, err)
+	})
+
+	t.Run("answer to another challenge", func(t *testing.T) {
+		rp := newRelyingParty(t)
+		authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+		require.NoError(t, err)
+		credential := register(t, rp, authenticator, "user@example.com")
+
+		_, session, err := rp.BeginLogin()
+		require.NoError(t, err)
+		otherOptions, _, err := rp.BeginLogin()
+		require.NoError(t, err)
+		response, err := authenticator.Login(otherOptions)
+		require.NoError(t, err)
+
+		_, err = rp.FinishLogin(session, response, credential)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,213 @@
+// Package passkeytest defines a software WebAuthn authenticator for testing passkey ceremonies.
+package passkeytest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator flags of authenticator data.
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// cborEncoding is the CTAP2 canonical CBOR encoding authenticators use.
+var cborEncoding = func() cbor.EncMode {
+	em, err := cbor.CTAP2EncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// Authenticator is a software authenticator holding a single ES256 passkey.
+// It answers the options of a relying party with the JSON a browser would post back.
+type Authenticator struct {
+	// Origin is the origin the client runs the ceremonies from.
+	Origin string
+	// AttestationFormat is "none" (the default) or "packed" (self attestation).
+	AttestationFormat string
+	// SignCount is the signature counter, incremented before each assertion unless zero.
+	SignCount uint32
+
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	rpID         string
+}
+
+// NewAuthenticator creates a new Authenticator used from origin.
+func NewAuthenticator(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &Authenticator{Origin: origin, key: key, credentialID: credentialID}, nil
+}
+
+// CredentialID returns the ID of the passkey.
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// Register creates the passkey for PublicKeyCredentialCreationOptions and returns the attestation response.
+func (a *Authenticator) Register(options []byte) ([]byte, error) {
+	var o struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			RP        struct {
+				ID string `json:"id"`
+			} `json:"rp"`
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &o); err != nil {
+		return nil, fmt.Errorf("parse creation options: %w", err)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(o.PublicKey.User.ID)
+	if err != nil {
+		return nil, fmt.Errorf("decode user handle: %w", err)
+	}
+	a.rpID = o.PublicKey.RP.ID
+	a.userHandle = userHandle
+
+	clientData, err := a.clientDataJSON("webauthn.create", o.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := a.attestedAuthenticatorData()
+	if err != nil {
+		return nil, err
+	}
+
+	format := a.AttestationFormat
+	if format == "" {
+		format = "none"
+	}
+	statement := map[string]any{}
+	if format == "packed" {
+		signature, err := a.sign(authData, clientData)
+		if err != nil {
+			return nil, err
+		}
+		statement = map[string]any{"alg": -7, "sig": signature}
+	}
+	attestationObject, err := cborEncoding.Marshal(map[string]any{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode attestation object: %w", err)
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"attestationObject": encode(attestationObject),
+	})
+}
+
+// Login signs the challenge of PublicKeyCredentialRequestOptions with the passkey and returns the assertion response.
+func (a *Authenticator) Login(options []byte) ([]byte, error) {
+	var o struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	if err := json.Unmarshal(options, &o); err != nil {
+		return nil, fmt.Errorf("parse request options: %w", err)
+	}
+	if a.SignCount > 0 {
+		a.SignCount++
+	}
+
+	clientData, err := a.clientDataJSON("webauthn.get", o.PublicKey.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified)
+	signature, err := a.sign(authData, clientData)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.credentialJSON(map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+// clientDataJSON returns the client data of a ceremony.
+func (a *Authenticator) clientDataJSON(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.Origin,
+	})
+}
+
+// authenticatorData returns the authenticator data of an assertion.
+func (a *Authenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount)
+}
+
+// attestedAuthenticatorData returns the authenticator data of a registration, carrying the public key.
+func (a *Authenticator) attestedAuthenticatorData() ([]byte, error) {
+	publicKey, err := cborEncoding.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode public key: %w", err)
+	}
+
+	data := a.authenticatorData(flagUserPresent | flagUserVerified | flagAttestedCredentialData)
+	data = append(data, make([]byte, 16)...) // zero AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...), nil
+}
+
+// sign signs authenticator data and the hash of the client data with the passkey.
+func (a *Authenticator) sign(authData, clientData []byte) ([]byte, error) {
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData[:len(authData):len(authData)], clientDataHash[:]...))
+	return ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+}
+
+// credentialJSON returns the PublicKeyCredential JSON of a response.
+func (a *Authenticator) credentialJSON(response map[string]string) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
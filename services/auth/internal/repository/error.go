@@ -8,4 +8,38 @@ var (
 	ErrRefreshTokenAlreadyExists = errors.New("refresh token already exists")
 	// ErrRefreshTokenNotFound is the error for when a refresh token is not found.
 	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	// ErrRefreshTokenRevoked is the error for when a refresh token has been revoked.
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	// ErrRefreshTokenReused is the error for when an already rotated refresh token is presented again.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrTOTPCredentialNotFound is the error for when a member has no TOTP credential.
+	ErrTOTPCredentialNotFound = errors.New("TOTP credential not found")
+	// ErrMFAChallengeNotFound is the error for when an MFA challenge is not found or has expired.
+	ErrMFAChallengeNotFound = errors.New("MFA challenge not found")
+	// ErrPasskeyCredentialNotFound is the error for when a passkey credential is not found.
+	ErrPasskeyCredentialNotFound = errors.New("passkey credential not found")
+	// ErrPasskeyCredentialExists is the error for when a passkey credential ID is already registered.
+	ErrPasskeyCredentialExists = errors.New("passkey credential already exists")
+	// ErrWebAuthnSessionNotFound is the error for when a WebAuthn ceremony is not found, has expired or was already used.
+	ErrWebAuthnSessionNotFound = errors.New("WebAuthn session not found")
+	// ErrOAuthClientNotFound is the error for when an OAuth client is not registered.
+	ErrOAuthClientNotFound = errors.New("OAuth client not found")
+	// ErrAuthorizationCodeNotFound is the error for when an authorization code is not found, has expired or was
+	// already used.
+	ErrAuthorizationCodeNotFound = errors.New("authorization code not found")
+	// ErrDeviceAuthorizationNotFound is the error for when a device authorization is not found or was already redeemed.
+	ErrDeviceAuthorizationNotFound = errors.New("device authorization not found")
+	// ErrUserCodeTaken is the error for when the user code of a new device authorization is already in use.
+	ErrUserCodeTaken = errors.New("user code taken")
+	// ErrPasswordResetNotFound is the error for when a password reset is not found, has expired or was already used.
+	ErrPasswordResetNotFound = errors.New("password reset not found")
+	// ErrEmailVerificationNotFound is the error for when an email verification is not found, has expired or was
+	// already used.
+	ErrEmailVerificationNotFound = errors.New("email verification not found")
+	// ErrPasswordlessChallengeNotFound is the error for when a passwordless login is not found, has expired or was
+	// already completed.
+	ErrPasswordlessChallengeNotFound = errors.New("passwordless challenge not found")
+	// ErrFederatedLoginNotFound is the error for when a federated login is not found, has expired or was already
+	// completed.
+	ErrFederatedLoginNotFound = errors.New("federated login not found")
 )
@@ -0,0 +1,68 @@
+// Package memoryrepo defines the memory access token denylist.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AccessTokenDenylist defines a memory access token denylist keyed by jti.
+type AccessTokenDenylist struct {
+	sync.RWMutex
+	denied map[string]time.Time            // jti -> expiresAt
+	issued map[string]map[string]time.Time // memberID -> jti -> expiresAt
+}
+
+// NewAccessTokenDenylist creates a new memory access token denylist.
+func NewAccessTokenDenylist() *AccessTokenDenylist {
+	return &AccessTokenDenylist{
+		denied: make(map[string]time.Time),
+		issued: make(map[string]map[string]time.Time),
+	}
+}
+
+// RecordAccessToken indexes an issued access token under its member.
+func (d *AccessTokenDenylist) RecordAccessToken(_ context.Context, memberID, jti string, expiresAt time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.issued[memberID] == nil {
+		d.issued[memberID] = make(map[string]time.Time)
+	}
+	d.issued[memberID][jti] = expiresAt
+	return nil
+}
+
+// DenyAccessToken denies a jti until the token expires.
+func (d *AccessTokenDenylist) DenyAccessToken(_ context.Context, jti string, expiresAt time.Time) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.denied[jti] = expiresAt
+	return nil
+}
+
+// DenyMemberAccessTokens denies every unexpired access token issued to a member.
+func (d *AccessTokenDenylist) DenyMemberAccessTokens(_ context.Context, memberID string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+	for jti, expiresAt := range d.issued[memberID] {
+		if now.Before(expiresAt) {
+			d.denied[jti] = expiresAt
+		}
+	}
+	delete(d.issued, memberID)
+	return nil
+}
+
+// IsAccessTokenDenied reports whether a jti has been denied and its token has not expired yet.
+func (d *AccessTokenDenylist) IsAccessTokenDenied(_ context.Context, jti string) (bool, error) {
+	d.RLock()
+	defer d.RUnlock()
+
+	expiresAt, ok := d.denied[jti]
+	return ok && time.Now().Before(expiresAt), nil
+}
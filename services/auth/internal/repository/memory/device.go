@@ -0,0 +1,93 @@
+// Package memoryrepo defines the memory repository of OAuth device authorizations.
+package memoryrepo
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// SaveDeviceAuthorization saves a new device authorization and indexes it by its user code.
+func (r *OAuthRepository) SaveDeviceAuthorization(_ context.Context, authorization *model.DeviceAuthorization) error {
+	r.Lock()
+	defer r.Unlock()
+	if hash, ok := r.userCodes[authorization.UserCode]; ok {
+		if existing, ok := r.devices[hash]; ok && !existing.IsExpired(time.Now()) {
+			return repository.ErrUserCodeTaken
+		}
+	}
+	r.devices[authorization.DeviceCodeHash] = cloneDeviceAuthorization(authorization)
+	r.userCodes[authorization.UserCode] = authorization.DeviceCodeHash
+	return nil
+}
+
+// GetDeviceAuthorization gets a device authorization by the hash of its device code, expired or not.
+func (r *OAuthRepository) GetDeviceAuthorization(_ context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error) {
+	r.Lock()
+	defer r.Unlock()
+	authorization, ok := r.devices[deviceCodeHash]
+	if !ok {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	authorization = cloneDeviceAuthorization(&authorization)
+	return &authorization, nil
+}
+
+// GetDeviceAuthorizationByUserCode gets an unexpired device authorization by its normalized user code.
+func (r *OAuthRepository) GetDeviceAuthorizationByUserCode(_ context.Context, userCode string) (*model.DeviceAuthorization, error) {
+	r.Lock()
+	defer r.Unlock()
+	authorization, ok := r.devices[r.userCodes[userCode]]
+	if !ok || authorization.IsExpired(time.Now()) {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	authorization = cloneDeviceAuthorization(&authorization)
+	return &authorization, nil
+}
+
+// UpdateDeviceAuthorization saves the status and grant of an existing device authorization.
+func (r *OAuthRepository) UpdateDeviceAuthorization(_ context.Context, authorization *model.DeviceAuthorization) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.devices[authorization.DeviceCodeHash]; !ok {
+		return repository.ErrDeviceAuthorizationNotFound
+	}
+	r.devices[authorization.DeviceCodeHash] = cloneDeviceAuthorization(authorization)
+	return nil
+}
+
+// ConsumeDeviceAuthorization gets and deletes a device authorization and its user code.
+func (r *OAuthRepository) ConsumeDeviceAuthorization(_ context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error) {
+	r.Lock()
+	defer r.Unlock()
+	authorization, ok := r.devices[deviceCodeHash]
+	if !ok {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	delete(r.devices, deviceCodeHash)
+	delete(r.userCodes, authorization.UserCode)
+	delete(r.polls, deviceCodeHash)
+	return &authorization, nil
+}
+
+// RecordDevicePoll records a poll of a device code and reports whether it came at least interval after the
+// previous one.
+func (r *OAuthRepository) RecordDevicePoll(_ context.Context, deviceCodeHash model.DeviceCodeHash, interval time.Duration) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if next, ok := r.polls[deviceCodeHash]; ok && now.Before(next) {
+		return false, nil
+	}
+	r.polls[deviceCodeHash] = now.Add(interval)
+	return true, nil
+}
+
+func cloneDeviceAuthorization(authorization *model.DeviceAuthorization) model.DeviceAuthorization {
+	clone := *authorization
+	clone.Scopes = slices.Clone(authorization.Scopes)
+	return clone
+}
@@ -0,0 +1,42 @@
+// Package memoryrepo defines the memory federated login repository.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// FederatedLoginRepository defines a memory repository of pending federated logins.
+type FederatedLoginRepository struct {
+	sync.Mutex
+	logins map[model.FederatedLoginHash]model.FederatedLogin
+}
+
+// NewFederatedLoginRepository creates a new memory federated login repository.
+func NewFederatedLoginRepository() *FederatedLoginRepository {
+	return &FederatedLoginRepository{logins: make(map[model.FederatedLoginHash]model.FederatedLogin)}
+}
+
+// SaveFederatedLogin saves a federated login.
+func (r *FederatedLoginRepository) SaveFederatedLogin(_ context.Context, login *model.FederatedLogin) error {
+	r.Lock()
+	defer r.Unlock()
+	r.logins[login.StateHash] = *login
+	return nil
+}
+
+// ConsumeFederatedLogin gets and deletes an unexpired federated login.
+func (r *FederatedLoginRepository) ConsumeFederatedLogin(_ context.Context, stateHash model.FederatedLoginHash) (*model.FederatedLogin, error) {
+	r.Lock()
+	defer r.Unlock()
+	login, ok := r.logins[stateHash]
+	delete(r.logins, stateHash)
+	if !ok || login.IsExpired(time.Now()) {
+		return nil, repository.ErrFederatedLoginNotFound
+	}
+	return &login, nil
+}
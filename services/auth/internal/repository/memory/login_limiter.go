@@ -0,0 +1,101 @@
+// Package memoryrepo defines the memory login limiter.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// LoginLimiter defines a memory limiter of failed logins per email and per client IP.
+type LoginLimiter struct {
+	sync.Mutex
+	emailPolicy model.LoginThrottlePolicy
+	ipPolicy    model.LoginThrottlePolicy
+	failures    map[string][]time.Time // subject -> failure times within the window
+	lockedUntil map[string]time.Time   // subject -> end of the active lockout
+	lockouts    map[string]lockouts    // subject -> recent lockouts
+}
+
+// lockouts counts the recent lockouts of a subject.
+type lockouts struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewLoginLimiter creates a new memory login limiter.
+func NewLoginLimiter(emailPolicy, ipPolicy model.LoginThrottlePolicy) *LoginLimiter {
+	return &LoginLimiter{
+		emailPolicy: emailPolicy,
+		ipPolicy:    ipPolicy,
+		failures:    make(map[string][]time.Time),
+		lockedUntil: make(map[string]time.Time),
+		lockouts:    make(map[string]lockouts),
+	}
+}
+
+// LoginRetryAfter returns how long logins for the email or from the IP are locked out, or zero.
+func (l *LoginLimiter) LoginRetryAfter(_ context.Context, email, ipAddress string) (time.Duration, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	var retryAfter time.Duration
+	for key := range l.subjects(email, ipAddress) {
+		retryAfter = max(retryAfter, l.lockedUntil[key].Sub(now))
+	}
+	return retryAfter, nil
+}
+
+// RecordLoginFailure records a failed login for the email and the IP, locking out either once it reaches its maximum.
+func (l *LoginLimiter) RecordLoginFailure(_ context.Context, email, ipAddress string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	for key, policy := range l.subjects(email, ipAddress) {
+		var recent []time.Time
+		for _, at := range l.failures[key] {
+			if now.Sub(at) < policy.Window {
+				recent = append(recent, at)
+			}
+		}
+		recent = append(recent, now)
+		if len(recent) < policy.MaxFailures {
+			l.failures[key] = recent
+			continue
+		}
+
+		count := l.lockouts[key]
+		if !now.Before(count.expiresAt) {
+			count = lockouts{}
+		}
+		count.count++
+		count.expiresAt = now.Add(policy.LockoutMemory)
+		l.lockouts[key] = count
+		l.lockedUntil[key] = now.Add(policy.LockoutDuration(count.count))
+		delete(l.failures, key)
+	}
+	return nil
+}
+
+// ResetLoginFailures forgets the failed logins and past lockouts of an email after a successful login.
+func (l *LoginLimiter) ResetLoginFailures(_ context.Context, email string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.failures, "email:"+email)
+	delete(l.lockouts, "email:"+email)
+	return nil
+}
+
+// subjects returns the subjects of a login attempt with their policies; an empty IP is not limited.
+func (l *LoginLimiter) subjects(email, ipAddress string) map[string]model.LoginThrottlePolicy {
+	subjects := map[string]model.LoginThrottlePolicy{"email:" + email: l.emailPolicy}
+	if ipAddress != "" {
+		subjects["ip:"+ipAddress] = l.ipPolicy
+	}
+	return subjects
+}
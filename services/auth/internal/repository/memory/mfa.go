@@ -0,0 +1,100 @@
+// Package memoryrepo defines the memory MFA repository.
+package memoryrepo
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// MFARepository defines a memory repository of TOTP credentials and pending MFA challenges.
+type MFARepository struct {
+	sync.Mutex
+	credentials map[string]model.TOTPCredential // member ID -> credential
+	usedSteps   map[string]time.Time            // member ID and step -> end of the replay window
+	challenges  map[model.MFAChallengeHash]model.MFAChallenge
+	failures    map[model.MFAChallengeHash]int64
+}
+
+// NewMFARepository creates a new memory MFA repository.
+func NewMFARepository() *MFARepository {
+	return &MFARepository{
+		credentials: make(map[string]model.TOTPCredential),
+		usedSteps:   make(map[string]time.Time),
+		challenges:  make(map[model.MFAChallengeHash]model.MFAChallenge),
+		failures:    make(map[model.MFAChallengeHash]int64),
+	}
+}
+
+// GetTOTPCredential gets the TOTP credential of a member.
+func (r *MFARepository) GetTOTPCredential(_ context.Context, memberID string) (*model.TOTPCredential, error) {
+	r.Lock()
+	defer r.Unlock()
+	credential, ok := r.credentials[memberID]
+	if !ok {
+		return nil, repository.ErrTOTPCredentialNotFound
+	}
+	return &credential, nil
+}
+
+// SaveTOTPCredential creates or replaces the TOTP credential of a member.
+func (r *MFARepository) SaveTOTPCredential(_ context.Context, credential *model.TOTPCredential) error {
+	r.Lock()
+	defer r.Unlock()
+	r.credentials[credential.MemberID] = *credential
+	return nil
+}
+
+// UseTOTPStep marks a TOTP time step of a member as used for ttl and reports whether it was unused.
+func (r *MFARepository) UseTOTPStep(_ context.Context, memberID string, step int64, ttl time.Duration) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	key := fmt.Sprintf("%s:%d", memberID, step)
+	now := time.Now()
+	if now.Before(r.usedSteps[key]) {
+		return false, nil
+	}
+	r.usedSteps[key] = now.Add(ttl)
+	return true, nil
+}
+
+// SaveMFAChallenge saves an MFA challenge until it expires.
+func (r *MFARepository) SaveMFAChallenge(_ context.Context, challenge *model.MFAChallenge) error {
+	r.Lock()
+	defer r.Unlock()
+	r.challenges[challenge.TokenHash] = *challenge
+	return nil
+}
+
+// GetMFAChallenge gets an unexpired MFA challenge by token hash.
+func (r *MFARepository) GetMFAChallenge(_ context.Context, tokenHash model.MFAChallengeHash) (*model.MFAChallenge, error) {
+	r.Lock()
+	defer r.Unlock()
+	challenge, ok := r.challenges[tokenHash]
+	if !ok || challenge.IsExpired(time.Now()) {
+		return nil, repository.ErrMFAChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+// RecordMFAChallengeFailure records a failed attempt at an MFA challenge and returns the number of failures so far.
+func (r *MFARepository) RecordMFAChallengeFailure(_ context.Context, challenge *model.MFAChallenge) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.failures[challenge.TokenHash]++
+	return r.failures[challenge.TokenHash], nil
+}
+
+// DeleteMFAChallenge deletes an MFA challenge and reports whether it still existed.
+func (r *MFARepository) DeleteMFAChallenge(_ context.Context, tokenHash model.MFAChallengeHash) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.challenges[tokenHash]
+	delete(r.challenges, tokenHash)
+	delete(r.failures, tokenHash)
+	return ok, nil
+}
@@ -0,0 +1,77 @@
+// Package memoryrepo defines the memory OAuth repository and client registry.
+package memoryrepo
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// OAuthRepository defines a memory repository of OAuth authorization codes and device authorizations.
+type OAuthRepository struct {
+	sync.Mutex
+	codes     map[model.AuthorizationCodeHash]model.AuthorizationCode
+	devices   map[model.DeviceCodeHash]model.DeviceAuthorization
+	userCodes map[string]model.DeviceCodeHash
+	polls     map[model.DeviceCodeHash]time.Time // earliest time of the next poll
+}
+
+// NewOAuthRepository creates a new memory OAuth repository.
+func NewOAuthRepository() *OAuthRepository {
+	return &OAuthRepository{
+		codes:     make(map[model.AuthorizationCodeHash]model.AuthorizationCode),
+		devices:   make(map[model.DeviceCodeHash]model.DeviceAuthorization),
+		userCodes: make(map[string]model.DeviceCodeHash),
+		polls:     make(map[model.DeviceCodeHash]time.Time),
+	}
+}
+
+// SaveAuthorizationCode saves an authorization code until it expires.
+func (r *OAuthRepository) SaveAuthorizationCode(_ context.Context, code *model.AuthorizationCode) error {
+	r.Lock()
+	defer r.Unlock()
+	r.codes[code.CodeHash] = *code
+	return nil
+}
+
+// ConsumeAuthorizationCode gets and deletes an unexpired authorization code.
+func (r *OAuthRepository) ConsumeAuthorizationCode(_ context.Context, codeHash model.AuthorizationCodeHash) (*model.AuthorizationCode, error) {
+	r.Lock()
+	defer r.Unlock()
+	code, ok := r.codes[codeHash]
+	delete(r.codes, codeHash)
+	if !ok || code.IsExpired(time.Now()) {
+		return nil, repository.ErrAuthorizationCodeNotFound
+	}
+	return &code, nil
+}
+
+// ClientRegistry defines a read-only registry of the OAuth clients known at startup.
+type ClientRegistry struct {
+	clients map[string]model.OAuthClient
+}
+
+// NewClientRegistry creates a new client registry of clients.
+func NewClientRegistry(clients ...model.OAuthClient) *ClientRegistry {
+	r := &ClientRegistry{clients: make(map[string]model.OAuthClient, len(clients))}
+	for _, client := range clients {
+		r.clients[client.ID] = client
+	}
+	return r
+}
+
+// GetClient gets a client by ID.
+func (r *ClientRegistry) GetClient(_ context.Context, clientID string) (*model.OAuthClient, error) {
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, repository.ErrOAuthClientNotFound
+	}
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	client.Scopes = slices.Clone(client.Scopes)
+	client.Audiences = slices.Clone(client.Audiences)
+	return &client, nil
+}
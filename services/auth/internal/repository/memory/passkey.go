@@ -0,0 +1,96 @@
+// Package memoryrepo defines the memory passkey repository.
+package memoryrepo
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// PasskeyRepository defines a memory repository of passkey credentials and pending WebAuthn ceremonies.
+type PasskeyRepository struct {
+	sync.Mutex
+	credentials map[string]model.PasskeyCredential // credential ID -> credential
+	sessions    map[model.WebAuthnSessionHash]model.WebAuthnSession
+}
+
+// NewPasskeyRepository creates a new memory passkey repository.
+func NewPasskeyRepository() *PasskeyRepository {
+	return &PasskeyRepository{
+		credentials: make(map[string]model.PasskeyCredential),
+		sessions:    make(map[model.WebAuthnSessionHash]model.WebAuthnSession),
+	}
+}
+
+// ListPasskeyCredentials lists the passkey credentials of a member, oldest first.
+func (r *PasskeyRepository) ListPasskeyCredentials(_ context.Context, memberID string) ([]*model.PasskeyCredential, error) {
+	r.Lock()
+	defer r.Unlock()
+	var credentials []*model.PasskeyCredential
+	for _, credential := range r.credentials {
+		if credential.MemberID == memberID {
+			credentials = append(credentials, &credential)
+		}
+	}
+	slices.SortFunc(credentials, func(a, b *model.PasskeyCredential) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return credentials, nil
+}
+
+// GetPasskeyCredential gets a passkey credential by credential ID.
+func (r *PasskeyRepository) GetPasskeyCredential(_ context.Context, credentialID []byte) (*model.PasskeyCredential, error) {
+	r.Lock()
+	defer r.Unlock()
+	credential, ok := r.credentials[string(credentialID)]
+	if !ok {
+		return nil, repository.ErrPasskeyCredentialNotFound
+	}
+	return &credential, nil
+}
+
+// CreatePasskeyCredential registers a new passkey credential.
+func (r *PasskeyRepository) CreatePasskeyCredential(_ context.Context, credential *model.PasskeyCredential) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.credentials[string(credential.ID)]; ok {
+		return repository.ErrPasskeyCredentialExists
+	}
+	r.credentials[string(credential.ID)] = *credential
+	return nil
+}
+
+// UpdatePasskeyCredential replaces a registered passkey credential.
+func (r *PasskeyRepository) UpdatePasskeyCredential(_ context.Context, credential *model.PasskeyCredential) error {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.credentials[string(credential.ID)]; !ok {
+		return repository.ErrPasskeyCredentialNotFound
+	}
+	r.credentials[string(credential.ID)] = *credential
+	return nil
+}
+
+// SaveWebAuthnSession saves a pending WebAuthn ceremony until it expires.
+func (r *PasskeyRepository) SaveWebAuthnSession(_ context.Context, session *model.WebAuthnSession) error {
+	r.Lock()
+	defer r.Unlock()
+	r.sessions[session.CeremonyHash] = *session
+	return nil
+}
+
+// ConsumeWebAuthnSession gets and deletes an unexpired WebAuthn ceremony.
+func (r *PasskeyRepository) ConsumeWebAuthnSession(_ context.Context, ceremonyHash model.WebAuthnSessionHash) (*model.WebAuthnSession, error) {
+	r.Lock()
+	defer r.Unlock()
+	session, ok := r.sessions[ceremonyHash]
+	delete(r.sessions, ceremonyHash)
+	if !ok || !time.Now().Before(session.ExpiresAt) {
+		return nil, repository.ErrWebAuthnSessionNotFound
+	}
+	return &session, nil
+}
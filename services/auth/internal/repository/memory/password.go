@@ -0,0 +1,59 @@
+// Package memoryrepo defines the memory password reset repository.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// PasswordResetRepository defines a memory repository of pending password resets.
+type PasswordResetRepository struct {
+	sync.Mutex
+	resets    map[model.PasswordResetTokenHash]model.PasswordReset
+	nextEmail map[string]time.Time
+}
+
+// NewPasswordResetRepository creates a new memory password reset repository.
+func NewPasswordResetRepository() *PasswordResetRepository {
+	return &PasswordResetRepository{
+		resets:    make(map[model.PasswordResetTokenHash]model.PasswordReset),
+		nextEmail: make(map[string]time.Time),
+	}
+}
+
+// SavePasswordReset saves a password reset.
+func (r *PasswordResetRepository) SavePasswordReset(_ context.Context, reset *model.PasswordReset) error {
+	r.Lock()
+	defer r.Unlock()
+	r.resets[reset.TokenHash] = *reset
+	return nil
+}
+
+// ConsumePasswordReset gets and deletes an unexpired password reset.
+func (r *PasswordResetRepository) ConsumePasswordReset(_ context.Context, tokenHash model.PasswordResetTokenHash) (*model.PasswordReset, error) {
+	r.Lock()
+	defer r.Unlock()
+	reset, ok := r.resets[tokenHash]
+	delete(r.resets, tokenHash)
+	if !ok || reset.IsExpired(time.Now()) {
+		return nil, repository.ErrPasswordResetNotFound
+	}
+	return &reset, nil
+}
+
+// RecordPasswordResetEmail records a password reset asked for an email, unless one was recorded less than interval
+// ago, in which case it returns how long until the next one may be asked for.
+func (r *PasswordResetRepository) RecordPasswordResetEmail(_ context.Context, email string, interval time.Duration) (time.Duration, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if next, ok := r.nextEmail[email]; ok && now.Before(next) {
+		return next.Sub(now), nil
+	}
+	r.nextEmail[email] = now.Add(interval)
+	return 0, nil
+}
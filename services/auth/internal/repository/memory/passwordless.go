@@ -0,0 +1,79 @@
+// Package memoryrepo defines the memory passwordless login repository.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// PasswordlessRepository defines a memory repository of pending passwordless logins.
+type PasswordlessRepository struct {
+	sync.Mutex
+	challenges map[model.PasswordlessChallengeHash]model.PasswordlessChallenge
+	failures   map[model.PasswordlessChallengeHash]int64
+	nextEmail  map[string]time.Time // when the next passwordless login email to an email may be sent
+}
+
+// NewPasswordlessRepository creates a new memory passwordless login repository.
+func NewPasswordlessRepository() *PasswordlessRepository {
+	return &PasswordlessRepository{
+		challenges: make(map[model.PasswordlessChallengeHash]model.PasswordlessChallenge),
+		failures:   make(map[model.PasswordlessChallengeHash]int64),
+		nextEmail:  make(map[string]time.Time),
+	}
+}
+
+// SavePasswordlessChallenge saves a passwordless challenge until it expires.
+func (r *PasswordlessRepository) SavePasswordlessChallenge(_ context.Context, challenge *model.PasswordlessChallenge) error {
+	r.Lock()
+	defer r.Unlock()
+	r.challenges[challenge.IDHash] = *challenge
+	return nil
+}
+
+// GetPasswordlessChallenge gets an unexpired passwordless challenge by ID hash.
+func (r *PasswordlessRepository) GetPasswordlessChallenge(_ context.Context, idHash model.PasswordlessChallengeHash) (*model.PasswordlessChallenge, error) {
+	r.Lock()
+	defer r.Unlock()
+	challenge, ok := r.challenges[idHash]
+	if !ok || challenge.IsExpired(time.Now()) {
+		return nil, repository.ErrPasswordlessChallengeNotFound
+	}
+	return &challenge, nil
+}
+
+// RecordPasswordlessChallengeFailure records a wrong code for a passwordless challenge and returns the number of
+// failures so far.
+func (r *PasswordlessRepository) RecordPasswordlessChallengeFailure(_ context.Context, challenge *model.PasswordlessChallenge) (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.failures[challenge.IDHash]++
+	return r.failures[challenge.IDHash], nil
+}
+
+// DeletePasswordlessChallenge deletes a passwordless challenge and reports whether it still existed.
+func (r *PasswordlessRepository) DeletePasswordlessChallenge(_ context.Context, idHash model.PasswordlessChallengeHash) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.challenges[idHash]
+	delete(r.challenges, idHash)
+	delete(r.failures, idHash)
+	return ok, nil
+}
+
+// RecordPasswordlessEmail records a passwordless login email to an email, unless one was recorded less than
+// interval ago, in which case it returns how long until the next one may be sent.
+func (r *PasswordlessRepository) RecordPasswordlessEmail(_ context.Context, email string, interval time.Duration) (time.Duration, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if next, ok := r.nextEmail[email]; ok && now.Before(next) {
+		return next.Sub(now), nil
+	}
+	r.nextEmail[email] = now.Add(interval)
+	return 0, nil
+}
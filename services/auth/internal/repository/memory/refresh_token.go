@@ -4,6 +4,7 @@ package memoryrepo
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/incheat/go-production-backend/services/auth/internal/repository"
 	"github.com/incheat/go-production-backend/services/auth/pkg/model"
@@ -23,15 +24,15 @@ func NewRefreshTokenRepository() *RefreshTokenRepository {
 }
 
 // GetRefreshTokenSession gets a refresh token session by token hash.
-func (r *RefreshTokenRepository) GetRefreshTokenSession(_ context.Context, refreshToken model.RefreshToken) (*model.RefreshTokenSession, error) {
+func (r *RefreshTokenRepository) GetRefreshTokenSession(_ context.Context, tokenHash model.RefreshTokenHash) (*model.RefreshTokenSession, error) {
 	r.RLock()
 	defer r.RUnlock()
-	tokenHash := string(refreshToken)
-	refreshTokenSession, ok := r.data[tokenHash]
+	refreshTokenSession, ok := r.data[string(tokenHash)]
 	if !ok {
 		return nil, repository.ErrRefreshTokenNotFound
 	}
-	return refreshTokenSession, nil
+	session := *refreshTokenSession
+	return &session, nil
 }
 
 // SaveRefreshTokenSession saves a refresh token session.
@@ -45,6 +46,92 @@ func (r *RefreshTokenRepository) SaveRefreshTokenSession(_ context.Context, refr
 		return repository.ErrRefreshTokenAlreadyExists
 	}
 
-	r.data[tokenHash] = refreshTokenSession
+	session := *refreshTokenSession
+	r.data[tokenHash] = &session
+	return nil
+}
+
+// RotateRefreshTokenSession marks the session of oldTokenHash as rotated and saves newSession in its place.
+func (r *RefreshTokenRepository) RotateRefreshTokenSession(_ context.Context, oldTokenHash model.RefreshTokenHash, newSession *model.RefreshTokenSession) error {
+	r.Lock()
+	defer r.Unlock()
+
+	old, ok := r.data[string(oldTokenHash)]
+	if !ok {
+		return repository.ErrRefreshTokenNotFound
+	}
+	if old.IsRevoked() {
+		return repository.ErrRefreshTokenRevoked
+	}
+	if old.IsRotated() {
+		return repository.ErrRefreshTokenReused
+	}
+
+	newTokenHash := string(newSession.TokenHash)
+	if _, exists := r.data[newTokenHash]; exists {
+		return repository.ErrRefreshTokenAlreadyExists
+	}
+
+	old.RotatedAt = newSession.CreatedAt
+	session := *newSession
+	r.data[newTokenHash] = &session
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every session that belongs to the given family.
+func (r *RefreshTokenRepository) RevokeRefreshTokenFamily(_ context.Context, familyID string, revokedAt time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, session := range r.data {
+		if session.FamilyID == familyID && !session.IsRevoked() {
+			session.RevokedAt = revokedAt
+		}
+	}
+	return nil
+}
+
+// ListMemberRefreshTokenSessions lists the unexpired, unrotated sessions of a member.
+func (r *RefreshTokenRepository) ListMemberRefreshTokenSessions(_ context.Context, memberID string) ([]*model.RefreshTokenSession, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	now := time.Now()
+	var sessions []*model.RefreshTokenSession
+	for _, session := range r.data {
+		if session.MemberID != memberID || session.IsRotated() || session.IsExpired(now) {
+			continue
+		}
+		s := *session
+		sessions = append(sessions, &s)
+	}
+	return sessions, nil
+}
+
+// RevokeRefreshTokenSession revokes the session of a single refresh token hash.
+func (r *RefreshTokenRepository) RevokeRefreshTokenSession(_ context.Context, tokenHash model.RefreshTokenHash, revokedAt time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+
+	session, ok := r.data[string(tokenHash)]
+	if !ok {
+		return repository.ErrRefreshTokenNotFound
+	}
+	if !session.IsRevoked() {
+		session.RevokedAt = revokedAt
+	}
+	return nil
+}
+
+// RevokeMemberRefreshTokenSessions revokes every session that belongs to the given member.
+func (r *RefreshTokenRepository) RevokeMemberRefreshTokenSessions(_ context.Context, memberID string, revokedAt time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, session := range r.data {
+		if session.MemberID == memberID && !session.IsRevoked() {
+			session.RevokedAt = revokedAt
+		}
+	}
 	return nil
 }
@@ -0,0 +1,59 @@
+// Package memoryrepo defines the memory email verification repository.
+package memoryrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// EmailVerificationRepository defines a memory repository of pending email verifications.
+type EmailVerificationRepository struct {
+	sync.Mutex
+	verifications map[model.EmailVerificationHash]model.EmailVerification
+	nextEmail     map[string]time.Time // when the next verification email to an email may be sent
+}
+
+// NewEmailVerificationRepository creates a new memory email verification repository.
+func NewEmailVerificationRepository() *EmailVerificationRepository {
+	return &EmailVerificationRepository{
+		verifications: make(map[model.EmailVerificationHash]model.EmailVerification),
+		nextEmail:     make(map[string]time.Time),
+	}
+}
+
+// SaveEmailVerification saves an email verification.
+func (r *EmailVerificationRepository) SaveEmailVerification(_ context.Context, verification *model.EmailVerification) error {
+	r.Lock()
+	defer r.Unlock()
+	r.verifications[verification.IDHash] = *verification
+	return nil
+}
+
+// ConsumeEmailVerification gets and deletes an unexpired email verification.
+func (r *EmailVerificationRepository) ConsumeEmailVerification(_ context.Context, idHash model.EmailVerificationHash) (*model.EmailVerification, error) {
+	r.Lock()
+	defer r.Unlock()
+	verification, ok := r.verifications[idHash]
+	delete(r.verifications, idHash)
+	if !ok || verification.IsExpired(time.Now()) {
+		return nil, repository.ErrEmailVerificationNotFound
+	}
+	return &verification, nil
+}
+
+// RecordVerificationEmail records a verification email to an email, unless one was recorded less than interval
+// ago, in which case it returns how long until the next one may be sent.
+func (r *EmailVerificationRepository) RecordVerificationEmail(_ context.Context, email string, interval time.Duration) (time.Duration, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	if next, ok := r.nextEmail[email]; ok && now.Before(next) {
+		return next.Sub(now), nil
+	}
+	r.nextEmail[email] = now.Add(interval)
+	return 0, nil
+}
@@ -0,0 +1,122 @@
+// Package redisrepo defines the Redis access token denylist.
+package redisrepo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/redis/go-redis/v9"
+)
+
+// AccessTokenDenylist defines a Redis access token denylist keyed by jti.
+// Issued tokens are indexed per member so that every live token of a member can be denied at once.
+type AccessTokenDenylist struct {
+	rdb          *redis.Client
+	prefix       string
+	memberPrefix string
+}
+
+// NewAccessTokenDenylist creates a new Redis access token denylist.
+func NewAccessTokenDenylist(rdb *redis.Client) *AccessTokenDenylist {
+	return &AccessTokenDenylist{
+		rdb:          rdb,
+		prefix:       constant.RedisAccessTokenDenylistPrefix,
+		memberPrefix: constant.RedisAccessTokenMemberPrefix,
+	}
+}
+
+// key builds the denylist key of a jti.
+func (d *AccessTokenDenylist) key(jti string) string {
+	return d.prefix + jti
+}
+
+// memberKey builds the Redis key of the sorted set indexing the jtis of a member by expiry.
+func (d *AccessTokenDenylist) memberKey(memberID string) string {
+	return d.memberPrefix + memberID
+}
+
+// RecordAccessToken indexes an issued access token under its member.
+func (d *AccessTokenDenylist) RecordAccessToken(ctx context.Context, memberID, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	memberKey := d.memberKey(memberID)
+	_, err := d.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, memberKey, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.ZAdd(ctx, memberKey, redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+		extendTTL(ctx, pipe, memberKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis ZADD error: %w", err)
+	}
+	return nil
+}
+
+// DenyAccessToken denies a jti until the token expires; expired tokens are ignored.
+func (d *AccessTokenDenylist) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if err := d.rdb.Set(ctx, d.key(jti), 1, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// DenyMemberAccessTokens denies every unexpired access token issued to a member.
+func (d *AccessTokenDenylist) DenyMemberAccessTokens(ctx context.Context, memberID string) error {
+	memberKey := d.memberKey(memberID)
+	now := time.Now()
+
+	entries, err := d.rdb.ZRangeByScoreWithScores(ctx, memberKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(now.Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("redis ZRANGEBYSCORE error: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	_, err = d.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			jti, ok := entry.Member.(string)
+			if !ok {
+				continue
+			}
+			ttl := time.Unix(int64(entry.Score), 0).Sub(now) + time.Second // exp has second precision
+			pipe.Set(ctx, d.key(jti), 1, ttl)
+		}
+		pipe.ZRem(ctx, memberKey, membersOf(entries)...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("deny access tokens of member %s: %w", memberID, err)
+	}
+	return nil
+}
+
+// IsAccessTokenDenied reports whether a jti has been denied.
+func (d *AccessTokenDenylist) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	n, err := d.rdb.Exists(ctx, d.key(jti)).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis EXISTS error: %w", err)
+	}
+	return n > 0, nil
+}
+
+func membersOf(entries []redis.Z) []any {
+	members := make([]any, 0, len(entries))
+	for _, entry := range entries {
+		members = append(members, entry.Member)
+	}
+	return members
+}
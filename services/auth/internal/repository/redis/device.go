@@ -0,0 +1,124 @@
+// Package redisrepo defines the Redis repository of OAuth device authorizations.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// expiredDeviceAuthorizationRetention is how long device authorizations are kept after they expire,
+// so that clients still polling learn that their device code expired rather than that it is unknown.
+const expiredDeviceAuthorizationRetention = 10 * time.Minute
+
+// SaveDeviceAuthorization saves a new device authorization and indexes it by its user code.
+// It fails with ErrUserCodeTaken when another authorization uses the same user code.
+func (r *OAuthRepository) SaveDeviceAuthorization(ctx context.Context, authorization *model.DeviceAuthorization) error {
+	data, err := json.Marshal(authorization)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(authorization.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("device authorization already expired at %s", authorization.ExpiresAt)
+	}
+
+	ok, err := r.rdb.SetNX(ctx, constant.RedisDeviceUserCodePrefix+authorization.UserCode, string(authorization.DeviceCodeHash), ttl).Result()
+	if err != nil {
+		return fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if !ok {
+		return repository.ErrUserCodeTaken
+	}
+	key := constant.RedisDeviceAuthorizationPrefix + string(authorization.DeviceCodeHash)
+	if err := r.rdb.Set(ctx, key, data, ttl+expiredDeviceAuthorizationRetention).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceAuthorization gets a device authorization by the hash of its device code.
+func (r *OAuthRepository) GetDeviceAuthorization(ctx context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error) {
+	data, err := r.rdb.Get(ctx, constant.RedisDeviceAuthorizationPrefix+string(deviceCodeHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+	return unmarshalDeviceAuthorization(data)
+}
+
+// GetDeviceAuthorizationByUserCode gets an unexpired device authorization by its normalized user code.
+func (r *OAuthRepository) GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*model.DeviceAuthorization, error) {
+	deviceCodeHash, err := r.rdb.Get(ctx, constant.RedisDeviceUserCodePrefix+userCode).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+	return r.GetDeviceAuthorization(ctx, model.DeviceCodeHash(deviceCodeHash))
+}
+
+// UpdateDeviceAuthorization saves the status and grant of an existing device authorization, keeping its expiry.
+func (r *OAuthRepository) UpdateDeviceAuthorization(ctx context.Context, authorization *model.DeviceAuthorization) error {
+	data, err := json.Marshal(authorization)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	key := constant.RedisDeviceAuthorizationPrefix + string(authorization.DeviceCodeHash)
+	err = r.rdb.SetArgs(ctx, key, data, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrDeviceAuthorizationNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeDeviceAuthorization gets and deletes a device authorization and its user code, so that the tokens of
+// an approved authorization are issued at most once.
+func (r *OAuthRepository) ConsumeDeviceAuthorization(ctx context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error) {
+	data, err := r.rdb.GetDel(ctx, constant.RedisDeviceAuthorizationPrefix+string(deviceCodeHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrDeviceAuthorizationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+	authorization, err := unmarshalDeviceAuthorization(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.rdb.Del(ctx, constant.RedisDeviceUserCodePrefix+authorization.UserCode).Err(); err != nil {
+		return nil, fmt.Errorf("redis DEL error: %w", err)
+	}
+	return authorization, nil
+}
+
+// RecordDevicePoll records a poll of a device code and reports whether it came at least interval after the
+// previous one.
+func (r *OAuthRepository) RecordDevicePoll(ctx context.Context, deviceCodeHash model.DeviceCodeHash, interval time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(ctx, constant.RedisDevicePollPrefix+string(deviceCodeHash), 1, interval).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	return ok, nil
+}
+
+func unmarshalDeviceAuthorization(data []byte) (*model.DeviceAuthorization, error) {
+	var authorization model.DeviceAuthorization
+	if err := json.Unmarshal(data, &authorization); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &authorization, nil
+}
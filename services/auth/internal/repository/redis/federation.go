@@ -0,0 +1,62 @@
+// Package redisrepo defines the Redis federated login repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// FederatedLoginRepository defines a Redis repository of pending federated logins.
+type FederatedLoginRepository struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+// NewFederatedLoginRepository creates a new Redis federated login repository.
+func NewFederatedLoginRepository(rdb *redis.Client) *FederatedLoginRepository {
+	return &FederatedLoginRepository{
+		rdb:    rdb,
+		prefix: constant.RedisFederatedLoginPrefix,
+	}
+}
+
+// SaveFederatedLogin saves a federated login until it expires.
+func (r *FederatedLoginRepository) SaveFederatedLogin(ctx context.Context, login *model.FederatedLogin) error {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(login.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("federated login already expired at %s", login.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, r.prefix+string(login.StateHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeFederatedLogin gets and deletes a federated login, so that each state completes at most one login.
+func (r *FederatedLoginRepository) ConsumeFederatedLogin(ctx context.Context, stateHash model.FederatedLoginHash) (*model.FederatedLogin, error) {
+	data, err := r.rdb.GetDel(ctx, r.prefix+string(stateHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrFederatedLoginNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+
+	var login model.FederatedLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &login, nil
+}
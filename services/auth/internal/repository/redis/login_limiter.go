@@ -0,0 +1,145 @@
+// Package redisrepo defines the Redis login limiter.
+package redisrepo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginLimiter defines a Redis limiter of failed logins per email and per client IP.
+// Failures are kept in a sliding window; reaching the maximum locks the email or IP out
+// for a duration that doubles with every lockout within the policy's lockout memory.
+type LoginLimiter struct {
+	rdb                *redis.Client
+	emailPolicy        model.LoginThrottlePolicy
+	ipPolicy           model.LoginThrottlePolicy
+	failuresPrefix     string
+	lockoutPrefix      string
+	lockoutCountPrefix string
+}
+
+// NewLoginLimiter creates a new Redis login limiter.
+func NewLoginLimiter(rdb *redis.Client, emailPolicy, ipPolicy model.LoginThrottlePolicy) *LoginLimiter {
+	return &LoginLimiter{
+		rdb:                rdb,
+		emailPolicy:        emailPolicy,
+		ipPolicy:           ipPolicy,
+		failuresPrefix:     constant.RedisLoginFailuresPrefix,
+		lockoutPrefix:      constant.RedisLoginLockoutPrefix,
+		lockoutCountPrefix: constant.RedisLoginLockoutCountPrefix,
+	}
+}
+
+// loginSubject is an email or IP failures are counted for.
+type loginSubject struct {
+	key    string
+	policy model.LoginThrottlePolicy
+}
+
+// subjects returns the subjects of a login attempt; an empty IP is not limited.
+func (l *LoginLimiter) subjects(email, ipAddress string) []loginSubject {
+	subjects := []loginSubject{{key: "email:" + email, policy: l.emailPolicy}}
+	if ipAddress != "" {
+		subjects = append(subjects, loginSubject{key: "ip:" + ipAddress, policy: l.ipPolicy})
+	}
+	return subjects
+}
+
+// LoginRetryAfter returns how long logins for the email or from the IP are locked out, or zero.
+func (l *LoginLimiter) LoginRetryAfter(ctx context.Context, email, ipAddress string) (time.Duration, error) {
+	subjects := l.subjects(email, ipAddress)
+	cmds := make([]*redis.DurationCmd, 0, len(subjects))
+	_, err := l.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, subject := range subjects {
+			cmds = append(cmds, pipe.PTTL(ctx, l.lockoutPrefix+subject.key))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis PTTL error: %w", err)
+	}
+
+	var retryAfter time.Duration
+	for _, cmd := range cmds {
+		// PTTL is negative for a missing key or a key without expiry.
+		retryAfter = max(retryAfter, cmd.Val())
+	}
+	return retryAfter, nil
+}
+
+// RecordLoginFailure records a failed login for the email and the IP, locking out either once it reaches its maximum.
+func (l *LoginLimiter) RecordLoginFailure(ctx context.Context, email, ipAddress string) error {
+	for _, subject := range l.subjects(email, ipAddress) {
+		if err := l.recordFailure(ctx, subject); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetLoginFailures forgets the failed logins and past lockouts of an email after a successful login.
+func (l *LoginLimiter) ResetLoginFailures(ctx context.Context, email string) error {
+	key := "email:" + email
+	if err := l.rdb.Del(ctx, l.failuresPrefix+key, l.lockoutCountPrefix+key).Err(); err != nil {
+		return fmt.Errorf("redis DEL error: %w", err)
+	}
+	return nil
+}
+
+func (l *LoginLimiter) recordFailure(ctx context.Context, subject loginSubject) error {
+	now := time.Now()
+	failuresKey := l.failuresPrefix + subject.key
+
+	var count *redis.IntCmd
+	_, err := l.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, failuresKey, "-inf", "("+strconv.FormatInt(now.Add(-subject.policy.Window).UnixMilli(), 10))
+		pipe.ZAdd(ctx, failuresKey, redis.Z{Score: float64(now.UnixMilli()), Member: uuid.NewString()})
+		count = pipe.ZCard(ctx, failuresKey)
+		pipe.PExpire(ctx, failuresKey, subject.policy.Window)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis ZADD error: %w", err)
+	}
+	if count.Val() < int64(subject.policy.MaxFailures) {
+		return nil
+	}
+
+	// Only the failure that creates the lockout escalates it, so concurrent failures lock out once.
+	lockoutKey := l.lockoutPrefix + subject.key
+	locked, err := l.rdb.SetNX(ctx, lockoutKey, 1, subject.policy.LockoutMax).Result()
+	if err != nil {
+		return fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if !locked {
+		return nil
+	}
+
+	countKey := l.lockoutCountPrefix + subject.key
+	var lockouts *redis.IntCmd
+	_, err = l.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		lockouts = pipe.Incr(ctx, countKey)
+		pipe.PExpire(ctx, countKey, subject.policy.LockoutMemory)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis INCR error: %w", err)
+	}
+
+	_, err = l.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.PExpire(ctx, lockoutKey, subject.policy.LockoutDuration(lockouts.Val()))
+		pipe.Del(ctx, failuresKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis PEXPIRE error: %w", err)
+	}
+	return nil
+}
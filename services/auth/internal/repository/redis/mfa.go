@@ -0,0 +1,139 @@
+// Package redisrepo defines the Redis MFA repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// MFARepository defines a Redis repository of TOTP credentials and pending MFA challenges.
+type MFARepository struct {
+	rdb                     *redis.Client
+	totpPrefix              string
+	totpUsedStepPrefix      string
+	challengePrefix         string
+	challengeFailuresPrefix string
+}
+
+// NewMFARepository creates a new Redis MFA repository.
+func NewMFARepository(rdb *redis.Client) *MFARepository {
+	return &MFARepository{
+		rdb:                     rdb,
+		totpPrefix:              constant.RedisTOTPCredentialPrefix,
+		totpUsedStepPrefix:      constant.RedisTOTPUsedStepPrefix,
+		challengePrefix:         constant.RedisMFAChallengePrefix,
+		challengeFailuresPrefix: constant.RedisMFAChallengeFailuresPrefix,
+	}
+}
+
+// GetTOTPCredential gets the TOTP credential of a member.
+func (r *MFARepository) GetTOTPCredential(ctx context.Context, memberID string) (*model.TOTPCredential, error) {
+	data, err := r.rdb.Get(ctx, r.totpPrefix+memberID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrTOTPCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+
+	var credential model.TOTPCredential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &credential, nil
+}
+
+// SaveTOTPCredential creates or replaces the TOTP credential of a member.
+func (r *MFARepository) SaveTOTPCredential(ctx context.Context, credential *model.TOTPCredential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	if err := r.rdb.Set(ctx, r.totpPrefix+credential.MemberID, data, 0).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// UseTOTPStep marks a TOTP time step of a member as used for ttl and reports whether it was unused,
+// so that each code is accepted at most once.
+func (r *MFARepository) UseTOTPStep(ctx context.Context, memberID string, step int64, ttl time.Duration) (bool, error) {
+	key := r.totpUsedStepPrefix + memberID + ":" + strconv.FormatInt(step, 10)
+	unused, err := r.rdb.SetNX(ctx, key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	return unused, nil
+}
+
+// SaveMFAChallenge saves an MFA challenge until it expires.
+func (r *MFARepository) SaveMFAChallenge(ctx context.Context, challenge *model.MFAChallenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(challenge.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("MFA challenge already expired at %s", challenge.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, r.challengePrefix+string(challenge.TokenHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// GetMFAChallenge gets an MFA challenge by token hash.
+func (r *MFARepository) GetMFAChallenge(ctx context.Context, tokenHash model.MFAChallengeHash) (*model.MFAChallenge, error) {
+	data, err := r.rdb.Get(ctx, r.challengePrefix+string(tokenHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+
+	var challenge model.MFAChallenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &challenge, nil
+}
+
+// RecordMFAChallengeFailure records a failed attempt at an MFA challenge and returns the number of failures so far.
+func (r *MFARepository) RecordMFAChallengeFailure(ctx context.Context, challenge *model.MFAChallenge) (int64, error) {
+	key := r.challengeFailuresPrefix + string(challenge.TokenHash)
+	var failures *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, challenge.ExpiresAt)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis INCR error: %w", err)
+	}
+	return failures.Val(), nil
+}
+
+// DeleteMFAChallenge deletes an MFA challenge and reports whether it still existed,
+// so that of concurrent completions of the same challenge only one succeeds.
+func (r *MFARepository) DeleteMFAChallenge(ctx context.Context, tokenHash model.MFAChallengeHash) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, r.challengePrefix+string(tokenHash))
+		pipe.Del(ctx, r.challengeFailuresPrefix+string(tokenHash))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis DEL error: %w", err)
+	}
+	return deleted.Val() > 0, nil
+}
@@ -0,0 +1,62 @@
+// Package redisrepo defines the Redis OAuth repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// OAuthRepository defines a Redis repository of OAuth authorization codes.
+type OAuthRepository struct {
+	rdb        *redis.Client
+	codePrefix string
+}
+
+// NewOAuthRepository creates a new Redis OAuth repository.
+func NewOAuthRepository(rdb *redis.Client) *OAuthRepository {
+	return &OAuthRepository{
+		rdb:        rdb,
+		codePrefix: constant.RedisAuthorizationCodePrefix,
+	}
+}
+
+// SaveAuthorizationCode saves an authorization code until it expires.
+func (r *OAuthRepository) SaveAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error {
+	data, err := json.Marshal(code)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(code.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("authorization code already expired at %s", code.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, r.codePrefix+string(code.CodeHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode gets and deletes an authorization code, so that each code is exchanged at most once.
+func (r *OAuthRepository) ConsumeAuthorizationCode(ctx context.Context, codeHash model.AuthorizationCodeHash) (*model.AuthorizationCode, error) {
+	data, err := r.rdb.GetDel(ctx, r.codePrefix+string(codeHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrAuthorizationCodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+
+	var code model.AuthorizationCode
+	if err := json.Unmarshal(data, &code); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &code, nil
+}
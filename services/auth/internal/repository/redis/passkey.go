@@ -0,0 +1,160 @@
+// Package redisrepo defines the Redis passkey repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// PasskeyRepository defines a Redis repository of passkey credentials and pending WebAuthn ceremonies.
+type PasskeyRepository struct {
+	rdb                    *redis.Client
+	credentialPrefix       string
+	memberCredentialPrefix string
+	sessionPrefix          string
+}
+
+// NewPasskeyRepository creates a new Redis passkey repository.
+func NewPasskeyRepository(rdb *redis.Client) *PasskeyRepository {
+	return &PasskeyRepository{
+		rdb:                    rdb,
+		credentialPrefix:       constant.RedisPasskeyCredentialPrefix,
+		memberCredentialPrefix: constant.RedisMemberPasskeysPrefix,
+		sessionPrefix:          constant.RedisWebAuthnSessionPrefix,
+	}
+}
+
+// ListPasskeyCredentials lists the passkey credentials of a member.
+func (r *PasskeyRepository) ListPasskeyCredentials(ctx context.Context, memberID string) ([]*model.PasskeyCredential, error) {
+	ids, err := r.rdb.SMembers(ctx, r.memberCredentialPrefix+memberID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis SMEMBERS error: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = r.credentialPrefix + id
+	}
+	values, err := r.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis MGET error: %w", err)
+	}
+
+	credentials := make([]*model.PasskeyCredential, 0, len(values))
+	for _, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var credential model.PasskeyCredential
+		if err := json.Unmarshal([]byte(raw), &credential); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+		}
+		credentials = append(credentials, &credential)
+	}
+	return credentials, nil
+}
+
+// GetPasskeyCredential gets a passkey credential by credential ID.
+func (r *PasskeyRepository) GetPasskeyCredential(ctx context.Context, credentialID []byte) (*model.PasskeyCredential, error) {
+	data, err := r.rdb.Get(ctx, r.credentialKey(credentialID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrPasskeyCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+
+	var credential model.PasskeyCredential
+	if err := json.Unmarshal(data, &credential); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &credential, nil
+}
+
+// CreatePasskeyCredential registers a new passkey credential,
+// failing with repository.ErrPasskeyCredentialExists if its ID is already registered.
+func (r *PasskeyRepository) CreatePasskeyCredential(ctx context.Context, credential *model.PasskeyCredential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	created, err := r.rdb.SetNX(ctx, r.credentialKey(credential.ID), data, 0).Result()
+	if err != nil {
+		return fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if !created {
+		return repository.ErrPasskeyCredentialExists
+	}
+	id := base64.RawURLEncoding.EncodeToString(credential.ID)
+	if err := r.rdb.SAdd(ctx, r.memberCredentialPrefix+credential.MemberID, id).Err(); err != nil {
+		return fmt.Errorf("redis SADD error: %w", err)
+	}
+	return nil
+}
+
+// UpdatePasskeyCredential replaces a registered passkey credential, e.g. after its sign count changed.
+func (r *PasskeyRepository) UpdatePasskeyCredential(ctx context.Context, credential *model.PasskeyCredential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	updated, err := r.rdb.SetXX(ctx, r.credentialKey(credential.ID), data, 0).Result()
+	if err != nil {
+		return fmt.Errorf("redis SET XX error: %w", err)
+	}
+	if !updated {
+		return repository.ErrPasskeyCredentialNotFound
+	}
+	return nil
+}
+
+// SaveWebAuthnSession saves a pending WebAuthn ceremony until it expires.
+func (r *PasskeyRepository) SaveWebAuthnSession(ctx context.Context, session *model.WebAuthnSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("WebAuthn session already expired at %s", session.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, r.sessionPrefix+string(session.CeremonyHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeWebAuthnSession gets and deletes a pending WebAuthn ceremony, so that each challenge is answered at most once.
+func (r *PasskeyRepository) ConsumeWebAuthnSession(ctx context.Context, ceremonyHash model.WebAuthnSessionHash) (*model.WebAuthnSession, error) {
+	data, err := r.rdb.GetDel(ctx, r.sessionPrefix+string(ceremonyHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrWebAuthnSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+
+	var session model.WebAuthnSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &session, nil
+}
+
+// credentialKey returns the key of a credential ID.
+func (r *PasskeyRepository) credentialKey(credentialID []byte) string {
+	return r.credentialPrefix + base64.RawURLEncoding.EncodeToString(credentialID)
+}
@@ -0,0 +1,84 @@
+// Package redisrepo defines the Redis password reset repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// PasswordResetRepository defines a Redis repository of pending password resets.
+type PasswordResetRepository struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+// NewPasswordResetRepository creates a new Redis password reset repository.
+func NewPasswordResetRepository(rdb *redis.Client) *PasswordResetRepository {
+	return &PasswordResetRepository{
+		rdb:    rdb,
+		prefix: constant.RedisPasswordResetPrefix,
+	}
+}
+
+// SavePasswordReset saves a password reset until it expires.
+func (r *PasswordResetRepository) SavePasswordReset(ctx context.Context, reset *model.PasswordReset) error {
+	data, err := json.Marshal(reset)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(reset.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("password reset already expired at %s", reset.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, r.prefix+string(reset.TokenHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumePasswordReset gets and deletes a password reset, so that each reset token is used at most once.
+func (r *PasswordResetRepository) ConsumePasswordReset(ctx context.Context, tokenHash model.PasswordResetTokenHash) (*model.PasswordReset, error) {
+	data, err := r.rdb.GetDel(ctx, r.prefix+string(tokenHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrPasswordResetNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+
+	var reset model.PasswordReset
+	if err := json.Unmarshal(data, &reset); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &reset, nil
+}
+
+// RecordPasswordResetEmail records a password reset asked for an email, unless one was recorded less than interval
+// ago, in which case it returns how long until the next one may be asked for.
+func (r *PasswordResetRepository) RecordPasswordResetEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error) {
+	key := constant.RedisPasswordResetEmailSentPrefix + email
+	ok, err := r.rdb.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if ok {
+		return 0, nil
+	}
+	retryAfter, err := r.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis PTTL error: %w", err)
+	}
+	// The key may have expired since SETNX; the next email may then be sent right away.
+	if retryAfter <= 0 {
+		return time.Millisecond, nil
+	}
+	return retryAfter, nil
+}
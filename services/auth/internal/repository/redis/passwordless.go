@@ -0,0 +1,111 @@
+// Package redisrepo defines the Redis passwordless login repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// PasswordlessRepository defines a Redis repository of pending passwordless logins.
+type PasswordlessRepository struct {
+	rdb *redis.Client
+}
+
+// NewPasswordlessRepository creates a new Redis passwordless login repository.
+func NewPasswordlessRepository(rdb *redis.Client) *PasswordlessRepository {
+	return &PasswordlessRepository{rdb: rdb}
+}
+
+// SavePasswordlessChallenge saves a passwordless challenge until it expires.
+func (r *PasswordlessRepository) SavePasswordlessChallenge(ctx context.Context, challenge *model.PasswordlessChallenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(challenge.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("passwordless challenge already expired at %s", challenge.ExpiresAt)
+	}
+	if err := r.rdb.Set(ctx, constant.RedisPasswordlessChallengePrefix+string(challenge.IDHash), data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// GetPasswordlessChallenge gets a passwordless challenge by ID hash.
+func (r *PasswordlessRepository) GetPasswordlessChallenge(ctx context.Context, idHash model.PasswordlessChallengeHash) (*model.PasswordlessChallenge, error) {
+	data, err := r.rdb.Get(ctx, constant.RedisPasswordlessChallengePrefix+string(idHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrPasswordlessChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET error: %w", err)
+	}
+
+	var challenge model.PasswordlessChallenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &challenge, nil
+}
+
+// RecordPasswordlessChallengeFailure records a wrong code for a passwordless challenge and returns the number of
+// failures so far.
+func (r *PasswordlessRepository) RecordPasswordlessChallengeFailure(ctx context.Context, challenge *model.PasswordlessChallenge) (int64, error) {
+	key := constant.RedisPasswordlessChallengeFailuresPrefix + string(challenge.IDHash)
+	var failures *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, challenge.ExpiresAt)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis INCR error: %w", err)
+	}
+	return failures.Val(), nil
+}
+
+// DeletePasswordlessChallenge deletes a passwordless challenge and reports whether it still existed,
+// so that of concurrent completions of the same challenge only one succeeds.
+func (r *PasswordlessRepository) DeletePasswordlessChallenge(ctx context.Context, idHash model.PasswordlessChallengeHash) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, constant.RedisPasswordlessChallengePrefix+string(idHash))
+		pipe.Del(ctx, constant.RedisPasswordlessChallengeFailuresPrefix+string(idHash))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("redis DEL error: %w", err)
+	}
+	return deleted.Val() > 0, nil
+}
+
+// RecordPasswordlessEmail records a passwordless login email to an email, unless one was recorded less than
+// interval ago, in which case it returns how long until the next one may be sent.
+func (r *PasswordlessRepository) RecordPasswordlessEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error) {
+	key := constant.RedisPasswordlessEmailSentPrefix + email
+	ok, err := r.rdb.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if ok {
+		return 0, nil
+	}
+	retryAfter, err := r.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis PTTL error: %w", err)
+	}
+	// The key may have expired since SETNX; the next email may then be sent right away.
+	if retryAfter <= 0 {
+		return time.Millisecond, nil
+	}
+	return retryAfter, nil
+}
@@ -4,7 +4,9 @@ package redisrepo
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/incheat/go-production-backend/services/auth/internal/constant"
@@ -13,17 +15,24 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// maxTxRetries is the number of optimistic transaction attempts before giving up.
+const maxTxRetries = 3
+
 // RefreshTokenRepository defines a Redis refresh token repository.
 type RefreshTokenRepository struct {
-	rdb    *redis.Client
-	prefix string
+	rdb          *redis.Client
+	prefix       string
+	familyPrefix string
+	memberPrefix string
 }
 
 // NewRefreshTokenRepository creates a new Redis refresh token repository.
 func NewRefreshTokenRepository(rdb *redis.Client) *RefreshTokenRepository {
 	return &RefreshTokenRepository{
-		rdb:    rdb,
-		prefix: constant.RedisRefreshTokenPrefix, // key prefix in Redis
+		rdb:          rdb,
+		prefix:       constant.RedisRefreshTokenPrefix, // key prefix in Redis
+		familyPrefix: constant.RedisRefreshTokenFamilyPrefix,
+		memberPrefix: constant.RedisRefreshTokenMemberPrefix,
 	}
 }
 
@@ -32,10 +41,19 @@ func (r *RefreshTokenRepository) key(hash string) string {
 	return r.prefix + hash
 }
 
+// familyKey builds the Redis key of the set holding the token hashes of a family.
+func (r *RefreshTokenRepository) familyKey(familyID string) string {
+	return r.familyPrefix + familyID
+}
+
+// memberKey builds the Redis key of the sorted set indexing the token hashes of a member by expiry.
+func (r *RefreshTokenRepository) memberKey(memberID string) string {
+	return r.memberPrefix + memberID
+}
+
 // GetRefreshTokenSession gets a refresh token session by token hash.
-func (r *RefreshTokenRepository) GetRefreshTokenSession(ctx context.Context, refreshToken model.RefreshToken) (*model.RefreshTokenSession, error) {
-	tokenHash := string(refreshToken)
-	key := r.key(tokenHash)
+func (r *RefreshTokenRepository) GetRefreshTokenSession(ctx context.Context, tokenHash model.RefreshTokenHash) (*model.RefreshTokenSession, error) {
+	key := r.key(string(tokenHash))
 
 	data, err := r.rdb.Get(ctx, key).Bytes()
 	if err == redis.Nil {
@@ -45,18 +63,12 @@ func (r *RefreshTokenRepository) GetRefreshTokenSession(ctx context.Context, ref
 		return nil, fmt.Errorf("redis GET error: %w", err)
 	}
 
-	var session model.RefreshTokenSession
-	if err := json.Unmarshal(data, &session); err != nil {
-		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
-	}
-
-	return &session, nil
+	return decodeSession(data)
 }
 
 // SaveRefreshTokenSession saves a refresh token session.
 func (r *RefreshTokenRepository) SaveRefreshTokenSession(ctx context.Context, session *model.RefreshTokenSession) error {
-	tokenHash := string(session.TokenHash)
-	key := r.key(tokenHash)
+	key := r.key(string(session.TokenHash))
 
 	// Check existence first (to match memory repo behavior)
 	exists, err := r.rdb.Exists(ctx, key).Result()
@@ -73,16 +85,304 @@ func (r *RefreshTokenRepository) SaveRefreshTokenSession(ctx context.Context, se
 		return fmt.Errorf("json.Marshal error: %w", err)
 	}
 
-	// TTL = ExpiresAt - Now
-	ttl := time.Until(session.ExpiresAt)
-	if ttl <= 0 {
-		ttl = time.Minute // fallback TTL just in case
-	}
+	ttl := sessionTTL(session)
 
-	// Store session with TTL
-	if err := r.rdb.Set(ctx, key, data, ttl).Err(); err != nil {
+	// Store session with TTL and index it under its family and member
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, ttl)
+		r.addToIndexes(ctx, pipe, session, ttl)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("redis SET error: %w", err)
 	}
 
 	return nil
 }
+
+// RotateRefreshTokenSession marks the session of oldTokenHash as rotated and saves newSession in its place.
+// The check-and-swap runs in a WATCH transaction so concurrent rotations of the same token cannot both succeed.
+func (r *RefreshTokenRepository) RotateRefreshTokenSession(ctx context.Context, oldTokenHash model.RefreshTokenHash, newSession *model.RefreshTokenSession) error {
+	oldKey := r.key(string(oldTokenHash))
+	newKey := r.key(string(newSession.TokenHash))
+
+	newData, err := json.Marshal(newSession)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	newTTL := sessionTTL(newSession)
+
+	err = r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, oldKey).Bytes()
+		if err == redis.Nil {
+			return repository.ErrRefreshTokenNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("redis GET error: %w", err)
+		}
+
+		old, err := decodeSession(data)
+		if err != nil {
+			return err
+		}
+		if old.IsRevoked() {
+			return repository.ErrRefreshTokenRevoked
+		}
+		if old.IsRotated() {
+			return repository.ErrRefreshTokenReused
+		}
+
+		exists, err := tx.Exists(ctx, newKey).Result()
+		if err != nil {
+			return fmt.Errorf("redis EXISTS error: %w", err)
+		}
+		if exists > 0 {
+			return repository.ErrRefreshTokenAlreadyExists
+		}
+
+		old.RotatedAt = newSession.CreatedAt
+		oldData, err := json.Marshal(old)
+		if err != nil {
+			return fmt.Errorf("json.Marshal error: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, oldKey, oldData, redis.KeepTTL)
+			pipe.Set(ctx, newKey, newData, newTTL)
+			// The rotated session stays in its family for reuse detection only.
+			pipe.ZRem(ctx, r.memberKey(old.MemberID), string(oldTokenHash))
+			r.addToIndexes(ctx, pipe, newSession, newTTL)
+			return nil
+		})
+		return err
+	}, oldKey)
+
+	// Another request rotated the same token between WATCH and EXEC.
+	if errors.Is(err, redis.TxFailedErr) {
+		return repository.ErrRefreshTokenReused
+	}
+	return err
+}
+
+// RevokeRefreshTokenFamily revokes every session that belongs to the given family.
+func (r *RefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	familyKey := r.familyKey(familyID)
+
+	for range maxTxRetries {
+		err := r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			hashes, err := tx.SMembers(ctx, familyKey).Result()
+			if err != nil {
+				return fmt.Errorf("redis SMEMBERS error: %w", err)
+			}
+			return r.revokeKeys(ctx, tx, r.keys(hashes), revokedAt)
+		}, familyKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("revoke refresh token family %s: %w", familyID, redis.TxFailedErr)
+}
+
+// ListMemberRefreshTokenSessions lists the unexpired, unrotated sessions of a member through the member index.
+// Index entries whose session has already expired are pruned on the way.
+func (r *RefreshTokenRepository) ListMemberRefreshTokenSessions(ctx context.Context, memberID string) ([]*model.RefreshTokenSession, error) {
+	memberKey := r.memberKey(memberID)
+
+	if err := r.rdb.ZRemRangeByScore(ctx, memberKey, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10)).Err(); err != nil {
+		return nil, fmt.Errorf("redis ZREMRANGEBYSCORE error: %w", err)
+	}
+
+	hashes, err := r.rdb.ZRange(ctx, memberKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis ZRANGE error: %w", err)
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	values, err := r.rdb.MGet(ctx, r.keys(hashes)...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis MGET error: %w", err)
+	}
+
+	sessions := make([]*model.RefreshTokenSession, 0, len(values))
+	var stale []any
+	for i, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			stale = append(stale, hashes[i]) // session key expired before its index entry
+			continue
+		}
+		session, err := decodeSession([]byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if len(stale) > 0 {
+		if err := r.rdb.ZRem(ctx, memberKey, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("redis ZREM error: %w", err)
+		}
+	}
+
+	return sessions, nil
+}
+
+// RevokeRefreshTokenSession revokes the session of a single refresh token hash.
+func (r *RefreshTokenRepository) RevokeRefreshTokenSession(ctx context.Context, tokenHash model.RefreshTokenHash, revokedAt time.Time) error {
+	key := r.key(string(tokenHash))
+
+	for range maxTxRetries {
+		err := r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			exists, err := tx.Exists(ctx, key).Result()
+			if err != nil {
+				return fmt.Errorf("redis EXISTS error: %w", err)
+			}
+			if exists == 0 {
+				return repository.ErrRefreshTokenNotFound
+			}
+			return r.revokeKeys(ctx, tx, []string{key}, revokedAt)
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("revoke refresh token session: %w", redis.TxFailedErr)
+}
+
+// RevokeMemberRefreshTokenSessions revokes every session that belongs to the given member.
+func (r *RefreshTokenRepository) RevokeMemberRefreshTokenSessions(ctx context.Context, memberID string, revokedAt time.Time) error {
+	memberKey := r.memberKey(memberID)
+
+	for range maxTxRetries {
+		err := r.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			hashes, err := r.liveMemberHashes(ctx, tx, memberKey)
+			if err != nil {
+				return err
+			}
+			return r.revokeKeys(ctx, tx, r.keys(hashes), revokedAt)
+		}, memberKey)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("revoke refresh token sessions of member %s: %w", memberID, redis.TxFailedErr)
+}
+
+// liveMemberHashes returns the token hashes indexed under a member whose sessions have not expired yet.
+func (r *RefreshTokenRepository) liveMemberHashes(ctx context.Context, tx *redis.Tx, memberKey string) ([]string, error) {
+	hashes, err := tx.ZRangeByScore(ctx, memberKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis ZRANGEBYSCORE error: %w", err)
+	}
+	return hashes, nil
+}
+
+// revokeKeys sets RevokedAt on every live session stored under keys, keeping their TTLs.
+func (r *RefreshTokenRepository) revokeKeys(ctx context.Context, tx *redis.Tx, keys []string, revokedAt time.Time) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := tx.Watch(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("redis WATCH error: %w", err)
+	}
+
+	values, err := tx.MGet(ctx, keys...).Result()
+	if err != nil {
+		return fmt.Errorf("redis MGET error: %w", err)
+	}
+
+	updates := make(map[string][]byte, len(keys))
+	for i, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			continue // expired since it was indexed
+		}
+		session, err := decodeSession([]byte(raw))
+		if err != nil {
+			return err
+		}
+		if session.IsRevoked() {
+			continue
+		}
+		session.RevokedAt = revokedAt
+		data, err := json.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("json.Marshal error: %w", err)
+		}
+		updates[keys[i]] = data
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, data := range updates {
+			pipe.Set(ctx, key, data, redis.KeepTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// addToIndexes queues the commands indexing session under its family and its member.
+// The member index is scored by expiry so expired entries can be pruned;
+// both indexes live at least as long as the newest session in them (EXPIRE GT/NX, Redis 7+).
+func (r *RefreshTokenRepository) addToIndexes(ctx context.Context, pipe redis.Pipeliner, session *model.RefreshTokenSession, ttl time.Duration) {
+	tokenHash := string(session.TokenHash)
+
+	if session.FamilyID != "" {
+		familyKey := r.familyKey(session.FamilyID)
+		pipe.SAdd(ctx, familyKey, tokenHash)
+		extendTTL(ctx, pipe, familyKey, ttl)
+	}
+
+	if session.MemberID != "" {
+		memberKey := r.memberKey(session.MemberID)
+		pipe.ZRemRangeByScore(ctx, memberKey, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10))
+		pipe.ZAdd(ctx, memberKey, redis.Z{Score: float64(session.ExpiresAt.Unix()), Member: tokenHash})
+		extendTTL(ctx, pipe, memberKey, ttl)
+	}
+}
+
+// extendTTL sets the TTL of key to ttl unless it already outlives it.
+func extendTTL(ctx context.Context, pipe redis.Pipeliner, key string, ttl time.Duration) {
+	pipe.ExpireGT(ctx, key, ttl)
+	pipe.ExpireNX(ctx, key, ttl)
+}
+
+// keys builds the Redis keys for a list of token hashes.
+func (r *RefreshTokenRepository) keys(hashes []string) []string {
+	keys := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		keys = append(keys, r.key(hash))
+	}
+	return keys
+}
+
+func decodeSession(data []byte) (*model.RefreshTokenSession, error) {
+	var session model.RefreshTokenSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &session, nil
+}
+
+// sessionTTL returns the Redis TTL for a session: ExpiresAt - Now.
+func sessionTTL(session *model.RefreshTokenSession) time.Duration {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		ttl = time.Minute // fallback TTL just in case
+	}
+	return ttl
+}
@@ -0,0 +1,82 @@
+// Package redisrepo defines the Redis email verification repository.
+package redisrepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/constant"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/redis/go-redis/v9"
+)
+
+// EmailVerificationRepository defines a Redis repository of pending email verifications.
+type EmailVerificationRepository struct {
+	rdb *redis.Client
+}
+
+// NewEmailVerificationRepository creates a new Redis email verification repository.
+func NewEmailVerificationRepository(rdb *redis.Client) *EmailVerificationRepository {
+	return &EmailVerificationRepository{rdb: rdb}
+}
+
+// SaveEmailVerification saves an email verification until it expires.
+func (r *EmailVerificationRepository) SaveEmailVerification(ctx context.Context, verification *model.EmailVerification) error {
+	data, err := json.Marshal(verification)
+	if err != nil {
+		return fmt.Errorf("json.Marshal error: %w", err)
+	}
+	ttl := time.Until(verification.ExpiresAt)
+	if ttl <= 0 {
+		return fmt.Errorf("email verification already expired at %s", verification.ExpiresAt)
+	}
+	key := constant.RedisEmailVerificationPrefix + string(verification.IDHash)
+	if err := r.rdb.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET error: %w", err)
+	}
+	return nil
+}
+
+// ConsumeEmailVerification gets and deletes an email verification, so that each verification link is followed at
+// most once.
+func (r *EmailVerificationRepository) ConsumeEmailVerification(ctx context.Context, idHash model.EmailVerificationHash) (*model.EmailVerification, error) {
+	data, err := r.rdb.GetDel(ctx, constant.RedisEmailVerificationPrefix+string(idHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, repository.ErrEmailVerificationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GETDEL error: %w", err)
+	}
+
+	var verification model.EmailVerification
+	if err := json.Unmarshal(data, &verification); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal error: %w", err)
+	}
+	return &verification, nil
+}
+
+// RecordVerificationEmail records a verification email to an email, unless one was recorded less than interval
+// ago, in which case it returns how long until the next one may be sent.
+func (r *EmailVerificationRepository) RecordVerificationEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error) {
+	key := constant.RedisEmailVerificationSentPrefix + email
+	ok, err := r.rdb.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	if ok {
+		return 0, nil
+	}
+	retryAfter, err := r.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis PTTL error: %w", err)
+	}
+	// The key may have expired since SETNX; the next email may then be sent right away.
+	if retryAfter <= 0 {
+		return time.Millisecond, nil
+	}
+	return retryAfter, nil
+}
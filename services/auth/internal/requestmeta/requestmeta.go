@@ -0,0 +1,26 @@
+// Package requestmeta defines the metadata of a request carried in its context, so that the services can read it
+// without depending on the transport that set it.
+package requestmeta
+
+import "context"
+
+type metaKey struct{}
+
+// Meta is the metadata for the request.
+type Meta struct {
+	RequestID string
+	UserAgent string
+	IPAddress string
+	// Additional metadata: Referer, AcceptLanguage, etc.
+}
+
+// With adds the request metadata to the context.
+func With(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, metaKey{}, meta)
+}
+
+// Get gets the request metadata from the context.
+func Get(ctx context.Context) (Meta, bool) {
+	meta, ok := ctx.Value(metaKey{}).(Meta)
+	return meta, ok
+}
@@ -0,0 +1,61 @@
+// Package authservice defines the audit trail of the auth API.
+package authservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// AuditSink is the interface for the destination of audit events.
+// Writes must not block the request, e.g. by buffering events; events that cannot be written are reported by the
+// sink, as auditing never fails a request.
+type AuditSink interface {
+	WriteAuditEvent(ctx context.Context, event model.AuditEvent) error
+}
+
+// recordAudit timestamps an audit event and sends it to the audit sink. The request ID, and the client IP and user
+// agent when the event has none, are taken from the request metadata of ctx.
+func (s *Service) recordAudit(ctx context.Context, event model.AuditEvent) {
+	event.OccurredAt = time.Now().UTC()
+	if meta, ok := requestmeta.Get(ctx); ok {
+		event.RequestID = meta.RequestID
+		if event.IPAddress == "" {
+			event.IPAddress = meta.IPAddress
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = meta.UserAgent
+		}
+	}
+	_ = s.audit.WriteAuditEvent(ctx, event)
+}
+
+// recordLoginFailure records a login of memberID refused for reason, with the factor it failed at.
+func (s *Service) recordLoginFailure(ctx context.Context, clientID, memberID, authMethod, ipAddress, reason string) {
+	s.recordAudit(ctx, model.AuditEvent{
+		Type:        model.AuditEventLoginFailed,
+		MemberID:    memberID,
+		ClientID:    clientID,
+		AuthMethods: []string{authMethod},
+		Reason:      reason,
+		IPAddress:   ipAddress,
+	})
+}
+
+// recordSessionRevoked records the revocation of a session for reason. Revocations of every session of a member
+// are recorded without session.
+func (s *Service) recordSessionRevoked(ctx context.Context, memberID string, session *model.RefreshTokenSession, reason string) {
+	event := model.AuditEvent{
+		Type:     model.AuditEventSessionRevoked,
+		MemberID: memberID,
+		Reason:   reason,
+	}
+	if session != nil {
+		event.SessionID = session.ID
+		event.FamilyID = session.FamilyID
+		event.ClientID = session.ClientID
+	}
+	s.recordAudit(ctx, event)
+}
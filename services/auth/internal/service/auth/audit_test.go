@@ -0,0 +1,114 @@
+package authservice_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/requestmeta"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitAudit tests that logins, refreshes and revocations of a session are audited with the member, the session
+// and the request.
+func TestUnitAudit(t *testing.T) {
+	ctx := requestmeta.With(context.Background(), requestmeta.Meta{
+		RequestID: "request-1",
+		UserAgent: "ua",
+		IPAddress: "1.2.3.4",
+	})
+	f := newOAuthFixture(&usermodel.User{Email: "user@example.com"})
+
+	_, err := f.svc.LoginWithEmailAndPassword(ctx, "user@example.com", "wrong", nil, "ua", "1.2.3.4")
+	require.ErrorIs(t, err, authservice.ErrInvalidCredentials)
+	_, err = f.svc.LoginWithEmailAndPassword(ctx, "user@example.com", "password", nil, "ua", "1.2.3.4")
+	require.NoError(t, err)
+	login, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+	require.NoError(t, err)
+	_, err = f.svc.Refresh(ctx, "refresh-token", "ua", "5.6.7.8")
+	require.NoError(t, err)
+	refreshed, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("rotated-refresh-token"))
+	require.NoError(t, err)
+	require.NoError(t, f.svc.Logout(ctx, "access-token", "rotated-refresh-token", false))
+	_, err = f.svc.Refresh(ctx, "refresh-token", "ua", "1.2.3.4")
+	require.ErrorIs(t, err, authservice.ErrRefreshTokenReused)
+
+	events := f.audit.Events()
+	require.Len(t, events, 5)
+	for _, event := range events {
+		assert.Equal(t, "user@example.com", event.MemberID)
+		assert.Equal(t, "request-1", event.RequestID)
+		assert.Equal(t, "ua", event.UserAgent)
+		assert.False(t, event.OccurredAt.IsZero())
+	}
+
+	assert.Equal(t, model.AuditEventLoginFailed, events[0].Type)
+	assert.Equal(t, model.AuditReasonInvalidCredentials, events[0].Reason)
+	assert.Equal(t, []string{model.AuthMethodPassword}, events[0].AuthMethods)
+	assert.Empty(t, events[0].SessionID)
+
+	assert.Equal(t, model.AuditEventLoginSucceeded, events[1].Type)
+	assert.Equal(t, login.ID, events[1].SessionID)
+	assert.Equal(t, login.FamilyID, events[1].FamilyID)
+	assert.Equal(t, []string{model.AuthMethodPassword}, events[1].AuthMethods)
+	assert.Empty(t, events[1].Reason)
+
+	assert.Equal(t, model.AuditEventTokenRefreshed, events[2].Type)
+	assert.Equal(t, refreshed.ID, events[2].SessionID)
+	assert.Equal(t, login.FamilyID, events[2].FamilyID)
+	assert.Equal(t, "5.6.7.8", events[2].IPAddress, "the IP of the service call wins over the request metadata")
+
+	assert.Equal(t, model.AuditEventSessionRevoked, events[3].Type)
+	assert.Equal(t, model.AuditReasonLogout, events[3].Reason)
+	assert.Equal(t, refreshed.ID, events[3].SessionID)
+	assert.Equal(t, "1.2.3.4", events[3].IPAddress)
+
+	assert.Equal(t, model.AuditEventSessionRevoked, events[4].Type)
+	assert.Equal(t, model.AuditReasonRefreshTokenReused, events[4].Reason)
+	assert.Equal(t, login.FamilyID, events[4].FamilyID)
+}
+
+// TestUnitAudit_SecondFactor tests that wrong one-time passwords are audited as failed logins of the member.
+func TestUnitAudit_SecondFactor(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{Email: "user@example.com"}
+	f := newOAuthFixture(user)
+	secret := f.mfa.enableTOTP(t)
+
+	res, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "ua", "1.2.3.4")
+	require.NoError(t, err)
+	require.NotNil(t, res.MFAChallenge)
+	assert.Empty(t, f.audit.Events(), "the login is not done until its second factor")
+
+	_, err = f.svc.LoginWithMFA(ctx, res.MFAChallenge.Token, "not-a-code", "ua", "1.2.3.4")
+	require.ErrorIs(t, err, authservice.ErrInvalidOTP)
+	_, err = f.svc.LoginWithMFA(ctx, res.MFAChallenge.Token, nextTOTPCode(secret), "ua", "1.2.3.4")
+	require.NoError(t, err)
+
+	events := f.audit.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, model.AuditEventLoginFailed, events[0].Type)
+	assert.Equal(t, model.AuditReasonInvalidOTP, events[0].Reason)
+	assert.Equal(t, []string{model.AuthMethodOTP}, events[0].AuthMethods)
+	assert.Equal(t, user.Email, events[0].MemberID)
+	assert.Equal(t, "1.2.3.4", events[0].IPAddress)
+	assert.Equal(t, model.AuditEventLoginSucceeded, events[1].Type)
+	assert.Equal(t, []string{model.AuthMethodPassword, model.AuthMethodOTP}, events[1].AuthMethods)
+}
+
+// assertLoginFailed asserts that the last audit event is a failed login of memberID with authMethod for reason.
+func assertLoginFailed(t *testing.T, sink *audit.MemorySink, memberID, authMethod, reason string) {
+	t.Helper()
+	events := sink.Events()
+	require.NotEmpty(t, events)
+	event := events[len(events)-1]
+	assert.Equal(t, model.AuditEventLoginFailed, event.Type)
+	assert.Equal(t, memberID, event.MemberID)
+	assert.Equal(t, []string{authMethod}, event.AuthMethods)
+	assert.Equal(t, reason, event.Reason)
+	assert.Equal(t, "1.2.3.4", event.IPAddress)
+}
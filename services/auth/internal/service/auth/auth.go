@@ -3,25 +3,52 @@ package authservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
 	"github.com/incheat/go-production-backend/services/auth/pkg/model"
 	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
 )
 
 // Service is the service for the auth API.
 type Service struct {
-	accessToken      AccessTokenMaker
-	refreshToken     RefreshTokenMaker
-	refreshTokenRepo RefreshTokenRepository
-	userGateway      UserGateway
+	accessToken       AccessTokenMaker
+	refreshToken      RefreshTokenMaker
+	refreshHasher     RefreshTokenHasher
+	refreshTokenRepo  RefreshTokenRepository
+	denylist          AccessTokenDenylist
+	loginLimiter      LoginLimiter
+	mfaRepo           MFARepository
+	totp              TOTPMaker
+	secretCipher      SecretCipher
+	passkeyRepo       PasskeyRepository
+	passkeys          PasskeyRelyingParty
+	clients           ClientRegistry
+	oauthRepo         OAuthRepository
+	idToken           IDTokenMaker
+	userGateway       UserGateway
+	passwordResets    PasswordResetRepository
+	mailer            Mailer
+	links             Links
+	verifications     EmailVerificationRepository
+	linkSigner        LinkSigner
+	passwordless      PasswordlessRepository
+	notifier          LoginNotifier
+	federatedLogins   FederatedLoginRepository
+	identityProviders IdentityProviders
+	audit             AuditSink
 }
 
 // AccessTokenMaker is the interface for the access token maker.
 type AccessTokenMaker interface {
-	CreateToken(ID string) (model.AccessToken, error)
+	CreateToken(ID string, grant model.AccessTokenGrant) (model.AccessToken, *model.AccessTokenClaims, error)
+	ParseToken(token string) (*model.AccessTokenClaims, error)
 }
 
 // RefreshTokenMaker is the interface for the refresh token maker.
@@ -31,34 +58,202 @@ type RefreshTokenMaker interface {
 	RefreshEndPoint() string
 }
 
+// RefreshTokenHasher is the interface for the refresh token hasher.
+type RefreshTokenHasher interface {
+	Hash(refreshToken model.RefreshToken) model.RefreshTokenHash
+	Candidates(refreshToken model.RefreshToken) []model.RefreshTokenHash
+}
+
 // RefreshTokenRepository is the interface for the refresh token repository.
 type RefreshTokenRepository interface {
+	GetRefreshTokenSession(ctx context.Context, tokenHash model.RefreshTokenHash) (*model.RefreshTokenSession, error)
 	SaveRefreshTokenSession(ctx context.Context, session *model.RefreshTokenSession) error
+	RotateRefreshTokenSession(ctx context.Context, oldTokenHash model.RefreshTokenHash, newSession *model.RefreshTokenSession) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeRefreshTokenSession(ctx context.Context, tokenHash model.RefreshTokenHash, revokedAt time.Time) error
+	RevokeMemberRefreshTokenSessions(ctx context.Context, memberID string, revokedAt time.Time) error
+	ListMemberRefreshTokenSessions(ctx context.Context, memberID string) ([]*model.RefreshTokenSession, error)
+}
+
+// AccessTokenDenylist is the interface for the access token denylist.
+type AccessTokenDenylist interface {
+	RecordAccessToken(ctx context.Context, memberID, jti string, expiresAt time.Time) error
+	DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	DenyMemberAccessTokens(ctx context.Context, memberID string) error
+	IsAccessTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+// LoginLimiter is the interface for the limiter of failed logins per normalized email and client IP.
+type LoginLimiter interface {
+	LoginRetryAfter(ctx context.Context, email, ipAddress string) (time.Duration, error)
+	RecordLoginFailure(ctx context.Context, email, ipAddress string) error
+	ResetLoginFailures(ctx context.Context, email string) error
 }
 
 // UserGateway is the interface for the user gateway.
 type UserGateway interface {
 	VerifyCredentials(ctx context.Context, email string, password string) (*usermodel.User, error)
+	GetUser(ctx context.Context, email string) (*usermodel.User, error)
+	CreateUser(ctx context.Context, email string, password string) (*usermodel.User, error)
+	SetPassword(ctx context.Context, email string, password string) error
+	VerifyEmail(ctx context.Context, email string) (*usermodel.User, error)
+}
+
+// Deps are the dependencies of a Service. A Service only uses those of the methods that are called, so the others
+// can be left nil.
+type Deps struct {
+	AccessToken       AccessTokenMaker
+	RefreshToken      RefreshTokenMaker
+	RefreshHasher     RefreshTokenHasher
+	RefreshTokenRepo  RefreshTokenRepository
+	Denylist          AccessTokenDenylist
+	LoginLimiter      LoginLimiter
+	MFARepo           MFARepository
+	TOTP              TOTPMaker
+	SecretCipher      SecretCipher
+	PasskeyRepo       PasskeyRepository
+	Passkeys          PasskeyRelyingParty
+	Clients           ClientRegistry
+	OAuthRepo         OAuthRepository
+	IDToken           IDTokenMaker
+	UserGateway       UserGateway
+	PasswordResets    PasswordResetRepository
+	Mailer            Mailer
+	Links             Links
+	Verifications     EmailVerificationRepository
+	LinkSigner        LinkSigner
+	Passwordless      PasswordlessRepository
+	Notifier          LoginNotifier
+	FederatedLogins   FederatedLoginRepository
+	IdentityProviders IdentityProviders
+	Audit             AuditSink
 }
 
 // New creates a new Service.
-func New(accessToken AccessTokenMaker, refreshToken RefreshTokenMaker, refreshTokenRepo RefreshTokenRepository, userGateway UserGateway) *Service {
-	return &Service{accessToken: accessToken, refreshToken: refreshToken, refreshTokenRepo: refreshTokenRepo, userGateway: userGateway}
+func New(deps Deps) *Service {
+	return &Service{
+		accessToken:       deps.AccessToken,
+		refreshToken:      deps.RefreshToken,
+		refreshHasher:     deps.RefreshHasher,
+		refreshTokenRepo:  deps.RefreshTokenRepo,
+		denylist:          deps.Denylist,
+		loginLimiter:      deps.LoginLimiter,
+		mfaRepo:           deps.MFARepo,
+		totp:              deps.TOTP,
+		secretCipher:      deps.SecretCipher,
+		passkeyRepo:       deps.PasskeyRepo,
+		passkeys:          deps.Passkeys,
+		clients:           deps.Clients,
+		oauthRepo:         deps.OAuthRepo,
+		idToken:           deps.IDToken,
+		userGateway:       deps.UserGateway,
+		passwordResets:    deps.PasswordResets,
+		mailer:            deps.Mailer,
+		links:             deps.Links,
+		verifications:     deps.Verifications,
+		linkSigner:        deps.LinkSigner,
+		passwordless:      deps.Passwordless,
+		notifier:          deps.Notifier,
+		federatedLogins:   deps.FederatedLogins,
+		identityProviders: deps.IdentityProviders,
+		audit:             deps.Audit,
+	}
 }
 
 // LoginWithEmailAndPassword logs in a user with email and password.
-func (s *Service) LoginWithEmailAndPassword(ctx context.Context, email string, password string, userAgent, ipAddress string) (*LoginResult, error) {
+// When scopes are requested, the access token is narrowed to the requested scopes the user is allowed;
+// otherwise it carries every scope of the user.
+// Failed logins are throttled per email and per IP; a locked out login fails with a *LoginThrottledError.
+// When the user has enabled a second factor, no tokens are issued: the result only carries an MFA challenge
+// that LoginWithMFA completes.
+func (s *Service) LoginWithEmailAndPassword(ctx context.Context, email string, password string, requestedScopes []string, userAgent, ipAddress string) (*LoginResult, error) {
+	user, err := s.verifyPassword(ctx, "", email, password, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	memberID := user.Email
+
+	scopes, err := grantedScopes(requestedScopes, user.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	grant := model.AccessTokenGrant{
+		Scopes:        scopes,
+		Roles:         user.Roles,
+		Audiences:     user.Audiences,
+		AuthMethods:   []string{model.AuthMethodPassword},
+		EmailVerified: &user.EmailVerified,
+	}
+
+	// Failed logins are only forgotten once every factor has been verified.
+	throttledEmail := normalizeEmail(email)
+	mfaRequired, err := s.isMFARequired(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaRequired {
+		challenge, err := s.startMFAChallenge(ctx, "", model.DeviceDecision{}, memberID, throttledEmail, grant)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	if err := s.loginLimiter.ResetLoginFailures(ctx, throttledEmail); err != nil {
+		return nil, fmt.Errorf("reset login failures: %w", err)
+	}
+	return s.startSession(ctx, "", memberID, grant, userAgent, ipAddress)
+}
+
+// verifyPassword verifies the email and password of a login, first-party or on behalf of clientID, throttling
+// failed logins per email and per IP. Refused logins are audited.
+// Failures are not reset, as the login may still need its second factor.
+func (s *Service) verifyPassword(ctx context.Context, clientID, email, password, ipAddress string) (*usermodel.User, error) {
+	throttledEmail := normalizeEmail(email)
+	retryAfter, err := s.loginLimiter.LoginRetryAfter(ctx, throttledEmail, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("check login limiter: %w", err)
+	}
+	if retryAfter > 0 {
+		s.recordLoginFailure(ctx, clientID, throttledEmail, model.AuthMethodPassword, ipAddress, model.AuditReasonThrottled)
+		return nil, &LoginThrottledError{RetryAfter: retryAfter}
+	}
 
-	fmt.Println("Starting to verify user credential")
 	user, err := s.userGateway.VerifyCredentials(ctx, email, password)
 	if err != nil {
-		fmt.Println("Error verifying user credential", err)
+		switch {
+		case errors.Is(err, gateway.ErrInvalidCredentials):
+			s.recordLoginFailure(ctx, clientID, throttledEmail, model.AuthMethodPassword, ipAddress, model.AuditReasonInvalidCredentials)
+			if err := s.loginLimiter.RecordLoginFailure(ctx, throttledEmail, ipAddress); err != nil {
+				return nil, fmt.Errorf("record login failure: %w", err)
+			}
+			return nil, ErrInvalidCredentials
+		case errors.Is(err, gateway.ErrAccountDisabled):
+			s.recordLoginFailure(ctx, clientID, throttledEmail, model.AuthMethodPassword, ipAddress, model.AuditReasonAccountDisabled)
+			return nil, ErrAccountDisabled
+		case errors.Is(err, gateway.ErrAccountLocked):
+			s.recordLoginFailure(ctx, clientID, throttledEmail, model.AuthMethodPassword, ipAddress, model.AuditReasonAccountLocked)
+			return nil, ErrAccountLocked
+		case errors.Is(err, gateway.ErrEmailNotVerified):
+			s.recordLoginFailure(ctx, clientID, throttledEmail, model.AuthMethodPassword, ipAddress, model.AuditReasonEmailNotVerified)
+			return nil, ErrEmailNotVerified
+		case errors.Is(err, gateway.ErrUnavailable):
+			return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+		}
 		return nil, err
 	}
+	return user, nil
+}
 
-	memberID := user.Email
+// startSession issues an access token and a refresh token of a new token family to a member who has logged in,
+// either first-party or, with a clientID, through an OAuth client.
+func (s *Service) startSession(ctx context.Context, clientID, memberID string, grant model.AccessTokenGrant, userAgent, ipAddress string) (*LoginResult, error) {
+	jkt, err := dpopKeyThumbprint(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	accessToken, err := s.accessToken.CreateToken(memberID)
+	accessToken, claims, err := s.issueAccessToken(ctx, memberID, grant)
 	if err != nil {
 		return nil, err
 	}
@@ -74,23 +269,402 @@ func (s *Service) LoginWithEmailAndPassword(ctx context.Context, email string, p
 
 	refreshTokenSession := &model.RefreshTokenSession{
 		ID:        uuid.NewString(),
+		FamilyID:  uuid.NewString(),
 		MemberID:  memberID,
-		TokenHash: refreshToken,
+		ClientID:  clientID,
+		TokenHash: s.refreshHasher.Hash(refreshToken),
 		ExpiresAt: now.Add(time.Duration(maxAge) * time.Second),
 		CreatedAt: now,
 		RevokedAt: time.Time{}, // not revoked yet, set to zero value
 		UserAgent: userAgent,
 		IPAddress: ipAddress,
+		Grant:     grant,
+		JKT:       jkt,
 	}
 	err = s.refreshTokenRepo.SaveRefreshTokenSession(ctx, refreshTokenSession)
 	if err != nil {
 		return nil, err
 	}
+	s.recordAudit(ctx, model.AuditEvent{
+		Type:        model.AuditEventLoginSucceeded,
+		MemberID:    memberID,
+		SessionID:   refreshTokenSession.ID,
+		FamilyID:    refreshTokenSession.FamilyID,
+		ClientID:    clientID,
+		AuthMethods: grant.AuthMethods,
+		IPAddress:   ipAddress,
+		UserAgent:   userAgent,
+	})
 
 	return &LoginResult{
 		AccessToken:      accessToken,
+		AccessExpiresAt:  claims.ExpiresAt,
+		TokenType:        tokenTypeOf(claims),
 		RefreshToken:     refreshToken,
 		RefreshMaxAgeSec: maxAge,
 		RefreshEndPoint:  refreshEndPoint,
+		Scopes:           grant.Scopes,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a rotated refresh token.
+// Presenting a refresh token that was already rotated revokes every session of its family.
+// A refresh token bound to a DPoP key must be presented with a proof made with that key; the access token of a
+// refresh with a proof is bound to its key, even when the refresh token is not.
+func (s *Service) Refresh(ctx context.Context, refreshToken model.RefreshToken, userAgent, ipAddress string) (*LoginResult, error) {
+	return s.refresh(ctx, "", refreshToken, userAgent, ipAddress)
+}
+
+// refresh rotates a refresh token issued to clientID, or first-party when clientID is empty.
+// A token issued to another client is reported as invalid, without revoking it.
+func (s *Service) refresh(ctx context.Context, clientID string, refreshToken model.RefreshToken, userAgent, ipAddress string) (*LoginResult, error) {
+	session, err := s.findRefreshTokenSession(ctx, refreshToken)
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if session.ClientID != clientID {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	now := time.Now()
+	if session.IsRotated() {
+		return nil, s.revokeFamily(ctx, session, now)
+	}
+	if session.IsRevoked() || session.IsExpired(now) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err := checkRefreshTokenBinding(ctx, session); err != nil {
+		return nil, err
+	}
+
+	accessToken, claims, err := s.issueAccessToken(ctx, session.MemberID, session.Grant)
+	if err != nil {
+		return nil, err
+	}
+
+	newRefreshToken, err := s.refreshToken.CreateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	maxAge := s.refreshToken.MaxAge()
+	refreshEndPoint := s.refreshToken.RefreshEndPoint()
+
+	newSession := &model.RefreshTokenSession{
+		ID:        uuid.NewString(),
+		FamilyID:  session.FamilyID,
+		MemberID:  session.MemberID,
+		ClientID:  session.ClientID,
+		TokenHash: s.refreshHasher.Hash(newRefreshToken),
+		ExpiresAt: now.Add(time.Duration(maxAge) * time.Second),
+		CreatedAt: now,
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+		Grant:     session.Grant,
+		JKT:       session.JKT,
+	}
+	err = s.refreshTokenRepo.RotateRefreshTokenSession(ctx, session.TokenHash, newSession)
+	switch {
+	case errors.Is(err, repository.ErrRefreshTokenReused):
+		// Lost a race against another rotation of the same token.
+		return nil, s.revokeFamily(ctx, session, now)
+	case errors.Is(err, repository.ErrRefreshTokenNotFound), errors.Is(err, repository.ErrRefreshTokenRevoked):
+		return nil, ErrInvalidRefreshToken
+	case err != nil:
+		return nil, err
+	}
+	s.recordAudit(ctx, model.AuditEvent{
+		Type:      model.AuditEventTokenRefreshed,
+		MemberID:  newSession.MemberID,
+		SessionID: newSession.ID,
+		FamilyID:  newSession.FamilyID,
+		ClientID:  newSession.ClientID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	})
+
+	return &LoginResult{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  claims.ExpiresAt,
+		TokenType:        tokenTypeOf(claims),
+		RefreshToken:     newRefreshToken,
+		RefreshMaxAgeSec: maxAge,
+		RefreshEndPoint:  refreshEndPoint,
+		Scopes:           session.Grant.Scopes,
 	}, nil
 }
+
+// ListSessions lists the active sessions of the member owning accessToken, newest first.
+// The session of refreshToken, if any, is flagged as the current one.
+func (s *Service) ListSessions(ctx context.Context, accessToken model.AccessToken, refreshToken model.RefreshToken) ([]SessionResult, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.activeSessions(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var current []model.RefreshTokenHash
+	if refreshToken != "" {
+		current = s.refreshHasher.Candidates(refreshToken)
+	}
+
+	results := make([]SessionResult, 0, len(sessions))
+	for _, session := range sessions {
+		results = append(results, SessionResult{
+			ID:        session.ID,
+			UserAgent: session.UserAgent,
+			IPAddress: session.IPAddress,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   slices.Contains(current, session.TokenHash),
+		})
+	}
+	return results, nil
+}
+
+// RevokeSession revokes one of the sessions of the member owning accessToken by its ID.
+// The whole token family is revoked so that no earlier token of the session can be replayed.
+func (s *Service) RevokeSession(ctx context.Context, accessToken model.AccessToken, sessionID string) error {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.activeSessions(ctx, claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	idx := slices.IndexFunc(sessions, func(session *model.RefreshTokenSession) bool {
+		return session.ID == sessionID
+	})
+	if idx < 0 {
+		return ErrSessionNotFound
+	}
+
+	if err := s.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, sessions[idx].FamilyID, time.Now()); err != nil {
+		return err
+	}
+	s.recordSessionRevoked(ctx, claims.Subject, sessions[idx], model.AuditReasonRevokedByMember)
+	return nil
+}
+
+// activeSessions returns the sessions of a member that are neither expired, revoked nor rotated, newest first.
+func (s *Service) activeSessions(ctx context.Context, memberID string) ([]*model.RefreshTokenSession, error) {
+	sessions, err := s.refreshTokenRepo.ListMemberRefreshTokenSessions(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions = slices.DeleteFunc(sessions, func(session *model.RefreshTokenSession) bool {
+		return session.MemberID != memberID || session.IsRevoked() || session.IsRotated() || session.IsExpired(now)
+	})
+	slices.SortFunc(sessions, func(a, b *model.RefreshTokenSession) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return sessions, nil
+}
+
+// findRefreshTokenSession looks a refresh token up by its hash under every known pepper,
+// so tokens issued before a pepper rotation keep working until they expire or rotate.
+func (s *Service) findRefreshTokenSession(ctx context.Context, refreshToken model.RefreshToken) (*model.RefreshTokenSession, error) {
+	for _, tokenHash := range s.refreshHasher.Candidates(refreshToken) {
+		session, err := s.refreshTokenRepo.GetRefreshTokenSession(ctx, tokenHash)
+		if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+			continue
+		}
+		return session, err
+	}
+	return nil, repository.ErrRefreshTokenNotFound
+}
+
+// revokeFamily revokes the whole token family of a session after reuse was detected and returns ErrRefreshTokenReused.
+func (s *Service) revokeFamily(ctx context.Context, session *model.RefreshTokenSession, now time.Time) error {
+	if err := s.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, session.FamilyID, now); err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	s.recordSessionRevoked(ctx, session.MemberID, session, model.AuditReasonRefreshTokenReused)
+	return ErrRefreshTokenReused
+}
+
+// Logout revokes accessToken and the refresh token session of the member owning it.
+// With allDevices set, every session and access token of the member is revoked instead.
+// An unknown refresh token or one owned by another member is ignored so logout stays idempotent.
+func (s *Service) Logout(ctx context.Context, accessToken model.AccessToken, refreshToken model.RefreshToken, allDevices bool) error {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	memberID := claims.Subject
+
+	now := time.Now()
+	if allDevices {
+		if err := s.refreshTokenRepo.RevokeMemberRefreshTokenSessions(ctx, memberID, now); err != nil {
+			return err
+		}
+		s.recordSessionRevoked(ctx, memberID, nil, model.AuditReasonLogoutAllDevices)
+		return s.RevokeMemberAccessTokens(ctx, memberID)
+	}
+
+	if err := s.revokeAccessToken(ctx, claims); err != nil {
+		return err
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	session, err := s.findRefreshTokenSession(ctx, refreshToken)
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.MemberID != memberID || session.IsRevoked() {
+		return nil
+	}
+
+	err = s.refreshTokenRepo.RevokeRefreshTokenSession(ctx, session.TokenHash, now)
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.recordSessionRevoked(ctx, memberID, session, model.AuditReasonLogout)
+	return nil
+}
+
+// VerifyAccessToken verifies accessToken and checks that it has not been revoked and, when it is bound to a DPoP
+// key, that the request carries a proof made with that key for it.
+func (s *Service) VerifyAccessToken(ctx context.Context, accessToken model.AccessToken) (*model.AccessTokenClaims, error) {
+	claims, err := s.verifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccessTokenBinding(ctx, accessToken, claims); err != nil {
+		if errors.Is(err, ErrInvalidDPoPProof) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAccessToken, err)
+		}
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyAccessToken verifies accessToken and checks that it has not been revoked, without checking its DPoP
+// binding: for clients that are shown a token rather than presenting it.
+func (s *Service) verifyAccessToken(ctx context.Context, accessToken model.AccessToken) (*model.AccessTokenClaims, error) {
+	claims, err := s.accessToken.ParseToken(string(accessToken))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAccessToken, err)
+	}
+
+	revoked, err := s.IsAccessTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAccessToken, ErrAccessTokenRevoked)
+	}
+	return claims, nil
+}
+
+// RevokeAccessToken revokes the access token with the given jti until it expires.
+func (s *Service) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return fmt.Errorf("%w: jti is empty", ErrInvalidAccessToken)
+	}
+	if err := s.denylist.DenyAccessToken(ctx, jti, expiresAt); err != nil {
+		return fmt.Errorf("deny access token: %w", err)
+	}
+	return nil
+}
+
+// RevokeMemberAccessTokens revokes every unexpired access token issued to a member,
+// e.g. when the account is disabled.
+func (s *Service) RevokeMemberAccessTokens(ctx context.Context, memberID string) error {
+	if err := s.denylist.DenyMemberAccessTokens(ctx, memberID); err != nil {
+		return fmt.Errorf("deny member access tokens: %w", err)
+	}
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether the access token with the given jti has been revoked.
+// Tokens issued before jti was introduced cannot be revoked individually and are reported as not revoked.
+func (s *Service) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	denied, err := s.denylist.IsAccessTokenDenied(ctx, jti)
+	if err != nil {
+		return false, fmt.Errorf("check access token denylist: %w", err)
+	}
+	return denied, nil
+}
+
+// issueAccessToken creates an access token and records it so that it can be revoked with its member.
+// When the request carries a DPoP proof, the token is bound to its key.
+func (s *Service) issueAccessToken(ctx context.Context, memberID string, grant model.AccessTokenGrant) (model.AccessToken, *model.AccessTokenClaims, error) {
+	jkt, err := dpopKeyThumbprint(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	grant.JKT = jkt
+	accessToken, claims, err := s.accessToken.CreateToken(memberID, grant)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.denylist.RecordAccessToken(ctx, memberID, claims.ID, claims.ExpiresAt); err != nil {
+		return "", nil, fmt.Errorf("record access token: %w", err)
+	}
+	return accessToken, claims, nil
+}
+
+// revokeAccessToken revokes a verified access token; tokens without a jti are left to expire.
+func (s *Service) revokeAccessToken(ctx context.Context, claims *model.AccessTokenClaims) error {
+	if claims.ID == "" {
+		return nil
+	}
+	return s.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt)
+}
+
+// tokenTypeOf returns the token type of an access token: DPoP when it is bound to a DPoP key, Bearer otherwise.
+func tokenTypeOf(claims *model.AccessTokenClaims) string {
+	if claims.JKT != "" {
+		return model.TokenTypeDPoP
+	}
+	return model.TokenTypeBearer
+}
+
+// grantedScopes returns the requested scopes the user is allowed, in the order they were requested,
+// or every allowed scope when none are requested.
+func grantedScopes(requested, allowed []string) ([]string, error) {
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	var granted []string
+	for _, scope := range requested {
+		if slices.Contains(allowed, scope) && !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return granted, nil
+}
+
+// normalizeEmail normalizes an email so that its failed logins are counted together however it is typed.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
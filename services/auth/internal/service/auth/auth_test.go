@@ -3,10 +3,21 @@ package authservice_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation"
+	"github.com/incheat/go-production-backend/services/auth/internal/federation/federationtest"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	"github.com/incheat/go-production-backend/services/auth/internal/passkey"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
 	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
 	"github.com/incheat/go-production-backend/services/auth/pkg/model"
 	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
 	"github.com/stretchr/testify/assert"
@@ -20,14 +31,16 @@ type MockAccessTokenMaker struct {
 	mock.Mock
 }
 
-func (m *MockAccessTokenMaker) CreateToken(id string) (model.AccessToken, error) {
-	args := m.Called(id)
-	return args.Get(0).(model.AccessToken), args.Error(1)
+func (m *MockAccessTokenMaker) CreateToken(id string, grant model.AccessTokenGrant) (model.AccessToken, *model.AccessTokenClaims, error) {
+	args := m.Called(id, grant)
+	claims, _ := args.Get(1).(*model.AccessTokenClaims)
+	return args.Get(0).(model.AccessToken), claims, args.Error(2)
 }
 
-func (m *MockAccessTokenMaker) ParseToken(token string) (string, error) {
+func (m *MockAccessTokenMaker) ParseToken(token string) (*model.AccessTokenClaims, error) {
 	args := m.Called(token)
-	return args.String(0), args.Error(1)
+	claims, _ := args.Get(0).(*model.AccessTokenClaims)
+	return claims, args.Error(1)
 }
 
 type MockRefreshTokenMaker struct {
@@ -61,15 +74,229 @@ func (m *MockRefreshTokenRepository) SaveRefreshTokenSession(
 	return args.Error(0)
 }
 
+func (m *MockRefreshTokenRepository) GetRefreshTokenSession(
+	ctx context.Context,
+	tokenHash model.RefreshTokenHash,
+) (*model.RefreshTokenSession, error) {
+	args := m.Called(ctx, tokenHash)
+	session, _ := args.Get(0).(*model.RefreshTokenSession)
+	return session, args.Error(1)
+}
+
+func (m *MockRefreshTokenRepository) RotateRefreshTokenSession(
+	ctx context.Context,
+	oldTokenHash model.RefreshTokenHash,
+	newSession *model.RefreshTokenSession,
+) error {
+	args := m.Called(ctx, oldTokenHash, newSession)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepository) RevokeRefreshTokenFamily(
+	ctx context.Context,
+	familyID string,
+	revokedAt time.Time,
+) error {
+	args := m.Called(ctx, familyID, revokedAt)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepository) RevokeRefreshTokenSession(
+	ctx context.Context,
+	tokenHash model.RefreshTokenHash,
+	revokedAt time.Time,
+) error {
+	args := m.Called(ctx, tokenHash, revokedAt)
+	return args.Error(0)
+}
+
+func (m *MockRefreshTokenRepository) ListMemberRefreshTokenSessions(
+	ctx context.Context,
+	memberID string,
+) ([]*model.RefreshTokenSession, error) {
+	args := m.Called(ctx, memberID)
+	sessions, _ := args.Get(0).([]*model.RefreshTokenSession)
+	return sessions, args.Error(1)
+}
+
+func (m *MockRefreshTokenRepository) RevokeMemberRefreshTokenSessions(
+	ctx context.Context,
+	memberID string,
+	revokedAt time.Time,
+) error {
+	args := m.Called(ctx, memberID, revokedAt)
+	return args.Error(0)
+}
+
 type MockUserGateway struct {
 	mock.Mock
 }
 
 func (m *MockUserGateway) VerifyCredentials(ctx context.Context, email string, password string) (*usermodel.User, error) {
 	args := m.Called(ctx, email, password)
-	return args.Get(0).(*usermodel.User), args.Error(1)
+	user, _ := args.Get(0).(*usermodel.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserGateway) GetUser(ctx context.Context, email string) (*usermodel.User, error) {
+	args := m.Called(ctx, email)
+	user, _ := args.Get(0).(*usermodel.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserGateway) CreateUser(ctx context.Context, email string, password string) (*usermodel.User, error) {
+	args := m.Called(ctx, email, password)
+	user, _ := args.Get(0).(*usermodel.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserGateway) SetPassword(ctx context.Context, email string, password string) error {
+	args := m.Called(ctx, email, password)
+	return args.Error(0)
 }
 
+func (m *MockUserGateway) VerifyEmail(ctx context.Context, email string) (*usermodel.User, error) {
+	args := m.Called(ctx, email)
+	u, _ := args.Get(0).(*usermodel.User)
+	return u, args.Error(1)
+}
+
+type MockIDTokenMaker struct {
+	mock.Mock
+}
+
+func (m *MockIDTokenMaker) CreateIDToken(claims *model.IDTokenClaims) (model.IDToken, error) {
+	args := m.Called(claims)
+	return args.Get(0).(model.IDToken), args.Error(1)
+}
+
+// claimsOf returns the claims of a live access token issued to memberID.
+func claimsOf(memberID string) *model.AccessTokenClaims {
+	now := time.Now()
+	return &model.AccessTokenClaims{
+		ID:        "jti-" + memberID,
+		Subject:   memberID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(15 * time.Minute),
+	}
+}
+
+// passwordGrant is the grant of a user without scopes, roles, audiences or verified email logged in with a password.
+var passwordGrant = model.AccessTokenGrant{AuthMethods: []string{model.AuthMethodPassword}, EmailVerified: new(bool)}
+
+// --- Login limiter ---
+
+var testLoginPolicy = model.LoginThrottlePolicy{
+	MaxFailures:   3,
+	Window:        time.Minute,
+	LockoutBase:   50 * time.Millisecond,
+	LockoutMax:    time.Second,
+	LockoutMemory: time.Minute,
+}
+
+func newLoginLimiter() *memoryrepo.LoginLimiter {
+	return memoryrepo.NewLoginLimiter(testLoginPolicy, testLoginPolicy)
+}
+
+// --- Refresh token hasher ---
+
+var previousPepper = token.Pepper{Version: "v0", Secret: []byte("previous-pepper")}
+
+var testHasher = func() *token.RefreshTokenHasher {
+	h, err := token.NewRefreshTokenHasher(token.Pepper{Version: "v1", Secret: []byte("test-pepper")}, previousPepper)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}()
+
+func hashOf(refreshToken model.RefreshToken) model.RefreshTokenHash {
+	return testHasher.Hash(refreshToken)
+}
+
+func previousHashOf(refreshToken model.RefreshToken) model.RefreshTokenHash {
+	h, err := token.NewRefreshTokenHasher(previousPepper)
+	if err != nil {
+		panic(err)
+	}
+	return h.Hash(refreshToken)
+}
+
+// --- MFA ---
+
+var testTOTP = token.NewTOTPMaker("test-issuer")
+
+var testCipher = func() *token.SecretCipher {
+	c, err := token.NewSecretCipher(token.EncryptionKey{Version: "v1", Key: []byte("0123456789abcdef0123456789abcdef")})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// --- Passkeys ---
+
+const testOrigin = "https://example.com"
+
+var testRelyingParty = func() *passkey.RelyingParty {
+	rp, err := passkey.NewRelyingParty("example.com", "Example", []string{testOrigin})
+	if err != nil {
+		panic(err)
+	}
+	return rp
+}()
+
+// --- OAuth ---
+
+var testClients = memoryrepo.NewClientRegistry(
+	model.OAuthClient{
+		ID:           "test-client",
+		Name:         "Test Client",
+		RedirectURIs: []string{"https://client.example.com/callback"},
+		Scopes:       []string{"user:read", "user:write"},
+	},
+	model.OAuthClient{
+		ID:           "other-client",
+		RedirectURIs: []string{"https://other.example.com/callback"},
+		Scopes:       []string{"user:read"},
+	},
+	model.OAuthClient{
+		ID:         "billing-job",
+		SecretHash: "12d043d4bd516bc34ea9e95648e9a12329d2d851840fb60b83822997f1382e17", // SHA-256 of billing-secret
+		Scopes:     []string{"user:read", "invoice:write"},
+		Audiences:  []string{"user-service", "billing-service"},
+	},
+)
+
+// --- Account emails ---
+
+var testLinks = authservice.Links{
+	PasswordReset:     "https://app.example.com/reset-password",
+	VerifyEmail:       "https://app.example.com/verify-email",
+	PasswordlessLogin: "https://app.example.com/login/email",
+}
+
+var testLinkSigner = func() *token.LinkSigner {
+	s, err := token.NewLinkSigner([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}()
+
+// --- Federated login ---
+
+// testIDP is the upstream identity provider of federated logins, known as "corp". It serves the whole test run.
+var testIDP = func() *federationtest.IdentityProvider {
+	idp, err := federationtest.NewIdentityProvider("https://app.example.com/login/federated")
+	if err != nil {
+		panic(err)
+	}
+	return idp
+}()
+
+var testIdentityProviders = federation.NewRegistry([]federation.Provider{testIDP.Provider("corp")}, nil)
+
 // TestUnitLoginWithEmailAndPassword_Success tests the happy path for LoginWithEmailAndPassword.
 func TestUnitLoginWithEmailAndPassword_Success(t *testing.T) {
 	ctx := context.Background()
@@ -98,8 +325,8 @@ func TestUnitLoginWithEmailAndPassword_Success(t *testing.T) {
 		Once()
 
 	accessMock.
-		On("CreateToken", email).
-		Return(accessToken, nil).
+		On("CreateToken", email, passwordGrant).
+		Return(accessToken, claimsOf(email), nil).
 		Once()
 
 	refreshMock.
@@ -123,7 +350,8 @@ func TestUnitLoginWithEmailAndPassword_Success(t *testing.T) {
 			mock.MatchedBy(func(sess *model.RefreshTokenSession) bool {
 				// Basic field checks
 				assert.Equal(t, email, sess.MemberID)
-				assert.Equal(t, refreshToken, sess.TokenHash)
+				assert.Equal(t, hashOf(refreshToken), sess.TokenHash)
+				assert.NotEqual(t, model.RefreshTokenHash(refreshToken), sess.TokenHash)
 				assert.Equal(t, userAgent, sess.UserAgent)
 				assert.Equal(t, ip, sess.IPAddress)
 
@@ -141,8 +369,9 @@ func TestUnitLoginWithEmailAndPassword_Success(t *testing.T) {
 				}
 				assert.LessOrEqual(t, diff, tolerance)
 
-				// ID should not be empty (uuid string)
+				// ID and FamilyID should not be empty (uuid string)
 				assert.NotEmpty(t, sess.ID)
+				assert.NotEmpty(t, sess.FamilyID)
 
 				return true
 			}),
@@ -150,9 +379,19 @@ func TestUnitLoginWithEmailAndPassword_Success(t *testing.T) {
 		Return(nil).
 		Once()
 
-	ctrl := authservice.New(accessMock, refreshMock, repoMock, userGatewayMock)
+	ctrl := authservice.New(authservice.Deps{
+		AccessToken:      accessMock,
+		RefreshToken:     refreshMock,
+		RefreshHasher:    testHasher,
+		RefreshTokenRepo: repoMock,
+		Denylist:         memoryrepo.NewAccessTokenDenylist(),
+		LoginLimiter:     newLoginLimiter(),
+		MFARepo:          memoryrepo.NewMFARepository(),
+		UserGateway:      userGatewayMock,
+		Audit:            audit.NewMemorySink(),
+	})
 
-	result, err := ctrl.LoginWithEmailAndPassword(ctx, email, "password", userAgent, ip)
+	result, err := ctrl.LoginWithEmailAndPassword(ctx, email, "password", nil, userAgent, ip)
 	require.NoError(t, err)
 	require.NotNil(t, result)
 
@@ -192,8 +431,8 @@ func TestUnitLoginWithEmailAndPassword_Errors(t *testing.T) {
 					Once()
 
 				err := errors.New("access error")
-				a.On("CreateToken", email).
-					Return(model.AccessToken(""), err).
+				a.On("CreateToken", email, passwordGrant).
+					Return(model.AccessToken(""), nil, err).
 					Once()
 			},
 			expectedErr:      errors.New("access error"),
@@ -212,8 +451,8 @@ func TestUnitLoginWithEmailAndPassword_Errors(t *testing.T) {
 					Return(user, nil).
 					Once()
 
-				a.On("CreateToken", email).
-					Return(model.AccessToken("access-token"), nil).
+				a.On("CreateToken", email, passwordGrant).
+					Return(model.AccessToken("access-token"), claimsOf(email), nil).
 					Once()
 
 				err := errors.New("refresh error")
@@ -237,8 +476,8 @@ func TestUnitLoginWithEmailAndPassword_Errors(t *testing.T) {
 					Return(user, nil).
 					Once()
 
-				a.On("CreateToken", email).
-					Return(model.AccessToken("access-token"), nil).
+				a.On("CreateToken", email, passwordGrant).
+					Return(model.AccessToken("access-token"), claimsOf(email), nil).
 					Once()
 
 				r.On("CreateToken").
@@ -270,9 +509,18 @@ func TestUnitLoginWithEmailAndPassword_Errors(t *testing.T) {
 
 			tt.setupMocks(accessMock, refreshMock, repoMock, userGatewayMock)
 
-			ctrl := authservice.New(accessMock, refreshMock, repoMock, userGatewayMock)
+			ctrl := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshToken:     refreshMock,
+				RefreshHasher:    testHasher,
+				RefreshTokenRepo: repoMock,
+				Denylist:         memoryrepo.NewAccessTokenDenylist(),
+				LoginLimiter:     newLoginLimiter(),
+				MFARepo:          memoryrepo.NewMFARepository(),
+				UserGateway:      userGatewayMock,
+			})
 
-			result, err := ctrl.LoginWithEmailAndPassword(ctx, email, "password", "agent", "ip")
+			result, err := ctrl.LoginWithEmailAndPassword(ctx, email, "password", nil, "agent", "ip")
 			require.Error(t, err)
 			assert.Nil(t, result)
 			assert.EqualError(t, err, tt.expectedErr.Error())
@@ -283,3 +531,753 @@ func TestUnitLoginWithEmailAndPassword_Errors(t *testing.T) {
 		})
 	}
 }
+
+// TestUnitLoginWithEmailAndPassword_GatewayErrors tests that user gateway errors are translated into domain errors.
+func TestUnitLoginWithEmailAndPassword_GatewayErrors(t *testing.T) {
+	ctx := context.Background()
+	email := "user@example.com"
+
+	tests := []struct {
+		name       string
+		gatewayErr error
+		wantErr    error
+	}{
+		{name: "invalid credentials", gatewayErr: gateway.ErrInvalidCredentials, wantErr: authservice.ErrInvalidCredentials},
+		{name: "account disabled", gatewayErr: gateway.ErrAccountDisabled, wantErr: authservice.ErrAccountDisabled},
+		{name: "account locked", gatewayErr: gateway.ErrAccountLocked, wantErr: authservice.ErrAccountLocked},
+		{name: "email not verified", gatewayErr: gateway.ErrEmailNotVerified, wantErr: authservice.ErrEmailNotVerified},
+		{name: "user service unavailable", gatewayErr: fmt.Errorf("%w: deadline exceeded", gateway.ErrUnavailable), wantErr: authservice.ErrDependencyUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessMock := new(MockAccessTokenMaker)
+			userGatewayMock := new(MockUserGateway)
+			userGatewayMock.On("VerifyCredentials", mock.Anything, email, "password").Return(nil, tt.gatewayErr).Once()
+
+			svc := authservice.New(authservice.Deps{
+				LoginLimiter: newLoginLimiter(),
+				UserGateway:  userGatewayMock,
+				Audit:        audit.NewMemorySink(),
+			})
+
+			result, err := svc.LoginWithEmailAndPassword(ctx, email, "password", nil, "agent", "ip")
+			assert.Nil(t, result)
+			assert.ErrorIs(t, err, tt.wantErr)
+			accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+// TestUnitLoginWithEmailAndPassword_Throttling tests that failed logins lock out the normalized email and the IP
+// progressively, and that a successful login resets the failures of the email.
+func TestUnitLoginWithEmailAndPassword_Throttling(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com"}
+
+	newService := func(userGateway *MockUserGateway) *authservice.Service {
+		accessMock := new(MockAccessTokenMaker)
+		accessMock.On("CreateToken", mock.Anything, mock.Anything).Return(model.AccessToken("access-token"), claimsOf(user.Email), nil)
+		refreshMock := new(MockRefreshTokenMaker)
+		refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token-1"), nil).Once()
+		refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token-2"), nil).Once()
+		refreshMock.On("MaxAge").Return(3600)
+		refreshMock.On("RefreshEndPoint").Return("/refresh")
+		return authservice.New(authservice.Deps{
+			AccessToken:      accessMock,
+			RefreshToken:     refreshMock,
+			RefreshHasher:    testHasher,
+			RefreshTokenRepo: memoryrepo.NewRefreshTokenRepository(),
+			Denylist:         memoryrepo.NewAccessTokenDenylist(),
+			LoginLimiter:     newLoginLimiter(),
+			MFARepo:          memoryrepo.NewMFARepository(),
+			UserGateway:      userGateway,
+			Audit:            audit.NewMemorySink(),
+		})
+	}
+	login := func(svc *authservice.Service, email, password, ip string) error {
+		_, err := svc.LoginWithEmailAndPassword(ctx, email, password, nil, "agent", ip)
+		return err
+	}
+	retryAfter := func(t *testing.T, err error) time.Duration {
+		t.Helper()
+		var throttled *authservice.LoginThrottledError
+		require.ErrorAs(t, err, &throttled)
+		assert.ErrorIs(t, err, authservice.ErrTooManyLoginAttempts)
+		return throttled.RetryAfter
+	}
+
+	t.Run("email is locked out progressively", func(t *testing.T) {
+		userGateway := new(MockUserGateway)
+		userGateway.On("VerifyCredentials", mock.Anything, mock.Anything, "wrong").Return(nil, gateway.ErrInvalidCredentials)
+		svc := newService(userGateway)
+
+		emails := []string{"user@example.com", " User@Example.com", "USER@example.com "}
+		for i, email := range emails {
+			assert.ErrorIs(t, login(svc, email, "wrong", fmt.Sprintf("10.0.0.%d", i)), authservice.ErrInvalidCredentials)
+		}
+		first := retryAfter(t, login(svc, "user@example.com", "wrong", "10.0.0.9"))
+		assert.InDelta(t, testLoginPolicy.LockoutBase, first, float64(10*time.Millisecond))
+		userGateway.AssertNumberOfCalls(t, "VerifyCredentials", 3)
+
+		time.Sleep(first)
+		for i := range testLoginPolicy.MaxFailures {
+			assert.ErrorIs(t, login(svc, "user@example.com", "wrong", fmt.Sprintf("10.0.1.%d", i)), authservice.ErrInvalidCredentials)
+		}
+		second := retryAfter(t, login(svc, "user@example.com", "wrong", "10.0.1.9"))
+		assert.Greater(t, second, first)
+	})
+
+	t.Run("IP is locked out across emails", func(t *testing.T) {
+		userGateway := new(MockUserGateway)
+		userGateway.On("VerifyCredentials", mock.Anything, mock.Anything, "wrong").Return(nil, gateway.ErrInvalidCredentials)
+		svc := newService(userGateway)
+
+		for i := range testLoginPolicy.MaxFailures {
+			assert.ErrorIs(t, login(svc, fmt.Sprintf("user%d@example.com", i), "wrong", "10.0.0.1"), authservice.ErrInvalidCredentials)
+		}
+		retryAfter(t, login(svc, "other@example.com", "wrong", "10.0.0.1"))
+		assert.ErrorIs(t, login(svc, "other@example.com", "wrong", "10.0.0.2"), authservice.ErrInvalidCredentials)
+	})
+
+	t.Run("successful login resets email failures", func(t *testing.T) {
+		userGateway := new(MockUserGateway)
+		userGateway.On("VerifyCredentials", mock.Anything, user.Email, "wrong").Return(nil, gateway.ErrInvalidCredentials)
+		userGateway.On("VerifyCredentials", mock.Anything, user.Email, "password").Return(user, nil)
+		svc := newService(userGateway)
+
+		for range testLoginPolicy.MaxFailures - 1 {
+			assert.ErrorIs(t, login(svc, user.Email, "wrong", ""), authservice.ErrInvalidCredentials)
+		}
+		require.NoError(t, login(svc, user.Email, "password", ""))
+		for range testLoginPolicy.MaxFailures - 1 {
+			assert.ErrorIs(t, login(svc, user.Email, "wrong", ""), authservice.ErrInvalidCredentials)
+		}
+		require.NoError(t, login(svc, user.Email, "password", ""))
+	})
+
+	t.Run("other gateway errors are not counted", func(t *testing.T) {
+		userGateway := new(MockUserGateway)
+		userGateway.On("VerifyCredentials", mock.Anything, user.Email, mock.Anything).Return(nil, errors.New("connection refused"))
+		svc := newService(userGateway)
+
+		for range testLoginPolicy.MaxFailures + 1 {
+			err := login(svc, user.Email, "wrong", "10.0.0.1")
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, authservice.ErrTooManyLoginAttempts)
+		}
+	})
+}
+
+// TestUnitLoginWithEmailAndPassword_Scopes tests that requested scopes are intersected with the scopes of the user
+// and that the grant is kept on the session for refresh.
+func TestUnitLoginWithEmailAndPassword_Scopes(t *testing.T) {
+	ctx := context.Background()
+	email := "user@example.com"
+	user := &usermodel.User{
+		ID:        "123",
+		Email:     email,
+		Roles:     []string{"member"},
+		Scopes:    []string{"auth:read", "user:read", "order:read"},
+		Audiences: []string{"user-api", "order-api"},
+	}
+
+	tests := []struct {
+		name       string
+		requested  []string
+		wantScopes []string
+		wantErr    error
+	}{
+		{name: "no scope requested", wantScopes: []string{"auth:read", "user:read", "order:read"}},
+		{name: "narrower scope", requested: []string{"order:read", "auth:read"}, wantScopes: []string{"order:read", "auth:read"}},
+		{name: "disallowed scopes are dropped", requested: []string{"order:read", "order:write", "order:read"}, wantScopes: []string{"order:read"}},
+		{name: "no allowed scope", requested: []string{"order:write", "admin"}, wantErr: authservice.ErrInvalidScope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantGrant := model.AccessTokenGrant{Scopes: tt.wantScopes, Roles: user.Roles, Audiences: user.Audiences, AuthMethods: []string{model.AuthMethodPassword}, EmailVerified: &user.EmailVerified}
+
+			accessMock := new(MockAccessTokenMaker)
+			refreshMock := new(MockRefreshTokenMaker)
+			repo := memoryrepo.NewRefreshTokenRepository()
+			userGatewayMock := new(MockUserGateway)
+
+			userGatewayMock.On("VerifyCredentials", mock.Anything, email, "password").Return(user, nil).Once()
+			if tt.wantErr == nil {
+				accessMock.On("CreateToken", email, wantGrant).Return(model.AccessToken("access-token"), claimsOf(email), nil).Once()
+				refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token"), nil).Once()
+				refreshMock.On("MaxAge").Return(3600)
+				refreshMock.On("RefreshEndPoint").Return("/refresh")
+			}
+
+			svc := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshToken:     refreshMock,
+				RefreshHasher:    testHasher,
+				RefreshTokenRepo: repo,
+				Denylist:         memoryrepo.NewAccessTokenDenylist(),
+				LoginLimiter:     newLoginLimiter(),
+				MFARepo:          memoryrepo.NewMFARepository(),
+				UserGateway:      userGatewayMock,
+				Audit:            audit.NewMemorySink(),
+			})
+
+			result, err := svc.LoginWithEmailAndPassword(ctx, email, "password", tt.requested, "agent", "ip")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantScopes, result.Scopes)
+
+			session, err := repo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+			require.NoError(t, err)
+			assert.Equal(t, wantGrant, session.Grant)
+
+			accessMock.AssertExpectations(t)
+		})
+	}
+}
+
+// TestUnitRefresh_Success tests that Refresh rotates the refresh token within the same family.
+func TestUnitRefresh_Success(t *testing.T) {
+	ctx := context.Background()
+	oldToken := model.RefreshToken("old-refresh-token")
+	newToken := model.RefreshToken("new-refresh-token")
+	accessToken := model.AccessToken("access-token")
+	now := time.Now()
+	session := &model.RefreshTokenSession{
+		ID:        "session-1",
+		FamilyID:  "family-1",
+		MemberID:  "user@example.com",
+		TokenHash: hashOf(oldToken),
+		CreatedAt: now.Add(-time.Hour),
+		ExpiresAt: now.Add(time.Hour),
+		Grant:     model.AccessTokenGrant{Scopes: []string{"order:read"}, Roles: []string{"member"}},
+	}
+
+	accessMock := new(MockAccessTokenMaker)
+	refreshMock := new(MockRefreshTokenMaker)
+	repoMock := new(MockRefreshTokenRepository)
+	userGatewayMock := new(MockUserGateway)
+
+	repoMock.On("GetRefreshTokenSession", mock.Anything, hashOf(oldToken)).Return(session, nil).Once()
+	accessMock.On("CreateToken", session.MemberID, session.Grant).Return(accessToken, claimsOf(session.MemberID), nil).Once()
+	refreshMock.On("CreateToken").Return(newToken, nil).Once()
+	refreshMock.On("MaxAge").Return(3600)
+	refreshMock.On("RefreshEndPoint").Return("/refresh")
+	repoMock.
+		On(
+			"RotateRefreshTokenSession",
+			mock.Anything,
+			hashOf(oldToken),
+			mock.MatchedBy(func(sess *model.RefreshTokenSession) bool {
+				return sess.FamilyID == session.FamilyID &&
+					sess.MemberID == session.MemberID &&
+					sess.TokenHash == hashOf(newToken) &&
+					sess.ID != session.ID &&
+					sess.UserAgent == "agent" &&
+					sess.IPAddress == "ip" &&
+					assert.ObjectsAreEqual(session.Grant, sess.Grant)
+			}),
+		).
+		Return(nil).
+		Once()
+
+	svc := authservice.New(authservice.Deps{
+		AccessToken:      accessMock,
+		RefreshToken:     refreshMock,
+		RefreshHasher:    testHasher,
+		RefreshTokenRepo: repoMock,
+		Denylist:         memoryrepo.NewAccessTokenDenylist(),
+		UserGateway:      userGatewayMock,
+		Audit:            audit.NewMemorySink(),
+	})
+
+	result, err := svc.Refresh(ctx, oldToken, "agent", "ip")
+	require.NoError(t, err)
+	assert.Equal(t, accessToken, result.AccessToken)
+	assert.Equal(t, []string{"order:read"}, result.Scopes)
+	assert.Equal(t, newToken, result.RefreshToken)
+	assert.Equal(t, 3600, result.RefreshMaxAgeSec)
+	assert.Equal(t, "/refresh", result.RefreshEndPoint)
+
+	accessMock.AssertExpectations(t)
+	refreshMock.AssertExpectations(t)
+	repoMock.AssertExpectations(t)
+}
+
+// TestUnitRefresh_PreviousPepper tests that a token hashed with a retired pepper is still found
+// and that its rotated successor is hashed with the current pepper.
+func TestUnitRefresh_PreviousPepper(t *testing.T) {
+	ctx := context.Background()
+	oldToken := model.RefreshToken("old-refresh-token")
+	newToken := model.RefreshToken("new-refresh-token")
+	now := time.Now()
+	session := &model.RefreshTokenSession{
+		ID:        "session-1",
+		FamilyID:  "family-1",
+		MemberID:  "user@example.com",
+		TokenHash: previousHashOf(oldToken),
+		CreatedAt: now.Add(-time.Hour),
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	accessMock := new(MockAccessTokenMaker)
+	refreshMock := new(MockRefreshTokenMaker)
+	repoMock := new(MockRefreshTokenRepository)
+	userGatewayMock := new(MockUserGateway)
+
+	repoMock.On("GetRefreshTokenSession", mock.Anything, hashOf(oldToken)).Return(nil, repository.ErrRefreshTokenNotFound).Once()
+	repoMock.On("GetRefreshTokenSession", mock.Anything, previousHashOf(oldToken)).Return(session, nil).Once()
+	accessMock.On("CreateToken", session.MemberID, model.AccessTokenGrant{}).Return(model.AccessToken("access-token"), claimsOf(session.MemberID), nil).Once()
+	refreshMock.On("CreateToken").Return(newToken, nil).Once()
+	refreshMock.On("MaxAge").Return(3600)
+	refreshMock.On("RefreshEndPoint").Return("/refresh")
+	repoMock.
+		On(
+			"RotateRefreshTokenSession",
+			mock.Anything,
+			previousHashOf(oldToken),
+			mock.MatchedBy(func(sess *model.RefreshTokenSession) bool {
+				return sess.TokenHash == hashOf(newToken)
+			}),
+		).
+		Return(nil).
+		Once()
+
+	svc := authservice.New(authservice.Deps{
+		AccessToken:      accessMock,
+		RefreshToken:     refreshMock,
+		RefreshHasher:    testHasher,
+		RefreshTokenRepo: repoMock,
+		Denylist:         memoryrepo.NewAccessTokenDenylist(),
+		UserGateway:      userGatewayMock,
+		Audit:            audit.NewMemorySink(),
+	})
+
+	result, err := svc.Refresh(ctx, oldToken, "agent", "ip")
+	require.NoError(t, err)
+	assert.Equal(t, newToken, result.RefreshToken)
+
+	repoMock.AssertExpectations(t)
+}
+
+// TestUnitRefresh_Errors tests the error cases for Refresh.
+func TestUnitRefresh_Errors(t *testing.T) {
+	ctx := context.Background()
+	token := model.RefreshToken("refresh-token")
+	now := time.Now()
+	activeSession := func() *model.RefreshTokenSession {
+		return &model.RefreshTokenSession{
+			ID:        "session-1",
+			FamilyID:  "family-1",
+			MemberID:  "user@example.com",
+			TokenHash: hashOf(token),
+			CreatedAt: now.Add(-time.Hour),
+			ExpiresAt: now.Add(time.Hour),
+		}
+	}
+
+	tests := []struct {
+		name        string
+		setupMocks  func(a *MockAccessTokenMaker, r *MockRefreshTokenMaker, repo *MockRefreshTokenRepository)
+		expectedErr error
+	}{
+		{
+			name: "unknown token",
+			setupMocks: func(_ *MockAccessTokenMaker, _ *MockRefreshTokenMaker, repo *MockRefreshTokenRepository) {
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(token)).
+					Return(nil, repository.ErrRefreshTokenNotFound).
+					Once()
+				repo.On("GetRefreshTokenSession", mock.Anything, previousHashOf(token)).
+					Return(nil, repository.ErrRefreshTokenNotFound).
+					Once()
+			},
+			expectedErr: authservice.ErrInvalidRefreshToken,
+		},
+		{
+			name: "expired token",
+			setupMocks: func(_ *MockAccessTokenMaker, _ *MockRefreshTokenMaker, repo *MockRefreshTokenRepository) {
+				session := activeSession()
+				session.ExpiresAt = now.Add(-time.Minute)
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(token)).Return(session, nil).Once()
+			},
+			expectedErr: authservice.ErrInvalidRefreshToken,
+		},
+		{
+			name: "revoked token",
+			setupMocks: func(_ *MockAccessTokenMaker, _ *MockRefreshTokenMaker, repo *MockRefreshTokenRepository) {
+				session := activeSession()
+				session.RevokedAt = now.Add(-time.Minute)
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(token)).Return(session, nil).Once()
+			},
+			expectedErr: authservice.ErrInvalidRefreshToken,
+		},
+		{
+			name: "replayed rotated token revokes family",
+			setupMocks: func(_ *MockAccessTokenMaker, _ *MockRefreshTokenMaker, repo *MockRefreshTokenRepository) {
+				session := activeSession()
+				session.RotatedAt = now.Add(-time.Minute)
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(token)).Return(session, nil).Once()
+				repo.On("RevokeRefreshTokenFamily", mock.Anything, "family-1", mock.AnythingOfType("time.Time")).
+					Return(nil).
+					Once()
+			},
+			expectedErr: authservice.ErrRefreshTokenReused,
+		},
+		{
+			name: "concurrent rotation revokes family",
+			setupMocks: func(a *MockAccessTokenMaker, r *MockRefreshTokenMaker, repo *MockRefreshTokenRepository) {
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(token)).Return(activeSession(), nil).Once()
+				a.On("CreateToken", "user@example.com", model.AccessTokenGrant{}).Return(model.AccessToken("access-token"), claimsOf("user@example.com"), nil).Once()
+				r.On("CreateToken").Return(model.RefreshToken("new-refresh-token"), nil).Once()
+				r.On("MaxAge").Return(3600)
+				r.On("RefreshEndPoint").Return("/refresh")
+				repo.On("RotateRefreshTokenSession", mock.Anything, hashOf(token), mock.AnythingOfType("*model.RefreshTokenSession")).
+					Return(repository.ErrRefreshTokenReused).
+					Once()
+				repo.On("RevokeRefreshTokenFamily", mock.Anything, "family-1", mock.AnythingOfType("time.Time")).
+					Return(nil).
+					Once()
+			},
+			expectedErr: authservice.ErrRefreshTokenReused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessMock := new(MockAccessTokenMaker)
+			refreshMock := new(MockRefreshTokenMaker)
+			repoMock := new(MockRefreshTokenRepository)
+			userGatewayMock := new(MockUserGateway)
+
+			tt.setupMocks(accessMock, refreshMock, repoMock)
+
+			svc := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshToken:     refreshMock,
+				RefreshHasher:    testHasher,
+				RefreshTokenRepo: repoMock,
+				Denylist:         memoryrepo.NewAccessTokenDenylist(),
+				UserGateway:      userGatewayMock,
+				Audit:            audit.NewMemorySink(),
+			})
+
+			result, err := svc.Refresh(ctx, token, "agent", "ip")
+			require.ErrorIs(t, err, tt.expectedErr)
+			assert.Nil(t, result)
+
+			accessMock.AssertExpectations(t)
+			refreshMock.AssertExpectations(t)
+			repoMock.AssertExpectations(t)
+		})
+	}
+}
+
+// TestUnitLogout tests that Logout revokes the caller's session or all of their sessions.
+func TestUnitLogout(t *testing.T) {
+	ctx := context.Background()
+	accessToken := model.AccessToken("access-token")
+	refreshToken := model.RefreshToken("refresh-token")
+	memberID := "user@example.com"
+
+	tests := []struct {
+		name         string
+		refreshToken model.RefreshToken
+		allDevices   bool
+		setupMocks   func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository)
+		expectedErr  error
+	}{
+		{
+			name:         "revokes current session",
+			refreshToken: refreshToken,
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(refreshToken)).
+					Return(&model.RefreshTokenSession{MemberID: memberID, TokenHash: hashOf(refreshToken)}, nil).
+					Once()
+				repo.On("RevokeRefreshTokenSession", mock.Anything, hashOf(refreshToken), mock.AnythingOfType("time.Time")).
+					Return(nil).
+					Once()
+			},
+		},
+		{
+			name:         "revokes all sessions of the member",
+			refreshToken: refreshToken,
+			allDevices:   true,
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("RevokeMemberRefreshTokenSessions", mock.Anything, memberID, mock.AnythingOfType("time.Time")).
+					Return(nil).
+					Once()
+			},
+		},
+		{
+			name:         "ignores session of another member",
+			refreshToken: refreshToken,
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(refreshToken)).
+					Return(&model.RefreshTokenSession{MemberID: "someone@example.com", TokenHash: hashOf(refreshToken)}, nil).
+					Once()
+			},
+		},
+		{
+			name:         "ignores unknown refresh token",
+			refreshToken: refreshToken,
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("GetRefreshTokenSession", mock.Anything, hashOf(refreshToken)).
+					Return(nil, repository.ErrRefreshTokenNotFound).
+					Once()
+				repo.On("GetRefreshTokenSession", mock.Anything, previousHashOf(refreshToken)).
+					Return(nil, repository.ErrRefreshTokenNotFound).
+					Once()
+			},
+		},
+		{
+			name: "without refresh token",
+			setupMocks: func(a *MockAccessTokenMaker, _ *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+			},
+		},
+		{
+			name:         "invalid access token",
+			refreshToken: refreshToken,
+			setupMocks: func(a *MockAccessTokenMaker, _ *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(nil, errors.New("token is expired")).Once()
+			},
+			expectedErr: authservice.ErrInvalidAccessToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessMock := new(MockAccessTokenMaker)
+			refreshMock := new(MockRefreshTokenMaker)
+			repoMock := new(MockRefreshTokenRepository)
+			userGatewayMock := new(MockUserGateway)
+
+			tt.setupMocks(accessMock, repoMock)
+
+			svc := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshToken:     refreshMock,
+				RefreshHasher:    testHasher,
+				RefreshTokenRepo: repoMock,
+				Denylist:         memoryrepo.NewAccessTokenDenylist(),
+				UserGateway:      userGatewayMock,
+				Audit:            audit.NewMemorySink(),
+			})
+
+			err := svc.Logout(ctx, accessToken, tt.refreshToken, tt.allDevices)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			accessMock.AssertExpectations(t)
+			repoMock.AssertExpectations(t)
+		})
+	}
+}
+
+// TestUnitListSessions tests that ListSessions only returns active sessions and flags the current one.
+func TestUnitListSessions(t *testing.T) {
+	ctx := context.Background()
+	accessToken := model.AccessToken("access-token")
+	memberID := "user@example.com"
+	currentToken := model.RefreshToken("current-refresh-token")
+	now := time.Now()
+
+	sessions := []*model.RefreshTokenSession{
+		{ID: "older", MemberID: memberID, TokenHash: hashOf("other"), UserAgent: "firefox", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: "current", MemberID: memberID, TokenHash: hashOf(currentToken), UserAgent: "chrome", IPAddress: "10.0.0.1", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{ID: "revoked", MemberID: memberID, TokenHash: hashOf("revoked"), CreatedAt: now, ExpiresAt: now.Add(time.Hour), RevokedAt: now},
+		{ID: "rotated", MemberID: memberID, TokenHash: hashOf("rotated"), CreatedAt: now, ExpiresAt: now.Add(time.Hour), RotatedAt: now},
+		{ID: "expired", MemberID: memberID, TokenHash: hashOf("expired"), CreatedAt: now.Add(-3 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+
+	accessMock := new(MockAccessTokenMaker)
+	repoMock := new(MockRefreshTokenRepository)
+	accessMock.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+	repoMock.On("ListMemberRefreshTokenSessions", mock.Anything, memberID).Return(sessions, nil).Once()
+
+	svc := authservice.New(authservice.Deps{
+		AccessToken:      accessMock,
+		RefreshHasher:    testHasher,
+		RefreshTokenRepo: repoMock,
+		Denylist:         memoryrepo.NewAccessTokenDenylist(),
+	})
+
+	result, err := svc.ListSessions(ctx, accessToken, currentToken)
+	require.NoError(t, err)
+	require.Len(t, result, 2)
+
+	assert.Equal(t, "current", result[0].ID)
+	assert.True(t, result[0].Current)
+	assert.Equal(t, "chrome", result[0].UserAgent)
+	assert.Equal(t, "10.0.0.1", result[0].IPAddress)
+	assert.Equal(t, "older", result[1].ID)
+	assert.False(t, result[1].Current)
+
+	accessMock.AssertExpectations(t)
+	repoMock.AssertExpectations(t)
+}
+
+// TestUnitRevokeSession tests that RevokeSession revokes the family of an owned session only.
+func TestUnitRevokeSession(t *testing.T) {
+	ctx := context.Background()
+	accessToken := model.AccessToken("access-token")
+	memberID := "user@example.com"
+	now := time.Now()
+	sessions := []*model.RefreshTokenSession{
+		{ID: "session-1", FamilyID: "family-1", MemberID: memberID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+	}
+
+	tests := []struct {
+		name        string
+		sessionID   string
+		setupMocks  func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository)
+		expectedErr error
+	}{
+		{
+			name:      "revokes session family",
+			sessionID: "session-1",
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("ListMemberRefreshTokenSessions", mock.Anything, memberID).Return(sessions, nil).Once()
+				repo.On("RevokeRefreshTokenFamily", mock.Anything, "family-1", mock.AnythingOfType("time.Time")).Return(nil).Once()
+			},
+		},
+		{
+			name:      "unknown session",
+			sessionID: "session-2",
+			setupMocks: func(a *MockAccessTokenMaker, repo *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(claimsOf(memberID), nil).Once()
+				repo.On("ListMemberRefreshTokenSessions", mock.Anything, memberID).Return(sessions, nil).Once()
+			},
+			expectedErr: authservice.ErrSessionNotFound,
+		},
+		{
+			name:      "invalid access token",
+			sessionID: "session-1",
+			setupMocks: func(a *MockAccessTokenMaker, _ *MockRefreshTokenRepository) {
+				a.On("ParseToken", string(accessToken)).Return(nil, errors.New("bad signature")).Once()
+			},
+			expectedErr: authservice.ErrInvalidAccessToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessMock := new(MockAccessTokenMaker)
+			repoMock := new(MockRefreshTokenRepository)
+			tt.setupMocks(accessMock, repoMock)
+
+			svc := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshTokenRepo: repoMock,
+				Denylist:         memoryrepo.NewAccessTokenDenylist(),
+				Audit:            audit.NewMemorySink(),
+			})
+
+			err := svc.RevokeSession(ctx, accessToken, tt.sessionID)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			accessMock.AssertExpectations(t)
+			repoMock.AssertExpectations(t)
+		})
+	}
+}
+
+// TestUnitAccessTokenRevocation tests that revoked access tokens fail verification before they expire.
+func TestUnitAccessTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	accessToken := model.AccessToken("access-token")
+	memberID := "user@example.com"
+	claims := claimsOf(memberID)
+
+	accessMock := new(MockAccessTokenMaker)
+	accessMock.On("ParseToken", string(accessToken)).Return(claims, nil)
+
+	svc := authservice.New(authservice.Deps{
+		AccessToken: accessMock,
+		Denylist:    memoryrepo.NewAccessTokenDenylist(),
+	})
+
+	got, err := svc.VerifyAccessToken(ctx, accessToken)
+	require.NoError(t, err)
+	assert.Equal(t, memberID, got.Subject)
+
+	require.NoError(t, svc.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt))
+
+	revoked, err := svc.IsAccessTokenRevoked(ctx, claims.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = svc.VerifyAccessToken(ctx, accessToken)
+	require.ErrorIs(t, err, authservice.ErrInvalidAccessToken)
+	require.ErrorIs(t, err, authservice.ErrAccessTokenRevoked)
+
+	_, err = svc.ListSessions(ctx, accessToken, "")
+	require.ErrorIs(t, err, authservice.ErrAccessTokenRevoked)
+}
+
+// TestUnitLogout_RevokesAccessTokens tests that logout revokes the presented access token,
+// and every issued access token of the member with allDevices.
+func TestUnitLogout_RevokesAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	memberID := "user@example.com"
+
+	tests := []struct {
+		name        string
+		allDevices  bool
+		wantRevoked []bool // presented token, other token of the member
+	}{
+		{name: "current device", wantRevoked: []bool{true, false}},
+		{name: "all devices", allDevices: true, wantRevoked: []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denylist := memoryrepo.NewAccessTokenDenylist()
+			presented, other := claimsOf(memberID), claimsOf(memberID)
+			presented.ID, other.ID = "jti-presented", "jti-other"
+			require.NoError(t, denylist.RecordAccessToken(ctx, memberID, presented.ID, presented.ExpiresAt))
+			require.NoError(t, denylist.RecordAccessToken(ctx, memberID, other.ID, other.ExpiresAt))
+
+			accessMock := new(MockAccessTokenMaker)
+			accessMock.On("ParseToken", "access-token").Return(presented, nil).Once()
+			repoMock := new(MockRefreshTokenRepository)
+			repoMock.On("RevokeMemberRefreshTokenSessions", mock.Anything, memberID, mock.AnythingOfType("time.Time")).Return(nil).Maybe()
+
+			svc := authservice.New(authservice.Deps{
+				AccessToken:      accessMock,
+				RefreshTokenRepo: repoMock,
+				Denylist:         denylist,
+				Mailer:           mailer.NewOutbox(),
+				Notifier:         notifier.NewOutbox(),
+				Audit:            audit.NewMemorySink(),
+			})
+
+			require.NoError(t, svc.Logout(ctx, "access-token", "", tt.allDevices))
+
+			for i, jti := range []string{presented.ID, other.ID} {
+				revoked, err := svc.IsAccessTokenRevoked(ctx, jti)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantRevoked[i], revoked, jti)
+			}
+			accessMock.AssertExpectations(t)
+			repoMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,323 @@
+// Package authservice defines the OAuth 2.0 device authorization grant of the auth API (RFC 8628).
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// deviceCodeTTL is how long a member has to approve a device authorization.
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is how long a client must wait between polls for the tokens of a device authorization.
+	devicePollInterval = 5 * time.Second
+	// userCodeAlphabet are the characters of user codes: consonants only, so that codes are easy to type, cannot
+	// be misread (no 0/O or 1/I) and do not spell words (RFC 8628 section 6.1).
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLength is the length of a user code, about 34 bits of entropy.
+	userCodeLength = 8
+	// maxUserCodeAttempts is how many user codes are tried when the first one is already in use.
+	maxUserCodeAttempts = 3
+)
+
+// StartDeviceAuthorization starts a device authorization for a client on a device that cannot show a login form.
+// The client shows the user code and verification URI to the member and polls PollDeviceAuthorization with the
+// device code until the member approves or denies it on another device.
+func (s *Service) StartDeviceAuthorization(ctx context.Context, clientID, clientSecret string, scopes []string) (*DeviceAuthorizationResult, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := grantedScopes(apiScopes(scopes), client.Scopes); err != nil {
+		return nil, err
+	}
+
+	b, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	deviceCode := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	authorization := &model.DeviceAuthorization{
+		DeviceCodeHash: hashDeviceCode(deviceCode),
+		ClientID:       client.ID,
+		Scopes:         scopes,
+		Status:         model.DeviceAuthorizationPending,
+		Interval:       devicePollInterval,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(deviceCodeTTL),
+	}
+	for attempt := 1; ; attempt++ {
+		if authorization.UserCode, err = newUserCode(); err != nil {
+			return nil, err
+		}
+		err = s.oauthRepo.SaveDeviceAuthorization(ctx, authorization)
+		if !errors.Is(err, repository.ErrUserCodeTaken) || attempt == maxUserCodeAttempts {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("save device authorization: %w", err)
+	}
+
+	return &DeviceAuthorizationResult{
+		DeviceCode: deviceCode,
+		UserCode:   formatUserCode(authorization.UserCode),
+		ExpiresAt:  authorization.ExpiresAt,
+		Interval:   authorization.Interval,
+	}, nil
+}
+
+// VerifyUserCode returns the device authorization a member entered the user code of, for them to review the client
+// and scopes before approving it. User codes are matched case-insensitively, ignoring separators. As user codes
+// are short, invalid ones are throttled per IP like failed logins (RFC 8628 section 5.1); a locked out IP gets a
+// *UserCodeThrottledError.
+func (s *Service) VerifyUserCode(ctx context.Context, userCode, ipAddress string) (*DeviceVerificationResult, error) {
+	authorization, client, err := s.pendingDeviceAuthorization(ctx, userCode, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceVerificationResult{
+		UserCode: formatUserCode(authorization.UserCode),
+		Client:   client,
+		Scopes:   authorization.Scopes,
+	}, nil
+}
+
+// ApproveDeviceWithPassword logs a member in with email and password and approves a device authorization with the
+// grant AuthorizeWithPassword would give its client. A member who has enabled a second factor only gets an MFA
+// challenge that ApproveDeviceWithMFA completes; otherwise the returned challenge is nil.
+func (s *Service) ApproveDeviceWithPassword(ctx context.Context, userCode, email, password, ipAddress string) (*MFAChallengeResult, error) {
+	return s.decideDeviceWithPassword(ctx, model.DeviceAuthorizationApproved, userCode, email, password, ipAddress)
+}
+
+// ApproveDeviceWithMFA completes with a TOTP code an approval that passed its password.
+func (s *Service) ApproveDeviceWithMFA(ctx context.Context, userCode, mfaToken, code, ipAddress string) error {
+	return s.decideDeviceWithMFA(ctx, model.DeviceAuthorizationApproved, userCode, mfaToken, code, ipAddress)
+}
+
+// DenyDeviceWithPassword logs a member in like ApproveDeviceWithPassword and denies a device authorization; its
+// client stops polling with access_denied. Denying takes the same login as approving, so that a user code alone
+// cannot cancel the device login of someone else.
+func (s *Service) DenyDeviceWithPassword(ctx context.Context, userCode, email, password, ipAddress string) (*MFAChallengeResult, error) {
+	return s.decideDeviceWithPassword(ctx, model.DeviceAuthorizationDenied, userCode, email, password, ipAddress)
+}
+
+// DenyDeviceWithMFA completes with a TOTP code a denial that passed its password.
+func (s *Service) DenyDeviceWithMFA(ctx context.Context, userCode, mfaToken, code, ipAddress string) error {
+	return s.decideDeviceWithMFA(ctx, model.DeviceAuthorizationDenied, userCode, mfaToken, code, ipAddress)
+}
+
+// decideDeviceWithPassword logs a member in with email and password and decides a device authorization with
+// status, or returns the MFA challenge of the login.
+func (s *Service) decideDeviceWithPassword(ctx context.Context, status, userCode, email, password, ipAddress string) (*MFAChallengeResult, error) {
+	authorization, client, err := s.pendingDeviceAuthorization(ctx, userCode, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	device := model.DeviceDecision{DeviceCodeHash: authorization.DeviceCodeHash, Status: status}
+	memberID, grant, challenge, err := s.loginForClient(ctx, client, device, authorization.Scopes, email, password, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return challenge, nil
+	}
+	return nil, s.decideDevice(ctx, authorization, status, memberID, grant)
+}
+
+// decideDeviceWithMFA completes with a TOTP code the login that decides a device authorization with status. Only
+// challenges started by decideDeviceWithPassword for the same authorization and status are accepted.
+func (s *Service) decideDeviceWithMFA(ctx context.Context, status, userCode, mfaToken, code, ipAddress string) error {
+	authorization, client, err := s.pendingDeviceAuthorization(ctx, userCode, ipAddress)
+	if err != nil {
+		return err
+	}
+
+	// The challenge must have been started for the same device authorization and decision, as its grant was
+	// computed from the scopes of that authorization.
+	device := model.DeviceDecision{DeviceCodeHash: authorization.DeviceCodeHash, Status: status}
+	challenge, err := s.completeMFAChallenge(ctx, client.ID, device, mfaToken, code, ipAddress)
+	if err != nil {
+		return err
+	}
+	return s.decideDevice(ctx, authorization, status, challenge.MemberID, challenge.Grant)
+}
+
+// PollDeviceAuthorization exchanges the device code of an approved device authorization for an access token and a
+// refresh token of its client, once. Until the member approves it, polls fail with ErrAuthorizationPending, and
+// with ErrSlowDown when they come faster than the interval of the authorization.
+func (s *Service) PollDeviceAuthorization(ctx context.Context, clientID, clientSecret, deviceCode, userAgent, ipAddress string) (*LoginResult, error) {
+	if _, err := s.authenticateClient(ctx, clientID, clientSecret); err != nil {
+		return nil, err
+	}
+
+	deviceCodeHash := hashDeviceCode(deviceCode)
+	authorization, err := s.oauthRepo.GetDeviceAuthorization(ctx, deviceCodeHash)
+	if errors.Is(err, repository.ErrDeviceAuthorizationNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get device authorization: %w", err)
+	}
+	if authorization.ClientID != clientID {
+		return nil, ErrInvalidGrant
+	}
+	if authorization.IsExpired(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+
+	allowed, err := s.oauthRepo.RecordDevicePoll(ctx, deviceCodeHash, authorization.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("record device poll: %w", err)
+	}
+	if !allowed {
+		return nil, ErrSlowDown
+	}
+	if authorization.Status == model.DeviceAuthorizationPending {
+		return nil, ErrAuthorizationPending
+	}
+
+	authorization, err = s.oauthRepo.ConsumeDeviceAuthorization(ctx, deviceCodeHash)
+	if errors.Is(err, repository.ErrDeviceAuthorizationNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume device authorization: %w", err)
+	}
+	if authorization.Status != model.DeviceAuthorizationApproved {
+		return nil, ErrAccessDenied
+	}
+	return s.startSession(ctx, clientID, authorization.MemberID, authorization.Grant, userAgent, ipAddress)
+}
+
+// pendingDeviceAuthorization gets the unexpired device authorization of a user code that is still waiting for a
+// member, and its client. Invalid user codes count against the IP they were entered from.
+func (s *Service) pendingDeviceAuthorization(ctx context.Context, userCode, ipAddress string) (*model.DeviceAuthorization, *model.OAuthClient, error) {
+	throttledSubject := userCodeThrottleSubject(ipAddress)
+	retryAfter, err := s.loginLimiter.LoginRetryAfter(ctx, throttledSubject, ipAddress)
+	if err != nil {
+		return nil, nil, fmt.Errorf("check login limiter: %w", err)
+	}
+	if retryAfter > 0 {
+		return nil, nil, &UserCodeThrottledError{RetryAfter: retryAfter}
+	}
+
+	authorization, err := s.deviceAuthorizationByUserCode(ctx, userCode)
+	if errors.Is(err, ErrInvalidUserCode) {
+		if err := s.loginLimiter.RecordLoginFailure(ctx, throttledSubject, ipAddress); err != nil {
+			return nil, nil, fmt.Errorf("record login failure: %w", err)
+		}
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := s.getClient(ctx, authorization.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return authorization, client, nil
+}
+
+// deviceAuthorizationByUserCode gets the device authorization of a user code, or ErrInvalidUserCode when it is
+// malformed, unknown, expired or no longer pending.
+func (s *Service) deviceAuthorizationByUserCode(ctx context.Context, userCode string) (*model.DeviceAuthorization, error) {
+	normalized, ok := normalizeUserCode(userCode)
+	if !ok {
+		return nil, ErrInvalidUserCode
+	}
+	authorization, err := s.oauthRepo.GetDeviceAuthorizationByUserCode(ctx, normalized)
+	if errors.Is(err, repository.ErrDeviceAuthorizationNotFound) {
+		return nil, ErrInvalidUserCode
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get device authorization: %w", err)
+	}
+	if authorization.Status != model.DeviceAuthorizationPending || authorization.IsExpired(time.Now()) {
+		return nil, ErrInvalidUserCode
+	}
+	return authorization, nil
+}
+
+// userCodeThrottleSubject returns the subject the invalid user codes entered from an IP are counted under in the
+// login limiter, next to the failed logins of the IP. It cannot be a normalized email, which has no colon outside
+// quotes.
+func userCodeThrottleSubject(ipAddress string) string {
+	return "user_code:" + ipAddress
+}
+
+// decideDevice records the decision of a member on a device authorization. Approved authorizations keep the grant
+// their tokens will be issued with.
+func (s *Service) decideDevice(ctx context.Context, authorization *model.DeviceAuthorization, status, memberID string, grant model.AccessTokenGrant) error {
+	authorization.Status = status
+	authorization.MemberID = memberID
+	if status == model.DeviceAuthorizationApproved {
+		authorization.Grant = grant
+	}
+	return s.updateDeviceAuthorization(ctx, authorization)
+}
+
+func (s *Service) updateDeviceAuthorization(ctx context.Context, authorization *model.DeviceAuthorization) error {
+	err := s.oauthRepo.UpdateDeviceAuthorization(ctx, authorization)
+	if errors.Is(err, repository.ErrDeviceAuthorizationNotFound) {
+		return ErrInvalidUserCode
+	}
+	if err != nil {
+		return fmt.Errorf("update device authorization: %w", err)
+	}
+	return nil
+}
+
+// newUserCode returns a random user code drawn uniformly from userCodeAlphabet.
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", fmt.Errorf("generate user code: %w", err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode uppercases a user code as typed by a member and drops separators and spaces.
+func normalizeUserCode(userCode string) (string, bool) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(userCode) {
+		switch {
+		case strings.ContainsRune(userCodeAlphabet, r):
+			b.WriteRune(r)
+		case r == '-' || r == ' ':
+		default:
+			return "", false
+		}
+	}
+	return b.String(), b.Len() == userCodeLength
+}
+
+// formatUserCode formats a normalized user code as XXXX-XXXX for display.
+func formatUserCode(userCode string) string {
+	return userCode[:userCodeLength/2] + "-" + userCode[userCodeLength/2:]
+}
+
+// hashDeviceCode hashes a device code for storage.
+func hashDeviceCode(deviceCode string) model.DeviceCodeHash {
+	sum := sha256.Sum256([]byte(deviceCode))
+	return model.DeviceCodeHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,250 @@
+package authservice_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitDeviceAuthorization tests that devices get tokens once the member approves their user code, and that
+// clients polling before then, too often or after a denial are told so.
+func TestUnitDeviceAuthorization(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}}
+
+	start := func(t *testing.T, f *oauthFixture) *authservice.DeviceAuthorizationResult {
+		t.Helper()
+		res, err := f.svc.StartDeviceAuthorization(ctx, "test-client", "", []string{"user:read"})
+		require.NoError(t, err)
+		require.NotEmpty(t, res.DeviceCode)
+		assert.Regexp(t, `^[B-Z]{4}-[B-Z]{4}$`, res.UserCode)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), res.ExpiresAt, time.Second)
+		assert.Equal(t, 5*time.Second, res.Interval)
+		return res
+	}
+
+	t.Run("polled before approval", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		_, err := f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending)
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrSlowDown, "polls must be at least the interval apart")
+	})
+
+	t.Run("approved with password", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		verification, err := f.svc.VerifyUserCode(ctx, device.UserCode, "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, "test-client", verification.Client.ID)
+		assert.Equal(t, []string{"user:read"}, verification.Scopes)
+
+		challenge, err := f.svc.ApproveDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Nil(t, challenge)
+
+		res, err := f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        []string{"user:read"},
+			AuthMethods:   []string{model.AuthMethodPassword},
+			ClientID:      "test-client",
+			EmailVerified: &user.EmailVerified,
+		})
+
+		_, err = f.svc.VerifyUserCode(ctx, device.UserCode, "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidUserCode, "user codes are single-use")
+	})
+
+	t.Run("approved with MFA", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		device := start(t, f)
+
+		challenge, err := f.svc.ApproveDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, challenge)
+
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending, "the password alone does not approve the device")
+
+		err = f.svc.ApproveDeviceWithMFA(ctx, device.UserCode, challenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		require.NoError(t, err)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		_, err := f.svc.ApproveDeviceWithPassword(ctx, device.UserCode, user.Email, "wrong", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidCredentials)
+
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		challenge, err := f.svc.DenyDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Nil(t, challenge)
+
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAccessDenied)
+		_, err = f.svc.ApproveDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidUserCode, "denied authorizations cannot be approved")
+	})
+
+	t.Run("denied with MFA", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		device := start(t, f)
+
+		challenge, err := f.svc.DenyDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, challenge)
+
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending, "the password alone does not deny the device")
+
+		err = f.svc.DenyDeviceWithMFA(ctx, device.UserCode, challenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		require.NoError(t, err)
+	})
+
+	t.Run("MFA token of another decision", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		device := start(t, f)
+		other := start(t, f)
+
+		challenge, err := f.svc.DenyDeviceWithPassword(ctx, device.UserCode, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, challenge)
+
+		err = f.svc.ApproveDeviceWithMFA(ctx, device.UserCode, challenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken, "a denial cannot be turned into an approval")
+		err = f.svc.DenyDeviceWithMFA(ctx, other.UserCode, challenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken, "the login is bound to its user code")
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", other.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending)
+
+		err = f.svc.DenyDeviceWithMFA(ctx, device.UserCode, challenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		require.NoError(t, err)
+	})
+
+	t.Run("denied without signing in", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		_, err := f.svc.DenyDeviceWithPassword(ctx, device.UserCode, user.Email, "wrong", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidCredentials)
+		err = f.svc.DenyDeviceWithMFA(ctx, device.UserCode, "forged", "123456", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken)
+
+		_, err = f.svc.PollDeviceAuthorization(ctx, "test-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAuthorizationPending, "the device is still waiting for its member")
+	})
+
+	t.Run("polled by another client", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		device := start(t, f)
+
+		_, err := f.svc.PollDeviceAuthorization(ctx, "other-client", "", device.DeviceCode, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidGrant)
+	})
+
+	t.Run("unknown device code", func(t *testing.T) {
+		f := newOAuthFixture(user)
+
+		_, err := f.svc.PollDeviceAuthorization(ctx, "test-client", "", "unknown", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidGrant)
+	})
+
+	t.Run("scopes the client is not allowed", func(t *testing.T) {
+		f := newOAuthFixture(user)
+
+		_, err := f.svc.StartDeviceAuthorization(ctx, "test-client", "", []string{"invoice:write"})
+		assert.ErrorIs(t, err, authservice.ErrInvalidScope)
+	})
+
+	t.Run("confidential client without its secret", func(t *testing.T) {
+		f := newOAuthFixture(user)
+
+		_, err := f.svc.StartDeviceAuthorization(ctx, "billing-job", "wrong", []string{"user:read"})
+		assert.ErrorIs(t, err, authservice.ErrInvalidClient)
+	})
+}
+
+// TestUnitVerifyUserCode tests that user codes are matched as members are likely to type them.
+func TestUnitVerifyUserCode(t *testing.T) {
+	ctx := context.Background()
+	f := newOAuthFixture(&usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}})
+	device, err := f.svc.StartDeviceAuthorization(ctx, "test-client", "", nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		userCode string
+		wantErr  error
+	}{
+		{name: "as shown", userCode: device.UserCode},
+		{name: "lowercase", userCode: strings.ToLower(device.UserCode)},
+		{name: "without separator", userCode: strings.ReplaceAll(device.UserCode, "-", "")},
+		{name: "with spaces", userCode: " " + strings.ReplaceAll(device.UserCode, "-", " ") + " "},
+		{name: "unknown", userCode: "BBBB-BBBB", wantErr: authservice.ErrInvalidUserCode},
+		{name: "too short", userCode: device.UserCode[:4], wantErr: authservice.ErrInvalidUserCode},
+		{name: "characters outside the alphabet", userCode: "AAAA-0000", wantErr: authservice.ErrInvalidUserCode},
+		{name: "empty", userCode: "", wantErr: authservice.ErrInvalidUserCode},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every case comes from its own IP, so that the invalid codes of the others do not throttle it.
+			res, err := f.svc.VerifyUserCode(ctx, tt.userCode, fmt.Sprintf("10.0.0.%d", i))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, device.UserCode, res.UserCode)
+		})
+	}
+}
+
+// TestUnitVerifyUserCode_Throttling tests that an IP entering too many invalid user codes is locked out, so that
+// user codes cannot be guessed (RFC 8628 section 5.1).
+func TestUnitVerifyUserCode_Throttling(t *testing.T) {
+	ctx := context.Background()
+	f := newOAuthFixture(&usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}})
+	device, err := f.svc.StartDeviceAuthorization(ctx, "test-client", "", nil)
+	require.NoError(t, err)
+
+	for range testLoginPolicy.MaxFailures {
+		_, err := f.svc.VerifyUserCode(ctx, "BBBB-BBBB", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidUserCode)
+	}
+
+	_, err = f.svc.VerifyUserCode(ctx, device.UserCode, "1.2.3.4")
+	var throttled *authservice.UserCodeThrottledError
+	require.ErrorAs(t, err, &throttled, "even the right code is refused while the IP is locked out")
+	assert.Positive(t, throttled.RetryAfter)
+	_, err = f.svc.ApproveDeviceWithPassword(ctx, device.UserCode, "user@example.com", "password", "1.2.3.4")
+	assert.ErrorIs(t, err, authservice.ErrTooManyUserCodes)
+
+	_, err = f.svc.VerifyUserCode(ctx, device.UserCode, "5.6.7.8")
+	assert.NoError(t, err, "other IPs are not locked out")
+}
@@ -0,0 +1,72 @@
+// Package authservice defines the DPoP (RFC 9449) binding of the tokens of the auth API.
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// dpopProof returns the DPoP proof of the request, as validated by the DPoPProof middleware, or nil when the
+// request carries none.
+func dpopProof(ctx context.Context) (*dpop.Proof, error) {
+	proof, err := dpop.GetProof(ctx)
+	switch {
+	case errors.Is(err, dpop.ErrInvalidProof):
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDPoPProof, err)
+	case err != nil:
+		return nil, fmt.Errorf("validate DPoP proof: %w", err)
+	}
+	return proof, nil
+}
+
+// dpopKeyThumbprint returns the thumbprint of the key of the DPoP proof of the request, which the tokens issued
+// for the request are bound to, or "" when the request carries no proof.
+func dpopKeyThumbprint(ctx context.Context) (string, error) {
+	proof, err := dpopProof(ctx)
+	if err != nil || proof == nil {
+		return "", err
+	}
+	return proof.JKT, nil
+}
+
+// checkAccessTokenBinding checks that an access token bound to a DPoP key is presented with a proof made with
+// that key for that token. Bearer tokens need no proof.
+func checkAccessTokenBinding(ctx context.Context, accessToken model.AccessToken, claims *model.AccessTokenClaims) error {
+	if claims.JKT == "" {
+		return nil
+	}
+	proof, err := dpopProof(ctx)
+	if err != nil {
+		return err
+	}
+	if proof == nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDPoPProof, dpop.ErrMissingProof)
+	}
+	if err := proof.CheckAccessToken(string(accessToken), claims.JKT); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDPoPProof, err)
+	}
+	return nil
+}
+
+// checkRefreshTokenBinding checks that the refresh token of a session bound to a DPoP key is presented with a
+// proof made with that key. Refresh tokens of sessions started without a proof stay unbound.
+func checkRefreshTokenBinding(ctx context.Context, session *model.RefreshTokenSession) error {
+	if session.JKT == "" {
+		return nil
+	}
+	jkt, err := dpopKeyThumbprint(ctx)
+	if err != nil {
+		return err
+	}
+	if jkt == "" {
+		return fmt.Errorf("%w: %w", ErrInvalidDPoPProof, dpop.ErrMissingProof)
+	}
+	if jkt != session.JKT {
+		return fmt.Errorf("%w: key does not match the refresh token", ErrInvalidDPoPProof)
+	}
+	return nil
+}
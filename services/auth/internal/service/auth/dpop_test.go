@@ -0,0 +1,169 @@
+package authservice_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// withProof returns ctx with a DPoP proof made with the key of thumbprint jkt, as validated by the middleware.
+func withProof(ctx context.Context, jkt, accessToken string) context.Context {
+	proof := &dpop.Proof{ID: "proof-id", Method: "POST", URL: "https://auth.example.com/v1/login", IssuedAt: time.Now(), JKT: jkt}
+	if accessToken != "" {
+		proof.AccessTokenHash = dpop.AccessTokenHash(accessToken)
+	}
+	return dpop.WithProof(ctx, proof, nil)
+}
+
+// boundGrant matches the grant of an access token bound to the key of thumbprint jkt.
+func boundGrant(jkt string) any {
+	return mock.MatchedBy(func(grant model.AccessTokenGrant) bool { return grant.JKT == jkt })
+}
+
+// TestUnitDPoP_Login tests that a login with a DPoP proof binds the access token and the session to its key, and
+// that an invalid proof fails the login.
+func TestUnitDPoP_Login(t *testing.T) {
+	user := &usermodel.User{Email: "user@example.com"}
+
+	t.Run("with proof", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		_, err := f.svc.LoginWithEmailAndPassword(withProof(context.Background(), "jkt-1", ""), user.Email, "password", nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, boundGrant("jkt-1"))
+		session, err := f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("refresh-token"))
+		require.NoError(t, err)
+		assert.Equal(t, "jkt-1", session.JKT)
+		assert.Empty(t, session.Grant.JKT, "the key is carried by the session, not by its grant")
+	})
+
+	t.Run("without proof", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		res, err := f.svc.LoginWithEmailAndPassword(context.Background(), user.Email, "password", nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.TokenTypeBearer, res.TokenType)
+
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, boundGrant(""))
+		session, err := f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("refresh-token"))
+		require.NoError(t, err)
+		assert.Empty(t, session.JKT)
+	})
+
+	t.Run("invalid proof", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		ctx := dpop.WithProof(context.Background(), nil, fmt.Errorf("%w: htu does not match the request", dpop.ErrInvalidProof))
+		_, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "ua", "1.2.3.4")
+		require.ErrorIs(t, err, authservice.ErrInvalidDPoPProof)
+
+		f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+		_, err = f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("refresh-token"))
+		require.Error(t, err, "no session is started")
+	})
+}
+
+// TestUnitDPoP_Refresh tests that the refresh token of a bound session can only be used with a proof made with
+// its key, and that the rotated session stays bound.
+func TestUnitDPoP_Refresh(t *testing.T) {
+	user := &usermodel.User{Email: "user@example.com"}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr error
+	}{
+		{name: "proof of the key", ctx: withProof(context.Background(), "jkt-1", "")},
+		{name: "no proof", ctx: context.Background(), wantErr: authservice.ErrInvalidDPoPProof},
+		{name: "proof of another key", ctx: withProof(context.Background(), "jkt-2", ""), wantErr: authservice.ErrInvalidDPoPProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			_, err := f.svc.LoginWithEmailAndPassword(withProof(context.Background(), "jkt-1", ""), user.Email, "password", nil, "ua", "1.2.3.4")
+			require.NoError(t, err)
+
+			_, err = f.svc.Refresh(tt.ctx, "refresh-token", "ua", "1.2.3.4")
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				session, err := f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("refresh-token"))
+				require.NoError(t, err)
+				assert.False(t, session.IsRotated(), "a refresh without the key does not use up the refresh token")
+				return
+			}
+			require.NoError(t, err)
+			rotated, err := f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("rotated-refresh-token"))
+			require.NoError(t, err)
+			assert.Equal(t, "jkt-1", rotated.JKT)
+		})
+	}
+
+	t.Run("unbound session", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		_, err := f.svc.LoginWithEmailAndPassword(context.Background(), user.Email, "password", nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		_, err = f.svc.Refresh(withProof(context.Background(), "jkt-1", ""), "refresh-token", "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, boundGrant("jkt-1"))
+		rotated, err := f.refreshRepo.GetRefreshTokenSession(context.Background(), hashOf("rotated-refresh-token"))
+		require.NoError(t, err)
+		assert.Empty(t, rotated.JKT, "the refresh token of a session started without a proof stays unbound")
+	})
+}
+
+// TestUnitDPoP_VerifyAccessToken tests that a bound access token is only accepted with a proof made with its key for
+// that very token, while introspection, which is shown the token rather than presented it, needs none.
+func TestUnitDPoP_VerifyAccessToken(t *testing.T) {
+	user := &usermodel.User{Email: "user@example.com"}
+	f := newOAuthFixture(user)
+	bound := claimsOf(user.Email)
+	bound.JKT = "jkt-1"
+	f.accessMock.On("ParseToken", "bound-access-token").Return(bound, nil)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wantErr bool
+	}{
+		{name: "proof for the token", ctx: withProof(context.Background(), "jkt-1", "bound-access-token")},
+		{name: "no proof", ctx: context.Background(), wantErr: true},
+		{name: "proof of another key", ctx: withProof(context.Background(), "jkt-2", "bound-access-token"), wantErr: true},
+		{name: "proof for another token", ctx: withProof(context.Background(), "jkt-1", "access-token"), wantErr: true},
+		{name: "proof without token", ctx: withProof(context.Background(), "jkt-1", ""), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := f.svc.VerifyAccessToken(tt.ctx, "bound-access-token")
+			if tt.wantErr {
+				require.ErrorIs(t, err, authservice.ErrInvalidAccessToken)
+				require.ErrorIs(t, err, authservice.ErrInvalidDPoPProof)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "jkt-1", claims.JKT)
+		})
+	}
+
+	t.Run("bearer token", func(t *testing.T) {
+		_, err := f.svc.VerifyAccessToken(context.Background(), "access-token")
+		require.NoError(t, err)
+	})
+
+	t.Run("introspection", func(t *testing.T) {
+		res, err := f.svc.IntrospectToken(context.Background(), "billing-job", "billing-secret", "bound-access-token", model.TokenTypeHintAccessToken)
+		require.NoError(t, err)
+		assert.True(t, res.Active)
+		assert.Equal(t, "jkt-1", res.JKT)
+	})
+}
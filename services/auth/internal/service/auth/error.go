@@ -0,0 +1,173 @@
+// Package authservice defines the errors for the auth API.
+package authservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the email and password do not match a user.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrAccountDisabled is returned when the credentials are valid but the account is disabled.
+	ErrAccountDisabled = errors.New("account disabled")
+	// ErrAccountLocked is returned when the credentials are valid but the account is locked.
+	ErrAccountLocked = errors.New("account locked")
+	// ErrEmailNotVerified is returned when the credentials are valid but the member has not verified their email yet.
+	ErrEmailNotVerified = errors.New("email not verified")
+	// ErrDependencyUnavailable is returned when a service the auth service depends on cannot be reached.
+	ErrDependencyUnavailable = errors.New("dependency unavailable")
+	// ErrTooManyLoginAttempts is returned, wrapped in a *LoginThrottledError, when logins are locked out.
+	ErrTooManyLoginAttempts = errors.New("too many login attempts")
+	// ErrInvalidAccessToken is returned when an access token cannot be verified.
+	ErrInvalidAccessToken = errors.New("invalid access token")
+	// ErrAccessTokenRevoked is returned when the access token has been revoked before its expiry.
+	ErrAccessTokenRevoked = errors.New("access token revoked")
+	// ErrInvalidDPoPProof is returned when the DPoP proof of a request is invalid, or a token bound to a DPoP key
+	// is presented without a proof made with that key. It is wrapped in ErrInvalidAccessToken for access tokens.
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is replayed.
+	// The whole token family is revoked before it is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrInvalidScope is returned when none of the scopes requested at login are allowed for the user.
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrInvalidMFAToken is returned when an MFA challenge token is unknown, expired or already used.
+	ErrInvalidMFAToken = errors.New("invalid MFA token")
+	// ErrInvalidOTP is returned when a one-time password is wrong or was already used.
+	ErrInvalidOTP = errors.New("invalid one-time password")
+	// ErrTOTPNotEnrolled is returned when confirming a TOTP enrollment that was never started.
+	ErrTOTPNotEnrolled = errors.New("TOTP not enrolled")
+	// ErrTOTPAlreadyEnabled is returned when enrolling or confirming TOTP while it is already enabled.
+	ErrTOTPAlreadyEnabled = errors.New("TOTP already enabled")
+	// ErrInvalidPasskeyCeremony is returned when a passkey ceremony ID is unknown, expired, already used
+	// or was started by another member.
+	ErrInvalidPasskeyCeremony = errors.New("invalid passkey ceremony")
+	// ErrInvalidPasskey is returned when the attestation or assertion of a passkey cannot be verified,
+	// or the passkey is not registered.
+	ErrInvalidPasskey = errors.New("invalid passkey")
+	// ErrPasskeyAlreadyRegistered is returned when registering a passkey whose credential ID is already registered.
+	ErrPasskeyAlreadyRegistered = errors.New("passkey already registered")
+	// ErrInvalidClient is returned when an OAuth client is not registered.
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrInvalidRedirectURI is returned when the redirect URI of an authorization request is not registered for its
+	// client.
+	ErrInvalidRedirectURI = errors.New("invalid redirect URI")
+	// ErrUnsupportedResponseType is returned when an authorization request asks for another response type than code.
+	ErrUnsupportedResponseType = errors.New("unsupported response type")
+	// ErrInvalidAuthorizationRequest is returned when an authorization request lacks a valid S256 PKCE code challenge.
+	ErrInvalidAuthorizationRequest = errors.New("invalid authorization request")
+	// ErrInvalidGrant is returned when an authorization code is unknown, expired, already used, issued to another
+	// client or redirect URI, or redeemed with the wrong code verifier.
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrUnauthorizedClient is returned when a public client asks for a token of its own.
+	ErrUnauthorizedClient = errors.New("unauthorized client")
+	// ErrInvalidTarget is returned when none of the audiences a client asks for are allowed for it.
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrAuthorizationPending is returned when a client polls for the tokens of a device authorization that
+	// has not been approved yet.
+	ErrAuthorizationPending = errors.New("authorization pending")
+	// ErrSlowDown is returned when a client polls for the tokens of a device authorization faster than its interval.
+	ErrSlowDown = errors.New("slow down")
+	// ErrAccessDenied is returned when a client polls for the tokens of a device authorization the member denied.
+	ErrAccessDenied = errors.New("access denied")
+	// ErrExpiredToken is returned when a client polls for the tokens of an expired device authorization.
+	ErrExpiredToken = errors.New("expired token")
+	// ErrInvalidUserCode is returned when a user code is unknown, expired or already approved or denied.
+	ErrInvalidUserCode = errors.New("invalid user code")
+	// ErrTooManyUserCodes is returned, wrapped in a *UserCodeThrottledError, when user codes entered from an IP are
+	// locked out after too many invalid ones.
+	ErrTooManyUserCodes = errors.New("too many invalid user codes")
+	// ErrInsufficientScope is returned when an access token without the openid scope asks for user info.
+	ErrInsufficientScope = errors.New("insufficient scope")
+	// ErrSessionNotFound is returned when a session does not exist or does not belong to the caller.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrInvalidEmail is returned when registering with a malformed email address.
+	ErrInvalidEmail = errors.New("invalid email")
+	// ErrInvalidPassword is returned when a new password does not meet the password policy.
+	ErrInvalidPassword = errors.New("invalid password")
+	// ErrUserAlreadyExists is returned when registering with the email of an existing user.
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrInvalidResetToken is returned when a password reset token is unknown, expired or already used.
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+	// ErrTooManyPasswordResetEmails is returned, wrapped in a *PasswordResetThrottledError, when password reset
+	// emails to an email are throttled.
+	ErrTooManyPasswordResetEmails = errors.New("too many password reset emails")
+	// ErrInvalidVerificationToken is returned when an email verification token is forged, expired or already used.
+	ErrInvalidVerificationToken = errors.New("invalid email verification token")
+	// ErrTooManyVerificationEmails is returned, wrapped in a *VerificationEmailThrottledError, when verification
+	// emails to an email are throttled.
+	ErrTooManyVerificationEmails = errors.New("too many verification emails")
+	// ErrInvalidLoginChallenge is returned when a passwordless login is unknown, expired, already completed or was
+	// discarded after too many wrong codes.
+	ErrInvalidLoginChallenge = errors.New("invalid login challenge")
+	// ErrInvalidLoginCode is returned when the code of a passwordless login is wrong.
+	ErrInvalidLoginCode = errors.New("invalid login code")
+	// ErrUnknownIdentityProvider is returned when a federated login asks for an identity provider that is not
+	// configured.
+	ErrUnknownIdentityProvider = errors.New("unknown identity provider")
+	// ErrInvalidFederatedLogin is returned when a federated login is unknown, expired, already completed or was
+	// started by another browser, or when the identity provider rejects its authorization code or ID token.
+	ErrInvalidFederatedLogin = errors.New("invalid federated login")
+	// ErrFederatedEmailNotVerified is returned when the identity provider of a federated login asserts no email,
+	// or an email it has not verified.
+	ErrFederatedEmailNotVerified = errors.New("email not verified by identity provider")
+)
+
+// LoginThrottledError is returned when logins for an email or from an IP are locked out after too many failures.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyLoginAttempts, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrTooManyLoginAttempts.
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
+
+// PasswordResetThrottledError is returned when a password reset was asked for the same email too recently.
+type PasswordResetThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *PasswordResetThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyPasswordResetEmails, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrTooManyPasswordResetEmails.
+func (e *PasswordResetThrottledError) Unwrap() error {
+	return ErrTooManyPasswordResetEmails
+}
+
+// VerificationEmailThrottledError is returned when a verification email was sent to the same email too recently.
+type VerificationEmailThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *VerificationEmailThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyVerificationEmails, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrTooManyVerificationEmails.
+func (e *VerificationEmailThrottledError) Unwrap() error {
+	return ErrTooManyVerificationEmails
+}
+
+// UserCodeThrottledError is returned when user codes entered from an IP are locked out after too many invalid ones.
+type UserCodeThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UserCodeThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyUserCodes, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrTooManyUserCodes.
+func (e *UserCodeThrottledError) Unwrap() error {
+	return ErrTooManyUserCodes
+}
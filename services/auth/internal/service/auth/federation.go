@@ -0,0 +1,229 @@
+// Package authservice defines the federated login of the auth API through upstream OpenID Connect identity providers.
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/federation"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+)
+
+// federatedLoginTTL is how long a member has to log in at the identity provider and come back.
+const federatedLoginTTL = 10 * time.Minute
+
+// IdentityProviders is the interface for the client of the upstream OpenID Connect identity providers.
+type IdentityProviders interface {
+	AuthorizationURL(ctx context.Context, providerID, state, nonce, codeChallenge string) (string, error)
+	Exchange(ctx context.Context, providerID, code, codeVerifier, nonce string) (*model.ExternalIdentity, error)
+}
+
+// FederatedLoginRepository is the interface for the repository of pending federated logins.
+type FederatedLoginRepository interface {
+	SaveFederatedLogin(ctx context.Context, login *model.FederatedLogin) error
+	ConsumeFederatedLogin(ctx context.Context, stateHash model.FederatedLoginHash) (*model.FederatedLogin, error)
+}
+
+// StartFederatedLogin starts a login through an identity provider and returns the URL to send the member to.
+// The state, nonce and PKCE code verifier of the login are kept until the member comes back. The returned binding
+// must be kept by the browser, e.g. in a cookie, so that a login started by someone else cannot be completed in it.
+func (s *Service) StartFederatedLogin(ctx context.Context, providerID string) (*FederatedLoginResult, error) {
+	secrets := make([]string, 4)
+	for i := range secrets {
+		b, err := randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		secrets[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	state, binding, nonce, codeVerifier := secrets[0], secrets[1], secrets[2], secrets[3]
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	authorizationURL, err := s.identityProviders.AuthorizationURL(ctx, providerID, state, nonce, base64.RawURLEncoding.EncodeToString(sum[:]))
+	switch {
+	case errors.Is(err, federation.ErrUnknownProvider):
+		return nil, ErrUnknownIdentityProvider
+	case errors.Is(err, federation.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("build authorization URL: %w", err)
+	}
+
+	now := time.Now()
+	login := &model.FederatedLogin{
+		StateHash:    hashFederatedLoginState(state),
+		Provider:     providerID,
+		BindingHash:  hashFederatedLoginBinding(binding),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(federatedLoginTTL),
+	}
+	if err := s.federatedLogins.SaveFederatedLogin(ctx, login); err != nil {
+		return nil, fmt.Errorf("save federated login: %w", err)
+	}
+	return &FederatedLoginResult{AuthorizationURL: authorizationURL, Binding: binding, ExpiresAt: login.ExpiresAt}, nil
+}
+
+// CompleteFederatedLogin completes a login through an identity provider with the state and authorization code the
+// member came back with, in the browser that started it. The ID token of the provider must assert a verified
+// email: the member with that email is logged in, and created if there is none. Like LoginWithEmailAndPassword,
+// the access token is narrowed to the requested scopes, and members who enabled a second factor get an MFA
+// challenge instead of tokens.
+// The login is single-use, even when it fails; a login rejected for its scopes must be started again.
+func (s *Service) CompleteFederatedLogin(ctx context.Context, state, binding, code string, requestedScopes []string, userAgent, ipAddress string) (*LoginResult, error) {
+	login, err := s.federatedLogins.ConsumeFederatedLogin(ctx, hashFederatedLoginState(state))
+	if errors.Is(err, repository.ErrFederatedLoginNotFound) {
+		s.recordLoginFailure(ctx, "", "", model.AuthMethodFederated, ipAddress, model.AuditReasonInvalidFederatedLogin)
+		return nil, ErrInvalidFederatedLogin
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume federated login: %w", err)
+	}
+	if login.IsExpired(time.Now()) || subtle.ConstantTimeCompare([]byte(hashFederatedLoginBinding(binding)), []byte(login.BindingHash)) != 1 {
+		s.recordLoginFailure(ctx, "", "", model.AuthMethodFederated, ipAddress, model.AuditReasonInvalidFederatedLogin)
+		return nil, ErrInvalidFederatedLogin
+	}
+
+	identity, err := s.identityProviders.Exchange(ctx, login.Provider, code, login.CodeVerifier, login.Nonce)
+	switch {
+	case errors.Is(err, federation.ErrInvalidGrant), errors.Is(err, federation.ErrInvalidIDToken), errors.Is(err, federation.ErrUnknownProvider):
+		s.recordLoginFailure(ctx, "", "", model.AuthMethodFederated, ipAddress, model.AuditReasonInvalidFederatedLogin)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFederatedLogin, err)
+	case errors.Is(err, federation.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("exchange authorization code: %w", err)
+	}
+	email := normalizeEmail(identity.Email)
+	if email == "" || !identity.EmailVerified {
+		s.recordLoginFailure(ctx, "", email, model.AuthMethodFederated, ipAddress, model.AuditReasonFederatedEmailNotVerified)
+		return nil, ErrFederatedEmailNotVerified
+	}
+
+	user, err := s.linkFederatedMember(ctx, email)
+	switch {
+	case errors.Is(err, ErrAccountDisabled):
+		s.recordLoginFailure(ctx, "", email, model.AuthMethodFederated, ipAddress, model.AuditReasonAccountDisabled)
+		return nil, err
+	case errors.Is(err, ErrAccountLocked):
+		s.recordLoginFailure(ctx, "", email, model.AuthMethodFederated, ipAddress, model.AuditReasonAccountLocked)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+	memberID := user.Email
+
+	scopes, err := grantedScopes(requestedScopes, user.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	grant := model.AccessTokenGrant{
+		Scopes:        scopes,
+		Roles:         user.Roles,
+		Audiences:     user.Audiences,
+		AuthMethods:   []string{model.AuthMethodFederated},
+		EmailVerified: &user.EmailVerified,
+	}
+
+	mfaRequired, err := s.isMFARequired(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaRequired {
+		mfaChallenge, err := s.startMFAChallenge(ctx, "", model.DeviceDecision{}, memberID, user.Email, grant)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAChallenge: mfaChallenge}, nil
+	}
+	return s.startSession(ctx, "", memberID, grant, userAgent, ipAddress)
+}
+
+// linkFederatedMember returns the member with an email an identity provider verified, creating them if there is
+// none. Members created this way get a random password, which they can replace with a password reset.
+// As the email is verified, members pending verification are activated; their password, set by whoever
+// registered without proving they own the email, is replaced first, so that the account cannot be taken over
+// by registering it ahead of its owner.
+func (s *Service) linkFederatedMember(ctx context.Context, email string) (*usermodel.User, error) {
+	user, err := s.userGateway.GetUser(ctx, email)
+	if errors.Is(err, gateway.ErrUserNotFound) {
+		user, err = s.createFederatedMember(ctx, email)
+	} else if err == nil && user.Status == usermodel.StatusPendingVerification {
+		err = s.setRandomPassword(ctx, email)
+	}
+	switch {
+	case errors.Is(err, gateway.ErrAccountDisabled):
+		return nil, ErrAccountDisabled
+	case errors.Is(err, gateway.ErrAccountLocked):
+		return nil, ErrAccountLocked
+	case errors.Is(err, gateway.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("link federated member: %w", err)
+	}
+	if user.EmailVerified {
+		return user, nil
+	}
+
+	user, err = s.userGateway.VerifyEmail(ctx, email)
+	switch {
+	case errors.Is(err, gateway.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("verify email: %w", err)
+	}
+	return user, nil
+}
+
+// createFederatedMember creates a member with a random password. A member created concurrently, e.g. by a login
+// completed twice at once, is returned instead.
+func (s *Service) createFederatedMember(ctx context.Context, email string) (*usermodel.User, error) {
+	password, err := randomPassword()
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userGateway.CreateUser(ctx, email, password)
+	if errors.Is(err, gateway.ErrUserAlreadyExists) {
+		return s.userGateway.GetUser(ctx, email)
+	}
+	return user, err
+}
+
+// setRandomPassword replaces the password of a member with a random one.
+func (s *Service) setRandomPassword(ctx context.Context, email string) error {
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	return s.userGateway.SetPassword(ctx, email, password)
+}
+
+// randomPassword generates a password nobody knows, for members who log in without one.
+func randomPassword() (string, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashFederatedLoginState hashes the state of a federated login for storage.
+func hashFederatedLoginState(state string) model.FederatedLoginHash {
+	sum := sha256.Sum256([]byte(state))
+	return model.FederatedLoginHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// hashFederatedLoginBinding hashes the browser binding of a federated login for storage.
+func hashFederatedLoginBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,206 @@
+package authservice_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/federation/federationtest"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// federatedLogin is a federated login the test identity provider redirected back from.
+type federatedLogin struct {
+	state   string
+	binding string
+	code    string
+}
+
+// unguessablePassword matches the random passwords members who log in through an identity provider get.
+var unguessablePassword = mock.MatchedBy(func(password string) bool { return len(password) >= 43 })
+
+// startFederatedLogin starts a federated login through the test identity provider and logs identity in there.
+func startFederatedLogin(t *testing.T, f *oauthFixture, identity federationtest.Identity) federatedLogin {
+	t.Helper()
+	res, err := f.svc.StartFederatedLogin(context.Background(), "corp")
+	require.NoError(t, err)
+	redirect, err := testIDP.Authorize(res.AuthorizationURL, identity)
+	require.NoError(t, err)
+	return federatedLogin{state: redirect.Query().Get("state"), binding: res.Binding, code: redirect.Query().Get("code")}
+}
+
+// TestUnitStartFederatedLogin tests that federated logins send the member to the identity provider with a state
+// and a PKCE code challenge.
+func TestUnitStartFederatedLogin(t *testing.T) {
+	ctx := context.Background()
+	f := newOAuthFixture(&usermodel.User{Email: "user@example.com"})
+
+	res, err := f.svc.StartFederatedLogin(ctx, "corp")
+	require.NoError(t, err)
+	u, err := url.Parse(res.AuthorizationURL)
+	require.NoError(t, err)
+	assert.Equal(t, testIDP.Issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.GreaterOrEqual(t, len(u.Query().Get("state")), 43, "states must not be guessable")
+	assert.NotEmpty(t, u.Query().Get("nonce"))
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.GreaterOrEqual(t, len(res.Binding), 43)
+	assert.NotContains(t, res.AuthorizationURL, res.Binding, "the binding must stay in the browser")
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), res.ExpiresAt, 5*time.Second)
+
+	_, err = f.svc.StartFederatedLogin(ctx, "unknown")
+	assert.ErrorIs(t, err, authservice.ErrUnknownIdentityProvider)
+}
+
+// TestUnitCompleteFederatedLogin tests that federated logins issue the same tokens and refresh session as password
+// logins, once, to the member with the email the identity provider verified, who is created if needed.
+func TestUnitCompleteFederatedLogin(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusActive, EmailVerified: true, Scopes: []string{"user:read", "order:read"}}
+	identity := federationtest.Identity{Subject: "248289761001", Email: "User@Example.com", EmailVerified: true}
+
+	t.Run("existing member", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+		login := startFederatedLogin(t, f, identity)
+
+		res, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, []string{"order:read"}, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Nil(t, res.MFAChallenge)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+		assert.Equal(t, []string{"order:read"}, res.Scopes)
+
+		wantGrant := model.AccessTokenGrant{
+			Scopes:        []string{"order:read"},
+			AuthMethods:   []string{model.AuthMethodFederated},
+			EmailVerified: &user.EmailVerified,
+		}
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, wantGrant)
+		session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+		require.NoError(t, err)
+		assert.Equal(t, user.Email, session.MemberID)
+		assert.Equal(t, wantGrant, session.Grant)
+		f.userGateway.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything, mock.Anything)
+		f.userGateway.AssertNotCalled(t, "SetPassword", mock.Anything, mock.Anything, mock.Anything)
+
+		_, err = f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidFederatedLogin, "federated logins are single-use")
+	})
+
+	t.Run("new member", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		pending := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusPendingVerification}
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, gateway.ErrUserNotFound).Once()
+		f.userGateway.On("CreateUser", mock.Anything, user.Email, unguessablePassword).Return(pending, nil).Once()
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(user, nil).Once()
+		login := startFederatedLogin(t, f, identity)
+
+		res, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		f.userGateway.AssertCalled(t, "CreateUser", mock.Anything, user.Email, unguessablePassword)
+		f.userGateway.AssertCalled(t, "VerifyEmail", mock.Anything, user.Email)
+	})
+
+	t.Run("member pending verification", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		pending := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusPendingVerification}
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(pending, nil).Once()
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, unguessablePassword).Return(nil).Once()
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(user, nil).Once()
+		login := startFederatedLogin(t, f, identity)
+
+		_, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		// The password of whoever registered the email without proving they own it is replaced.
+		f.userGateway.AssertCalled(t, "SetPassword", mock.Anything, user.Email, unguessablePassword)
+		f.userGateway.AssertCalled(t, "VerifyEmail", mock.Anything, user.Email)
+	})
+
+	t.Run("second factor", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+		login := startFederatedLogin(t, f, identity)
+
+		res, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, res.MFAChallenge)
+		assert.Empty(t, res.AccessToken)
+
+		res, err = f.svc.LoginWithMFA(ctx, res.MFAChallenge.Token, nextTOTPCode(secret), "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        user.Scopes,
+			AuthMethods:   []string{model.AuthMethodFederated, model.AuthMethodOTP},
+			EmailVerified: &user.EmailVerified,
+		})
+	})
+
+	t.Run("other browser", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		login := startFederatedLogin(t, f, identity)
+
+		_, err := f.svc.CompleteFederatedLogin(ctx, login.state, "other-binding", login.code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidFederatedLogin)
+		_, err = f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidFederatedLogin, "a login tried from another browser must be discarded")
+		assertLoginFailed(t, f.audit, "", model.AuthMethodFederated, model.AuditReasonInvalidFederatedLogin)
+	})
+
+	t.Run("code rejected by identity provider", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		login := startFederatedLogin(t, f, identity)
+
+		_, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, "wrong-code", nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidFederatedLogin)
+	})
+
+	t.Run("email not verified by identity provider", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		login := startFederatedLogin(t, f, federationtest.Identity{Subject: identity.Subject, Email: user.Email})
+
+		_, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrFederatedEmailNotVerified)
+		f.userGateway.AssertNotCalled(t, "GetUser", mock.Anything, mock.Anything)
+		assertLoginFailed(t, f.audit, user.Email, model.AuthMethodFederated, model.AuditReasonFederatedEmailNotVerified)
+	})
+
+	t.Run("scope not allowed", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+		login := startFederatedLogin(t, f, identity)
+
+		_, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, []string{"admin"}, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidScope)
+	})
+
+	tests := []struct {
+		name       string
+		gatewayErr error
+		wantErr    error
+	}{
+		{name: "disabled member", gatewayErr: gateway.ErrAccountDisabled, wantErr: authservice.ErrAccountDisabled},
+		{name: "locked member", gatewayErr: gateway.ErrAccountLocked, wantErr: authservice.ErrAccountLocked},
+		{name: "user service unavailable", gatewayErr: gateway.ErrUnavailable, wantErr: authservice.ErrDependencyUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, tt.gatewayErr).Once()
+			login := startFederatedLogin(t, f, identity)
+
+			_, err := f.svc.CompleteFederatedLogin(ctx, login.state, login.binding, login.code, nil, "ua", "1.2.3.4")
+			assert.ErrorIs(t, err, tt.wantErr)
+			f.userGateway.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Package authservice defines the token introspection (RFC 7662) and revocation (RFC 7009) of the auth API.
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// IntrospectToken reports whether an access or refresh token is active and, if so, who and what it was issued
+// for. Only confidential clients, such as resource servers, may introspect tokens. Invalid, expired, revoked and
+// rotated tokens are all reported as inactive, without telling why. The token type hint only decides which kind
+// of token is looked up first.
+func (s *Service) IntrospectToken(ctx context.Context, clientID, clientSecret, token, tokenTypeHint string) (*IntrospectionResult, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsConfidential() {
+		return nil, ErrUnauthorizedClient
+	}
+
+	lookups := []func(context.Context, string) (*IntrospectionResult, error){s.introspectAccessToken, s.introspectRefreshToken}
+	if tokenTypeHint == model.TokenTypeHintRefreshToken {
+		slices.Reverse(lookups)
+	}
+	for _, lookup := range lookups {
+		res, err := lookup(ctx, token)
+		if err != nil || res != nil {
+			return res, err
+		}
+	}
+	return &IntrospectionResult{}, nil
+}
+
+// RevokeToken revokes an access or refresh token a client was issued. Revoking a refresh token revokes its whole
+// token family, so that no earlier token of the session can be replayed. Invalid and already revoked tokens, and
+// tokens of other clients, are ignored so that clients cannot probe them (RFC 7009 section 2.2).
+func (s *Service) RevokeToken(ctx context.Context, clientID, clientSecret, token, tokenTypeHint string) error {
+	if _, err := s.authenticateClient(ctx, clientID, clientSecret); err != nil {
+		return err
+	}
+
+	revocations := []func(ctx context.Context, clientID, token string) (bool, error){s.revokeClientAccessToken, s.revokeClientRefreshToken}
+	if tokenTypeHint == model.TokenTypeHintRefreshToken {
+		slices.Reverse(revocations)
+	}
+	for _, revoke := range revocations {
+		found, err := revoke(ctx, clientID, token)
+		if err != nil || found {
+			return err
+		}
+	}
+	return nil
+}
+
+// introspectAccessToken returns the introspection of an active access token, or nil when token is not one.
+func (s *Service) introspectAccessToken(ctx context.Context, token string) (*IntrospectionResult, error) {
+	claims, err := s.verifyAccessToken(ctx, model.AccessToken(token))
+	if errors.Is(err, ErrInvalidAccessToken) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &IntrospectionResult{
+		Active:    true,
+		TokenType: model.TokenTypeHintAccessToken,
+		Subject:   claims.Subject,
+		ClientID:  claims.ClientID,
+		Scopes:    claims.Scopes,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		JKT:       claims.JKT,
+	}, nil
+}
+
+// introspectRefreshToken returns the introspection of an active refresh token, or nil when token is not one.
+func (s *Service) introspectRefreshToken(ctx context.Context, token string) (*IntrospectionResult, error) {
+	session, err := s.findRefreshTokenSession(ctx, model.RefreshToken(token))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get refresh token session: %w", err)
+	}
+	if session.IsRevoked() || session.IsRotated() || session.IsExpired(time.Now()) {
+		return nil, nil
+	}
+	return &IntrospectionResult{
+		Active:    true,
+		TokenType: model.TokenTypeHintRefreshToken,
+		Subject:   session.MemberID,
+		ClientID:  session.ClientID,
+		Scopes:    session.Grant.Scopes,
+		IssuedAt:  session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+		JKT:       session.JKT,
+	}, nil
+}
+
+// revokeClientAccessToken denylists token if it is a live access token of clientID, and reports whether token is
+// a live access token at all.
+func (s *Service) revokeClientAccessToken(ctx context.Context, clientID, token string) (bool, error) {
+	claims, err := s.verifyAccessToken(ctx, model.AccessToken(token))
+	if errors.Is(err, ErrInvalidAccessToken) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if claims.ClientID != clientID {
+		return true, nil
+	}
+	return true, s.revokeAccessToken(ctx, claims)
+}
+
+// revokeClientRefreshToken revokes the token family of token if it is a refresh token of clientID, and reports
+// whether token is a known refresh token at all.
+func (s *Service) revokeClientRefreshToken(ctx context.Context, clientID, token string) (bool, error) {
+	session, err := s.findRefreshTokenSession(ctx, model.RefreshToken(token))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get refresh token session: %w", err)
+	}
+	if session.ClientID != clientID || session.IsRevoked() {
+		return true, nil
+	}
+	if err := s.refreshTokenRepo.RevokeRefreshTokenFamily(ctx, session.FamilyID, time.Now()); err != nil {
+		return true, fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	s.recordSessionRevoked(ctx, session.MemberID, session, model.AuditReasonRevokedByClient)
+	return true, nil
+}
@@ -0,0 +1,169 @@
+package authservice_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenFixture returns a service whose member has authorized the test client, which holds the tokens
+// "client-access-token" and "refresh-token". Other tokens are not access tokens.
+func newTokenFixture(t *testing.T) *oauthFixture {
+	t.Helper()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}}
+	f := newOAuthFixture(user)
+	req := newAuthorizationRequest()
+	_, err := f.svc.ExchangeAuthorizationCode(context.Background(), req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+	require.NoError(t, err)
+
+	claims := claimsOf(user.Email)
+	claims.ID = "jti-client"
+	claims.Scopes = []string{"user:read"}
+	claims.ClientID = "test-client"
+	f.accessMock.On("ParseToken", "client-access-token").Return(claims, nil)
+	f.accessMock.On("ParseToken", mock.Anything).Return(nil, errors.New("token is malformed"))
+	return f
+}
+
+// TestUnitIntrospectToken tests that confidential clients learn whether access and refresh tokens are active and
+// what they were issued for, and nothing about inactive tokens.
+func TestUnitIntrospectToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("access token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		res, err := f.svc.IntrospectToken(ctx, "billing-job", "billing-secret", "client-access-token", "")
+		require.NoError(t, err)
+		assert.True(t, res.Active)
+		assert.Equal(t, model.TokenTypeHintAccessToken, res.TokenType)
+		assert.Equal(t, "user@example.com", res.Subject)
+		assert.Equal(t, "test-client", res.ClientID)
+		assert.Equal(t, []string{"user:read"}, res.Scopes)
+		assert.WithinDuration(t, time.Now().Add(15*time.Minute), res.ExpiresAt, time.Second)
+	})
+
+	for _, hint := range []string{"", model.TokenTypeHintAccessToken, model.TokenTypeHintRefreshToken} {
+		t.Run("refresh token with hint "+hint, func(t *testing.T) {
+			f := newTokenFixture(t)
+			res, err := f.svc.IntrospectToken(ctx, "billing-job", "billing-secret", "refresh-token", hint)
+			require.NoError(t, err)
+			assert.True(t, res.Active)
+			assert.Equal(t, model.TokenTypeHintRefreshToken, res.TokenType)
+			assert.Equal(t, "user@example.com", res.Subject)
+			assert.Equal(t, "test-client", res.ClientID)
+			assert.Equal(t, []string{"user:read"}, res.Scopes)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), res.ExpiresAt, time.Second)
+		})
+	}
+
+	t.Run("rotated refresh token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		_, err := f.svc.RefreshClientToken(ctx, "test-client", "", "refresh-token", "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		res, err := f.svc.IntrospectToken(ctx, "billing-job", "billing-secret", "refresh-token", model.TokenTypeHintRefreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, &authservice.IntrospectionResult{}, res)
+	})
+
+	t.Run("revoked access token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		require.NoError(t, f.svc.RevokeAccessToken(ctx, "jti-client", time.Now().Add(15*time.Minute)))
+
+		res, err := f.svc.IntrospectToken(ctx, "billing-job", "billing-secret", "client-access-token", "")
+		require.NoError(t, err)
+		assert.Equal(t, &authservice.IntrospectionResult{}, res)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		res, err := f.svc.IntrospectToken(ctx, "billing-job", "billing-secret", "unknown", "")
+		require.NoError(t, err)
+		assert.Equal(t, &authservice.IntrospectionResult{}, res)
+	})
+
+	t.Run("public client", func(t *testing.T) {
+		f := newTokenFixture(t)
+		_, err := f.svc.IntrospectToken(ctx, "test-client", "", "client-access-token", "")
+		assert.ErrorIs(t, err, authservice.ErrUnauthorizedClient)
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		f := newTokenFixture(t)
+		_, err := f.svc.IntrospectToken(ctx, "billing-job", "wrong", "client-access-token", "")
+		assert.ErrorIs(t, err, authservice.ErrInvalidClient)
+	})
+}
+
+// TestUnitRevokeToken tests that clients revoke their own access and refresh tokens, and that tokens of other
+// clients and unknown tokens are silently left alone.
+func TestUnitRevokeToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("refresh token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		require.NoError(t, f.svc.RevokeToken(ctx, "test-client", "", "refresh-token", ""))
+
+		_, err := f.svc.RefreshClientToken(ctx, "test-client", "", "refresh-token", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidRefreshToken)
+
+		require.NoError(t, f.svc.RevokeToken(ctx, "test-client", "", "refresh-token", ""), "revocation is idempotent")
+	})
+
+	t.Run("rotated refresh token revokes its family", func(t *testing.T) {
+		f := newTokenFixture(t)
+		_, err := f.svc.RefreshClientToken(ctx, "test-client", "", "refresh-token", "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		require.NoError(t, f.svc.RevokeToken(ctx, "test-client", "", "refresh-token", model.TokenTypeHintRefreshToken))
+
+		session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("rotated-refresh-token"))
+		require.NoError(t, err)
+		assert.True(t, session.IsRevoked())
+	})
+
+	t.Run("refresh token of another client", func(t *testing.T) {
+		f := newTokenFixture(t)
+		require.NoError(t, f.svc.RevokeToken(ctx, "other-client", "", "refresh-token", ""))
+
+		_, err := f.svc.RefreshClientToken(ctx, "test-client", "", "refresh-token", "ua", "1.2.3.4")
+		assert.NoError(t, err)
+	})
+
+	t.Run("access token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		require.NoError(t, f.svc.RevokeToken(ctx, "test-client", "", "client-access-token", model.TokenTypeHintAccessToken))
+
+		revoked, err := f.svc.IsAccessTokenRevoked(ctx, "jti-client")
+		require.NoError(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("access token of another client", func(t *testing.T) {
+		f := newTokenFixture(t)
+		require.NoError(t, f.svc.RevokeToken(ctx, "other-client", "", "client-access-token", ""))
+
+		revoked, err := f.svc.IsAccessTokenRevoked(ctx, "jti-client")
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		f := newTokenFixture(t)
+		assert.NoError(t, f.svc.RevokeToken(ctx, "test-client", "", "unknown", ""))
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		f := newTokenFixture(t)
+		err := f.svc.RevokeToken(ctx, "billing-job", "wrong", "client-access-token", "")
+		assert.ErrorIs(t, err, authservice.ErrInvalidClient)
+	})
+}
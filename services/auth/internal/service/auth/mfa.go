@@ -0,0 +1,289 @@
+// Package authservice defines the multi-factor authentication of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// mfaChallengeTTL is how long a login that passed its password waits for the second factor.
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAChallengeFailures is the number of wrong codes after which an MFA challenge is discarded.
+	maxMFAChallengeFailures = 5
+	// totpUsedStepTTL is how long a used TOTP time step is remembered; it outlives the steps a code is accepted in.
+	totpUsedStepTTL = 3 * time.Minute
+)
+
+// TOTPMaker is the interface for the generator and validator of TOTP codes.
+type TOTPMaker interface {
+	GenerateSecret() ([]byte, error)
+	EncodeSecret(secret []byte) string
+	URI(account string, secret []byte) string
+	Validate(secret []byte, code string, at time.Time) (step int64, ok bool)
+}
+
+// SecretCipher is the interface for the cipher of secrets stored at rest.
+type SecretCipher interface {
+	Encrypt(plaintext, additionalData []byte) (string, error)
+	Decrypt(ciphertext string, additionalData []byte) ([]byte, error)
+}
+
+// MFARepository is the interface for the repository of TOTP credentials and pending MFA challenges.
+type MFARepository interface {
+	GetTOTPCredential(ctx context.Context, memberID string) (*model.TOTPCredential, error)
+	SaveTOTPCredential(ctx context.Context, credential *model.TOTPCredential) error
+	UseTOTPStep(ctx context.Context, memberID string, step int64, ttl time.Duration) (bool, error)
+	SaveMFAChallenge(ctx context.Context, challenge *model.MFAChallenge) error
+	GetMFAChallenge(ctx context.Context, tokenHash model.MFAChallengeHash) (*model.MFAChallenge, error)
+	RecordMFAChallengeFailure(ctx context.Context, challenge *model.MFAChallenge) (int64, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash model.MFAChallengeHash) (bool, error)
+}
+
+// EnrollTOTP generates a TOTP secret for the member owning accessToken, replacing any unconfirmed one.
+// The secret is stored encrypted and only required at login once confirmed with ConfirmTOTP.
+func (s *Service) EnrollTOTP(ctx context.Context, accessToken model.AccessToken) (*TOTPEnrollmentResult, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	memberID := claims.Subject
+
+	credential, err := s.mfaRepo.GetTOTPCredential(ctx, memberID)
+	switch {
+	case err == nil && credential.IsConfirmed():
+		return nil, ErrTOTPAlreadyEnabled
+	case err != nil && !errors.Is(err, repository.ErrTOTPCredentialNotFound):
+		return nil, fmt.Errorf("get TOTP credential: %w", err)
+	}
+
+	secret, err := s.totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecret, err := s.secretCipher.Encrypt(secret, []byte(memberID))
+	if err != nil {
+		return nil, fmt.Errorf("encrypt TOTP secret: %w", err)
+	}
+	err = s.mfaRepo.SaveTOTPCredential(ctx, &model.TOTPCredential{
+		MemberID:        memberID,
+		EncryptedSecret: encryptedSecret,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save TOTP credential: %w", err)
+	}
+
+	return &TOTPEnrollmentResult{
+		Secret: s.totp.EncodeSecret(secret),
+		URI:    s.totp.URI(memberID, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the TOTP enrollment of the member owning accessToken with a code from the authenticator,
+// proving that the authenticator was set up correctly. From then on, logins require a code.
+func (s *Service) ConfirmTOTP(ctx context.Context, accessToken model.AccessToken, code string) error {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	memberID := claims.Subject
+
+	credential, err := s.mfaRepo.GetTOTPCredential(ctx, memberID)
+	if errors.Is(err, repository.ErrTOTPCredentialNotFound) {
+		return ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return fmt.Errorf("get TOTP credential: %w", err)
+	}
+	if credential.IsConfirmed() {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	if err := s.verifyTOTP(ctx, credential, code); err != nil {
+		return err
+	}
+
+	credential.ConfirmedAt = time.Now()
+	if err := s.mfaRepo.SaveTOTPCredential(ctx, credential); err != nil {
+		return fmt.Errorf("save TOTP credential: %w", err)
+	}
+	return nil
+}
+
+// LoginWithMFA completes a login that passed its password with a TOTP code and issues its tokens,
+// whose amr claim records both factors. The MFA token is single-use.
+// Wrong codes count as failed logins of the email, and a challenge is discarded after too many of them.
+func (s *Service) LoginWithMFA(ctx context.Context, mfaToken, code, userAgent, ipAddress string) (*LoginResult, error) {
+	challenge, err := s.completeMFAChallenge(ctx, "", model.DeviceDecision{}, mfaToken, code, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, "", challenge.MemberID, challenge.Grant, userAgent, ipAddress)
+}
+
+// completeMFAChallenge verifies the TOTP code of an MFA challenge started for clientID and device, and deletes the
+// challenge. The grant of the returned challenge records both factors.
+func (s *Service) completeMFAChallenge(ctx context.Context, clientID string, device model.DeviceDecision, mfaToken, code, ipAddress string) (*model.MFAChallenge, error) {
+	challenge, err := s.mfaRepo.GetMFAChallenge(ctx, hashMFAChallengeToken(mfaToken))
+	if errors.Is(err, repository.ErrMFAChallengeNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get MFA challenge: %w", err)
+	}
+	if challenge.IsExpired(time.Now()) || challenge.ClientID != clientID || challenge.Device != device {
+		return nil, ErrInvalidMFAToken
+	}
+
+	retryAfter, err := s.loginLimiter.LoginRetryAfter(ctx, challenge.Email, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("check login limiter: %w", err)
+	}
+	if retryAfter > 0 {
+		s.recordLoginFailure(ctx, clientID, challenge.MemberID, model.AuthMethodOTP, ipAddress, model.AuditReasonThrottled)
+		return nil, &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	credential, err := s.mfaRepo.GetTOTPCredential(ctx, challenge.MemberID)
+	if errors.Is(err, repository.ErrTOTPCredentialNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get TOTP credential: %w", err)
+	}
+
+	err = s.verifyTOTP(ctx, credential, code)
+	if errors.Is(err, ErrInvalidOTP) {
+		s.recordLoginFailure(ctx, clientID, challenge.MemberID, model.AuthMethodOTP, ipAddress, model.AuditReasonInvalidOTP)
+		if err := s.recordMFAFailure(ctx, challenge, ipAddress); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidOTP
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the completion that deletes the challenge issues tokens.
+	deleted, err := s.mfaRepo.DeleteMFAChallenge(ctx, challenge.TokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("delete MFA challenge: %w", err)
+	}
+	if !deleted {
+		return nil, ErrInvalidMFAToken
+	}
+	if err := s.loginLimiter.ResetLoginFailures(ctx, challenge.Email); err != nil {
+		return nil, fmt.Errorf("reset login failures: %w", err)
+	}
+
+	challenge.Grant.AuthMethods = append(slices.Clone(challenge.Grant.AuthMethods), model.AuthMethodOTP)
+	return challenge, nil
+}
+
+// isMFARequired reports whether a member has enabled a second factor.
+func (s *Service) isMFARequired(ctx context.Context, memberID string) (bool, error) {
+	credential, err := s.mfaRepo.GetTOTPCredential(ctx, memberID)
+	if errors.Is(err, repository.ErrTOTPCredentialNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get TOTP credential: %w", err)
+	}
+	return credential.IsConfirmed(), nil
+}
+
+// startMFAChallenge saves a login that passed its password until its second factor is verified.
+// A challenge started for an OAuth client can only be completed for the same client.
+func (s *Service) startMFAChallenge(ctx context.Context, clientID string, device model.DeviceDecision, memberID, email string, grant model.AccessTokenGrant) (*MFAChallengeResult, error) {
+	mfaToken, err := newMFAChallengeToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	challenge := &model.MFAChallenge{
+		TokenHash: hashMFAChallengeToken(mfaToken),
+		MemberID:  memberID,
+		Email:     email,
+		ClientID:  clientID,
+		Device:    device,
+		Grant:     grant,
+		CreatedAt: now,
+		ExpiresAt: now.Add(mfaChallengeTTL),
+	}
+	if err := s.mfaRepo.SaveMFAChallenge(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("save MFA challenge: %w", err)
+	}
+
+	return &MFAChallengeResult{
+		Token:     mfaToken,
+		Methods:   []string{model.AuthMethodOTP},
+		ExpiresAt: challenge.ExpiresAt,
+	}, nil
+}
+
+// recordMFAFailure counts a wrong code against the challenge and as a failed login of its email,
+// so that the second factor cannot be brute forced by restarting logins with a known password.
+func (s *Service) recordMFAFailure(ctx context.Context, challenge *model.MFAChallenge, ipAddress string) error {
+	if err := s.loginLimiter.RecordLoginFailure(ctx, challenge.Email, ipAddress); err != nil {
+		return fmt.Errorf("record login failure: %w", err)
+	}
+	failures, err := s.mfaRepo.RecordMFAChallengeFailure(ctx, challenge)
+	if err != nil {
+		return fmt.Errorf("record MFA challenge failure: %w", err)
+	}
+	if failures >= maxMFAChallengeFailures {
+		if _, err := s.mfaRepo.DeleteMFAChallenge(ctx, challenge.TokenHash); err != nil {
+			return fmt.Errorf("delete MFA challenge: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyTOTP checks a code against the secret of a TOTP credential and consumes its time step,
+// so that a code cannot be replayed.
+func (s *Service) verifyTOTP(ctx context.Context, credential *model.TOTPCredential, code string) error {
+	secret, err := s.secretCipher.Decrypt(credential.EncryptedSecret, []byte(credential.MemberID))
+	if err != nil {
+		return fmt.Errorf("decrypt TOTP secret: %w", err)
+	}
+
+	step, ok := s.totp.Validate(secret, code, time.Now())
+	if !ok {
+		return ErrInvalidOTP
+	}
+	unused, err := s.mfaRepo.UseTOTPStep(ctx, credential.MemberID, step, totpUsedStepTTL)
+	if err != nil {
+		return fmt.Errorf("use TOTP step: %w", err)
+	}
+	if !unused {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+// newMFAChallengeToken generates a URL-safe random MFA challenge token.
+func newMFAChallengeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashMFAChallengeToken hashes an MFA challenge token for storage.
+// The token is random and short-lived, so an unkeyed hash is enough to keep it out of storage.
+func hashMFAChallengeToken(mfaToken string) model.MFAChallengeHash {
+	sum := sha256.Sum256([]byte(mfaToken))
+	return model.MFAChallengeHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,221 @@
+package authservice_test
+
+import (
+	"context"
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// mfaFixture is a service whose member can enroll TOTP and log in with it.
+type mfaFixture struct {
+	svc         *authservice.Service
+	accessMock  *MockAccessTokenMaker
+	refreshRepo *memoryrepo.RefreshTokenRepository
+	mfaRepo     *memoryrepo.MFARepository
+}
+
+func newMFAFixture(user *usermodel.User) *mfaFixture {
+	accessMock := new(MockAccessTokenMaker)
+	accessMock.On("ParseToken", "access-token").Return(claimsOf(user.Email), nil)
+	accessMock.On("CreateToken", user.Email, mock.Anything).Return(model.AccessToken("access-token"), claimsOf(user.Email), nil)
+
+	refreshMock := new(MockRefreshTokenMaker)
+	refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token"), nil).Once()
+	refreshMock.On("MaxAge").Return(3600)
+	refreshMock.On("RefreshEndPoint").Return("/refresh")
+
+	userGateway := new(MockUserGateway)
+	userGateway.On("VerifyCredentials", mock.Anything, user.Email, "password").Return(user, nil)
+
+	refreshRepo := memoryrepo.NewRefreshTokenRepository()
+	mfaRepo := memoryrepo.NewMFARepository()
+	svc := authservice.New(authservice.Deps{
+		AccessToken:       accessMock,
+		RefreshToken:      refreshMock,
+		RefreshHasher:     testHasher,
+		RefreshTokenRepo:  refreshRepo,
+		Denylist:          memoryrepo.NewAccessTokenDenylist(),
+		LoginLimiter:      newLoginLimiter(),
+		MFARepo:           mfaRepo,
+		TOTP:              testTOTP,
+		SecretCipher:      testCipher,
+		PasskeyRepo:       memoryrepo.NewPasskeyRepository(),
+		Passkeys:          testRelyingParty,
+		Clients:           testClients,
+		OAuthRepo:         memoryrepo.NewOAuthRepository(),
+		IDToken:           new(MockIDTokenMaker),
+		UserGateway:       userGateway,
+		PasswordResets:    memoryrepo.NewPasswordResetRepository(),
+		Mailer:            mailer.NewOutbox(),
+		Links:             testLinks,
+		Verifications:     memoryrepo.NewEmailVerificationRepository(),
+		LinkSigner:        testLinkSigner,
+		Passwordless:      memoryrepo.NewPasswordlessRepository(),
+		Notifier:          notifier.NewOutbox(),
+		FederatedLogins:   memoryrepo.NewFederatedLoginRepository(),
+		IdentityProviders: testIdentityProviders,
+		Audit:             audit.NewMemorySink(),
+	})
+	return &mfaFixture{svc: svc, accessMock: accessMock, refreshRepo: refreshRepo, mfaRepo: mfaRepo}
+}
+
+// enableTOTP enrolls and confirms TOTP for the member and returns its secret.
+// The code of the current time step is used up by the confirmation.
+func (f *mfaFixture) enableTOTP(t *testing.T) []byte {
+	t.Helper()
+	ctx := context.Background()
+	enrollment, err := f.svc.EnrollTOTP(ctx, "access-token")
+	require.NoError(t, err)
+	secret := decodeTOTPSecret(t, enrollment.Secret)
+	require.NoError(t, f.svc.ConfirmTOTP(ctx, "access-token", token.TOTPCode(secret, time.Now())))
+	return secret
+}
+
+func decodeTOTPSecret(t *testing.T, encoded string) []byte {
+	t.Helper()
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	require.NoError(t, err)
+	return secret
+}
+
+// nextTOTPCode returns the code of the next time step, which is accepted but not used up yet.
+func nextTOTPCode(secret []byte) string {
+	return token.TOTPCode(secret, time.Now().Add(30*time.Second))
+}
+
+// TestUnitTOTPEnrollment tests that TOTP is enrolled with an encrypted secret and only enabled once confirmed.
+func TestUnitTOTPEnrollment(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com"}
+	f := newMFAFixture(user)
+
+	err := f.svc.ConfirmTOTP(ctx, "access-token", "123456")
+	assert.ErrorIs(t, err, authservice.ErrTOTPNotEnrolled)
+
+	first, err := f.svc.EnrollTOTP(ctx, "access-token")
+	require.NoError(t, err)
+	enrollment, err := f.svc.EnrollTOTP(ctx, "access-token")
+	require.NoError(t, err, "an unconfirmed enrollment can be restarted")
+	assert.NotEqual(t, first.Secret, enrollment.Secret)
+
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "/test-issuer:"+user.Email, uri.Path)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+
+	credential, err := f.mfaRepo.GetTOTPCredential(ctx, user.Email)
+	require.NoError(t, err)
+	assert.False(t, credential.IsConfirmed())
+	assert.NotContains(t, credential.EncryptedSecret, enrollment.Secret, "the secret must be stored encrypted")
+
+	secret := decodeTOTPSecret(t, enrollment.Secret)
+	err = f.svc.ConfirmTOTP(ctx, "access-token", token.TOTPCode(decodeTOTPSecret(t, first.Secret), time.Now()))
+	assert.ErrorIs(t, err, authservice.ErrInvalidOTP, "the replaced secret must no longer be accepted")
+
+	require.NoError(t, f.svc.ConfirmTOTP(ctx, "access-token", token.TOTPCode(secret, time.Now())))
+	credential, err = f.mfaRepo.GetTOTPCredential(ctx, user.Email)
+	require.NoError(t, err)
+	assert.True(t, credential.IsConfirmed())
+
+	_, err = f.svc.EnrollTOTP(ctx, "access-token")
+	assert.ErrorIs(t, err, authservice.ErrTOTPAlreadyEnabled)
+	err = f.svc.ConfirmTOTP(ctx, "access-token", nextTOTPCode(secret))
+	assert.ErrorIs(t, err, authservice.ErrTOTPAlreadyEnabled)
+}
+
+// TestUnitLoginWithMFA tests the two-step login of a member with TOTP enabled.
+func TestUnitLoginWithMFA(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"order:read"}}
+
+	t.Run("password alone does not issue tokens", func(t *testing.T) {
+		f := newMFAFixture(user)
+		f.enableTOTP(t)
+		f.accessMock.Calls = nil
+
+		result, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "agent", "ip")
+		require.NoError(t, err)
+		require.NotNil(t, result.MFAChallenge)
+		assert.NotEmpty(t, result.MFAChallenge.Token)
+		assert.Equal(t, []string{model.AuthMethodOTP}, result.MFAChallenge.Methods)
+		assert.WithinDuration(t, time.Now().Add(5*time.Minute), result.MFAChallenge.ExpiresAt, time.Second)
+		assert.Empty(t, result.AccessToken)
+		assert.Empty(t, result.RefreshToken)
+		f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+	})
+
+	t.Run("code completes the login with both methods", func(t *testing.T) {
+		f := newMFAFixture(user)
+		secret := f.enableTOTP(t)
+
+		challenge, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "agent", "ip")
+		require.NoError(t, err)
+
+		result, err := f.svc.LoginWithMFA(ctx, challenge.MFAChallenge.Token, nextTOTPCode(secret), "agent-2", "ip-2")
+		require.NoError(t, err)
+		assert.Nil(t, result.MFAChallenge)
+		assert.Equal(t, model.AccessToken("access-token"), result.AccessToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), result.RefreshToken)
+		assert.Equal(t, user.Scopes, result.Scopes)
+
+		wantGrant := model.AccessTokenGrant{Scopes: user.Scopes, AuthMethods: []string{model.AuthMethodPassword, model.AuthMethodOTP}, EmailVerified: &user.EmailVerified}
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, wantGrant)
+		session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+		require.NoError(t, err)
+		assert.Equal(t, wantGrant, session.Grant, "refreshed access tokens must keep the amr")
+		assert.Equal(t, "agent-2", session.UserAgent)
+		assert.Equal(t, "ip-2", session.IPAddress)
+
+		_, err = f.svc.LoginWithMFA(ctx, challenge.MFAChallenge.Token, token.TOTPCode(secret, time.Now().Add(-30*time.Second)), "agent", "ip")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken, "the MFA token must be single-use")
+	})
+
+	t.Run("codes cannot be replayed", func(t *testing.T) {
+		f := newMFAFixture(user)
+		secret := f.enableTOTP(t)
+
+		challenge, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "agent", "ip")
+		require.NoError(t, err)
+		_, err = f.svc.LoginWithMFA(ctx, challenge.MFAChallenge.Token, token.TOTPCode(secret, time.Now()), "agent", "ip")
+		assert.ErrorIs(t, err, authservice.ErrInvalidOTP, "the code used to confirm the enrollment must not log in")
+	})
+
+	t.Run("unknown MFA token", func(t *testing.T) {
+		f := newMFAFixture(user)
+		secret := f.enableTOTP(t)
+
+		_, err := f.svc.LoginWithMFA(ctx, "unknown", nextTOTPCode(secret), "agent", "ip")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken)
+	})
+
+	t.Run("wrong codes throttle the email", func(t *testing.T) {
+		f := newMFAFixture(user)
+		secret := f.enableTOTP(t)
+
+		challenge, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "agent", "ip")
+		require.NoError(t, err)
+		for range testLoginPolicy.MaxFailures {
+			_, err = f.svc.LoginWithMFA(ctx, challenge.MFAChallenge.Token, "abcdef", "agent", "ip")
+			assert.ErrorIs(t, err, authservice.ErrInvalidOTP)
+		}
+
+		_, err = f.svc.LoginWithMFA(ctx, challenge.MFAChallenge.Token, nextTOTPCode(secret), "agent", "ip")
+		assert.ErrorIs(t, err, authservice.ErrTooManyLoginAttempts)
+		_, err = f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "agent", "ip")
+		assert.ErrorIs(t, err, authservice.ErrTooManyLoginAttempts, "a known password must not restart the challenge")
+	})
+}
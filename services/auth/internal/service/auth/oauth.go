@@ -0,0 +1,331 @@
+// Package authservice defines the OAuth 2.0 authorization code and client credentials flows of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// authorizationCodeTTL is how long an authorization code can be exchanged; the client redeems it right away.
+	authorizationCodeTTL = time.Minute
+	// responseTypeCode is the response type of the authorization code flow.
+	responseTypeCode = "code"
+)
+
+var (
+	// codeChallengePattern matches an S256 code challenge, the base64url encoded SHA-256 of the verifier.
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+	// codeVerifierPattern matches a code verifier (RFC 7636 section 4.1).
+	codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+)
+
+// ClientRegistry is the interface for the registry of OAuth clients.
+type ClientRegistry interface {
+	GetClient(ctx context.Context, clientID string) (*model.OAuthClient, error)
+}
+
+// OAuthRepository is the interface for the repository of authorization codes waiting to be exchanged and
+// device authorizations waiting to be approved.
+type OAuthRepository interface {
+	SaveAuthorizationCode(ctx context.Context, code *model.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash model.AuthorizationCodeHash) (*model.AuthorizationCode, error)
+	SaveDeviceAuthorization(ctx context.Context, authorization *model.DeviceAuthorization) error
+	GetDeviceAuthorization(ctx context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error)
+	GetDeviceAuthorizationByUserCode(ctx context.Context, userCode string) (*model.DeviceAuthorization, error)
+	UpdateDeviceAuthorization(ctx context.Context, authorization *model.DeviceAuthorization) error
+	ConsumeDeviceAuthorization(ctx context.Context, deviceCodeHash model.DeviceCodeHash) (*model.DeviceAuthorization, error)
+	RecordDevicePoll(ctx context.Context, deviceCodeHash model.DeviceCodeHash, interval time.Duration) (bool, error)
+}
+
+// ValidateAuthorizationRequest checks an authorization request and returns its client.
+// Errors wrapping ErrInvalidClient or ErrInvalidRedirectURI must be shown to the user rather than redirected
+// to the client (RFC 6749 section 4.1.2.1). PKCE with S256 is mandatory.
+func (s *Service) ValidateAuthorizationRequest(ctx context.Context, req *model.AuthorizationRequest) (*model.OAuthClient, error) {
+	client, err := s.getClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+	if req.ResponseType != responseTypeCode {
+		return nil, ErrUnsupportedResponseType
+	}
+	if req.CodeChallengeMethod != model.CodeChallengeMethodS256 {
+		return nil, fmt.Errorf("%w: code_challenge_method must be S256", ErrInvalidAuthorizationRequest)
+	}
+	if !codeChallengePattern.MatchString(req.CodeChallenge) {
+		return nil, fmt.Errorf("%w: code_challenge is missing or malformed", ErrInvalidAuthorizationRequest)
+	}
+	if _, err := grantedScopes(apiScopes(req.Scopes), client.Scopes); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// AuthorizeWithPassword logs a member in with email and password on behalf of a client and issues an
+// authorization code for the request. The access token is narrowed to the scopes both the user and the client are
+// allowed, plus the OpenID Connect scopes requested. As with LoginWithEmailAndPassword, failed logins are
+// throttled, and a member who has enabled a second factor only gets an MFA challenge that AuthorizeWithMFA
+// completes.
+func (s *Service) AuthorizeWithPassword(ctx context.Context, req *model.AuthorizationRequest, email, password, ipAddress string) (*AuthorizationResult, error) {
+	client, err := s.ValidateAuthorizationRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	memberID, grant, challenge, err := s.loginForClient(ctx, client, model.DeviceDecision{}, req.Scopes, email, password, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthorizationResult{MFAChallenge: challenge}, nil
+	}
+	return s.issueAuthorizationCode(ctx, req, memberID, grant)
+}
+
+// loginForClient logs a member in with email and password on behalf of a client, and returns the member ID and
+// the grant of the client: the requested scopes both the user and the client are allowed, plus the OpenID
+// Connect scopes requested. When the member has enabled a second factor, only an MFA challenge bound to the
+// client, and to the device decision the login is for if any, is returned.
+func (s *Service) loginForClient(ctx context.Context, client *model.OAuthClient, device model.DeviceDecision, requestedScopes []string, email, password, ipAddress string) (string, model.AccessTokenGrant, *MFAChallengeResult, error) {
+	user, err := s.verifyPassword(ctx, client.ID, email, password, ipAddress)
+	if err != nil {
+		return "", model.AccessTokenGrant{}, nil, err
+	}
+	memberID := user.Email
+
+	scopes, err := grantedScopes(apiScopes(requestedScopes), clientScopes(user.Scopes, client))
+	if err != nil {
+		return "", model.AccessTokenGrant{}, nil, err
+	}
+	grant := model.AccessTokenGrant{
+		Scopes:        append(oidcScopes(requestedScopes), scopes...),
+		Roles:         user.Roles,
+		Audiences:     user.Audiences,
+		AuthMethods:   []string{model.AuthMethodPassword},
+		ClientID:      client.ID,
+		EmailVerified: &user.EmailVerified,
+	}
+
+	throttledEmail := normalizeEmail(email)
+	mfaRequired, err := s.isMFARequired(ctx, memberID)
+	if err != nil {
+		return "", model.AccessTokenGrant{}, nil, err
+	}
+	if mfaRequired {
+		challenge, err := s.startMFAChallenge(ctx, client.ID, device, memberID, throttledEmail, grant)
+		if err != nil {
+			return "", model.AccessTokenGrant{}, nil, err
+		}
+		return memberID, grant, challenge, nil
+	}
+
+	if err := s.loginLimiter.ResetLoginFailures(ctx, throttledEmail); err != nil {
+		return "", model.AccessTokenGrant{}, nil, fmt.Errorf("reset login failures: %w", err)
+	}
+	return memberID, grant, nil, nil
+}
+
+// AuthorizeWithMFA completes with a TOTP code an authorization that passed its password and issues its
+// authorization code. Only challenges started by AuthorizeWithPassword for the same client are accepted.
+func (s *Service) AuthorizeWithMFA(ctx context.Context, req *model.AuthorizationRequest, mfaToken, code, ipAddress string) (*AuthorizationResult, error) {
+	client, err := s.ValidateAuthorizationRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.completeMFAChallenge(ctx, client.ID, model.DeviceDecision{}, mfaToken, code, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueAuthorizationCode(ctx, req, challenge.MemberID, challenge.Grant)
+}
+
+// ExchangeAuthorizationCode exchanges an authorization code for an access token and a refresh token of the client.
+// The code is single-use, bound to the client and redirect URI it was issued for, and only redeemed with the
+// PKCE code verifier of its challenge. Confidential clients must also authenticate with their secret.
+// When the openid scope was granted, an ID token is issued as well.
+func (s *Service) ExchangeAuthorizationCode(ctx context.Context, clientID, clientSecret, code, redirectURI, codeVerifier, userAgent, ipAddress string) (*LoginResult, error) {
+	if _, err := s.authenticateClient(ctx, clientID, clientSecret); err != nil {
+		return nil, err
+	}
+
+	authorizationCode, err := s.oauthRepo.ConsumeAuthorizationCode(ctx, hashAuthorizationCode(code))
+	if errors.Is(err, repository.ErrAuthorizationCodeNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume authorization code: %w", err)
+	}
+	if authorizationCode.IsExpired(time.Now()) || authorizationCode.ClientID != clientID {
+		return nil, ErrInvalidGrant
+	}
+	if authorizationCode.RedirectURI != redirectURI {
+		return nil, fmt.Errorf("%w: redirect_uri does not match the authorization request", ErrInvalidGrant)
+	}
+	if !verifyCodeChallenge(codeVerifier, authorizationCode.CodeChallenge) {
+		return nil, fmt.Errorf("%w: code_verifier does not match the code challenge", ErrInvalidGrant)
+	}
+
+	var idToken model.IDToken
+	if slices.Contains(authorizationCode.Grant.Scopes, model.ScopeOpenID) {
+		if idToken, err = s.issueIDToken(ctx, authorizationCode); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := s.startSession(ctx, clientID, authorizationCode.MemberID, authorizationCode.Grant, userAgent, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	res.IDToken = idToken
+	return res, nil
+}
+
+// RefreshClientToken exchanges a refresh token issued to a client for a new access token and a rotated
+// refresh token, as Refresh does for first-party sessions.
+func (s *Service) RefreshClientToken(ctx context.Context, clientID, clientSecret string, refreshToken model.RefreshToken, userAgent, ipAddress string) (*LoginResult, error) {
+	if _, err := s.authenticateClient(ctx, clientID, clientSecret); err != nil {
+		return nil, err
+	}
+	return s.refresh(ctx, clientID, refreshToken, userAgent, ipAddress)
+}
+
+// IssueClientToken issues a confidential client an access token of its own (the client credentials grant,
+// RFC 6749 section 4.4). The token's subject is the client ID, its scopes and audiences are narrowed to those
+// requested, and no refresh token is issued: the client authenticates again when the token expires.
+func (s *Service) IssueClientToken(ctx context.Context, clientID, clientSecret string, scopes, audiences []string) (*LoginResult, error) {
+	client, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsConfidential() {
+		return nil, ErrUnauthorizedClient
+	}
+
+	scopes, err = grantedScopes(scopes, client.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	// Audiences are narrowed as scopes are.
+	audiences, err = grantedScopes(audiences, client.Audiences)
+	if errors.Is(err, ErrInvalidScope) {
+		return nil, ErrInvalidTarget
+	}
+	grant := model.AccessTokenGrant{
+		Scopes:    scopes,
+		Audiences: audiences,
+		ClientID:  client.ID,
+	}
+
+	accessToken, claims, err := s.issueAccessToken(ctx, client.ID, grant)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{
+		AccessToken:     accessToken,
+		AccessExpiresAt: claims.ExpiresAt,
+		TokenType:       tokenTypeOf(claims),
+		Scopes:          claims.Scopes,
+	}, nil
+}
+
+// authenticateClient gets a registered client and checks its secret. Public clients must not send one;
+// confidential clients must send theirs, compared by hash in constant time.
+func (s *Service) authenticateClient(ctx context.Context, clientID, clientSecret string) (*model.OAuthClient, error) {
+	client, err := s.getClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsConfidential() {
+		if clientSecret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+	secretHash, err := hex.DecodeString(client.SecretHash)
+	if err != nil {
+		return nil, fmt.Errorf("decode secret hash of client %s: %w", client.ID, err)
+	}
+	sum := sha256.Sum256([]byte(clientSecret))
+	if subtle.ConstantTimeCompare(sum[:], secretHash) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// getClient gets a registered client.
+func (s *Service) getClient(ctx context.Context, clientID string) (*model.OAuthClient, error) {
+	client, err := s.clients.GetClient(ctx, clientID)
+	if errors.Is(err, repository.ErrOAuthClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get OAuth client: %w", err)
+	}
+	return client, nil
+}
+
+// issueAuthorizationCode saves the grant of an authorized request under a new random authorization code.
+func (s *Service) issueAuthorizationCode(ctx context.Context, req *model.AuthorizationRequest, memberID string, grant model.AccessTokenGrant) (*AuthorizationResult, error) {
+	b, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	authorizationCode := &model.AuthorizationCode{
+		CodeHash:      hashAuthorizationCode(code),
+		ClientID:      req.ClientID,
+		RedirectURI:   req.RedirectURI,
+		CodeChallenge: req.CodeChallenge,
+		MemberID:      memberID,
+		Grant:         grant,
+		Nonce:         req.Nonce,
+		AuthTime:      now,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+	}
+	if err := s.oauthRepo.SaveAuthorizationCode(ctx, authorizationCode); err != nil {
+		return nil, fmt.Errorf("save authorization code: %w", err)
+	}
+
+	return &AuthorizationResult{Code: code, ExpiresAt: authorizationCode.ExpiresAt}, nil
+}
+
+// clientScopes returns the scopes of a user that a client may be granted.
+func clientScopes(userScopes []string, client *model.OAuthClient) []string {
+	return slices.DeleteFunc(slices.Clone(userScopes), func(scope string) bool {
+		return !slices.Contains(client.Scopes, scope)
+	})
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against its S256 code challenge in constant time.
+func verifyCodeChallenge(codeVerifier, codeChallenge string) bool {
+	if !codeVerifierPattern.MatchString(codeVerifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(codeVerifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(codeChallenge)) == 1
+}
+
+// hashAuthorizationCode hashes an authorization code for storage.
+func hashAuthorizationCode(code string) model.AuthorizationCodeHash {
+	sum := sha256.Sum256([]byte(code))
+	return model.AuthorizationCodeHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
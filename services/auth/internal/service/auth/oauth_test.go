@@ -0,0 +1,484 @@
+package authservice_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// PKCE example of RFC 7636 appendix B.
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// newAuthorizationRequest returns a valid authorization request of the test client.
+func newAuthorizationRequest() *model.AuthorizationRequest {
+	return &model.AuthorizationRequest{
+		ClientID:            "test-client",
+		RedirectURI:         "https://client.example.com/callback",
+		ResponseType:        "code",
+		State:               "xyz",
+		CodeChallenge:       testCodeChallenge,
+		CodeChallengeMethod: model.CodeChallengeMethodS256,
+	}
+}
+
+// oauthFixture is a service whose member can authorize the test clients.
+type oauthFixture struct {
+	svc         *authservice.Service
+	accessMock  *MockAccessTokenMaker
+	idTokenMock *MockIDTokenMaker
+	userGateway *MockUserGateway
+	refreshRepo *memoryrepo.RefreshTokenRepository
+	outbox      *mailer.Outbox
+	logins      *notifier.Outbox
+	audit       *audit.MemorySink
+	mfa         *mfaFixture // enables TOTP for the member
+}
+
+func newOAuthFixture(user *usermodel.User) *oauthFixture {
+	accessMock := new(MockAccessTokenMaker)
+	accessMock.On("ParseToken", "access-token").Return(claimsOf(user.Email), nil)
+	accessMock.On("CreateToken", user.Email, mock.Anything).Return(model.AccessToken("access-token"), claimsOf(user.Email), nil)
+
+	refreshMock := new(MockRefreshTokenMaker)
+	refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token"), nil).Once()
+	refreshMock.On("CreateToken").Return(model.RefreshToken("rotated-refresh-token"), nil).Once()
+	refreshMock.On("MaxAge").Return(3600)
+	refreshMock.On("RefreshEndPoint").Return("/refresh")
+
+	userGateway := new(MockUserGateway)
+	userGateway.On("VerifyCredentials", mock.Anything, user.Email, "password").Return(user, nil)
+	userGateway.On("VerifyCredentials", mock.Anything, user.Email, "wrong").Return(nil, gateway.ErrInvalidCredentials)
+
+	idTokenMock := new(MockIDTokenMaker)
+	idTokenMock.On("CreateIDToken", mock.Anything).Return(model.IDToken("id-token"), nil)
+
+	refreshRepo := memoryrepo.NewRefreshTokenRepository()
+	mfaRepo := memoryrepo.NewMFARepository()
+	outbox := mailer.NewOutbox()
+	logins := notifier.NewOutbox()
+	auditSink := audit.NewMemorySink()
+	svc := authservice.New(authservice.Deps{
+		AccessToken:       accessMock,
+		RefreshToken:      refreshMock,
+		RefreshHasher:     testHasher,
+		RefreshTokenRepo:  refreshRepo,
+		Denylist:          memoryrepo.NewAccessTokenDenylist(),
+		LoginLimiter:      newLoginLimiter(),
+		MFARepo:           mfaRepo,
+		TOTP:              testTOTP,
+		SecretCipher:      testCipher,
+		PasskeyRepo:       memoryrepo.NewPasskeyRepository(),
+		Passkeys:          testRelyingParty,
+		Clients:           testClients,
+		OAuthRepo:         memoryrepo.NewOAuthRepository(),
+		IDToken:           idTokenMock,
+		UserGateway:       userGateway,
+		PasswordResets:    memoryrepo.NewPasswordResetRepository(),
+		Mailer:            outbox,
+		Links:             testLinks,
+		Verifications:     memoryrepo.NewEmailVerificationRepository(),
+		LinkSigner:        testLinkSigner,
+		Passwordless:      memoryrepo.NewPasswordlessRepository(),
+		Notifier:          logins,
+		FederatedLogins:   memoryrepo.NewFederatedLoginRepository(),
+		IdentityProviders: testIdentityProviders,
+		Audit:             auditSink,
+	})
+	return &oauthFixture{
+		svc:         svc,
+		accessMock:  accessMock,
+		idTokenMock: idTokenMock,
+		userGateway: userGateway,
+		refreshRepo: refreshRepo,
+		outbox:      outbox,
+		logins:      logins,
+		audit:       auditSink,
+		mfa:         &mfaFixture{svc: svc, accessMock: accessMock, refreshRepo: refreshRepo, mfaRepo: mfaRepo},
+	}
+}
+
+// authorize authorizes req with the password of the member and returns the authorization code.
+func (f *oauthFixture) authorize(t *testing.T, req *model.AuthorizationRequest) string {
+	t.Helper()
+	res, err := f.svc.AuthorizeWithPassword(context.Background(), req, "user@example.com", "password", "1.2.3.4")
+	require.NoError(t, err)
+	require.Nil(t, res.MFAChallenge)
+	require.NotEmpty(t, res.Code)
+	return res.Code
+}
+
+// TestUnitValidateAuthorizationRequest tests that authorization requests need a registered client and
+// redirect URI, the code response type and an S256 PKCE code challenge.
+func TestUnitValidateAuthorizationRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(req *model.AuthorizationRequest)
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			modify: func(req *model.AuthorizationRequest) {},
+		},
+		{
+			name:    "unknown client",
+			modify:  func(req *model.AuthorizationRequest) { req.ClientID = "unknown" },
+			wantErr: authservice.ErrInvalidClient,
+		},
+		{
+			name:    "unregistered redirect URI",
+			modify:  func(req *model.AuthorizationRequest) { req.RedirectURI = "https://client.example.com/callback/evil" },
+			wantErr: authservice.ErrInvalidRedirectURI,
+		},
+		{
+			name:    "redirect URI of another client",
+			modify:  func(req *model.AuthorizationRequest) { req.RedirectURI = "https://other.example.com/callback" },
+			wantErr: authservice.ErrInvalidRedirectURI,
+		},
+		{
+			name:    "implicit flow",
+			modify:  func(req *model.AuthorizationRequest) { req.ResponseType = "token" },
+			wantErr: authservice.ErrUnsupportedResponseType,
+		},
+		{
+			name:    "missing code challenge",
+			modify:  func(req *model.AuthorizationRequest) { req.CodeChallenge, req.CodeChallengeMethod = "", "" },
+			wantErr: authservice.ErrInvalidAuthorizationRequest,
+		},
+		{
+			name: "plain code challenge",
+			modify: func(req *model.AuthorizationRequest) {
+				req.CodeChallenge, req.CodeChallengeMethod = testCodeVerifier, "plain"
+			},
+			wantErr: authservice.ErrInvalidAuthorizationRequest,
+		},
+		{
+			name:    "malformed code challenge",
+			modify:  func(req *model.AuthorizationRequest) { req.CodeChallenge = "too-short" },
+			wantErr: authservice.ErrInvalidAuthorizationRequest,
+		},
+		{
+			name:    "scope the client is not allowed",
+			modify:  func(req *model.AuthorizationRequest) { req.Scopes = []string{"admin"} },
+			wantErr: authservice.ErrInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(&usermodel.User{Email: "user@example.com"})
+			req := newAuthorizationRequest()
+			tt.modify(req)
+
+			client, err := f.svc.ValidateAuthorizationRequest(context.Background(), req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "Test Client", client.Name)
+		})
+	}
+}
+
+// TestUnitAuthorizationCodeFlow tests that authorization codes are exchanged once, by their client,
+// with the redirect URI and code verifier of their request.
+func TestUnitAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{
+		ID:        "123",
+		Email:     "user@example.com",
+		Scopes:    []string{"user:read", "admin"},
+		Roles:     []string{"member"},
+		Audiences: []string{"user-api"},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		code := f.authorize(t, newAuthorizationRequest())
+
+		res, err := f.svc.ExchangeAuthorizationCode(ctx, "test-client", "", code, "https://client.example.com/callback", testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+		assert.False(t, res.AccessExpiresAt.IsZero())
+		assert.Equal(t, []string{"user:read"}, res.Scopes, "scopes are narrowed to those of the client")
+
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        []string{"user:read"},
+			Roles:         user.Roles,
+			Audiences:     user.Audiences,
+			AuthMethods:   []string{model.AuthMethodPassword},
+			ClientID:      "test-client",
+			EmailVerified: &user.EmailVerified,
+		})
+		session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+		require.NoError(t, err)
+		assert.Equal(t, "test-client", session.ClientID)
+	})
+
+	t.Run("code is single use", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		code := f.authorize(t, newAuthorizationRequest())
+		_, err := f.svc.ExchangeAuthorizationCode(ctx, "test-client", "", code, "https://client.example.com/callback", testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		_, err = f.svc.ExchangeAuthorizationCode(ctx, "test-client", "", code, "https://client.example.com/callback", testCodeVerifier, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidGrant)
+	})
+
+	tests := []struct {
+		name         string
+		clientID     string
+		redirectURI  string
+		codeVerifier string
+		wantErr      error
+	}{
+		{
+			name:         "wrong code verifier",
+			clientID:     "test-client",
+			redirectURI:  "https://client.example.com/callback",
+			codeVerifier: "wrong-verifier-wrong-verifier-wrong-verifier",
+			wantErr:      authservice.ErrInvalidGrant,
+		},
+		{
+			name:         "missing code verifier",
+			clientID:     "test-client",
+			redirectURI:  "https://client.example.com/callback",
+			codeVerifier: "",
+			wantErr:      authservice.ErrInvalidGrant,
+		},
+		{
+			name:         "other redirect URI",
+			clientID:     "test-client",
+			redirectURI:  "https://client.example.com/other",
+			codeVerifier: testCodeVerifier,
+			wantErr:      authservice.ErrInvalidGrant,
+		},
+		{
+			name:         "other client",
+			clientID:     "other-client",
+			redirectURI:  "https://client.example.com/callback",
+			codeVerifier: testCodeVerifier,
+			wantErr:      authservice.ErrInvalidGrant,
+		},
+		{
+			name:         "unknown client",
+			clientID:     "unknown",
+			redirectURI:  "https://client.example.com/callback",
+			codeVerifier: testCodeVerifier,
+			wantErr:      authservice.ErrInvalidClient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			code := f.authorize(t, newAuthorizationRequest())
+
+			_, err := f.svc.ExchangeAuthorizationCode(ctx, tt.clientID, "", code, tt.redirectURI, tt.codeVerifier, "ua", "1.2.3.4")
+			assert.ErrorIs(t, err, tt.wantErr)
+			f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		_, err := f.svc.AuthorizeWithPassword(ctx, newAuthorizationRequest(), user.Email, "wrong", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidCredentials)
+	})
+
+	t.Run("invalid request is checked before the password", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		req := newAuthorizationRequest()
+		req.CodeChallenge = ""
+		_, err := f.svc.AuthorizeWithPassword(ctx, req, user.Email, "password", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidAuthorizationRequest)
+	})
+}
+
+// TestUnitAuthorizeWithMFA tests that members with a second factor complete authorizations with it.
+func TestUnitAuthorizeWithMFA(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}}
+
+	t.Run("success", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		req := newAuthorizationRequest()
+
+		res, err := f.svc.AuthorizeWithPassword(ctx, req, user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, res.MFAChallenge)
+		assert.Empty(t, res.Code)
+
+		res, err = f.svc.AuthorizeWithMFA(ctx, req, res.MFAChallenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		require.NoError(t, err)
+		require.NotEmpty(t, res.Code)
+
+		_, err = f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", res.Code, req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        []string{"user:read"},
+			AuthMethods:   []string{model.AuthMethodPassword, model.AuthMethodOTP},
+			ClientID:      "test-client",
+			EmailVerified: &user.EmailVerified,
+		})
+	})
+
+	t.Run("challenge of a first-party login", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		login, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, login.MFAChallenge)
+
+		_, err = f.svc.AuthorizeWithMFA(ctx, newAuthorizationRequest(), login.MFAChallenge.Token, nextTOTPCode(secret), "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken)
+	})
+
+	t.Run("challenge of a client cannot log in first-party", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		res, err := f.svc.AuthorizeWithPassword(ctx, newAuthorizationRequest(), user.Email, "password", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, res.MFAChallenge)
+
+		_, err = f.svc.LoginWithMFA(ctx, res.MFAChallenge.Token, nextTOTPCode(secret), "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidMFAToken)
+	})
+}
+
+// TestUnitRefreshClientToken tests that refresh tokens issued to a client are only rotated by that client.
+func TestUnitRefreshClientToken(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", Scopes: []string{"user:read"}}
+
+	newSession := func(t *testing.T) *oauthFixture {
+		t.Helper()
+		f := newOAuthFixture(user)
+		req := newAuthorizationRequest()
+		_, err := f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		return f
+	}
+
+	t.Run("success", func(t *testing.T) {
+		f := newSession(t)
+		res, err := f.svc.RefreshClientToken(ctx, "test-client", "", "refresh-token", "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.RefreshToken("rotated-refresh-token"), res.RefreshToken)
+
+		session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("rotated-refresh-token"))
+		require.NoError(t, err)
+		assert.Equal(t, "test-client", session.ClientID)
+	})
+
+	t.Run("other client", func(t *testing.T) {
+		f := newSession(t)
+		_, err := f.svc.RefreshClientToken(ctx, "other-client", "", "refresh-token", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidRefreshToken)
+	})
+
+	t.Run("first-party refresh", func(t *testing.T) {
+		f := newSession(t)
+		_, err := f.svc.Refresh(ctx, "refresh-token", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidRefreshToken)
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		f := newSession(t)
+		_, err := f.svc.RefreshClientToken(ctx, "unknown", "", "refresh-token", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidClient)
+	})
+}
+
+// TestUnitIssueClientToken tests that confidential clients authenticated with their secret get access tokens of
+// their own, narrowed to the scopes and audiences they ask for, without a refresh token.
+func TestUnitIssueClientToken(t *testing.T) {
+	ctx := context.Background()
+
+	newService := func() (*authservice.Service, *MockAccessTokenMaker) {
+		accessMock := new(MockAccessTokenMaker)
+		accessMock.On("CreateToken", "billing-job", mock.Anything).Return(model.AccessToken("client-token"), &model.AccessTokenClaims{
+			ID:        "jti-billing-job",
+			Subject:   "billing-job",
+			Scopes:    []string{"invoice:write"},
+			ExpiresAt: time.Now().Add(15 * time.Minute),
+			ClientID:  "billing-job",
+		}, nil)
+		svc := authservice.New(authservice.Deps{
+			AccessToken: accessMock,
+			Denylist:    memoryrepo.NewAccessTokenDenylist(),
+			Clients:     testClients,
+		})
+		return svc, accessMock
+	}
+
+	t.Run("success", func(t *testing.T) {
+		svc, accessMock := newService()
+		res, err := svc.IssueClientToken(ctx, "billing-job", "billing-secret", []string{"invoice:write"}, []string{"billing-service", "unknown-service"})
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("client-token"), res.AccessToken)
+		assert.Empty(t, res.RefreshToken)
+		assert.False(t, res.AccessExpiresAt.IsZero())
+		assert.Equal(t, []string{"invoice:write"}, res.Scopes)
+
+		accessMock.AssertCalled(t, "CreateToken", "billing-job", model.AccessTokenGrant{
+			Scopes:    []string{"invoice:write"},
+			Audiences: []string{"billing-service"},
+			ClientID:  "billing-job",
+		})
+	})
+
+	t.Run("everything allowed by default", func(t *testing.T) {
+		svc, accessMock := newService()
+		_, err := svc.IssueClientToken(ctx, "billing-job", "billing-secret", nil, nil)
+		require.NoError(t, err)
+
+		accessMock.AssertCalled(t, "CreateToken", "billing-job", model.AccessTokenGrant{
+			Scopes:    []string{"user:read", "invoice:write"},
+			Audiences: []string{"user-service", "billing-service"},
+			ClientID:  "billing-job",
+		})
+	})
+
+	tests := []struct {
+		name         string
+		clientID     string
+		clientSecret string
+		scopes       []string
+		audiences    []string
+		wantErr      error
+	}{
+		{name: "unknown client", clientID: "unknown", clientSecret: "billing-secret", wantErr: authservice.ErrInvalidClient},
+		{name: "wrong secret", clientID: "billing-job", clientSecret: "wrong", wantErr: authservice.ErrInvalidClient},
+		{name: "missing secret", clientID: "billing-job", wantErr: authservice.ErrInvalidClient},
+		{name: "public client", clientID: "test-client", wantErr: authservice.ErrUnauthorizedClient},
+		{name: "public client with a secret", clientID: "test-client", clientSecret: "billing-secret", wantErr: authservice.ErrInvalidClient},
+		{name: "scope the client is not allowed", clientID: "billing-job", clientSecret: "billing-secret", scopes: []string{"admin"}, wantErr: authservice.ErrInvalidScope},
+		{name: "audience the client is not allowed", clientID: "billing-job", clientSecret: "billing-secret", audiences: []string{"admin-service"}, wantErr: authservice.ErrInvalidTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, accessMock := newService()
+			res, err := svc.IssueClientToken(ctx, tt.clientID, tt.clientSecret, tt.scopes, tt.audiences)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Nil(t, res)
+			accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package authservice defines the OpenID Connect layer of the auth API on top of the authorization code flow.
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// IDTokenMaker is the interface for the OpenID Connect ID token maker.
+type IDTokenMaker interface {
+	CreateIDToken(claims *model.IDTokenClaims) (model.IDToken, error)
+}
+
+// UserInfo returns the claims about the member an access token was issued for (OpenID Connect Core 1.0
+// section 5.3). The token must carry the openid scope; the email is only returned with the email scope.
+func (s *Service) UserInfo(ctx context.Context, accessToken model.AccessToken) (*UserInfoResult, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(claims.Scopes, model.ScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	user, err := s.userGateway.GetUser(ctx, claims.Subject)
+	if err != nil {
+		switch {
+		case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAccessToken, err)
+		case errors.Is(err, gateway.ErrUnavailable):
+			return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+		}
+		return nil, err
+	}
+
+	res := &UserInfoResult{Subject: claims.Subject}
+	if slices.Contains(claims.Scopes, model.ScopeEmail) {
+		res.Email = user.Email
+		res.EmailVerified = user.EmailVerified
+	}
+	return res, nil
+}
+
+// issueIDToken issues the ID token of an authorization code granted the openid scope. The email claims are read
+// from the user service when the code is exchanged, so that they are current.
+func (s *Service) issueIDToken(ctx context.Context, code *model.AuthorizationCode) (model.IDToken, error) {
+	user, err := s.userGateway.GetUser(ctx, code.MemberID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+			return "", fmt.Errorf("%w: %w", ErrInvalidGrant, err)
+		case errors.Is(err, gateway.ErrUnavailable):
+			return "", fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+		}
+		return "", err
+	}
+
+	claims := &model.IDTokenClaims{
+		Subject:     code.MemberID,
+		Audience:    code.ClientID,
+		Nonce:       code.Nonce,
+		AuthTime:    code.AuthTime,
+		AuthMethods: code.Grant.AuthMethods,
+	}
+	if slices.Contains(code.Grant.Scopes, model.ScopeEmail) {
+		claims.Email = user.Email
+		claims.EmailVerified = user.EmailVerified
+	}
+	idToken, err := s.idToken.CreateIDToken(claims)
+	if err != nil {
+		return "", fmt.Errorf("create ID token: %w", err)
+	}
+	return idToken, nil
+}
+
+// oidcScopes returns the OpenID Connect scopes of requested.
+func oidcScopes(requested []string) []string {
+	return slices.DeleteFunc(slices.Clone(requested), func(scope string) bool {
+		return !isOIDCScope(scope)
+	})
+}
+
+// apiScopes returns the scopes of requested that give access to an API, narrowed like any other scope.
+func apiScopes(requested []string) []string {
+	return slices.DeleteFunc(slices.Clone(requested), isOIDCScope)
+}
+
+func isOIDCScope(scope string) bool {
+	return scope == model.ScopeOpenID || scope == model.ScopeEmail
+}
@@ -0,0 +1,179 @@
+package authservice_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitOpenIDConnectFlow tests that authorization codes granted the openid scope are exchanged for an ID token
+// carrying the nonce, the authentication time and, with the email scope, the email claims of the user service.
+func TestUnitOpenIDConnectFlow(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", EmailVerified: true, Scopes: []string{"user:read"}}
+
+	newOIDCRequest := func(scopes ...string) *model.AuthorizationRequest {
+		req := newAuthorizationRequest()
+		req.Scopes = scopes
+		req.Nonce = "n-0S6_WzA2Mj"
+		return req
+	}
+
+	t.Run("openid and email scopes", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+		req := newOIDCRequest(model.ScopeOpenID, model.ScopeEmail, "user:read")
+
+		before := time.Now()
+		res, err := f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.IDToken("id-token"), res.IDToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        []string{model.ScopeOpenID, model.ScopeEmail, "user:read"},
+			AuthMethods:   []string{model.AuthMethodPassword},
+			ClientID:      "test-client",
+			EmailVerified: &user.EmailVerified,
+		})
+		require.Len(t, f.idTokenMock.Calls, 1)
+		claims := f.idTokenMock.Calls[0].Arguments.Get(0).(*model.IDTokenClaims)
+		assert.Equal(t, user.Email, claims.Subject)
+		assert.Equal(t, "test-client", claims.Audience)
+		assert.Equal(t, "n-0S6_WzA2Mj", claims.Nonce)
+		assert.WithinDuration(t, before, claims.AuthTime, time.Second)
+		assert.Equal(t, []string{model.AuthMethodPassword}, claims.AuthMethods)
+		assert.Equal(t, user.Email, claims.Email)
+		assert.True(t, claims.EmailVerified)
+	})
+
+	t.Run("openid scope only", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+		req := newOIDCRequest(model.ScopeOpenID)
+
+		res, err := f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.IDToken("id-token"), res.IDToken)
+
+		claims := f.idTokenMock.Calls[0].Arguments.Get(0).(*model.IDTokenClaims)
+		assert.Empty(t, claims.Email, "the email is only disclosed with the email scope")
+		assert.False(t, claims.EmailVerified)
+	})
+
+	t.Run("without openid scope", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		req := newOIDCRequest("user:read")
+
+		res, err := f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Empty(t, res.IDToken)
+		f.idTokenMock.AssertNotCalled(t, "CreateIDToken", mock.Anything)
+	})
+
+	t.Run("user disabled before the exchange", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, gateway.ErrAccountDisabled)
+		req := newOIDCRequest(model.ScopeOpenID)
+
+		_, err := f.svc.ExchangeAuthorizationCode(ctx, req.ClientID, "", f.authorize(t, req), req.RedirectURI, testCodeVerifier, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidGrant)
+		f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+	})
+}
+
+// TestUnitUserInfo tests that user info is only returned for live access tokens with the openid scope.
+func TestUnitUserInfo(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "123", Email: "user@example.com", EmailVerified: true}
+
+	tokenWithScopes := func(scopes ...string) *model.AccessTokenClaims {
+		claims := claimsOf(user.Email)
+		claims.Scopes = scopes
+		return claims
+	}
+
+	tests := []struct {
+		name       string
+		claims     *model.AccessTokenClaims
+		parseErr   error
+		gatewayErr error
+		want       *authservice.UserInfoResult
+		wantErr    error
+	}{
+		{
+			name:   "openid and email scopes",
+			claims: tokenWithScopes(model.ScopeOpenID, model.ScopeEmail),
+			want:   &authservice.UserInfoResult{Subject: user.Email, Email: user.Email, EmailVerified: true},
+		},
+		{
+			name:   "openid scope only",
+			claims: tokenWithScopes(model.ScopeOpenID, "user:read"),
+			want:   &authservice.UserInfoResult{Subject: user.Email},
+		},
+		{
+			name:    "without openid scope",
+			claims:  tokenWithScopes("user:read"),
+			wantErr: authservice.ErrInsufficientScope,
+		},
+		{
+			name:     "invalid token",
+			parseErr: errors.New("token is expired"),
+			wantErr:  authservice.ErrInvalidAccessToken,
+		},
+		{
+			name:       "user disabled",
+			claims:     tokenWithScopes(model.ScopeOpenID),
+			gatewayErr: gateway.ErrAccountDisabled,
+			wantErr:    authservice.ErrInvalidAccessToken,
+		},
+		{
+			name:       "user service unavailable",
+			claims:     tokenWithScopes(model.ScopeOpenID),
+			gatewayErr: gateway.ErrUnavailable,
+			wantErr:    authservice.ErrDependencyUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessMock := new(MockAccessTokenMaker)
+			accessMock.On("ParseToken", "access-token").Return(tt.claims, tt.parseErr)
+			userGateway := new(MockUserGateway)
+			if tt.gatewayErr != nil {
+				userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, tt.gatewayErr)
+			} else {
+				userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+			}
+			svc := authservice.New(authservice.Deps{
+				AccessToken: accessMock,
+				Denylist:    memoryrepo.NewAccessTokenDenylist(),
+				UserGateway: userGateway,
+				Mailer:      mailer.NewOutbox(),
+				Notifier:    notifier.NewOutbox(),
+				Audit:       audit.NewMemorySink(),
+			})
+
+			res, err := svc.UserInfo(ctx, "access-token")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Nil(t, res)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, res)
+		})
+	}
+}
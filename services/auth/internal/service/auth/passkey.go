@@ -0,0 +1,235 @@
+// Package authservice defines the passkey (WebAuthn) authentication of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+)
+
+const (
+	// webAuthnCeremonyTTL is how long a passkey registration or login waits for the authenticator.
+	webAuthnCeremonyTTL = 5 * time.Minute
+	// passkeyUserHandleBytes is the length of the random WebAuthn user handles of members.
+	passkeyUserHandleBytes = 32
+)
+
+// PasskeyRelyingParty is the interface for the WebAuthn relying party running passkey ceremonies.
+// Options and ceremony state are opaque JSON.
+type PasskeyRelyingParty interface {
+	BeginRegistration(memberID string, userHandle []byte, existing []*model.PasskeyCredential) (options, session []byte, err error)
+	FinishRegistration(memberID string, session, response []byte) (*model.PasskeyCredential, error)
+	BeginLogin() (options, session []byte, err error)
+	AssertionCredentialID(response []byte) ([]byte, error)
+	FinishLogin(session, response []byte, credential *model.PasskeyCredential) (*model.PasskeyCredential, error)
+}
+
+// PasskeyRepository is the interface for the repository of passkey credentials and pending WebAuthn ceremonies.
+type PasskeyRepository interface {
+	ListPasskeyCredentials(ctx context.Context, memberID string) ([]*model.PasskeyCredential, error)
+	GetPasskeyCredential(ctx context.Context, credentialID []byte) (*model.PasskeyCredential, error)
+	CreatePasskeyCredential(ctx context.Context, credential *model.PasskeyCredential) error
+	UpdatePasskeyCredential(ctx context.Context, credential *model.PasskeyCredential) error
+	SaveWebAuthnSession(ctx context.Context, session *model.WebAuthnSession) error
+	ConsumeWebAuthnSession(ctx context.Context, ceremonyHash model.WebAuthnSessionHash) (*model.WebAuthnSession, error)
+}
+
+// BeginPasskeyRegistration starts the registration of a passkey for the member owning accessToken.
+// All passkeys of a member share one random user handle, so that authenticators keep a single passkey per account.
+func (s *Service) BeginPasskeyRegistration(ctx context.Context, accessToken model.AccessToken) (*PasskeyCeremonyResult, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	memberID := claims.Subject
+
+	existing, err := s.passkeyRepo.ListPasskeyCredentials(ctx, memberID)
+	if err != nil {
+		return nil, fmt.Errorf("list passkey credentials: %w", err)
+	}
+	var userHandle []byte
+	if len(existing) > 0 {
+		userHandle = existing[0].UserHandle
+	} else if userHandle, err = randomBytes(passkeyUserHandleBytes); err != nil {
+		return nil, err
+	}
+
+	options, data, err := s.passkeys.BeginRegistration(memberID, userHandle, existing)
+	if err != nil {
+		return nil, err
+	}
+	return s.startWebAuthnCeremony(ctx, memberID, options, data)
+}
+
+// FinishPasskeyRegistration verifies the attestation of the authenticator for a registration started by the
+// same member and stores the new passkey.
+func (s *Service) FinishPasskeyRegistration(ctx context.Context, accessToken model.AccessToken, ceremonyID string, response []byte) error {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	memberID := claims.Subject
+
+	session, err := s.consumeWebAuthnCeremony(ctx, ceremonyID)
+	if err != nil {
+		return err
+	}
+	if session.MemberID != memberID {
+		return ErrInvalidPasskeyCeremony
+	}
+
+	credential, err := s.passkeys.FinishRegistration(memberID, session.Data, response)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPasskey, err)
+	}
+	credential.CreatedAt = time.Now()
+
+	err = s.passkeyRepo.CreatePasskeyCredential(ctx, credential)
+	if errors.Is(err, repository.ErrPasskeyCredentialExists) {
+		return ErrPasskeyAlreadyRegistered
+	}
+	if err != nil {
+		return fmt.Errorf("create passkey credential: %w", err)
+	}
+	return nil
+}
+
+// BeginPasskeyLogin starts a passwordless login with a passkey.
+// No account is named: the authenticator lets the user pick one of their passkeys for the relying party.
+func (s *Service) BeginPasskeyLogin(ctx context.Context) (*PasskeyCeremonyResult, error) {
+	options, data, err := s.passkeys.BeginLogin()
+	if err != nil {
+		return nil, err
+	}
+	return s.startWebAuthnCeremony(ctx, "", options, data)
+}
+
+// FinishPasskeyLogin verifies the assertion of a passkey and issues the tokens of its member,
+// whose amr claim records a hardware-secured key. Passkeys verify the user themselves, so no other factor is asked.
+func (s *Service) FinishPasskeyLogin(ctx context.Context, ceremonyID string, response []byte, userAgent, ipAddress string) (*LoginResult, error) {
+	session, err := s.consumeWebAuthnCeremony(ctx, ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID, err := s.passkeys.AssertionCredentialID(response)
+	if err != nil {
+		s.recordLoginFailure(ctx, "", "", model.AuthMethodHardwareKey, ipAddress, model.AuditReasonInvalidPasskey)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPasskey, err)
+	}
+	credential, err := s.passkeyRepo.GetPasskeyCredential(ctx, credentialID)
+	if errors.Is(err, repository.ErrPasskeyCredentialNotFound) {
+		s.recordLoginFailure(ctx, "", "", model.AuthMethodHardwareKey, ipAddress, model.AuditReasonInvalidPasskey)
+		return nil, ErrInvalidPasskey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get passkey credential: %w", err)
+	}
+	memberID := credential.MemberID
+
+	verified, err := s.passkeys.FinishLogin(session.Data, response, credential)
+	if err != nil {
+		s.recordLoginFailure(ctx, "", memberID, model.AuthMethodHardwareKey, ipAddress, model.AuditReasonInvalidPasskey)
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPasskey, err)
+	}
+	verified.LastUsedAt = time.Now()
+	if err := s.passkeyRepo.UpdatePasskeyCredential(ctx, verified); err != nil {
+		return nil, fmt.Errorf("update passkey credential: %w", err)
+	}
+
+	user, err := s.userGateway.GetUser(ctx, memberID)
+	if err != nil {
+		switch {
+		case errors.Is(err, gateway.ErrUserNotFound):
+			s.recordLoginFailure(ctx, "", memberID, model.AuthMethodHardwareKey, ipAddress, model.AuditReasonInvalidPasskey)
+			return nil, ErrInvalidPasskey
+		case errors.Is(err, gateway.ErrAccountDisabled):
+			s.recordLoginFailure(ctx, "", memberID, model.AuthMethodHardwareKey, ipAddress, model.AuditReasonAccountDisabled)
+			return nil, ErrAccountDisabled
+		case errors.Is(err, gateway.ErrAccountLocked):
+			s.recordLoginFailure(ctx, "", memberID, model.AuthMethodHardwareKey, ipAddress, model.AuditReasonAccountLocked)
+			return nil, ErrAccountLocked
+		case errors.Is(err, gateway.ErrUnavailable):
+			return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+		}
+		return nil, err
+	}
+	if user.Status == usermodel.StatusPendingVerification {
+		s.recordLoginFailure(ctx, "", memberID, model.AuthMethodHardwareKey, ipAddress, model.AuditReasonEmailNotVerified)
+		return nil, ErrEmailNotVerified
+	}
+
+	grant := model.AccessTokenGrant{
+		Scopes:        user.Scopes,
+		Roles:         user.Roles,
+		Audiences:     user.Audiences,
+		AuthMethods:   []string{model.AuthMethodHardwareKey},
+		EmailVerified: &user.EmailVerified,
+	}
+	return s.startSession(ctx, "", user.Email, grant, userAgent, ipAddress)
+}
+
+// startWebAuthnCeremony saves the state of a WebAuthn ceremony under a new random ceremony ID.
+func (s *Service) startWebAuthnCeremony(ctx context.Context, memberID string, options, data []byte) (*PasskeyCeremonyResult, error) {
+	id, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	ceremonyID := base64.RawURLEncoding.EncodeToString(id)
+
+	session := &model.WebAuthnSession{
+		CeremonyHash: hashWebAuthnCeremonyID(ceremonyID),
+		MemberID:     memberID,
+		Data:         data,
+		ExpiresAt:    time.Now().Add(webAuthnCeremonyTTL),
+	}
+	if err := s.passkeyRepo.SaveWebAuthnSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("save WebAuthn session: %w", err)
+	}
+
+	return &PasskeyCeremonyResult{
+		CeremonyID: ceremonyID,
+		Options:    options,
+		ExpiresAt:  session.ExpiresAt,
+	}, nil
+}
+
+// consumeWebAuthnCeremony gets and discards the state of a WebAuthn ceremony: whatever the outcome,
+// a challenge is answered at most once.
+func (s *Service) consumeWebAuthnCeremony(ctx context.Context, ceremonyID string) (*model.WebAuthnSession, error) {
+	session, err := s.passkeyRepo.ConsumeWebAuthnSession(ctx, hashWebAuthnCeremonyID(ceremonyID))
+	if errors.Is(err, repository.ErrWebAuthnSessionNotFound) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume WebAuthn session: %w", err)
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	return session, nil
+}
+
+// randomBytes reads n random bytes.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("read random bytes: %w", err)
+	}
+	return b, nil
+}
+
+// hashWebAuthnCeremonyID hashes a WebAuthn ceremony ID for storage.
+func hashWebAuthnCeremonyID(ceremonyID string) model.WebAuthnSessionHash {
+	sum := sha256.Sum256([]byte(ceremonyID))
+	return model.WebAuthnSessionHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
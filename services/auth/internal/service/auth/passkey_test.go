@@ -0,0 +1,290 @@
+package authservice_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/audit"
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/mailer"
+	"github.com/incheat/go-production-backend/services/auth/internal/notifier"
+	"github.com/incheat/go-production-backend/services/auth/internal/passkey/passkeytest"
+	memoryrepo "github.com/incheat/go-production-backend/services/auth/internal/repository/memory"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// passkeyFixture is a service whose members can register passkeys and log in with them.
+type passkeyFixture struct {
+	svc         *authservice.Service
+	accessMock  *MockAccessTokenMaker
+	userGateway *MockUserGateway
+	passkeyRepo *memoryrepo.PasskeyRepository
+	audit       *audit.MemorySink
+}
+
+func newPasskeyFixture() *passkeyFixture {
+	accessMock := new(MockAccessTokenMaker)
+	accessMock.On("ParseToken", "access-token").Return(claimsOf("user@example.com"), nil)
+	accessMock.On("ParseToken", "other-access-token").Return(claimsOf("other@example.com"), nil)
+
+	refreshMock := new(MockRefreshTokenMaker)
+	refreshMock.On("CreateToken").Return(model.RefreshToken("refresh-token"), nil)
+	refreshMock.On("MaxAge").Return(3600)
+	refreshMock.On("RefreshEndPoint").Return("/refresh")
+
+	userGateway := new(MockUserGateway)
+	passkeyRepo := memoryrepo.NewPasskeyRepository()
+	auditSink := audit.NewMemorySink()
+	svc := authservice.New(authservice.Deps{
+		AccessToken:       accessMock,
+		RefreshToken:      refreshMock,
+		RefreshHasher:     testHasher,
+		RefreshTokenRepo:  memoryrepo.NewRefreshTokenRepository(),
+		Denylist:          memoryrepo.NewAccessTokenDenylist(),
+		LoginLimiter:      newLoginLimiter(),
+		MFARepo:           memoryrepo.NewMFARepository(),
+		TOTP:              testTOTP,
+		SecretCipher:      testCipher,
+		PasskeyRepo:       passkeyRepo,
+		Passkeys:          testRelyingParty,
+		Clients:           testClients,
+		OAuthRepo:         memoryrepo.NewOAuthRepository(),
+		IDToken:           new(MockIDTokenMaker),
+		UserGateway:       userGateway,
+		PasswordResets:    memoryrepo.NewPasswordResetRepository(),
+		Mailer:            mailer.NewOutbox(),
+		Links:             testLinks,
+		Verifications:     memoryrepo.NewEmailVerificationRepository(),
+		LinkSigner:        testLinkSigner,
+		Passwordless:      memoryrepo.NewPasswordlessRepository(),
+		Notifier:          notifier.NewOutbox(),
+		FederatedLogins:   memoryrepo.NewFederatedLoginRepository(),
+		IdentityProviders: testIdentityProviders,
+		Audit:             auditSink,
+	})
+	return &passkeyFixture{svc: svc, accessMock: accessMock, userGateway: userGateway, passkeyRepo: passkeyRepo, audit: auditSink}
+}
+
+// register registers the passkey of authenticator for the member owning accessToken.
+func (f *passkeyFixture) register(accessToken model.AccessToken, authenticator *passkeytest.Authenticator) error {
+	ctx := context.Background()
+	ceremony, err := f.svc.BeginPasskeyRegistration(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	response, err := authenticator.Register(ceremony.Options)
+	if err != nil {
+		return err
+	}
+	return f.svc.FinishPasskeyRegistration(ctx, accessToken, ceremony.CeremonyID, response)
+}
+
+// login logs in with the passkey of authenticator.
+func (f *passkeyFixture) login(t *testing.T, authenticator *passkeytest.Authenticator) (*authservice.LoginResult, error) {
+	t.Helper()
+	ctx := context.Background()
+	ceremony, err := f.svc.BeginPasskeyLogin(ctx)
+	require.NoError(t, err)
+	response, err := authenticator.Login(ceremony.Options)
+	require.NoError(t, err)
+	return f.svc.FinishPasskeyLogin(ctx, ceremony.CeremonyID, response, "ua", "1.2.3.4")
+}
+
+func newAuthenticator(t *testing.T) *passkeytest.Authenticator {
+	t.Helper()
+	authenticator, err := passkeytest.NewAuthenticator(testOrigin)
+	require.NoError(t, err)
+	return authenticator
+}
+
+// TestUnitPasskeyRegistration tests that passkeys are registered for the member owning the access token.
+func TestUnitPasskeyRegistration(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("passkeys of a member share a user handle", func(t *testing.T) {
+		f := newPasskeyFixture()
+		require.NoError(t, f.register("access-token", newAuthenticator(t)))
+		require.NoError(t, f.register("access-token", newAuthenticator(t)))
+
+		credentials, err := f.passkeyRepo.ListPasskeyCredentials(ctx, "user@example.com")
+		require.NoError(t, err)
+		require.Len(t, credentials, 2)
+		assert.Len(t, credentials[0].UserHandle, 32)
+		assert.Equal(t, credentials[0].UserHandle, credentials[1].UserHandle)
+		assert.Equal(t, "none", credentials[0].AttestationType)
+		assert.False(t, credentials[0].CreatedAt.IsZero())
+	})
+
+	t.Run("same passkey twice", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+
+		err := f.register("other-access-token", authenticator)
+		assert.ErrorIs(t, err, authservice.ErrPasskeyAlreadyRegistered)
+	})
+
+	t.Run("ceremony of another member", func(t *testing.T) {
+		f := newPasskeyFixture()
+		ceremony, err := f.svc.BeginPasskeyRegistration(ctx, "access-token")
+		require.NoError(t, err)
+		response, err := newAuthenticator(t).Register(ceremony.Options)
+		require.NoError(t, err)
+
+		err = f.svc.FinishPasskeyRegistration(ctx, "other-access-token", ceremony.CeremonyID, response)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskeyCeremony)
+	})
+
+	t.Run("ceremony is single use", func(t *testing.T) {
+		f := newPasskeyFixture()
+		ceremony, err := f.svc.BeginPasskeyRegistration(ctx, "access-token")
+		require.NoError(t, err)
+		response, err := newAuthenticator(t).Register(ceremony.Options)
+		require.NoError(t, err)
+		require.NoError(t, f.svc.FinishPasskeyRegistration(ctx, "access-token", ceremony.CeremonyID, response))
+
+		err = f.svc.FinishPasskeyRegistration(ctx, "access-token", ceremony.CeremonyID, response)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskeyCeremony)
+	})
+
+	t.Run("invalid attestation", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		authenticator.Origin = "https://evil.example"
+
+		err := f.register("access-token", authenticator)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskey)
+	})
+}
+
+// TestUnitPasskeyLogin tests passwordless logins with a passkey.
+func TestUnitPasskeyLogin(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{
+		ID:        "123",
+		Email:     "user@example.com",
+		Scopes:    []string{"user:read"},
+		Roles:     []string{"member"},
+		Audiences: []string{"user-api"},
+	}
+
+	t.Run("success", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		authenticator.SignCount = 1
+		require.NoError(t, f.register("access-token", authenticator))
+
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+		wantGrant := model.AccessTokenGrant{
+			Scopes:        user.Scopes,
+			Roles:         user.Roles,
+			Audiences:     user.Audiences,
+			AuthMethods:   []string{model.AuthMethodHardwareKey},
+			EmailVerified: &user.EmailVerified,
+		}
+		f.accessMock.On("CreateToken", user.Email, wantGrant).Return(model.AccessToken("new-access-token"), claimsOf(user.Email), nil).Once()
+
+		res, err := f.login(t, authenticator)
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("new-access-token"), res.AccessToken)
+		assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+		assert.Nil(t, res.MFAChallenge)
+
+		credential, err := f.passkeyRepo.GetPasskeyCredential(ctx, authenticator.CredentialID())
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), credential.SignCount)
+		assert.False(t, credential.LastUsedAt.IsZero())
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, wantGrant)
+		f.userGateway.AssertExpectations(t)
+	})
+
+	t.Run("ceremony is single use", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+		f.accessMock.On("CreateToken", user.Email, mock.Anything).Return(model.AccessToken("new-access-token"), claimsOf(user.Email), nil)
+
+		ceremony, err := f.svc.BeginPasskeyLogin(ctx)
+		require.NoError(t, err)
+		response, err := authenticator.Login(ceremony.Options)
+		require.NoError(t, err)
+		_, err = f.svc.FinishPasskeyLogin(ctx, ceremony.CeremonyID, response, "ua", "1.2.3.4")
+		require.NoError(t, err)
+
+		_, err = f.svc.FinishPasskeyLogin(ctx, ceremony.CeremonyID, response, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskeyCeremony)
+	})
+
+	t.Run("unregistered passkey", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+		other := newAuthenticator(t)
+		_, err := other.Register(mustBeginRegistration(t, f))
+		require.NoError(t, err)
+
+		_, err = f.login(t, other)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskey)
+		assertLoginFailed(t, f.audit, "", model.AuthMethodHardwareKey, model.AuditReasonInvalidPasskey)
+	})
+
+	t.Run("sign count regression", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		authenticator.SignCount = 5
+		require.NoError(t, f.register("access-token", authenticator))
+		authenticator.SignCount = 1 // a clone lagging behind
+
+		_, err := f.login(t, authenticator)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskey)
+	})
+
+	t.Run("disabled account", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, gateway.ErrAccountDisabled).Once()
+
+		_, err := f.login(t, authenticator)
+		assert.ErrorIs(t, err, authservice.ErrAccountDisabled)
+		assertLoginFailed(t, f.audit, user.Email, model.AuthMethodHardwareKey, model.AuditReasonAccountDisabled)
+	})
+
+	t.Run("email not verified", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+		pending := *user
+		pending.Status = usermodel.StatusPendingVerification
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(&pending, nil).Once()
+
+		_, err := f.login(t, authenticator)
+		assert.ErrorIs(t, err, authservice.ErrEmailNotVerified)
+		f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+		assertLoginFailed(t, f.audit, user.Email, model.AuthMethodHardwareKey, model.AuditReasonEmailNotVerified)
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		f := newPasskeyFixture()
+		authenticator := newAuthenticator(t)
+		require.NoError(t, f.register("access-token", authenticator))
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, gateway.ErrUserNotFound).Once()
+
+		_, err := f.login(t, authenticator)
+		assert.ErrorIs(t, err, authservice.ErrInvalidPasskey)
+	})
+}
+
+// mustBeginRegistration begins a registration that is never finished and returns its options.
+func mustBeginRegistration(t *testing.T, f *passkeyFixture) []byte {
+	t.Helper()
+	ceremony, err := f.svc.BeginPasskeyRegistration(context.Background(), "access-token")
+	require.NoError(t, err)
+	return ceremony.Options
+}
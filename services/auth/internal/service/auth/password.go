@@ -0,0 +1,190 @@
+// Package authservice defines the password reset of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+)
+
+const (
+	// passwordResetTTL is how long a member has to follow the link of a password reset email.
+	passwordResetTTL = 30 * time.Minute
+	// passwordResetEmailInterval is the least time between two password reset emails to the same email.
+	passwordResetEmailInterval = time.Minute
+	// accountEmailMinDuration is the least time a request for a password reset or verification email takes, so
+	// that the response time does not tell whether an email was sent, and so whether the email belongs to a member.
+	accountEmailMinDuration = time.Second
+)
+
+// PasswordResetRepository is the interface for the repository of pending password resets.
+type PasswordResetRepository interface {
+	SavePasswordReset(ctx context.Context, reset *model.PasswordReset) error
+	ConsumePasswordReset(ctx context.Context, tokenHash model.PasswordResetTokenHash) (*model.PasswordReset, error)
+	RecordPasswordResetEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error)
+}
+
+// Mailer is the interface for the sender of emails to members.
+// Sends must not wait for the mail relay, e.g. by queueing emails, so that requests take as long whether they send
+// an email or not.
+type Mailer interface {
+	Send(ctx context.Context, mail model.Mail) error
+}
+
+// Links are the URLs of the pages of the web app that emails to members link to.
+type Links struct {
+	PasswordReset string // the reset token is added as the token query parameter
+	VerifyEmail   string // the verification token is added as the token query parameter
+	// PasswordlessLogin gets the challenge ID and code of a login link as the challenge and code query parameters.
+	PasswordlessLogin string
+}
+
+// ForgotPassword emails a link to reset their password to the active member with an email. Requests are throttled
+// per email address, whether it belongs to a member or not, and a throttled request fails with a
+// *PasswordResetThrottledError. Otherwise it succeeds after the same time whether the email belongs to a member or
+// not, so that it cannot be used to find out who is a member; an email that cannot be sent is reported by the
+// mailer, not by the request.
+func (s *Service) ForgotPassword(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	retryAfter, err := s.passwordResets.RecordPasswordResetEmail(ctx, email, passwordResetEmailInterval)
+	if err != nil {
+		return fmt.Errorf("record password reset email: %w", err)
+	}
+	if retryAfter > 0 {
+		return &PasswordResetThrottledError{RetryAfter: retryAfter}
+	}
+
+	defer waitUntil(ctx, time.Now().Add(accountEmailMinDuration))
+
+	user, err := s.userGateway.GetUser(ctx, email)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+		return nil
+	case errors.Is(err, gateway.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("get user: %w", err)
+	}
+
+	b, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	reset := &model.PasswordReset{
+		TokenHash: hashPasswordResetToken(token),
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	}
+	if err := s.passwordResets.SavePasswordReset(ctx, reset); err != nil {
+		return fmt.Errorf("save password reset: %w", err)
+	}
+
+	link, err := linkWithToken(s.links.PasswordReset, token)
+	if err != nil {
+		return err
+	}
+	// The error is not returned, as only members are sent an email: it would tell who is one.
+	_ = s.mailer.Send(ctx, model.Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, follow this link "+
+			"within %d minutes to choose a new password:\n\n%s\n\n"+
+			"If it was not you, ignore this email; your password stays the same.\n",
+			int(passwordResetTTL/time.Minute), link),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password with the token of a password reset email, once, and revokes every session and
+// access token of the member, which may have been obtained with the old password. As following the link proves the
+// member owns the email, members pending verification are activated.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) error {
+	// The password is checked first, so that a password rejected by the policy does not use up the token.
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	reset, err := s.passwordResets.ConsumePasswordReset(ctx, hashPasswordResetToken(token))
+	if errors.Is(err, repository.ErrPasswordResetNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return fmt.Errorf("consume password reset: %w", err)
+	}
+	now := time.Now()
+	if reset.IsExpired(now) {
+		return ErrInvalidResetToken
+	}
+
+	err = s.userGateway.SetPassword(ctx, reset.Email, password)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound):
+		return ErrInvalidResetToken
+	case errors.Is(err, gateway.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("set password: %w", err)
+	}
+
+	memberID := reset.Email
+	if err := s.refreshTokenRepo.RevokeMemberRefreshTokenSessions(ctx, memberID, now); err != nil {
+		return fmt.Errorf("revoke member refresh token sessions: %w", err)
+	}
+	s.recordSessionRevoked(ctx, memberID, nil, model.AuditReasonPasswordReset)
+	if err := s.RevokeMemberAccessTokens(ctx, memberID); err != nil {
+		return err
+	}
+
+	user, err := s.userGateway.GetUser(ctx, reset.Email)
+	if err == nil && user.Status == usermodel.StatusPendingVerification {
+		_, err = s.userGateway.VerifyEmail(ctx, reset.Email)
+	}
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+		// The password is set all the same; there is no member to activate.
+		return nil
+	case errors.Is(err, gateway.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("verify email: %w", err)
+	}
+	return nil
+}
+
+// linkWithToken adds a token to the query of the URL of a page of the web app.
+func linkWithToken(page, token string) (string, error) {
+	return linkWithQuery(page, map[string]string{"token": token})
+}
+
+// linkWithQuery adds parameters to the query of the URL of a page of the web app.
+func linkWithQuery(page string, params map[string]string) (string, error) {
+	u, err := url.Parse(page)
+	if err != nil {
+		return "", fmt.Errorf("parse link %q: %w", page, err)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// hashPasswordResetToken hashes a password reset token for storage.
+func hashPasswordResetToken(token string) model.PasswordResetTokenHash {
+	sum := sha256.Sum256([]byte(token))
+	return model.PasswordResetTokenHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,181 @@
+package authservice_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// resetLinkPattern matches the password reset link of a password reset email.
+var resetLinkPattern = regexp.MustCompile(`https://app\.example\.com/reset-password\?token=\S+`)
+
+// requestPasswordReset asks for a password reset of the member and returns the token emailed to them.
+func requestPasswordReset(t *testing.T, f *oauthFixture, user *usermodel.User) string {
+	t.Helper()
+	f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+	require.NoError(t, f.svc.ForgotPassword(context.Background(), user.Email))
+
+	mails := f.outbox.Mails()
+	require.NotEmpty(t, mails)
+	link, err := url.Parse(resetLinkPattern.FindString(mails[len(mails)-1].Body))
+	require.NoError(t, err)
+	token := link.Query().Get("token")
+	require.NotEmpty(t, token)
+	return token
+}
+
+// TestUnitForgotPassword tests that only active members are emailed a reset link, that requests for other emails
+// take as long, and that requests are throttled whether the email belongs to a member or not.
+func TestUnitForgotPassword(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusActive}
+
+	t.Run("member", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+
+		start := time.Now()
+		require.NoError(t, f.svc.ForgotPassword(ctx, " User@Example.com "))
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+		mails := f.outbox.Mails()
+		require.Len(t, mails, 1)
+		assert.Equal(t, user.Email, mails[0].To)
+		assert.Equal(t, "Reset your password", mails[0].Subject)
+		assert.Regexp(t, resetLinkPattern, mails[0].Body)
+
+		err := f.svc.ForgotPassword(ctx, user.Email)
+		var throttled *authservice.PasswordResetThrottledError
+		require.ErrorAs(t, err, &throttled)
+		assert.ErrorIs(t, err, authservice.ErrTooManyPasswordResetEmails)
+		assert.Positive(t, throttled.RetryAfter)
+		assert.Len(t, f.outbox.Mails(), 1)
+	})
+
+	t.Run("unknown email throttled", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, "other@example.com").Return(nil, gateway.ErrUserNotFound).Once()
+
+		require.NoError(t, f.svc.ForgotPassword(ctx, "other@example.com"))
+		err := f.svc.ForgotPassword(ctx, "other@example.com")
+		var throttled *authservice.PasswordResetThrottledError
+		require.ErrorAs(t, err, &throttled, "throttling must not tell whether the email belongs to a member")
+	})
+
+	tests := []struct {
+		name       string
+		gatewayErr error
+		wantErr    error
+	}{
+		{name: "unknown email", gatewayErr: gateway.ErrUserNotFound},
+		{name: "disabled member", gatewayErr: gateway.ErrAccountDisabled},
+		{name: "user service unavailable", gatewayErr: gateway.ErrUnavailable, wantErr: authservice.ErrDependencyUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, tt.gatewayErr).Once()
+
+			start := time.Now()
+			err := f.svc.ForgotPassword(ctx, user.Email)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.GreaterOrEqual(t, time.Since(start), time.Second, "the response time must not tell whether an email was sent")
+			assert.Empty(t, f.outbox.Mails())
+		})
+	}
+}
+
+// TestUnitResetPassword tests that reset tokens set a new password once and log the member out everywhere.
+func TestUnitResetPassword(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusActive}
+	password := "correct horse battery staple"
+
+	t.Run("success", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		_, err := f.svc.LoginWithEmailAndPassword(ctx, user.Email, "password", nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		token := requestPasswordReset(t, f, user)
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, password).Return(nil).Once()
+
+		require.NoError(t, f.svc.ResetPassword(ctx, token, password))
+		f.userGateway.AssertCalled(t, "SetPassword", mock.Anything, user.Email, password)
+		f.userGateway.AssertNotCalled(t, "VerifyEmail", mock.Anything, mock.Anything)
+		events := f.audit.Events()
+		require.NotEmpty(t, events)
+		revoked := events[len(events)-1]
+		assert.Equal(t, model.AuditEventSessionRevoked, revoked.Type)
+		assert.Equal(t, model.AuditReasonPasswordReset, revoked.Reason)
+		assert.Equal(t, user.Email, revoked.MemberID)
+
+		_, err = f.svc.Refresh(ctx, "refresh-token", "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidRefreshToken, "sessions must be revoked")
+		_, err = f.svc.VerifyAccessToken(ctx, "access-token")
+		assert.ErrorIs(t, err, authservice.ErrInvalidAccessToken, "access tokens must be revoked")
+
+		err = f.svc.ResetPassword(ctx, token, password)
+		assert.ErrorIs(t, err, authservice.ErrInvalidResetToken, "reset tokens are single-use")
+	})
+
+	t.Run("member pending verification", func(t *testing.T) {
+		pending := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusPendingVerification}
+		f := newOAuthFixture(pending)
+		token := requestPasswordReset(t, f, pending)
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, password).Return(nil).Once()
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(user, nil).Once()
+
+		require.NoError(t, f.svc.ResetPassword(ctx, token, password))
+		f.userGateway.AssertCalled(t, "VerifyEmail", mock.Anything, user.Email)
+	})
+
+	t.Run("password not meeting the policy", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestPasswordReset(t, f, user)
+
+		err := f.svc.ResetPassword(ctx, token, "short")
+		assert.ErrorIs(t, err, authservice.ErrInvalidPassword)
+		f.userGateway.AssertNotCalled(t, "SetPassword", mock.Anything, mock.Anything, mock.Anything)
+
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, password).Return(nil).Once()
+		assert.NoError(t, f.svc.ResetPassword(ctx, token, password), "a rejected password must not use up the token")
+	})
+
+	t.Run("member deleted since", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestPasswordReset(t, f, user)
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, password).Return(gateway.ErrUserNotFound).Once()
+
+		err := f.svc.ResetPassword(ctx, token, password)
+		assert.ErrorIs(t, err, authservice.ErrInvalidResetToken)
+	})
+
+	t.Run("user service unavailable", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestPasswordReset(t, f, user)
+		f.userGateway.On("SetPassword", mock.Anything, user.Email, password).Return(gateway.ErrUnavailable).Once()
+
+		err := f.svc.ResetPassword(ctx, token, password)
+		assert.ErrorIs(t, err, authservice.ErrDependencyUnavailable)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		f := newOAuthFixture(user)
+
+		err := f.svc.ResetPassword(ctx, "unknown", password)
+		assert.ErrorIs(t, err, authservice.ErrInvalidResetToken)
+	})
+}
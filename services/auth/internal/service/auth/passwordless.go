@@ -0,0 +1,270 @@
+// Package authservice defines the passwordless login of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+)
+
+const (
+	// passwordlessChallengeTTL is how long a member has to enter the code or follow the link of a passwordless login.
+	passwordlessChallengeTTL = 10 * time.Minute
+	// maxPasswordlessChallengeFailures is the number of wrong codes after which a passwordless login is discarded.
+	maxPasswordlessChallengeFailures = 5
+	// passwordlessEmailInterval is the least time between two passwordless login emails to the same email.
+	passwordlessEmailInterval = time.Minute
+	// loginCodeDigits is the number of digits of a login code.
+	loginCodeDigits = 6
+)
+
+// PasswordlessRepository is the interface for the repository of pending passwordless logins.
+type PasswordlessRepository interface {
+	SavePasswordlessChallenge(ctx context.Context, challenge *model.PasswordlessChallenge) error
+	GetPasswordlessChallenge(ctx context.Context, idHash model.PasswordlessChallengeHash) (*model.PasswordlessChallenge, error)
+	RecordPasswordlessChallengeFailure(ctx context.Context, challenge *model.PasswordlessChallenge) (int64, error)
+	DeletePasswordlessChallenge(ctx context.Context, idHash model.PasswordlessChallengeHash) (bool, error)
+	RecordPasswordlessEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error)
+}
+
+// LoginNotifier is the interface for the notifier delivering the codes and links of passwordless logins.
+type LoginNotifier interface {
+	NotifyLogin(ctx context.Context, notification model.LoginNotification) error
+}
+
+// StartPasswordlessLogin sends the member with an email a 6-digit code or a link to log in with, as method asks,
+// if their account is active. The returned challenge ID identifies the login when completing it with the code.
+// Like ForgotPassword, it succeeds after the same time whether an email was sent or not, with a challenge ID that
+// no code completes when none was. Emails are throttled per email address, and logins locked out after failures
+// are refused, both with a *LoginThrottledError.
+func (s *Service) StartPasswordlessLogin(ctx context.Context, email, method, ipAddress string) (*PasswordlessChallengeResult, error) {
+	if method != model.PasswordlessMethodLink && method != model.PasswordlessMethodCode {
+		return nil, fmt.Errorf("unsupported passwordless method %q", method)
+	}
+	email = normalizeEmail(email)
+
+	retryAfter, err := s.loginLimiter.LoginRetryAfter(ctx, email, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("check login limiter: %w", err)
+	}
+	if retryAfter == 0 {
+		retryAfter, err = s.passwordless.RecordPasswordlessEmail(ctx, email, passwordlessEmailInterval)
+		if err != nil {
+			return nil, fmt.Errorf("record passwordless email: %w", err)
+		}
+	}
+	if retryAfter > 0 {
+		return nil, &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	defer waitUntil(ctx, time.Now().Add(accountEmailMinDuration))
+
+	b, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	res := &PasswordlessChallengeResult{
+		ChallengeID: id,
+		Method:      method,
+		ExpiresAt:   time.Now().Add(passwordlessChallengeTTL),
+	}
+
+	user, err := s.userGateway.GetUser(ctx, email)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+		return res, nil
+	case errors.Is(err, gateway.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	if user.Status == usermodel.StatusPendingVerification {
+		return res, nil
+	}
+
+	code, err := newPasswordlessCode(method)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := &model.PasswordlessChallenge{
+		IDHash:     hashPasswordlessChallengeID(id),
+		Email:      user.Email,
+		Method:     method,
+		SecretHash: hashPasswordlessCode(id, code),
+		CreatedAt:  time.Now(),
+		ExpiresAt:  res.ExpiresAt,
+	}
+	if err := s.passwordless.SavePasswordlessChallenge(ctx, challenge); err != nil {
+		return nil, fmt.Errorf("save passwordless challenge: %w", err)
+	}
+
+	notification := model.LoginNotification{To: user.Email, ExpiresAt: challenge.ExpiresAt}
+	if method == model.PasswordlessMethodCode {
+		notification.Code = code
+	} else {
+		notification.Link, err = linkWithQuery(s.links.PasswordlessLogin, map[string]string{"challenge": id, "code": code})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.notifier.NotifyLogin(ctx, notification); err != nil {
+		return nil, fmt.Errorf("notify login: %w", err)
+	}
+	return res, nil
+}
+
+// CompletePasswordlessLogin completes a passwordless login with the code emailed to the member, or the code of
+// the login link, and logs them in like LoginWithEmailAndPassword: the access token is narrowed to the requested
+// scopes, and members who enabled a second factor get an MFA challenge instead of tokens.
+// The challenge is single-use. Wrong codes count as failed logins of the email, and a challenge is discarded after
+// too many of them.
+func (s *Service) CompletePasswordlessLogin(ctx context.Context, challengeID, code string, requestedScopes []string, userAgent, ipAddress string) (*LoginResult, error) {
+	challenge, err := s.passwordless.GetPasswordlessChallenge(ctx, hashPasswordlessChallengeID(challengeID))
+	if errors.Is(err, repository.ErrPasswordlessChallengeNotFound) {
+		return nil, ErrInvalidLoginChallenge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get passwordless challenge: %w", err)
+	}
+	if challenge.IsExpired(time.Now()) {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	retryAfter, err := s.loginLimiter.LoginRetryAfter(ctx, challenge.Email, ipAddress)
+	if err != nil {
+		return nil, fmt.Errorf("check login limiter: %w", err)
+	}
+	if retryAfter > 0 {
+		s.recordLoginFailure(ctx, "", challenge.Email, model.AuthMethodEmail, ipAddress, model.AuditReasonThrottled)
+		return nil, &LoginThrottledError{RetryAfter: retryAfter}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashPasswordlessCode(challengeID, code)), []byte(challenge.SecretHash)) != 1 {
+		s.recordLoginFailure(ctx, "", challenge.Email, model.AuthMethodEmail, ipAddress, model.AuditReasonInvalidLoginCode)
+		if err := s.recordPasswordlessFailure(ctx, challenge, ipAddress); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidLoginCode
+	}
+
+	user, err := s.userGateway.GetUser(ctx, challenge.Email)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound):
+		return nil, ErrInvalidLoginChallenge
+	case errors.Is(err, gateway.ErrAccountDisabled):
+		s.recordLoginFailure(ctx, "", challenge.Email, model.AuthMethodEmail, ipAddress, model.AuditReasonAccountDisabled)
+		return nil, ErrAccountDisabled
+	case errors.Is(err, gateway.ErrAccountLocked):
+		s.recordLoginFailure(ctx, "", challenge.Email, model.AuthMethodEmail, ipAddress, model.AuditReasonAccountLocked)
+		return nil, ErrAccountLocked
+	case errors.Is(err, gateway.ErrUnavailable):
+		return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	if user.Status == usermodel.StatusPendingVerification {
+		s.recordLoginFailure(ctx, "", challenge.Email, model.AuthMethodEmail, ipAddress, model.AuditReasonEmailNotVerified)
+		return nil, ErrEmailNotVerified
+	}
+	memberID := user.Email
+
+	// The scopes are checked before the challenge is used up, so that the login can be retried with other scopes.
+	scopes, err := grantedScopes(requestedScopes, user.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	grant := model.AccessTokenGrant{
+		Scopes:        scopes,
+		Roles:         user.Roles,
+		Audiences:     user.Audiences,
+		AuthMethods:   []string{model.AuthMethodEmail},
+		EmailVerified: &user.EmailVerified,
+	}
+
+	// Only the completion that deletes the challenge logs in.
+	deleted, err := s.passwordless.DeletePasswordlessChallenge(ctx, challenge.IDHash)
+	if err != nil {
+		return nil, fmt.Errorf("delete passwordless challenge: %w", err)
+	}
+	if !deleted {
+		return nil, ErrInvalidLoginChallenge
+	}
+
+	mfaRequired, err := s.isMFARequired(ctx, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if mfaRequired {
+		mfaChallenge, err := s.startMFAChallenge(ctx, "", model.DeviceDecision{}, memberID, challenge.Email, grant)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAChallenge: mfaChallenge}, nil
+	}
+
+	if err := s.loginLimiter.ResetLoginFailures(ctx, challenge.Email); err != nil {
+		return nil, fmt.Errorf("reset login failures: %w", err)
+	}
+	return s.startSession(ctx, "", memberID, grant, userAgent, ipAddress)
+}
+
+// recordPasswordlessFailure counts a wrong code against the challenge and as a failed login of its email.
+func (s *Service) recordPasswordlessFailure(ctx context.Context, challenge *model.PasswordlessChallenge, ipAddress string) error {
+	if err := s.loginLimiter.RecordLoginFailure(ctx, challenge.Email, ipAddress); err != nil {
+		return fmt.Errorf("record login failure: %w", err)
+	}
+	failures, err := s.passwordless.RecordPasswordlessChallengeFailure(ctx, challenge)
+	if err != nil {
+		return fmt.Errorf("record passwordless challenge failure: %w", err)
+	}
+	if failures >= maxPasswordlessChallengeFailures {
+		if _, err := s.passwordless.DeletePasswordlessChallenge(ctx, challenge.IDHash); err != nil {
+			return fmt.Errorf("delete passwordless challenge: %w", err)
+		}
+	}
+	return nil
+}
+
+// newPasswordlessCode generates the code of a passwordless login: a code of loginCodeDigits digits to type in, or
+// a random code long enough for a link.
+func newPasswordlessCode(method string) (string, error) {
+	if method == model.PasswordlessMethodLink {
+		b, err := randomBytes(32)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.Pow10(loginCodeDigits))))
+	if err != nil {
+		return "", fmt.Errorf("generate login code: %w", err)
+	}
+	return fmt.Sprintf("%0*d", loginCodeDigits, n.Int64()), nil
+}
+
+// hashPasswordlessChallengeID hashes the ID of a passwordless challenge for storage.
+func hashPasswordlessChallengeID(id string) model.PasswordlessChallengeHash {
+	sum := sha256.Sum256([]byte(id))
+	return model.PasswordlessChallengeHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
+
+// hashPasswordlessCode hashes the code of a passwordless challenge with its ID. The ID is random and only stored
+// hashed, so the few possible login codes cannot be tried against a stored hash.
+func hashPasswordlessCode(id, code string) string {
+	sum := sha256.Sum256([]byte(id + "." + code))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,230 @@
+package authservice_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// startPasswordlessLogin starts a passwordless login of the member and returns the challenge ID and the code
+// delivered to them, taken from the login link for the link method.
+func startPasswordlessLogin(t *testing.T, f *oauthFixture, user *usermodel.User, method string) (string, string) {
+	t.Helper()
+	f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil)
+	res, err := f.svc.StartPasswordlessLogin(context.Background(), user.Email, method, "1.2.3.4")
+	require.NoError(t, err)
+
+	notifications := f.logins.Notifications()
+	require.NotEmpty(t, notifications)
+	notification := notifications[len(notifications)-1]
+	if method == model.PasswordlessMethodCode {
+		return res.ChallengeID, notification.Code
+	}
+	link, err := url.Parse(notification.Link)
+	require.NoError(t, err)
+	assert.Equal(t, res.ChallengeID, link.Query().Get("challenge"))
+	return link.Query().Get("challenge"), link.Query().Get("code")
+}
+
+// TestUnitStartPasswordlessLogin tests that only active members are sent a code or link, that requests for other
+// emails look the same, and that login emails are throttled.
+func TestUnitStartPasswordlessLogin(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusActive}
+
+	t.Run("code", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+
+		start := time.Now()
+		res, err := f.svc.StartPasswordlessLogin(ctx, " User@Example.com ", model.PasswordlessMethodCode, "1.2.3.4")
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+		assert.NotEmpty(t, res.ChallengeID)
+		assert.Equal(t, model.PasswordlessMethodCode, res.Method)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), res.ExpiresAt, 5*time.Second)
+
+		notifications := f.logins.Notifications()
+		require.Len(t, notifications, 1)
+		assert.Equal(t, user.Email, notifications[0].To)
+		assert.Regexp(t, `^[0-9]{6}$`, notifications[0].Code)
+		assert.Empty(t, notifications[0].Link)
+		assert.Equal(t, res.ExpiresAt, notifications[0].ExpiresAt)
+
+		_, err = f.svc.StartPasswordlessLogin(ctx, user.Email, model.PasswordlessMethodLink, "1.2.3.4")
+		var throttled *authservice.LoginThrottledError
+		require.ErrorAs(t, err, &throttled)
+		assert.Positive(t, throttled.RetryAfter)
+		assert.Len(t, f.logins.Notifications(), 1)
+	})
+
+	t.Run("link", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+
+		res, err := f.svc.StartPasswordlessLogin(ctx, user.Email, model.PasswordlessMethodLink, "1.2.3.4")
+		require.NoError(t, err)
+
+		notifications := f.logins.Notifications()
+		require.Len(t, notifications, 1)
+		assert.Empty(t, notifications[0].Code)
+		link, err := url.Parse(notifications[0].Link)
+		require.NoError(t, err)
+		assert.Equal(t, "app.example.com", link.Host)
+		assert.Equal(t, "/login/email", link.Path)
+		assert.Equal(t, res.ChallengeID, link.Query().Get("challenge"))
+		assert.GreaterOrEqual(t, len(link.Query().Get("code")), 43, "link codes must not be guessable")
+	})
+
+	pending := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusPendingVerification}
+	tests := []struct {
+		name       string
+		user       *usermodel.User
+		gatewayErr error
+		wantErr    error
+	}{
+		{name: "unknown email", gatewayErr: gateway.ErrUserNotFound},
+		{name: "disabled member", gatewayErr: gateway.ErrAccountDisabled},
+		{name: "member pending verification", user: pending},
+		{name: "user service unavailable", gatewayErr: gateway.ErrUnavailable, wantErr: authservice.ErrDependencyUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			f.userGateway.On("GetUser", mock.Anything, user.Email).Return(tt.user, tt.gatewayErr).Once()
+
+			start := time.Now()
+			res, err := f.svc.StartPasswordlessLogin(ctx, user.Email, model.PasswordlessMethodCode, "1.2.3.4")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.NotEmpty(t, res.ChallengeID, "the response must not tell whether a code was sent")
+				_, err = f.svc.CompletePasswordlessLogin(ctx, res.ChallengeID, "000000", nil, "ua", "1.2.3.4")
+				assert.ErrorIs(t, err, authservice.ErrInvalidLoginChallenge)
+			}
+			assert.GreaterOrEqual(t, time.Since(start), time.Second, "the response time must not tell whether a code was sent")
+			assert.Empty(t, f.logins.Notifications())
+		})
+	}
+}
+
+// TestUnitCompletePasswordlessLogin tests that passwordless logins issue the same tokens and refresh session as
+// password logins, once, and that wrong codes are counted.
+func TestUnitCompletePasswordlessLogin(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusActive, EmailVerified: true, Scopes: []string{"user:read", "order:read"}}
+
+	for _, method := range []string{model.PasswordlessMethodCode, model.PasswordlessMethodLink} {
+		t.Run(method, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			challengeID, code := startPasswordlessLogin(t, f, user, method)
+
+			res, err := f.svc.CompletePasswordlessLogin(ctx, challengeID, code, []string{"order:read"}, "ua", "1.2.3.4")
+			require.NoError(t, err)
+			assert.Nil(t, res.MFAChallenge)
+			assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+			assert.Equal(t, model.RefreshToken("refresh-token"), res.RefreshToken)
+			assert.Equal(t, []string{"order:read"}, res.Scopes)
+
+			wantGrant := model.AccessTokenGrant{
+				Scopes:        []string{"order:read"},
+				AuthMethods:   []string{model.AuthMethodEmail},
+				EmailVerified: &user.EmailVerified,
+			}
+			f.accessMock.AssertCalled(t, "CreateToken", user.Email, wantGrant)
+			session, err := f.refreshRepo.GetRefreshTokenSession(ctx, hashOf("refresh-token"))
+			require.NoError(t, err)
+			assert.Equal(t, user.Email, session.MemberID)
+			assert.Equal(t, wantGrant, session.Grant)
+
+			_, err = f.svc.CompletePasswordlessLogin(ctx, challengeID, code, nil, "ua", "1.2.3.4")
+			assert.ErrorIs(t, err, authservice.ErrInvalidLoginChallenge, "passwordless logins are single-use")
+		})
+	}
+
+	t.Run("second factor", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		secret := f.mfa.enableTOTP(t)
+		challengeID, code := startPasswordlessLogin(t, f, user, model.PasswordlessMethodCode)
+
+		res, err := f.svc.CompletePasswordlessLogin(ctx, challengeID, code, nil, "ua", "1.2.3.4")
+		require.NoError(t, err)
+		require.NotNil(t, res.MFAChallenge)
+		assert.Empty(t, res.AccessToken)
+
+		res, err = f.svc.LoginWithMFA(ctx, res.MFAChallenge.Token, nextTOTPCode(secret), "ua", "1.2.3.4")
+		require.NoError(t, err)
+		assert.Equal(t, model.AccessToken("access-token"), res.AccessToken)
+		f.accessMock.AssertCalled(t, "CreateToken", user.Email, model.AccessTokenGrant{
+			Scopes:        user.Scopes,
+			AuthMethods:   []string{model.AuthMethodEmail, model.AuthMethodOTP},
+			EmailVerified: &user.EmailVerified,
+		})
+	})
+
+	t.Run("scope not allowed", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		challengeID, code := startPasswordlessLogin(t, f, user, model.PasswordlessMethodCode)
+
+		_, err := f.svc.CompletePasswordlessLogin(ctx, challengeID, code, []string{"admin"}, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidScope)
+
+		_, err = f.svc.CompletePasswordlessLogin(ctx, challengeID, code, nil, "ua", "1.2.3.4")
+		assert.NoError(t, err, "a rejected scope must not use up the login")
+	})
+
+	t.Run("wrong codes", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		challengeID, code := startPasswordlessLogin(t, f, user, model.PasswordlessMethodCode)
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+
+		// Wrong codes also lock the email out like failed logins, so lockouts are waited out.
+		for failures := 0; failures < 5; {
+			_, err := f.svc.CompletePasswordlessLogin(ctx, challengeID, wrong, nil, "ua", "1.2.3.4")
+			var throttled *authservice.LoginThrottledError
+			if errors.As(err, &throttled) {
+				time.Sleep(throttled.RetryAfter)
+				continue
+			}
+			require.ErrorIs(t, err, authservice.ErrInvalidLoginCode)
+			failures++
+		}
+
+		_, err := f.svc.CompletePasswordlessLogin(ctx, challengeID, code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidLoginChallenge, "the login must be discarded after too many wrong codes")
+	})
+
+	t.Run("member disabled since", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(nil, gateway.ErrAccountDisabled).Once()
+		res, err := f.svc.StartPasswordlessLogin(ctx, user.Email, model.PasswordlessMethodCode, "1.2.3.4")
+		require.NoError(t, err)
+		notifications := f.logins.Notifications()
+		require.Len(t, notifications, 1)
+
+		_, err = f.svc.CompletePasswordlessLogin(ctx, res.ChallengeID, notifications[0].Code, nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrAccountDisabled)
+	})
+
+	t.Run("unknown challenge", func(t *testing.T) {
+		f := newOAuthFixture(user)
+
+		_, err := f.svc.CompletePasswordlessLogin(ctx, "unknown", "123456", nil, "ua", "1.2.3.4")
+		assert.ErrorIs(t, err, authservice.ErrInvalidLoginChallenge)
+	})
+}
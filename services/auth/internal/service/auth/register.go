@@ -0,0 +1,88 @@
+// Package authservice defines the registration of the auth API.
+package authservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+	"unicode/utf8"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+)
+
+const (
+	// minPasswordLength is the fewest characters a password may have.
+	minPasswordLength = 8
+	// maxPasswordLength is the most bytes a password may have, as bcrypt ignores the bytes after them.
+	maxPasswordLength = 72
+	// maxEmailLength is the longest email address that can be delivered to (RFC 5321 section 4.5.3.1).
+	maxEmailLength = 254
+	// registrationMinDuration is the least time a registration takes, so that the response time does not tell
+	// whether the email was taken.
+	registrationMinDuration = 250 * time.Millisecond
+)
+
+// Register creates a member with an email and a password through the user service, pending verification of their
+// email, and emails them a verification link. Registering with the email of an existing member fails with
+// ErrUserAlreadyExists, after as long as a successful registration.
+// The member exists even if the verification email cannot be sent; they can then ask for another one.
+func (s *Service) Register(ctx context.Context, email, password string) (*RegisterResult, error) {
+	email, err := validateRegistration(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	defer waitUntil(ctx, time.Now().Add(registrationMinDuration))
+
+	user, err := s.userGateway.CreateUser(ctx, email, password)
+	if err != nil {
+		switch {
+		case errors.Is(err, gateway.ErrUserAlreadyExists):
+			return nil, ErrUserAlreadyExists
+		case errors.Is(err, gateway.ErrUnavailable):
+			return nil, fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+		}
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	res := &RegisterResult{Email: user.Email}
+	if _, err := s.verifications.RecordVerificationEmail(ctx, user.Email, verificationEmailInterval); err != nil {
+		res.VerificationEmailErr = fmt.Errorf("record verification email: %w", err)
+		return res, nil
+	}
+	res.VerificationEmailErr = s.sendVerificationEmail(ctx, user.Email)
+	return res, nil
+}
+
+// validateRegistration checks an email and password against the password policy and returns the normalized email.
+func validateRegistration(email, password string) (string, error) {
+	email = normalizeEmail(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+	if err := validatePassword(password); err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// validatePassword checks a new password against the password policy.
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// waitUntil blocks until t, or until ctx is done.
+func waitUntil(ctx context.Context, t time.Time) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,81 @@
+package authservice_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnitRegister tests that members register with a valid email and password, pending verification of their
+// email, and that a taken email fails no faster than a successful registration.
+func TestUnitRegister(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "2", Email: "new@example.com", Status: usermodel.StatusPendingVerification, Scopes: []string{"user:read"}}
+	password := "correct horse battery staple"
+
+	t.Run("success", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("CreateUser", mock.Anything, user.Email, password).Return(user, nil).Once()
+
+		res, err := f.svc.Register(ctx, "  New@Example.com ", password)
+		require.NoError(t, err)
+		assert.Equal(t, user.Email, res.Email)
+		assert.NoError(t, res.VerificationEmailErr)
+		f.accessMock.AssertNotCalled(t, "CreateToken", mock.Anything, mock.Anything)
+
+		mails := f.outbox.Mails()
+		require.Len(t, mails, 1)
+		assert.Equal(t, user.Email, mails[0].To)
+		assert.Equal(t, "Verify your email", mails[0].Subject)
+		assert.Regexp(t, verifyLinkPattern, mails[0].Body)
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("CreateUser", mock.Anything, user.Email, password).Return(nil, gateway.ErrUserAlreadyExists).Once()
+
+		start := time.Now()
+		_, err := f.svc.Register(ctx, user.Email, password)
+		assert.ErrorIs(t, err, authservice.ErrUserAlreadyExists)
+		assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond, "a taken email must not answer faster")
+		assert.Empty(t, f.outbox.Mails())
+	})
+
+	t.Run("user service unavailable", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("CreateUser", mock.Anything, user.Email, password).Return(nil, gateway.ErrUnavailable).Once()
+
+		_, err := f.svc.Register(ctx, user.Email, password)
+		assert.ErrorIs(t, err, authservice.ErrDependencyUnavailable)
+	})
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantErr  error
+	}{
+		{name: "malformed email", email: "new.example.com", password: password, wantErr: authservice.ErrInvalidEmail},
+		{name: "email with display name", email: "New <new@example.com>", password: password, wantErr: authservice.ErrInvalidEmail},
+		{name: "email too long", email: strings.Repeat("a", 250) + "@example.com", password: password, wantErr: authservice.ErrInvalidEmail},
+		{name: "password too short", email: user.Email, password: "short", wantErr: authservice.ErrInvalidPassword},
+		{name: "password too long", email: user.Email, password: strings.Repeat("a", 73), wantErr: authservice.ErrInvalidPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+
+			_, err := f.svc.Register(ctx, tt.email, tt.password)
+			assert.ErrorIs(t, err, tt.wantErr)
+			f.userGateway.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
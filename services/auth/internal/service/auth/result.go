@@ -1,13 +1,118 @@
 // Package authservice defines the result for the auth API.
 package authservice
 
-import "github.com/incheat/go-production-backend/services/auth/pkg/model"
+import (
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
 
 // LoginResult is the result for the login API.
 type LoginResult struct {
 	AccessToken      model.AccessToken
+	AccessExpiresAt  time.Time
+	TokenType        string // model.TokenTypeDPoP when the tokens are bound to the DPoP key of the request
 	RefreshToken     model.RefreshToken
 	RefreshMaxAgeSec int
 	RefreshEndPoint  string
 	RefreshCookie    string
+	Scopes           []string      // granted to the access token
+	IDToken          model.IDToken // issued to OAuth clients granted the openid scope
+	// MFAChallenge is set instead of the tokens when the login must be completed with a second factor.
+	MFAChallenge *MFAChallengeResult
+}
+
+// MFAChallengeResult is a login waiting for its second factor.
+type MFAChallengeResult struct {
+	Token     string
+	Methods   []string // second factors the login can be completed with
+	ExpiresAt time.Time
+}
+
+// PasswordlessChallengeResult is a passwordless login waiting for the code or link emailed to the member.
+type PasswordlessChallengeResult struct {
+	ChallengeID string // completes the login together with the emailed code
+	Method      string // model.PasswordlessMethodLink or model.PasswordlessMethodCode
+	ExpiresAt   time.Time
+}
+
+// FederatedLoginResult is a login through an upstream identity provider, waiting for the member to come back
+// from it.
+type FederatedLoginResult struct {
+	AuthorizationURL string // URL of the identity provider to send the member to
+	Binding          string // secret of the browser that started the login, to be kept in a cookie
+	ExpiresAt        time.Time
+}
+
+// AuthorizationResult is the result of an authorization request a member has logged in for.
+type AuthorizationResult struct {
+	Code      string // authorization code to redirect to the client with
+	ExpiresAt time.Time
+	// MFAChallenge is set instead of the code when the login must be completed with a second factor.
+	MFAChallenge *MFAChallengeResult
+}
+
+// RegisterResult is the result for the register API.
+type RegisterResult struct {
+	Email string // normalized, the member ID
+	// VerificationEmailErr is set when the member was created but their verification email could not be sent.
+	VerificationEmailErr error
+}
+
+// DeviceAuthorizationResult is a started device authorization (RFC 8628 section 3.2).
+type DeviceAuthorizationResult struct {
+	DeviceCode string // polled for by the client
+	UserCode   string // entered by the member on the verification page, formatted as XXXX-XXXX
+	ExpiresAt  time.Time
+	Interval   time.Duration
+}
+
+// DeviceVerificationResult is a device authorization waiting for a member to approve it.
+type DeviceVerificationResult struct {
+	UserCode string // formatted as XXXX-XXXX
+	Client   *model.OAuthClient
+	Scopes   []string // requested
+}
+
+// IntrospectionResult is the state of an introspected token (RFC 7662 section 2.2). Only Active is set for an
+// inactive token.
+type IntrospectionResult struct {
+	Active    bool
+	TokenType string // access_token or refresh_token
+	Subject   string
+	ClientID  string // empty for tokens of first-party logins
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	JKT       string // thumbprint of the DPoP key the token is bound to; empty for bearer tokens
+}
+
+// UserInfoResult is the result for the OpenID Connect user info API.
+type UserInfoResult struct {
+	Subject       string
+	Email         string // only with the email scope
+	EmailVerified bool
+}
+
+// TOTPEnrollmentResult is the result for the TOTP enrollment API.
+type TOTPEnrollmentResult struct {
+	Secret string // base32 encoded
+	URI    string // otpauth:// URI
+}
+
+// PasskeyCeremonyResult is a started passkey registration or login.
+type PasskeyCeremonyResult struct {
+	CeremonyID string
+	Options    []byte // JSON options for navigator.credentials.create() or get()
+	ExpiresAt  time.Time
+}
+
+// SessionResult is the result for the session listing API.
+type SessionResult struct {
+	ID        string
+	UserAgent string
+	IPAddress string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Current   bool
 }
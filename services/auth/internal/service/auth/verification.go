@@ -0,0 +1,167 @@
+// Package authservice defines the email verification of the auth API.
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	"github.com/incheat/go-production-backend/services/auth/internal/repository"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+const (
+	// emailVerificationTTL is how long a member has to follow the link of a verification email.
+	emailVerificationTTL = 24 * time.Hour
+	// verificationEmailInterval is the least time between two verification emails to the same email.
+	verificationEmailInterval = time.Minute
+	// emailVerificationPurpose is signed with the verification tokens, so that the signature of another kind of
+	// link cannot be passed off as theirs.
+	emailVerificationPurpose = "email-verification"
+)
+
+// EmailVerificationRepository is the interface for the repository of pending email verifications.
+type EmailVerificationRepository interface {
+	SaveEmailVerification(ctx context.Context, verification *model.EmailVerification) error
+	ConsumeEmailVerification(ctx context.Context, idHash model.EmailVerificationHash) (*model.EmailVerification, error)
+	RecordVerificationEmail(ctx context.Context, email string, interval time.Duration) (time.Duration, error)
+}
+
+// LinkSigner is the interface for the signer of the tokens of the links emailed to members.
+type LinkSigner interface {
+	Sign(data string) string
+	Verify(data, signature string) bool
+}
+
+// VerifyEmail verifies the email of a member with the token of a verification email, once, which activates
+// members pending verification.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	id, ok := s.parseVerificationToken(token, time.Now())
+	if !ok {
+		return ErrInvalidVerificationToken
+	}
+	verification, err := s.verifications.ConsumeEmailVerification(ctx, hashEmailVerificationID(id))
+	if errors.Is(err, repository.ErrEmailVerificationNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return fmt.Errorf("consume email verification: %w", err)
+	}
+	if verification.IsExpired(time.Now()) {
+		return ErrInvalidVerificationToken
+	}
+
+	_, err = s.userGateway.VerifyEmail(ctx, verification.Email)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound):
+		return ErrInvalidVerificationToken
+	case errors.Is(err, gateway.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("verify email: %w", err)
+	}
+	return nil
+}
+
+// ResendVerificationEmail emails a new verification link to the member with an email, if they have not verified
+// it yet. Emails are throttled per email address, whether it belongs to a member or not, and a throttled request
+// fails with a *VerificationEmailThrottledError. Like ForgotPassword, it otherwise succeeds after the same time
+// whether an email was sent or not.
+func (s *Service) ResendVerificationEmail(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	retryAfter, err := s.verifications.RecordVerificationEmail(ctx, email, verificationEmailInterval)
+	if err != nil {
+		return fmt.Errorf("record verification email: %w", err)
+	}
+	if retryAfter > 0 {
+		return &VerificationEmailThrottledError{RetryAfter: retryAfter}
+	}
+
+	defer waitUntil(ctx, time.Now().Add(accountEmailMinDuration))
+
+	user, err := s.userGateway.GetUser(ctx, email)
+	switch {
+	case errors.Is(err, gateway.ErrUserNotFound), errors.Is(err, gateway.ErrAccountDisabled), errors.Is(err, gateway.ErrAccountLocked):
+		return nil
+	case errors.Is(err, gateway.ErrUnavailable):
+		return fmt.Errorf("%w: %w", ErrDependencyUnavailable, err)
+	case err != nil:
+		return fmt.Errorf("get user: %w", err)
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	return s.sendVerificationEmail(ctx, user.Email)
+}
+
+// sendVerificationEmail emails a member a link to verify their email.
+func (s *Service) sendVerificationEmail(ctx context.Context, email string) error {
+	b, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	verification := &model.EmailVerification{
+		IDHash:    hashEmailVerificationID(id),
+		Email:     email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTTL),
+	}
+	if err := s.verifications.SaveEmailVerification(ctx, verification); err != nil {
+		return fmt.Errorf("save email verification: %w", err)
+	}
+
+	link, err := linkWithToken(s.links.VerifyEmail, s.verificationToken(id, verification.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	err = s.mailer.Send(ctx, model.Mail{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Follow this link within %d hours to verify your email and activate your account:\n\n%s\n\n"+
+			"If you did not create an account, ignore this email.\n",
+			int(emailVerificationTTL/time.Hour), link),
+	})
+	if err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+	return nil
+}
+
+// verificationToken formats the token of a verification link as "<id>.<expiry>.<signature>", the expiry in Unix
+// seconds.
+func (s *Service) verificationToken(id string, expiresAt time.Time) string {
+	payload := id + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return payload + "." + s.linkSigner.Sign(emailVerificationPurpose+"."+payload)
+}
+
+// parseVerificationToken returns the verification ID of a token that is correctly signed and not yet expired.
+func (s *Service) parseVerificationToken(token string, now time.Time) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	id, expiry, signature := parts[0], parts[1], parts[2]
+	if !s.linkSigner.Verify(emailVerificationPurpose+"."+id+"."+expiry, signature) {
+		return "", false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", false
+	}
+	return id, true
+}
+
+// hashEmailVerificationID hashes the ID of an email verification for storage.
+func hashEmailVerificationID(id string) model.EmailVerificationHash {
+	sum := sha256.Sum256([]byte(id))
+	return model.EmailVerificationHash(base64.RawURLEncoding.EncodeToString(sum[:]))
+}
@@ -0,0 +1,165 @@
+package authservice_test
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/gateway"
+	authservice "github.com/incheat/go-production-backend/services/auth/internal/service/auth"
+	usermodel "github.com/incheat/go-production-backend/services/user/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyLinkPattern matches the verification link of a verification email.
+var verifyLinkPattern = regexp.MustCompile(`https://app\.example\.com/verify-email\?token=\S+`)
+
+// requestVerificationEmail asks for a verification email for the member and returns the token emailed to them.
+func requestVerificationEmail(t *testing.T, f *oauthFixture, user *usermodel.User) string {
+	t.Helper()
+	f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+	require.NoError(t, f.svc.ResendVerificationEmail(context.Background(), user.Email))
+
+	mails := f.outbox.Mails()
+	require.NotEmpty(t, mails)
+	link, err := url.Parse(verifyLinkPattern.FindString(mails[len(mails)-1].Body))
+	require.NoError(t, err)
+	token := link.Query().Get("token")
+	require.NotEmpty(t, token)
+	return token
+}
+
+// TestUnitResendVerificationEmail tests that only members pending verification are emailed a verification link,
+// that requests for other emails take as long, and that emails are throttled.
+func TestUnitResendVerificationEmail(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusPendingVerification}
+
+	t.Run("member pending verification", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("GetUser", mock.Anything, user.Email).Return(user, nil).Once()
+
+		start := time.Now()
+		require.NoError(t, f.svc.ResendVerificationEmail(ctx, " User@Example.com "))
+		assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+		mails := f.outbox.Mails()
+		require.Len(t, mails, 1)
+		assert.Equal(t, user.Email, mails[0].To)
+		assert.Equal(t, "Verify your email", mails[0].Subject)
+		assert.Regexp(t, verifyLinkPattern, mails[0].Body)
+
+		err := f.svc.ResendVerificationEmail(ctx, user.Email)
+		var throttled *authservice.VerificationEmailThrottledError
+		require.ErrorAs(t, err, &throttled)
+		assert.ErrorIs(t, err, authservice.ErrTooManyVerificationEmails)
+		assert.Positive(t, throttled.RetryAfter)
+		assert.Len(t, f.outbox.Mails(), 1)
+	})
+
+	t.Run("after registration", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		f.userGateway.On("CreateUser", mock.Anything, user.Email, "correct horse battery staple").Return(user, nil).Once()
+		_, err := f.svc.Register(ctx, user.Email, "correct horse battery staple")
+		require.NoError(t, err)
+
+		err = f.svc.ResendVerificationEmail(ctx, user.Email)
+		assert.ErrorIs(t, err, authservice.ErrTooManyVerificationEmails, "the registration email counts")
+	})
+
+	verified := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusActive, EmailVerified: true}
+	tests := []struct {
+		name       string
+		user       *usermodel.User
+		gatewayErr error
+		wantErr    error
+	}{
+		{name: "email already verified", user: verified},
+		{name: "unknown email", gatewayErr: gateway.ErrUserNotFound},
+		{name: "disabled member", gatewayErr: gateway.ErrAccountDisabled},
+		{name: "user service unavailable", gatewayErr: gateway.ErrUnavailable, wantErr: authservice.ErrDependencyUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOAuthFixture(user)
+			f.userGateway.On("GetUser", mock.Anything, user.Email).Return(tt.user, tt.gatewayErr).Once()
+
+			start := time.Now()
+			err := f.svc.ResendVerificationEmail(ctx, user.Email)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.GreaterOrEqual(t, time.Since(start), time.Second, "the response time must not tell whether an email was sent")
+			assert.Empty(t, f.outbox.Mails())
+		})
+	}
+}
+
+// TestUnitVerifyEmail tests that verification tokens verify the email of the member once, and that tokens that
+// were tampered with are refused before they reach the user service.
+func TestUnitVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	user := &usermodel.User{ID: "1", Email: "user@example.com", Status: usermodel.StatusPendingVerification}
+	verified := &usermodel.User{ID: "1", Email: user.Email, Status: usermodel.StatusActive, EmailVerified: true}
+
+	t.Run("success", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestVerificationEmail(t, f, user)
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(verified, nil).Once()
+
+		require.NoError(t, f.svc.VerifyEmail(ctx, token))
+		f.userGateway.AssertCalled(t, "VerifyEmail", mock.Anything, user.Email)
+
+		err := f.svc.VerifyEmail(ctx, token)
+		assert.ErrorIs(t, err, authservice.ErrInvalidVerificationToken, "verification tokens are single-use")
+	})
+
+	t.Run("member deleted since", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestVerificationEmail(t, f, user)
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(nil, gateway.ErrUserNotFound).Once()
+
+		err := f.svc.VerifyEmail(ctx, token)
+		assert.ErrorIs(t, err, authservice.ErrInvalidVerificationToken)
+	})
+
+	t.Run("user service unavailable", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestVerificationEmail(t, f, user)
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(nil, gateway.ErrUnavailable).Once()
+
+		err := f.svc.VerifyEmail(ctx, token)
+		assert.ErrorIs(t, err, authservice.ErrDependencyUnavailable)
+	})
+
+	t.Run("tampered tokens", func(t *testing.T) {
+		f := newOAuthFixture(user)
+		token := requestVerificationEmail(t, f, user)
+		parts := strings.Split(token, ".")
+		require.Len(t, parts, 3)
+
+		tampered := []string{
+			"",
+			"unknown",
+			parts[0] + "." + parts[1],
+			parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])),
+			parts[0] + "." + "9999999999" + "." + parts[2],
+			"other-id." + parts[1] + "." + parts[2],
+		}
+		for _, token := range tampered {
+			err := f.svc.VerifyEmail(ctx, token)
+			assert.ErrorIs(t, err, authservice.ErrInvalidVerificationToken, token)
+		}
+		f.userGateway.AssertNotCalled(t, "VerifyEmail", mock.Anything, mock.Anything)
+
+		f.userGateway.On("VerifyEmail", mock.Anything, user.Email).Return(verified, nil).Once()
+		assert.NoError(t, f.svc.VerifyEmail(ctx, token), "a refused token must not use up the real one")
+	})
+}
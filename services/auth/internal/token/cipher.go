@@ -0,0 +1,90 @@
+// Package token defines the secret cipher for the auth service.
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncryptionKey is a versioned AES-256 key used to encrypt secrets at rest.
+type EncryptionKey struct {
+	Version string
+	Key     []byte
+}
+
+// SecretCipher encrypts secrets at rest, such as TOTP secrets, with AES-256-GCM.
+// Secrets are encrypted with the current key; previous keys are kept to decrypt
+// secrets encrypted before a key rotation.
+type SecretCipher struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewSecretCipher creates a new SecretCipher.
+func NewSecretCipher(current EncryptionKey, previous ...EncryptionKey) (*SecretCipher, error) {
+	c := &SecretCipher{current: current.Version, aeads: make(map[string]cipher.AEAD, len(previous)+1)}
+	for _, k := range append([]EncryptionKey{current}, previous...) {
+		if k.Version == "" || strings.Contains(k.Version, ":") {
+			return nil, errors.New("encryption key version must be non-empty and must not contain ':'")
+		}
+		if len(k.Key) != 32 {
+			return nil, errors.New("encryption key " + k.Version + " must be 32 bytes")
+		}
+		if _, ok := c.aeads[k.Version]; ok {
+			return nil, errors.New("duplicate encryption key version " + k.Version)
+		}
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", k.Version, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", k.Version, err)
+		}
+		c.aeads[k.Version] = aead
+	}
+	return c, nil
+}
+
+// Encrypt encrypts plaintext under the current key as "<version>:<base64url(nonce|ciphertext)>".
+// The additional data, e.g. the ID of the owner, is authenticated but not stored,
+// so a ciphertext cannot be moved to another owner.
+func (c *SecretCipher) Encrypt(plaintext, additionalData []byte) (string, error) {
+	aead := c.aeads[c.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+	return c.current + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt with the same additional data.
+func (c *SecretCipher) Decrypt(ciphertext string, additionalData []byte) ([]byte, error) {
+	version, encoded, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		return nil, errors.New("malformed ciphertext")
+	}
+	aead, ok := c.aeads[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key version %q", version)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode ciphertext: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt ciphertext: %w", err)
+	}
+	return plaintext, nil
+}
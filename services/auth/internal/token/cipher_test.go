@@ -0,0 +1,73 @@
+package token_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEncryptionKey(version string, b byte) token.EncryptionKey {
+	return token.EncryptionKey{Version: version, Key: bytes.Repeat([]byte{b}, 32)}
+}
+
+func TestUnitSecretCipher_EncryptDecrypt(t *testing.T) {
+	c, err := token.NewSecretCipher(testEncryptionKey("v1", 1))
+	require.NoError(t, err)
+
+	ciphertext, err := c.Encrypt([]byte("totp-secret"), []byte("member-1"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ciphertext, "v1:"))
+	assert.NotContains(t, ciphertext, "totp-secret")
+
+	other, err := c.Encrypt([]byte("totp-secret"), []byte("member-1"))
+	require.NoError(t, err)
+	assert.NotEqual(t, ciphertext, other, "nonce must be random")
+
+	plaintext, err := c.Decrypt(ciphertext, []byte("member-1"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("totp-secret"), plaintext)
+
+	_, err = c.Decrypt(ciphertext, []byte("member-2"))
+	assert.Error(t, err, "ciphertext must be bound to its additional data")
+
+	_, err = c.Decrypt("v1:"+strings.Repeat("A", 10), []byte("member-1"))
+	assert.Error(t, err)
+}
+
+func TestUnitSecretCipher_Rotation(t *testing.T) {
+	previous := testEncryptionKey("v1", 1)
+	old, err := token.NewSecretCipher(previous)
+	require.NoError(t, err)
+	ciphertext, err := old.Encrypt([]byte("totp-secret"), nil)
+	require.NoError(t, err)
+
+	c, err := token.NewSecretCipher(testEncryptionKey("v2", 2), previous)
+	require.NoError(t, err)
+	plaintext, err := c.Decrypt(ciphertext, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("totp-secret"), plaintext)
+
+	reencrypted, err := c.Encrypt(plaintext, nil)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(reencrypted, "v2:"))
+
+	current, err := token.NewSecretCipher(testEncryptionKey("v2", 2))
+	require.NoError(t, err)
+	_, err = current.Decrypt(ciphertext, nil)
+	assert.Error(t, err, "a retired key must no longer decrypt")
+}
+
+func TestUnitNewSecretCipher_Errors(t *testing.T) {
+	_, err := token.NewSecretCipher(token.EncryptionKey{Version: "v1", Key: []byte("short")})
+	assert.Error(t, err)
+	_, err = token.NewSecretCipher(testEncryptionKey("", 1))
+	assert.Error(t, err)
+	_, err = token.NewSecretCipher(testEncryptionKey("v:1", 1))
+	assert.Error(t, err)
+	_, err = token.NewSecretCipher(testEncryptionKey("v1", 1), testEncryptionKey("v1", 2))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+// Package token defines the refresh token hasher for the auth service.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+)
+
+// Pepper is a versioned server-side secret used to key refresh token hashes.
+type Pepper struct {
+	Version string
+	Secret  []byte
+}
+
+// RefreshTokenHasher hashes refresh tokens with HMAC-SHA256 so that only hashes are stored.
+// New tokens are hashed with the current pepper; previous peppers are kept for lookups
+// until every token hashed with them has expired.
+type RefreshTokenHasher struct {
+	current  Pepper
+	previous []Pepper
+}
+
+// NewRefreshTokenHasher creates a new RefreshTokenHasher.
+func NewRefreshTokenHasher(current Pepper, previous ...Pepper) (*RefreshTokenHasher, error) {
+	seen := make(map[string]bool, len(previous)+1)
+	for _, p := range append([]Pepper{current}, previous...) {
+		if p.Version == "" || strings.Contains(p.Version, ":") {
+			return nil, errors.New("refresh token pepper version must be non-empty and must not contain ':'")
+		}
+		if len(p.Secret) == 0 {
+			return nil, errors.New("refresh token pepper " + p.Version + " is empty")
+		}
+		if seen[p.Version] {
+			return nil, errors.New("duplicate refresh token pepper version " + p.Version)
+		}
+		seen[p.Version] = true
+	}
+	return &RefreshTokenHasher{current: current, previous: previous}, nil
+}
+
+// Hash returns the hash of a refresh token under the current pepper.
+func (h *RefreshTokenHasher) Hash(refreshToken model.RefreshToken) model.RefreshTokenHash {
+	return hashWithPepper(h.current, refreshToken)
+}
+
+// Candidates returns the hashes of a refresh token under every known pepper, current first.
+func (h *RefreshTokenHasher) Candidates(refreshToken model.RefreshToken) []model.RefreshTokenHash {
+	hashes := make([]model.RefreshTokenHash, 0, len(h.previous)+1)
+	hashes = append(hashes, hashWithPepper(h.current, refreshToken))
+	for _, p := range h.previous {
+		hashes = append(hashes, hashWithPepper(p, refreshToken))
+	}
+	return hashes
+}
+
+// hashWithPepper formats the hash as "<version>:<base64url(HMAC-SHA256)>".
+func hashWithPepper(p Pepper, refreshToken model.RefreshToken) model.RefreshTokenHash {
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write([]byte(refreshToken))
+	return model.RefreshTokenHash(p.Version + ":" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+}
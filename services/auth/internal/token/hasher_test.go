@@ -0,0 +1,65 @@
+package token_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitRefreshTokenHasher_Hash(t *testing.T) {
+	h, err := token.NewRefreshTokenHasher(token.Pepper{Version: "v2", Secret: []byte("pepper-2")})
+	require.NoError(t, err)
+
+	refreshToken := model.RefreshToken("raw-refresh-token")
+	hash := h.Hash(refreshToken)
+
+	assert.True(t, strings.HasPrefix(string(hash), "v2:"))
+	assert.NotContains(t, string(hash), string(refreshToken))
+	assert.Equal(t, hash, h.Hash(refreshToken), "hash must be deterministic")
+	assert.NotEqual(t, hash, h.Hash("other-refresh-token"))
+}
+
+func TestUnitRefreshTokenHasher_Candidates(t *testing.T) {
+	current := token.Pepper{Version: "v2", Secret: []byte("pepper-2")}
+	previous := token.Pepper{Version: "v1", Secret: []byte("pepper-1")}
+
+	h, err := token.NewRefreshTokenHasher(current, previous)
+	require.NoError(t, err)
+	old, err := token.NewRefreshTokenHasher(previous)
+	require.NoError(t, err)
+
+	refreshToken := model.RefreshToken("raw-refresh-token")
+	candidates := h.Candidates(refreshToken)
+
+	require.Len(t, candidates, 2)
+	assert.Equal(t, h.Hash(refreshToken), candidates[0])
+	assert.Equal(t, old.Hash(refreshToken), candidates[1])
+}
+
+func TestUnitNewRefreshTokenHasher_InvalidPeppers(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  token.Pepper
+		previous []token.Pepper
+	}{
+		{name: "empty version", current: token.Pepper{Secret: []byte("s")}},
+		{name: "version with separator", current: token.Pepper{Version: "v:1", Secret: []byte("s")}},
+		{name: "empty secret", current: token.Pepper{Version: "v1"}},
+		{
+			name:     "duplicate version",
+			current:  token.Pepper{Version: "v1", Secret: []byte("a")},
+			previous: []token.Pepper{{Version: "v1", Secret: []byte("b")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := token.NewRefreshTokenHasher(tt.current, tt.previous...)
+			assert.Error(t, err)
+		})
+	}
+}
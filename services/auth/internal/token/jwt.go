@@ -1,94 +1,243 @@
 package token
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/incheat/go-production-backend/services/auth/pkg/model"
 )
 
 // JWTMaker is a JWT maker.
 type JWTMaker struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
-	kid        string
-	issuer     string
-	audience   string
-	expire     time.Duration
+	keys     *Keyring
+	issuer   string
+	audience string
+	expire   time.Duration
 }
 
-// New creates a new JWTMaker from a PEM encoded private key.
-func New(privateKeyPEM, kid, issuer, audience string, expire time.Duration) (*JWTMaker, error) {
-	if privateKeyPEM == "" {
-		return nil, errors.New("JWT privateKeyPEM is empty")
+// New creates a new JWTMaker signing with the active key of keys.
+func New(keys *Keyring, issuer, audience string, expire time.Duration) (*JWTMaker, error) {
+	if keys == nil {
+		return nil, errors.New("JWT keyring is nil")
 	}
-	if kid == "" {
-		return nil, errors.New("JWT kid is empty")
-	}
-	block, _ := pem.Decode([]byte(privateKeyPEM))
-	if block == nil {
-		return nil, errors.New("failed to decode PEM")
-	}
-
-	var priv *rsa.PrivateKey
-	switch block.Type {
-	case "RSA PRIVATE KEY":
-		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		priv = key
-	case "PRIVATE KEY":
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		var ok bool
-		priv, ok = key.(*rsa.PrivateKey)
-		if !ok {
-			return nil, errors.New("PKCS8 key is not RSA")
-		}
-	default:
-		return nil, errors.New("unsupported PEM block type: " + block.Type)
-	}
-
 	return &JWTMaker{
-		privateKey: priv,
-		publicKey:  &priv.PublicKey,
-		kid:        kid,
-		issuer:     issuer,
-		audience:   audience,
-		expire:     expire,
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		expire:   expire,
 	}, nil
 }
 
-// CreateToken creates a new RS256 JWT token for a user.
-func (m *JWTMaker) CreateToken(ID string) (model.AccessToken, error) {
+// CreateToken creates a new JWT token for a user, signed with the active key (RS256, ES256 or EdDSA).
+// Every token carries a unique jti so that it can be revoked before it expires.
+// The scopes of grant are encoded as a space separated "scope" claim, its roles as "roles",
+// its audiences are added to the audience of the auth service, its authentication methods are encoded as "amr",
+// its OAuth client as "client_id" and whether the member verified their email as "email_verified".
+func (m *JWTMaker) CreateToken(ID string, grant model.AccessTokenGrant) (model.AccessToken, *model.AccessTokenClaims, error) {
 	now := time.Now()
+	tokenClaims := &model.AccessTokenClaims{
+		ID:            uuid.NewString(),
+		Subject:       ID,
+		Scopes:        slices.Clone(grant.Scopes),
+		Roles:         slices.Clone(grant.Roles),
+		Audiences:     m.audiences(grant.Audiences),
+		AuthMethods:   slices.Clone(grant.AuthMethods),
+		ClientID:      grant.ClientID,
+		IssuedAt:      now,
+		EmailVerified: cloneBool(grant.EmailVerified),
+		JKT:           grant.JKT,
+		ExpiresAt:     now.Add(m.expire),
+	}
 	claims := jwt.MapClaims{
+		"jti": tokenClaims.ID,
 		"sub": ID,
 		"iss": m.issuer,
-		"aud": m.audience, // ["user-api", "order-api", "auth-api"]
 		"iat": now.Unix(),
-		"exp": now.Add(m.expire).Unix(),
-		// "scope": "user:read order:read auth:read"
+		"exp": tokenClaims.ExpiresAt.Unix(),
+	}
+	if len(tokenClaims.Audiences) == 1 {
+		claims["aud"] = tokenClaims.Audiences[0]
+	} else {
+		claims["aud"] = tokenClaims.Audiences // ["auth-api", "user-api", "order-api"]
+	}
+	if len(tokenClaims.Scopes) > 0 {
+		claims["scope"] = strings.Join(tokenClaims.Scopes, " ") // "auth:read user:read order:read"
+	}
+	if len(tokenClaims.Roles) > 0 {
+		claims["roles"] = tokenClaims.Roles
+	}
+	if len(tokenClaims.AuthMethods) > 0 {
+		claims["amr"] = tokenClaims.AuthMethods // ["pwd", "otp"]
+	}
+	if tokenClaims.ClientID != "" {
+		claims["client_id"] = tokenClaims.ClientID // RFC 9068
+	}
+	if tokenClaims.EmailVerified != nil {
+		claims["email_verified"] = *tokenClaims.EmailVerified
+	}
+	if tokenClaims.JKT != "" {
+		claims["cnf"] = map[string]string{"jkt": tokenClaims.JKT} // RFC 9449 section 6.1
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	t.Header["kid"] = m.kid
-	tokenStr, err := t.SignedString(m.privateKey)
+	key := m.keys.activeKey()
+	t := jwt.NewWithClaims(key.method, claims)
+	t.Header["kid"] = key.kid
+	tokenStr, err := t.SignedString(key.privateKey)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	accessToken := model.AccessToken(tokenStr)
-	return accessToken, nil
+	return accessToken, tokenClaims, nil
+}
+
+// CreateIDToken creates an OpenID Connect ID token for a client, signed with the active key like access tokens.
+// Its issued and expiry times are set on claims; it expires with the access tokens issued with it.
+func (m *JWTMaker) CreateIDToken(claims *model.IDTokenClaims) (model.IDToken, error) {
+	now := time.Now()
+	claims.IssuedAt = now
+	claims.ExpiresAt = now.Add(m.expire)
+	mapClaims := jwt.MapClaims{
+		"iss":       m.issuer,
+		"sub":       claims.Subject,
+		"aud":       claims.Audience,
+		"iat":       now.Unix(),
+		"exp":       claims.ExpiresAt.Unix(),
+		"auth_time": claims.AuthTime.Unix(),
+	}
+	if claims.Nonce != "" {
+		mapClaims["nonce"] = claims.Nonce
+	}
+	if len(claims.AuthMethods) > 0 {
+		mapClaims["amr"] = claims.AuthMethods
+	}
+	if claims.Email != "" {
+		mapClaims["email"] = claims.Email
+		mapClaims["email_verified"] = claims.EmailVerified
+	}
+
+	key := m.keys.activeKey()
+	t := jwt.NewWithClaims(key.method, mapClaims)
+	t.Header["kid"] = key.kid
+	tokenStr, err := t.SignedString(key.privateKey)
+	if err != nil {
+		return "", err
+	}
+	return model.IDToken(tokenStr), nil
+}
+
+// SigningAlgorithms returns the algorithms of the published keys, as advertised in the OpenID provider metadata.
+func (m *JWTMaker) SigningAlgorithms() []string {
+	var algorithms []string
+	for _, key := range m.keys.publishedKeys() {
+		if alg := key.method.Alg(); !slices.Contains(algorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// jwtClaims are the claims of an access token as encoded in the JWT.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Scope    string   `json:"scope,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	AMR      []string `json:"amr,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	// EmailVerified is a pointer so that a token without the claim is told apart from an unverified email.
+	EmailVerified *bool `json:"email_verified,omitempty"`
+	// Confirmation binds the token to a DPoP key.
+	Confirmation *confirmation `json:"cnf,omitempty"`
+}
+
+// confirmation is the cnf claim of a DPoP bound access token (RFC 9449 section 6.1).
+type confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// ParseToken verifies an access token signed by any published key and returns its claims.
+func (m *JWTMaker) ParseToken(tokenStr string) (*model.AccessTokenClaims, error) {
+	claims := jwtClaims{}
+	_, err := jwt.ParseWithClaims(
+		tokenStr,
+		&claims,
+		m.verificationKey,
+		jwt.WithValidMethods(validMethods),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("JWT sub is empty")
+	}
+
+	tokenClaims := &model.AccessTokenClaims{
+		ID:            claims.ID,
+		Subject:       claims.Subject,
+		Scopes:        strings.Fields(claims.Scope),
+		Roles:         claims.Roles,
+		Audiences:     claims.Audience,
+		AuthMethods:   claims.AMR,
+		ClientID:      claims.ClientID,
+		ExpiresAt:     claims.ExpiresAt.Time,
+		EmailVerified: claims.EmailVerified,
+	}
+	if claims.IssuedAt != nil {
+		tokenClaims.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.Confirmation != nil {
+		tokenClaims.JKT = claims.Confirmation.JKT
+	}
+	return tokenClaims, nil
+}
+
+// audiences returns the audience of the auth service followed by extra, without duplicates.
+func (m *JWTMaker) audiences(extra []string) []string {
+	audiences := []string{m.audience}
+	for _, aud := range extra {
+		if aud != "" && !slices.Contains(audiences, aud) {
+			audiences = append(audiences, aud)
+		}
+	}
+	return audiences
+}
+
+// validMethods are the signing algorithms keyring keys may use.
+var validMethods = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// verificationKey resolves the public key of a token by its kid header.
+// The token's alg must match the algorithm of the key, so a key cannot be used with another algorithm.
+func (m *JWTMaker) verificationKey(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("JWT kid is empty")
+	}
+	key, ok := m.keys.verificationKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown JWT kid %q", kid)
+	}
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("JWT alg %s does not match kid %q", t.Method.Alg(), kid)
+	}
+	return key.privateKey.Public(), nil
 }
 
 // ---- JWKS ----
@@ -98,23 +247,34 @@ type jwks struct {
 }
 
 type jwkKey struct {
-	Kty string `json:"kty"` // "RSA"
+	Kty string `json:"kty"` // "RSA", "EC" or "OKP"
 	Use string `json:"use"` // "sig"
-	Alg string `json:"alg"` // "RS256"
+	Alg string `json:"alg"` // "RS256", "ES256" or "EdDSA"
 	Kid string `json:"kid"`
-	N   string `json:"n"`
-	E   string `json:"e"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"` // "P-256" or "Ed25519"
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
 }
 
-// JWKSJSON returns the JWKS JSON for the public key.
+// JWKSJSON returns the JWKS JSON for the published public keys.
 func (m *JWTMaker) JWKSJSON() ([]byte, error) {
+	published := m.keys.publishedKeys()
 	j := jwks{
-		Keys: []jwkKey{rsaPublicKeyToJWK(m.publicKey, m.kid)},
+		Keys: make([]jwkKey, 0, len(published)),
+	}
+	for _, key := range published {
+		jwk, err := publicKeyToJWK(key.privateKey.Public(), key.kid)
+		if err != nil {
+			return nil, err
+		}
+		j.Keys = append(j.Keys, jwk)
 	}
 	return json.Marshal(j)
 }
 
-// JWKSHandler returns the JWKS JSON for the public key.
+// JWKSHandler returns the JWKS JSON for the published public keys.
 func (m *JWTMaker) JWKSHandler(w http.ResponseWriter, _ *http.Request) {
 	b, err := m.JWKSJSON()
 	if err != nil {
@@ -125,6 +285,19 @@ func (m *JWTMaker) JWKSHandler(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write(b)
 }
 
+func publicKeyToJWK(pub crypto.PublicKey, kid string) (jwkKey, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsaPublicKeyToJWK(pub, kid), nil
+	case *ecdsa.PublicKey:
+		return ecPublicKeyToJWK(pub, kid)
+	case ed25519.PublicKey:
+		return ed25519PublicKeyToJWK(pub, kid), nil
+	default:
+		return jwkKey{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
 func rsaPublicKeyToJWK(pub *rsa.PublicKey, kid string) jwkKey {
 	n := base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
 	e := base64.RawURLEncoding.EncodeToString(intToBytes(pub.E))
@@ -138,6 +311,44 @@ func rsaPublicKeyToJWK(pub *rsa.PublicKey, kid string) jwkKey {
 	}
 }
 
+func ecPublicKeyToJWK(pub *ecdsa.PublicKey, kid string) (jwkKey, error) {
+	ecdhPub, err := pub.ECDH()
+	if err != nil {
+		return jwkKey{}, err
+	}
+	// Uncompressed point: 0x04 || X || Y, each coordinate padded to the curve size.
+	point := ecdhPub.Bytes()
+	size := (len(point) - 1) / 2
+	return jwkKey{
+		Kty: "EC",
+		Use: "sig",
+		Alg: "ES256",
+		Kid: kid,
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+	}, nil
+}
+
+func ed25519PublicKeyToJWK(pub ed25519.PublicKey, kid string) jwkKey {
+	return jwkKey{
+		Kty: "OKP",
+		Use: "sig",
+		Alg: "EdDSA",
+		Kid: kid,
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(pub),
+	}
+}
+
+func cloneBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
 func intToBytes(i int) []byte {
 	if i == 0 {
 		return []byte{0}
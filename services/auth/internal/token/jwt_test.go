@@ -0,0 +1,511 @@
+package token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer     = "auth-service"
+	testAudience   = "auth-api"
+	testExpire     = 15 * time.Minute
+	testPrePublish = 10 * time.Minute
+	testRetire     = 30 * time.Minute
+)
+
+func TestUnitJWTMaker_StaticKeyring(t *testing.T) {
+	keys, err := token.NewStaticKeyring(string(newKeyPEM(t)), "static")
+	require.NoError(t, err)
+	m, err := token.New(keys, testIssuer, testAudience, testExpire)
+	require.NoError(t, err)
+
+	accessToken, issued, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+	require.NoError(t, err)
+	assert.Equal(t, "static", kidOf(t, string(accessToken)))
+	assert.NotEmpty(t, issued.ID)
+
+	claims, err := m.ParseToken(string(accessToken))
+	require.NoError(t, err)
+	assert.Equal(t, "member-1", claims.Subject)
+	assert.Equal(t, issued.ID, claims.ID)
+	assert.Equal(t, issued.ExpiresAt.Unix(), claims.ExpiresAt.Unix())
+	assert.Equal(t, []string{"static"}, jwksKids(t, m))
+
+	_, other, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+	require.NoError(t, err)
+	assert.NotEqual(t, issued.ID, other.ID, "jti must be unique per token")
+}
+
+func TestUnitJWTMaker_Grant(t *testing.T) {
+	keys, err := token.NewStaticKeyring(string(newKeyPEM(t)), "static")
+	require.NoError(t, err)
+	m, err := token.New(keys, testIssuer, testAudience, testExpire)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		grant         model.AccessTokenGrant
+		wantAudiences []string
+		wantAudClaim  any
+	}{
+		{
+			name:          "empty grant",
+			wantAudiences: []string{testAudience},
+			wantAudClaim:  testAudience,
+		},
+		{
+			name: "scopes, roles, audiences, authentication methods, verified email and DPoP key",
+			grant: model.AccessTokenGrant{
+				Scopes:        []string{"auth:read", "order:read"},
+				Roles:         []string{"member", "support"},
+				Audiences:     []string{"user-api", testAudience, "order-api", "user-api"},
+				AuthMethods:   []string{model.AuthMethodPassword, model.AuthMethodOTP},
+				ClientID:      "web-app",
+				EmailVerified: new(bool),
+				JKT:           "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I",
+			},
+			wantAudiences: []string{testAudience, "user-api", "order-api"},
+			wantAudClaim:  []any{testAudience, "user-api", "order-api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessToken, issued, err := m.CreateToken("member-1", tt.grant)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAudiences, issued.Audiences)
+
+			payload := jwtPayload(t, string(accessToken))
+			assert.Equal(t, tt.wantAudClaim, payload["aud"])
+			if len(tt.grant.Scopes) > 0 {
+				assert.Equal(t, "auth:read order:read", payload["scope"])
+				assert.Equal(t, []any{"pwd", "otp"}, payload["amr"])
+				assert.Equal(t, "web-app", payload["client_id"])
+				assert.Equal(t, false, payload["email_verified"], "an unverified email is told apart from no claim")
+				assert.Equal(t, map[string]any{"jkt": tt.grant.JKT}, payload["cnf"])
+			} else {
+				assert.NotContains(t, payload, "scope")
+				assert.NotContains(t, payload, "roles")
+				assert.NotContains(t, payload, "amr")
+				assert.NotContains(t, payload, "client_id")
+				assert.NotContains(t, payload, "email_verified")
+				assert.NotContains(t, payload, "cnf")
+			}
+
+			claims, err := m.ParseToken(string(accessToken))
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.grant.Scopes, claims.Scopes)
+			assert.ElementsMatch(t, tt.grant.Roles, claims.Roles)
+			assert.Equal(t, tt.grant.AuthMethods, claims.AuthMethods)
+			assert.Equal(t, tt.grant.ClientID, claims.ClientID)
+			assert.Equal(t, tt.grant.EmailVerified, claims.EmailVerified)
+			assert.Equal(t, tt.grant.JKT, claims.JKT)
+			assert.Equal(t, tt.wantAudiences, claims.Audiences)
+		})
+	}
+}
+
+func TestUnitJWTMaker_IDToken(t *testing.T) {
+	keys, err := token.NewStaticKeyring(string(newKeyPEM(t)), "static")
+	require.NoError(t, err)
+	m, err := token.New(keys, testIssuer, testAudience, testExpire)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"RS256"}, m.SigningAlgorithms())
+
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	claims := &model.IDTokenClaims{
+		Subject:       "member-1",
+		Audience:      "web-app",
+		Nonce:         "n-0S6_WzA2Mj",
+		AuthTime:      authTime,
+		AuthMethods:   []string{"pwd"},
+		Email:         "member@example.com",
+		EmailVerified: true,
+	}
+	idToken, err := m.CreateIDToken(claims)
+	require.NoError(t, err)
+	assert.Equal(t, "static", kidOf(t, string(idToken)))
+	assert.WithinDuration(t, claims.IssuedAt.Add(testExpire), claims.ExpiresAt, time.Second)
+
+	payload := jwtPayload(t, string(idToken))
+	assert.Equal(t, testIssuer, payload["iss"])
+	assert.Equal(t, "member-1", payload["sub"])
+	assert.Equal(t, "web-app", payload["aud"])
+	assert.Equal(t, "n-0S6_WzA2Mj", payload["nonce"])
+	assert.Equal(t, float64(authTime.Unix()), payload["auth_time"])
+	assert.Equal(t, []any{"pwd"}, payload["amr"])
+	assert.Equal(t, "member@example.com", payload["email"])
+	assert.Equal(t, true, payload["email_verified"])
+
+	_, err = m.ParseToken(string(idToken))
+	assert.Error(t, err, "an ID token must not be accepted as an access token")
+
+	idToken, err = m.CreateIDToken(&model.IDTokenClaims{Subject: "member-1", Audience: "web-app", AuthTime: authTime})
+	require.NoError(t, err)
+	payload = jwtPayload(t, string(idToken))
+	assert.NotContains(t, payload, "nonce")
+	assert.NotContains(t, payload, "email")
+	assert.NotContains(t, payload, "email_verified")
+}
+
+func TestUnitJWTMaker_KeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	ecSEC1, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		pem     []byte
+		wantAlg string
+		wantJWK map[string]any
+	}{
+		{
+			name:    "RSA PKCS1",
+			pem:     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			wantAlg: "RS256",
+			wantJWK: map[string]any{"kty": "RSA", "alg": "RS256"},
+		},
+		{
+			name:    "P-256 SEC1",
+			pem:     pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSEC1}),
+			wantAlg: "ES256",
+			wantJWK: map[string]any{"kty": "EC", "alg": "ES256", "crv": "P-256"},
+		},
+		{
+			name:    "P-256 PKCS8",
+			pem:     pkcs8PEM(t, ecKey),
+			wantAlg: "ES256",
+			wantJWK: map[string]any{"kty": "EC", "alg": "ES256", "crv": "P-256"},
+		},
+		{
+			name:    "Ed25519 PKCS8",
+			pem:     pkcs8PEM(t, edKey),
+			wantAlg: "EdDSA",
+			wantJWK: map[string]any{"kty": "OKP", "alg": "EdDSA", "crv": "Ed25519"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := token.NewStaticKeyring(string(tt.pem), "kid-1")
+			require.NoError(t, err)
+			m, err := token.New(keys, testIssuer, testAudience, testExpire)
+			require.NoError(t, err)
+
+			accessToken, _, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(string(accessToken), jwt.MapClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAlg, parsed.Method.Alg())
+
+			claims, err := m.ParseToken(string(accessToken))
+			require.NoError(t, err)
+			assert.Equal(t, "member-1", claims.Subject)
+
+			b, err := m.JWKSJSON()
+			require.NoError(t, err)
+			var set struct {
+				Keys []map[string]any `json:"keys"`
+			}
+			require.NoError(t, json.Unmarshal(b, &set))
+			require.Len(t, set.Keys, 1)
+			jwk := set.Keys[0]
+			for field, want := range tt.wantJWK {
+				assert.Equal(t, want, jwk[field], field)
+			}
+			assert.Equal(t, "kid-1", jwk["kid"])
+
+			// The published JWK must verify the token on its own.
+			pub, err := jwkPublicKey(jwk)
+			require.NoError(t, err)
+			_, err = jwt.Parse(string(accessToken), func(*jwt.Token) (any, error) { return pub, nil })
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestUnitNewStaticKeyring_UnsupportedKeys(t *testing.T) {
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	_, err = token.NewStaticKeyring(string(pkcs8PEM(t, p384Key)), "kid-1")
+	assert.Error(t, err)
+}
+
+func TestUnitJWTMaker_ParseTokenRejectsAlgMismatch(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys, err := token.NewStaticKeyring(string(pkcs8PEM(t, ecKey)), "kid-1")
+	require.NoError(t, err)
+	m, err := token.New(keys, testIssuer, testAudience, testExpire)
+	require.NoError(t, err)
+
+	// An RS256 token carrying the kid of the ES256 key.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "member-1",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = "kid-1"
+	forgedStr, err := forged.SignedString(rsaKey)
+	require.NoError(t, err)
+
+	_, err = m.ParseToken(forgedStr)
+	assert.Error(t, err)
+}
+
+func TestUnitJWTMaker_KeyringRotation(t *testing.T) {
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name          string
+		keys          map[string]time.Time
+		wantActive    string
+		wantPublished []string
+	}{
+		{
+			name:          "first key is active immediately",
+			keys:          map[string]time.Time{"k1": now},
+			wantActive:    "k1",
+			wantPublished: []string{"k1"},
+		},
+		{
+			name: "new key is pre-published but not yet signing",
+			keys: map[string]time.Time{
+				"k1": now.Add(-24 * time.Hour),
+				"k2": now.Add(-testPrePublish / 2),
+			},
+			wantActive:    "k1",
+			wantPublished: []string{"k1", "k2"},
+		},
+		{
+			name: "new key signs after pre-publish and old key stays until retired",
+			keys: map[string]time.Time{
+				"k1": now.Add(-24 * time.Hour),
+				"k2": now.Add(-testPrePublish - testRetire/2),
+			},
+			wantActive:    "k2",
+			wantPublished: []string{"k1", "k2"},
+		},
+		{
+			name: "old key is dropped after the retire window",
+			keys: map[string]time.Time{
+				"k1": now.Add(-24 * time.Hour),
+				"k2": now.Add(-testPrePublish - testRetire - time.Minute),
+			},
+			wantActive:    "k2",
+			wantPublished: []string{"k2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for kid, createdAt := range tt.keys {
+				writeKeyFile(t, dir, kid, createdAt)
+			}
+
+			keys, err := token.LoadKeyring(dir, testPrePublish, testRetire)
+			require.NoError(t, err)
+			m, err := token.New(keys, testIssuer, testAudience, testExpire)
+			require.NoError(t, err)
+
+			accessToken, _, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantActive, kidOf(t, string(accessToken)))
+			assert.Equal(t, tt.wantActive, keys.ActiveKeyID())
+			assert.ElementsMatch(t, tt.wantPublished, jwksKids(t, m))
+		})
+	}
+}
+
+func TestUnitJWTMaker_ParseTokenAfterRotation(t *testing.T) {
+	now := time.Now().UTC()
+	dir := t.TempDir()
+	writeKeyFile(t, dir, "k1", now.Add(-24*time.Hour))
+
+	keys, err := token.LoadKeyring(dir, testPrePublish, testRetire)
+	require.NoError(t, err)
+	m, err := token.New(keys, testIssuer, testAudience, testExpire)
+	require.NoError(t, err)
+
+	oldToken, _, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+	require.NoError(t, err)
+
+	// Operator adds k2, which took over a few minutes ago.
+	writeKeyFile(t, dir, "k2", now.Add(-testPrePublish-time.Minute))
+	require.NoError(t, keys.Reload())
+
+	newToken, _, err := m.CreateToken("member-1", model.AccessTokenGrant{})
+	require.NoError(t, err)
+	assert.Equal(t, "k2", kidOf(t, string(newToken)))
+
+	for _, accessToken := range []string{string(oldToken), string(newToken)} {
+		claims, err := m.ParseToken(accessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "member-1", claims.Subject)
+	}
+
+	// Operator removes k1; tokens it signed are no longer accepted.
+	require.NoError(t, os.Remove(filepath.Join(dir, keyFileName("k1", now.Add(-24*time.Hour)))))
+	require.NoError(t, keys.Reload())
+
+	_, err = m.ParseToken(string(oldToken))
+	assert.Error(t, err)
+}
+
+func TestUnitLoadKeyring_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+	}{
+		{
+			name:  "empty directory",
+			setup: func(*testing.T, string) {},
+		},
+		{
+			name: "missing creation time",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), newKeyPEM(t), 0o600))
+			},
+		},
+		{
+			name: "invalid PEM",
+			setup: func(t *testing.T, dir string) {
+				name := keyFileName("k1", time.Now())
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("not a key"), 0o600))
+			},
+		},
+		{
+			name: "duplicate kid",
+			setup: func(t *testing.T, dir string) {
+				writeKeyFile(t, dir, "k1", time.Now().Add(-time.Hour))
+				writeKeyFile(t, dir, "k1", time.Now())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.setup(t, dir)
+
+			_, err := token.LoadKeyring(dir, testPrePublish, testRetire)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func newKeyPEM(t *testing.T) []byte {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func pkcs8PEM(t *testing.T, key any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// jwkPublicKey rebuilds a public key from a JWK, the way an external verifier would.
+func jwkPublicKey(jwk map[string]any) (any, error) {
+	field := func(name string) []byte {
+		s, _ := jwk[name].(string)
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return b
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(field("n")),
+			E: int(new(big.Int).SetBytes(field("e")).Int64()),
+		}, nil
+	case "EC":
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(field("x")),
+			Y:     new(big.Int).SetBytes(field("y")),
+		}, nil
+	case "OKP":
+		return ed25519.PublicKey(field("x")), nil
+	default:
+		return nil, errors.New("unsupported kty")
+	}
+}
+
+func keyFileName(kid string, createdAt time.Time) string {
+	return createdAt.UTC().Format("20060102T150405Z") + "_" + kid + ".pem"
+}
+
+func writeKeyFile(t *testing.T, dir, kid string, createdAt time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, keyFileName(kid, createdAt)), newKeyPEM(t), 0o600))
+}
+
+func kidOf(t *testing.T, accessToken string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(accessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func jwtPayload(t *testing.T, accessToken string) jwt.MapClaims {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(accessToken, claims)
+	require.NoError(t, err)
+	return claims
+}
+
+func jwksKids(t *testing.T, m *token.JWTMaker) []string {
+	t.Helper()
+	b, err := m.JWKSJSON()
+	require.NoError(t, err)
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+		} `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(b, &set))
+
+	kids := make([]string, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		kids = append(kids, key.Kid)
+	}
+	return kids
+}
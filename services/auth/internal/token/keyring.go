@@ -0,0 +1,241 @@
+// Package token defines the JWT signing keyring for the auth service.
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyFileTimeLayout is the layout of the creation time prefix of key file names.
+const keyFileTimeLayout = "20060102T150405Z"
+
+// signingKey is a private key of the keyring.
+type signingKey struct {
+	kid        string
+	privateKey crypto.Signer
+	method     jwt.SigningMethod
+	createdAt  time.Time
+}
+
+// Keyring holds one active signing key and the verification-only keys published next to it.
+//
+// Keys are ordered by creation time. A key is published in the JWKS as soon as it is created,
+// becomes the active signing key once it has been published for the pre-publish window
+// (so verifiers have fetched it before the first token signed with it arrives),
+// and stays published for the retire window after a newer key took over
+// (so tokens it signed can still be verified until they expire).
+type Keyring struct {
+	mu         sync.RWMutex
+	keys       []signingKey
+	dir        string
+	prePublish time.Duration
+	retire     time.Duration
+}
+
+// NewStaticKeyring creates a keyring holding a single, always active key.
+func NewStaticKeyring(privateKeyPEM, kid string) (*Keyring, error) {
+	if privateKeyPEM == "" {
+		return nil, errors.New("JWT privateKeyPEM is empty")
+	}
+	if kid == "" {
+		return nil, errors.New("JWT kid is empty")
+	}
+	priv, method, err := parsePrivateKeyPEM([]byte(privateKeyPEM))
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{
+		keys: []signingKey{{kid: kid, privateKey: priv, method: method}},
+	}, nil
+}
+
+// LoadKeyring creates a keyring from a directory of PEM files named "<created>_<kid>.pem",
+// where <created> is a UTC timestamp such as 20261018T000000Z.
+func LoadKeyring(dir string, prePublish, retire time.Duration) (*Keyring, error) {
+	k := &Keyring{
+		dir:        dir,
+		prePublish: prePublish,
+		retire:     retire,
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the key directory so that keys added or removed by operators are picked up.
+// A static keyring is left unchanged.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	keys, err := readKeyDir(k.dir)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// ActiveKeyID returns the kid of the key new tokens are signed with, which changes as keys rotate.
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeKey().kid
+}
+
+// activeKey returns the key new tokens are signed with.
+func (k *Keyring) activeKey() signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.activeIndex(time.Now())]
+}
+
+// publishedKeys returns the keys that must be served in the JWKS and accepted for verification.
+func (k *Keyring) publishedKeys() []signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	active := k.activeIndex(now)
+
+	published := make([]signingKey, 0, len(k.keys))
+	for i, key := range k.keys {
+		if i < active {
+			// Retired when its successor became active.
+			retiredAt := k.keys[i+1].createdAt.Add(k.prePublish)
+			if !now.Before(retiredAt.Add(k.retire)) {
+				continue
+			}
+		}
+		published = append(published, key)
+	}
+	return published
+}
+
+// verificationKey returns the published key with the given kid.
+func (k *Keyring) verificationKey(kid string) (signingKey, bool) {
+	for _, key := range k.publishedKeys() {
+		if key.kid == kid {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// activeIndex returns the index of the newest key whose pre-publish window has elapsed.
+// When no key is old enough yet (first deployment), the oldest key is used.
+func (k *Keyring) activeIndex(now time.Time) int {
+	for i := len(k.keys) - 1; i >= 0; i-- {
+		if !now.Before(k.keys[i].createdAt.Add(k.prePublish)) {
+			return i
+		}
+	}
+	return 0
+}
+
+// readKeyDir reads every "<created>_<kid>.pem" file of dir, sorted by creation time.
+func readKeyDir(dir string) ([]signingKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read JWT key directory: %w", err)
+	}
+
+	var keys []signingKey
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".pem" {
+			continue
+		}
+
+		createdRaw, kid, ok := strings.Cut(strings.TrimSuffix(name, ".pem"), "_")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("JWT key file %s: expected <created>_<kid>.pem", name)
+		}
+		createdAt, err := time.Parse(keyFileTimeLayout, createdRaw)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key file %s: invalid creation time: %w", name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, name)) // #nosec G304 -- operator-provided key directory
+		if err != nil {
+			return nil, fmt.Errorf("read JWT key file %s: %w", name, err)
+		}
+		priv, method, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key file %s: %w", name, err)
+		}
+
+		keys = append(keys, signingKey{kid: kid, privateKey: priv, method: method, createdAt: createdAt})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no JWT keys found in %s", dir)
+	}
+
+	slices.SortFunc(keys, func(a, b signingKey) int {
+		return a.createdAt.Compare(b.createdAt)
+	})
+	for i := 1; i < len(keys); i++ {
+		if slices.ContainsFunc(keys[:i], func(key signingKey) bool { return key.kid == keys[i].kid }) {
+			return nil, fmt.Errorf("duplicate JWT kid %s in %s", keys[i].kid, dir)
+		}
+	}
+	return keys, nil
+}
+
+// parsePrivateKeyPEM parses a PKCS1 RSA, SEC1 EC or PKCS8 (RSA, EC or Ed25519) private key
+// and returns it together with the signing method it is used with.
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, jwt.SigningMethod, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("failed to decode PEM")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, nil, errors.New("unsupported PEM block type: " + block.Type)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch priv := key.(type) {
+	case *rsa.PrivateKey:
+		return priv, jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		if priv.Curve != elliptic.P256() {
+			return nil, nil, errors.New("unsupported EC curve: " + priv.Curve.Params().Name)
+		}
+		return priv, jwt.SigningMethodES256, nil
+	case ed25519.PrivateKey:
+		return priv, jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
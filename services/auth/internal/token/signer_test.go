@@ -0,0 +1,28 @@
+package token_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnitLinkSigner(t *testing.T) {
+	s, err := token.NewLinkSigner([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	other, err := token.NewLinkSigner([]byte(strings.Repeat("o", 32)))
+	require.NoError(t, err)
+
+	signature := s.Sign("email-verification.abc.1700000000")
+	assert.True(t, s.Verify("email-verification.abc.1700000000", signature))
+	assert.False(t, s.Verify("email-verification.abc.1800000000", signature), "altered data must be rejected")
+	assert.False(t, other.Verify("email-verification.abc.1700000000", signature), "another key must not verify")
+	assert.False(t, s.Verify("email-verification.abc.1700000000", "not base64!"))
+}
+
+func TestUnitNewLinkSigner_ShortKey(t *testing.T) {
+	_, err := token.NewLinkSigner([]byte(strings.Repeat("k", 31)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,109 @@
+// Package token defines the TOTP maker for the auth service.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// totpSecretBytes is the length of TOTP secrets, the 160 bits RFC 4226 recommends for HMAC-SHA1.
+	totpSecretBytes = 20
+	// totpDigits is the number of digits of a TOTP code.
+	totpDigits = 6
+	// totpPeriod is the time step of TOTP codes.
+	totpPeriod = 30 * time.Second
+	// totpSkew is the number of time steps before and after the current one that are accepted,
+	// to tolerate clock drift and codes entered at the end of their step.
+	totpSkew = 1
+)
+
+// totpEncoding is the unpadded base32 encoding authenticator apps expect secrets in.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPMaker generates and validates RFC 6238 time-based one-time passwords
+// with the parameters every authenticator app supports: HMAC-SHA1, 6 digits and 30 second steps.
+type TOTPMaker struct {
+	issuer string
+}
+
+// NewTOTPMaker creates a new TOTPMaker; issuer names the service in authenticator apps.
+func NewTOTPMaker(issuer string) *TOTPMaker {
+	return &TOTPMaker{issuer: issuer}
+}
+
+// GenerateSecret generates a random TOTP secret.
+func (m *TOTPMaker) GenerateSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("read random bytes: %w", err)
+	}
+	return secret, nil
+}
+
+// EncodeSecret encodes a secret as the base32 text users can type into an authenticator app.
+func (m *TOTPMaker) EncodeSecret(secret []byte) string {
+	return totpEncoding.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// URI of a secret that authenticator apps import, usually from a QR code.
+func (m *TOTPMaker) URI(account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", m.EncodeSecret(secret))
+	query.Set("issuer", m.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + m.issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// Validate checks a code against a secret at the given time and returns the time step it matched.
+// Callers must reject a step that was already used so that a code cannot be replayed.
+func (m *TOTPMaker) Validate(secret []byte, code string, at time.Time) (step int64, ok bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := TOTPStep(at)
+	for s := current - totpSkew; s <= current+totpSkew; s++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPStep returns the TOTP time step of a time.
+func TOTPStep(at time.Time) int64 {
+	return at.Unix() / int64(totpPeriod.Seconds())
+}
+
+// TOTPCode returns the TOTP code of a secret at the given time.
+func TOTPCode(secret []byte, at time.Time) string {
+	return totpCode(secret, TOTPStep(at))
+}
+
+// totpCode computes the HOTP value (RFC 4226) of a secret for a time step.
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
@@ -0,0 +1,81 @@
+package token_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 secret of the RFC 6238 test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestUnitTOTPCode_RFC6238(t *testing.T) {
+	// The RFC 6238 appendix B vectors, truncated to 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, token.TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0)), "unix %d", tt.unix)
+	}
+}
+
+func TestUnitTOTPMaker_Validate(t *testing.T) {
+	m := token.NewTOTPMaker("Example")
+	now := time.Unix(1111111111, 0)
+	current := token.TOTPStep(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantOK   bool
+		wantStep int64
+	}{
+		{name: "current step", code: token.TOTPCode(rfc6238Secret, now), wantOK: true, wantStep: current},
+		{name: "previous step", code: token.TOTPCode(rfc6238Secret, now.Add(-30*time.Second)), wantOK: true, wantStep: current - 1},
+		{name: "next step", code: token.TOTPCode(rfc6238Secret, now.Add(30*time.Second)), wantOK: true, wantStep: current + 1},
+		{name: "outside skew", code: token.TOTPCode(rfc6238Secret, now.Add(-90*time.Second))},
+		{name: "wrong length", code: "12345"},
+		{name: "wrong code", code: "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := m.Validate(rfc6238Secret, tt.code, now)
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.Equal(t, tt.wantStep, step)
+			}
+		})
+	}
+}
+
+func TestUnitTOTPMaker_URI(t *testing.T) {
+	m := token.NewTOTPMaker("Example Co")
+
+	secret, err := m.GenerateSecret()
+	require.NoError(t, err)
+	require.Len(t, secret, 20)
+
+	u, err := url.Parse(m.URI("alice@example.com", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Example Co:alice@example.com", u.Path)
+	assert.Equal(t, m.EncodeSecret(secret), u.Query().Get("secret"))
+	assert.Equal(t, "Example Co", u.Query().Get("issuer"))
+	assert.Equal(t, "6", u.Query().Get("digits"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
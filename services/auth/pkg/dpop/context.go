@@ -0,0 +1,24 @@
+package dpop
+
+import "context"
+
+type proofContextKey struct{}
+
+// validatedProof is the outcome of the validation of the DPoP proof of a request.
+type validatedProof struct {
+	proof *Proof
+	err   error
+}
+
+// WithProof adds the DPoP proof of the request, or the error it failed validation with, to the context, for the
+// services that issue and check the tokens bound to it.
+func WithProof(ctx context.Context, proof *Proof, err error) context.Context {
+	return context.WithValue(ctx, proofContextKey{}, validatedProof{proof: proof, err: err})
+}
+
+// GetProof gets the DPoP proof of the request from the context, or the error it failed validation with.
+// Both are nil when the request carries no proof.
+func GetProof(ctx context.Context) (*Proof, error) {
+	p, _ := ctx.Value(proofContextKey{}).(validatedProof)
+	return p.proof, p.err
+}
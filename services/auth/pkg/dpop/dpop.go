@@ -0,0 +1,212 @@
+// Package dpop validates DPoP proofs (RFC 9449), which bind access and refresh tokens to a key held by the client,
+// so that a stolen token cannot be used without the private key.
+package dpop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// HeaderName is the header carrying a DPoP proof.
+	HeaderName = "DPoP"
+	// Scheme is the Authorization scheme of a DPoP bound access token.
+	Scheme = "DPoP"
+	// proofType is the typ header of a DPoP proof.
+	proofType = "dpop+jwt"
+	// DefaultMaxAge is how long after its iat a proof is accepted.
+	DefaultMaxAge = time.Minute
+	// DefaultClockSkew is how far in the future the iat of a proof may be.
+	DefaultClockSkew = 5 * time.Second
+	// maxIDLength bounds the jti of a proof, which is kept in the replay cache.
+	maxIDLength = 256
+)
+
+var (
+	// ErrMissingProof is returned when a token bound to a key is presented without a DPoP proof.
+	ErrMissingProof = errors.New("missing DPoP proof")
+	// ErrInvalidProof is returned when a DPoP proof fails validation, or is not made with the key of the token
+	// it is presented with.
+	ErrInvalidProof = errors.New("invalid DPoP proof")
+	// ErrReplayedProof is returned (wrapped in ErrInvalidProof) when the jti of a proof was already presented.
+	ErrReplayedProof = errors.New("DPoP proof replayed")
+)
+
+// SigningAlgorithms are the algorithms a proof may be signed with.
+var SigningAlgorithms = []string{
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// ReplayCache remembers the proofs that were already presented.
+type ReplayCache interface {
+	// MarkProofUsed records id until expiresAt and reports whether it was not recorded yet.
+	MarkProofUsed(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+}
+
+// Proof is a validated DPoP proof.
+type Proof struct {
+	ID       string // jti
+	Method   string // htm
+	URL      string // htu
+	IssuedAt time.Time
+	// JKT is the JWK SHA-256 thumbprint (RFC 7638) of the key the proof is signed with.
+	JKT string
+	// AccessTokenHash is the ath claim, set when the proof is presented with an access token.
+	AccessTokenHash string
+}
+
+// CheckAccessToken checks that the proof was made with the key accessToken is bound to, whose thumbprint is jkt,
+// for that very access token.
+func (p *Proof) CheckAccessToken(accessToken, jkt string) error {
+	if p.JKT != jkt {
+		return fmt.Errorf("%w: key does not match the token", ErrInvalidProof)
+	}
+	if p.AccessTokenHash != AccessTokenHash(accessToken) {
+		return fmt.Errorf("%w: ath does not match the token", ErrInvalidProof)
+	}
+	return nil
+}
+
+// AccessTokenHash returns the ath of a proof presented with accessToken.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// proofClaims are the claims of a DPoP proof.
+type proofClaims struct {
+	jwt.RegisteredClaims
+	Method string `json:"htm"`
+	URL    string `json:"htu"`
+	Ath    string `json:"ath,omitempty"`
+}
+
+// Validator validates DPoP proofs.
+type Validator struct {
+	cache     ReplayCache
+	parser    *jwt.Parser
+	maxAge    time.Duration
+	clockSkew time.Duration
+}
+
+// NewValidator creates a new Validator that rejects the proofs recorded in cache.
+func NewValidator(cache ReplayCache) *Validator {
+	return &Validator{
+		cache:     cache,
+		parser:    jwt.NewParser(jwt.WithValidMethods(SigningAlgorithms), jwt.WithoutClaimsValidation()),
+		maxAge:    DefaultMaxAge,
+		clockSkew: DefaultClockSkew,
+	}
+}
+
+// Validate validates a DPoP proof presented with a request of method to requestURL: its signature with the public
+// key of its header, its htm and htu, that it was issued recently, and that it was not presented before.
+// Errors other than ErrInvalidProof mean the replay cache could not be checked.
+func (v *Validator) Validate(ctx context.Context, proof, method, requestURL string) (*Proof, error) {
+	if proof == "" {
+		return nil, ErrMissingProof
+	}
+
+	var jkt string
+	claims := &proofClaims{}
+	_, err := v.parser.ParseWithClaims(proof, claims, func(t *jwt.Token) (any, error) {
+		if typ, _ := t.Header["typ"].(string); typ != proofType {
+			return nil, fmt.Errorf("typ is not %s", proofType)
+		}
+		key, err := parseJWK(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != t.Method.Alg() {
+			return nil, fmt.Errorf("alg %s does not match the jwk", t.Method.Alg())
+		}
+		jkt = key.thumbprint
+		return key.publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+
+	switch {
+	case claims.ID == "" || len(claims.ID) > maxIDLength:
+		return nil, fmt.Errorf("%w: invalid jti", ErrInvalidProof)
+	case claims.Method != method:
+		return nil, fmt.Errorf("%w: htm does not match the request", ErrInvalidProof)
+	case !sameURL(claims.URL, requestURL):
+		return nil, fmt.Errorf("%w: htu does not match the request", ErrInvalidProof)
+	case claims.IssuedAt == nil:
+		return nil, fmt.Errorf("%w: iat is missing", ErrInvalidProof)
+	}
+	now := time.Now()
+	issuedAt := claims.IssuedAt.Time
+	if issuedAt.After(now.Add(v.clockSkew)) || issuedAt.Before(now.Add(-v.maxAge)) {
+		return nil, fmt.Errorf("%w: iat is too far from now", ErrInvalidProof)
+	}
+
+	fresh, err := v.cache.MarkProofUsed(ctx, replayKey(jkt, claims.ID), issuedAt.Add(v.maxAge+v.clockSkew))
+	if err != nil {
+		return nil, fmt.Errorf("check DPoP proof replay: %w", err)
+	}
+	if !fresh {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, ErrReplayedProof)
+	}
+
+	return &Proof{
+		ID:              claims.ID,
+		Method:          claims.Method,
+		URL:             claims.URL,
+		IssuedAt:        issuedAt,
+		JKT:             jkt,
+		AccessTokenHash: claims.Ath,
+	}, nil
+}
+
+// replayKey is the replay cache key of a proof. The jti is only unique per key, and is hashed to bound its size.
+func replayKey(jkt, jti string) string {
+	sum := sha256.Sum256([]byte(jti))
+	return jkt + ":" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sameURL reports whether the htu of a proof is the URL of the request, ignoring the query and fragment
+// (RFC 9449 section 4.3) and normalizing the scheme, host and default port (RFC 3986 section 6.2).
+func sameURL(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+	if err != nil || !a.IsAbs() {
+		return false
+	}
+	b, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		normalizeHost(a) == normalizeHost(b) &&
+		normalizePath(a) == normalizePath(b)
+}
+
+func normalizeHost(u *url.URL) string {
+	host := strings.ToLower(u.Host)
+	switch {
+	case strings.EqualFold(u.Scheme, "https"):
+		return strings.TrimSuffix(host, ":443")
+	case strings.EqualFold(u.Scheme, "http"):
+		return strings.TrimSuffix(host, ":80")
+	}
+	return host
+}
+
+func normalizePath(u *url.URL) string {
+	if u.EscapedPath() == "" {
+		return "/"
+	}
+	return u.EscapedPath()
+}
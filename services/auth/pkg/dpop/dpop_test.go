@@ -0,0 +1,213 @@
+package dpop_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testURL = "https://auth.example.com/v1/login"
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+// ecJWK is the public JWK of key, for proofs signed by hand.
+func ecJWK(key *ecdsa.PrivateKey) map[string]any {
+	return map[string]any{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// signProof signs a proof with key, with the given headers and claims on top of valid ones.
+func signProof(t *testing.T, key *ecdsa.PrivateKey, header map[string]any, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{"jti": "proof-id", "htm": "POST", "htu": testURL, "iat": time.Now().Unix()}
+	for name, value := range claims {
+		all[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, all)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = ecJWK(key)
+	for name, value := range header {
+		token.Header[name] = value
+	}
+	proof, err := token.SignedString(key)
+	require.NoError(t, err)
+	return proof
+}
+
+func TestUnitValidator_Validate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		key  crypto.Signer
+	}{
+		{"ES256", newECKey(t)},
+		{"RS256", rsaKey},
+		{"EdDSA", edKey},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			validator := dpop.NewValidator(dpop.NewMemoryReplayCache())
+			proof, err := dpop.NewProof(tc.key, "POST", testURL, "")
+			require.NoError(t, err)
+
+			got, err := validator.Validate(context.Background(), proof, "POST", testURL+"?redirect=1")
+			require.NoError(t, err)
+
+			jkt, err := dpop.Thumbprint(tc.key.Public())
+			require.NoError(t, err)
+			assert.Equal(t, jkt, got.JKT)
+			assert.NotEmpty(t, got.ID)
+			assert.Equal(t, "POST", got.Method)
+			assert.Equal(t, testURL, got.URL)
+			assert.WithinDuration(t, time.Now(), got.IssuedAt, 5*time.Second)
+			assert.Empty(t, got.AccessTokenHash)
+		})
+	}
+}
+
+func TestUnitValidator_Validate_Invalid(t *testing.T) {
+	key := newECKey(t)
+	hmacProof, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti": "proof-id", "htm": "POST", "htu": testURL, "iat": time.Now().Unix(),
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	privateJWK := ecJWK(key)
+	privateJWK["d"] = base64.RawURLEncoding.EncodeToString(key.D.Bytes())
+
+	tests := []struct {
+		name  string
+		proof string
+		err   error
+	}{
+		{"missing", "", dpop.ErrMissingProof},
+		{"malformed", "not-a-jwt", dpop.ErrInvalidProof},
+		{"symmetric algorithm", hmacProof, dpop.ErrInvalidProof},
+		{"wrong typ", signProof(t, key, map[string]any{"typ": "JWT"}, nil), dpop.ErrInvalidProof},
+		{"missing jwk", signProof(t, key, map[string]any{"jwk": nil}, nil), dpop.ErrInvalidProof},
+		{"private jwk", signProof(t, key, map[string]any{"jwk": privateJWK}, nil), dpop.ErrInvalidProof},
+		{"jwk of another key", signProof(t, key, map[string]any{"jwk": ecJWK(newECKey(t))}, nil), dpop.ErrInvalidProof},
+		{"missing jti", signProof(t, key, nil, jwt.MapClaims{"jti": ""}), dpop.ErrInvalidProof},
+		{"other method", signProof(t, key, nil, jwt.MapClaims{"htm": "GET"}), dpop.ErrInvalidProof},
+		{"other URL", signProof(t, key, nil, jwt.MapClaims{"htu": "https://auth.example.com/v1/refresh"}), dpop.ErrInvalidProof},
+		{"other host", signProof(t, key, nil, jwt.MapClaims{"htu": "https://evil.example.com/v1/login"}), dpop.ErrInvalidProof},
+		{"relative URL", signProof(t, key, nil, jwt.MapClaims{"htu": "/v1/login"}), dpop.ErrInvalidProof},
+		{"missing iat", signProof(t, key, nil, jwt.MapClaims{"iat": nil}), dpop.ErrInvalidProof},
+		{"stale", signProof(t, key, nil, jwt.MapClaims{"iat": time.Now().Add(-2 * time.Minute).Unix()}), dpop.ErrInvalidProof},
+		{"issued in the future", signProof(t, key, nil, jwt.MapClaims{"iat": time.Now().Add(time.Minute).Unix()}), dpop.ErrInvalidProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := dpop.NewValidator(dpop.NewMemoryReplayCache())
+			_, err := validator.Validate(context.Background(), tt.proof, "POST", testURL)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestUnitValidator_Validate_NormalizesURL(t *testing.T) {
+	key := newECKey(t)
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache())
+	proof := signProof(t, key, nil, jwt.MapClaims{"htu": "HTTPS://Auth.Example.com:443/v1/login#fragment"})
+
+	_, err := validator.Validate(context.Background(), proof, "POST", testURL)
+	require.NoError(t, err)
+}
+
+func TestUnitValidator_Validate_Replay(t *testing.T) {
+	key := newECKey(t)
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache())
+	proof := signProof(t, key, nil, nil)
+
+	_, err := validator.Validate(context.Background(), proof, "POST", testURL)
+	require.NoError(t, err)
+
+	_, err = validator.Validate(context.Background(), proof, "POST", testURL)
+	require.ErrorIs(t, err, dpop.ErrInvalidProof)
+	require.ErrorIs(t, err, dpop.ErrReplayedProof)
+
+	// The jti is only unique per key.
+	_, err = validator.Validate(context.Background(), signProof(t, newECKey(t), nil, nil), "POST", testURL)
+	require.NoError(t, err)
+}
+
+func TestUnitProof_CheckAccessToken(t *testing.T) {
+	key := newECKey(t)
+	jkt, err := dpop.Thumbprint(key.Public())
+	require.NoError(t, err)
+	validator := dpop.NewValidator(dpop.NewMemoryReplayCache())
+
+	proof, err := dpop.NewProof(key, "GET", testURL, "access-token")
+	require.NoError(t, err)
+	got, err := validator.Validate(context.Background(), proof, "GET", testURL)
+	require.NoError(t, err)
+	assert.Equal(t, dpop.AccessTokenHash("access-token"), got.AccessTokenHash)
+
+	require.NoError(t, got.CheckAccessToken("access-token", jkt))
+	require.ErrorIs(t, got.CheckAccessToken("other-access-token", jkt), dpop.ErrInvalidProof)
+	require.ErrorIs(t, got.CheckAccessToken("access-token", "other-jkt"), dpop.ErrInvalidProof)
+}
+
+func TestUnitThumbprint(t *testing.T) {
+	// Example of RFC 7638 section 3.1.
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	jkt, err := dpop.Thumbprint(pub)
+	require.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jkt)
+}
+
+func TestUnitRequestURL(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://auth:8080/v1/login?scope=openid", nil)
+	assert.Equal(t, "http://auth:8080/v1/login", dpop.RequestURL(r))
+	assert.Equal(t, "http://auth:8080/v1/login", dpop.ForwardedRequestURL(r))
+
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "auth.example.com, envoy")
+	assert.Equal(t, "http://auth:8080/v1/login", dpop.RequestURL(r), "forwarded headers are only trusted when asked to")
+	assert.Equal(t, "https://auth.example.com/v1/login", dpop.ForwardedRequestURL(r))
+}
+
+func TestUnitMemoryReplayCache(t *testing.T) {
+	cache := dpop.NewMemoryReplayCache()
+	ctx := context.Background()
+
+	ok, err := cache.MarkProofUsed(ctx, "id", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = cache.MarkProofUsed(ctx, "id", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = cache.MarkProofUsed(ctx, "expired", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
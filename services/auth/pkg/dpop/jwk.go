@@ -0,0 +1,190 @@
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// minRSAKeyBits is the smallest RSA key a proof may be signed with.
+const minRSAKeyBits = 2048
+
+// jwk is the public key in the jwk header of a proof.
+type jwk struct {
+	Kty string `json:"kty,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// proofKey is the verification key of a proof.
+type proofKey struct {
+	alg        string
+	publicKey  crypto.PublicKey
+	thumbprint string
+}
+
+// parseJWK parses the jwk header of a proof.
+func parseJWK(header any) (proofKey, error) {
+	if header == nil {
+		return proofKey{}, errors.New("jwk is missing")
+	}
+	raw, err := json.Marshal(header)
+	if err != nil {
+		return proofKey{}, fmt.Errorf("marshal jwk: %w", err)
+	}
+	var k jwk
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return proofKey{}, fmt.Errorf("jwk is malformed: %w", err)
+	}
+	if k.D != "" {
+		return proofKey{}, errors.New("jwk is a private key")
+	}
+
+	key := proofKey{}
+	switch {
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return proofKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return proofKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := pub.ECDH(); err != nil {
+			return proofKey{}, fmt.Errorf("invalid EC point: %w", err)
+		}
+		key.alg, key.publicKey = "ES256", pub
+
+	case k.Kty == "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return proofKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return proofKey{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return proofKey{}, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < minRSAKeyBits {
+			return proofKey{}, fmt.Errorf("RSA key is shorter than %d bits", minRSAKeyBits)
+		}
+		key.alg, key.publicKey = "RS256", &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return proofKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return proofKey{}, errors.New("invalid Ed25519 key size")
+		}
+		key.alg, key.publicKey = "EdDSA", ed25519.PublicKey(x)
+
+	default:
+		return proofKey{}, fmt.Errorf("unsupported key %s", k.Kty)
+	}
+
+	key.thumbprint, err = k.thumbprint()
+	if err != nil {
+		return proofKey{}, err
+	}
+	return key, nil
+}
+
+// thumbprint returns the JWK SHA-256 thumbprint (RFC 7638) of the key: the hash of its required members, in
+// lexicographic order and without whitespace.
+func (k jwk) thumbprint() (string, error) {
+	var members any
+	switch k.Kty {
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	default:
+		return "", fmt.Errorf("unsupported key %s", k.Kty)
+	}
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwk thumbprint members: %w", err)
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// publicJWK returns the public JWK of a key a client signs its proofs with, and the algorithm it signs with.
+func publicJWK(pub crypto.PublicKey) (jwk, string, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return jwk{}, "", errors.New("EC key is not on P-256")
+		}
+		return jwk{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+		}, "ES256", nil
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, "RS256", nil
+	case ed25519.PublicKey:
+		return jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, "EdDSA", nil
+	default:
+		return jwk{}, "", fmt.Errorf("unsupported key %T", pub)
+	}
+}
+
+// Thumbprint returns the JWK SHA-256 thumbprint (RFC 7638) of a public key, the jkt of the tokens bound to it.
+func Thumbprint(pub crypto.PublicKey) (string, error) {
+	k, _, err := publicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	return k.thumbprint()
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,76 @@
+package dpop
+
+import (
+	"crypto"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// NewProof creates the DPoP proof of a request of method to url, signed with the private key of a client, which
+// must be an ECDSA P-256, RSA or Ed25519 key. A proof presented with an access token carries its hash; proofs of
+// logins and refreshes are created with an empty accessToken. A new proof is needed for every request.
+func NewProof(key crypto.Signer, method, url, accessToken string) (string, error) {
+	pub, alg, err := publicJWK(key.Public())
+	if err != nil {
+		return "", err
+	}
+	claims := proofClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+		Method: method,
+		URL:    url,
+	}
+	if accessToken != "" {
+		claims.Ath = AccessTokenHash(accessToken)
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["typ"] = proofType
+	token.Header["jwk"] = pub
+	proof, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("sign DPoP proof: %w", err)
+	}
+	return proof, nil
+}
+
+// RequestURL returns the URL a DPoP proof of r must be made for, without its query: https if r came over TLS,
+// http otherwise, and the host of its Host header. Behind a proxy terminating TLS, see ForwardedRequestURL.
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// ForwardedRequestURL is RequestURL for requests forwarded by a reverse proxy: the scheme and host are taken from
+// the X-Forwarded-Proto and X-Forwarded-Host headers when set. Clients can set these headers too, so it must only
+// be used behind a proxy that overwrites them; otherwise a proof made for another host or scheme is accepted.
+func ForwardedRequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := firstHeaderValue(r, "X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host + r.URL.EscapedPath()
+}
+
+// firstHeaderValue returns the first value of a header, which proxies may have appended theirs to.
+func firstHeaderValue(r *http.Request, name string) string {
+	value, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,67 @@
+package dpop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisProofPrefix is the prefix of the keys of the DPoP proofs already presented, by key thumbprint and jti hash,
+// in the Redis of a RedisReplayCache.
+const RedisProofPrefix = "dpop_proof:"
+
+// RedisReplayCache is a ReplayCache shared by every instance of a service through Redis.
+type RedisReplayCache struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+// NewRedisReplayCache creates a new RedisReplayCache.
+func NewRedisReplayCache(rdb redis.UniversalClient) *RedisReplayCache {
+	return &RedisReplayCache{rdb: rdb, prefix: RedisProofPrefix}
+}
+
+// MarkProofUsed records id until expiresAt and reports whether it was not recorded yet.
+func (c *RedisReplayCache) MarkProofUsed(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+	ok, err := c.rdb.SetNX(ctx, c.prefix+id, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis SETNX error: %w", err)
+	}
+	return ok, nil
+}
+
+// MemoryReplayCache is a ReplayCache of a single process, for services that run a single instance and tests.
+type MemoryReplayCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryReplayCache creates a new MemoryReplayCache.
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{expires: map[string]time.Time{}}
+}
+
+// MarkProofUsed records id until expiresAt and reports whether it was not recorded yet.
+func (c *MemoryReplayCache) MarkProofUsed(_ context.Context, id string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, exp := range c.expires {
+		if !exp.After(now) {
+			delete(c.expires, key)
+		}
+	}
+	if _, ok := c.expires[id]; ok || !expiresAt.After(now) {
+		return false, nil
+	}
+	c.expires[id] = expiresAt
+	return true, nil
+}
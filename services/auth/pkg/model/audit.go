@@ -0,0 +1,56 @@
+// Package model defines the audit event models for the auth service.
+package model
+
+import "time"
+
+// AuditEventType is the type of an audit event.
+type AuditEventType string
+
+const (
+	// AuditEventLoginSucceeded is recorded when a member logs in and a session is started.
+	AuditEventLoginSucceeded AuditEventType = "login_succeeded"
+	// AuditEventLoginFailed is recorded when a login is refused, with the reason why.
+	AuditEventLoginFailed AuditEventType = "login_failed"
+	// AuditEventTokenRefreshed is recorded when a refresh token is rotated.
+	AuditEventTokenRefreshed AuditEventType = "token_refreshed"
+	// AuditEventSessionRevoked is recorded when sessions are revoked, with the reason why.
+	AuditEventSessionRevoked AuditEventType = "session_revoked"
+	// AuditEventKeyRotated is recorded when another key starts signing the tokens.
+	AuditEventKeyRotated AuditEventType = "key_rotated"
+)
+
+// Reasons of failed logins and revoked sessions.
+const (
+	AuditReasonInvalidCredentials        = "invalid_credentials"
+	AuditReasonInvalidOTP                = "invalid_otp"
+	AuditReasonInvalidLoginCode          = "invalid_login_code"
+	AuditReasonThrottled                 = "throttled"
+	AuditReasonAccountDisabled           = "account_disabled"
+	AuditReasonAccountLocked             = "account_locked"
+	AuditReasonEmailNotVerified          = "email_not_verified"
+	AuditReasonInvalidPasskey            = "invalid_passkey"
+	AuditReasonInvalidFederatedLogin     = "invalid_federated_login"
+	AuditReasonFederatedEmailNotVerified = "federated_email_not_verified"
+	AuditReasonLogout                    = "logout"
+	AuditReasonLogoutAllDevices          = "logout_all_devices"
+	AuditReasonRevokedByMember           = "revoked_by_member"
+	AuditReasonRevokedByClient           = "revoked_by_client"
+	AuditReasonRefreshTokenReused        = "refresh_token_reused"
+	AuditReasonPasswordReset             = "password_reset"
+)
+
+// AuditEvent is an entry of the audit trail of the auth service. Fields that do not apply to an event are empty.
+type AuditEvent struct {
+	Type        AuditEventType `json:"type"`
+	OccurredAt  time.Time      `json:"occurred_at"`
+	MemberID    string         `json:"member_id,omitempty"`
+	SessionID   string         `json:"session_id,omitempty"` // ID of the refresh token session
+	FamilyID    string         `json:"family_id,omitempty"`  // token family of the session, stable across refreshes
+	ClientID    string         `json:"client_id,omitempty"`  // OAuth client, empty for first-party sessions
+	AuthMethods []string       `json:"auth_methods,omitempty"`
+	Reason      string         `json:"reason,omitempty"`
+	KeyID       string         `json:"key_id,omitempty"` // kid of the new signing key of a key rotation
+	IPAddress   string         `json:"ip_address,omitempty"`
+	UserAgent   string         `json:"user_agent,omitempty"`
+	RequestID   string         `json:"request_id,omitempty"`
+}
@@ -0,0 +1,40 @@
+// Package model defines the OAuth 2.0 device authorization models for the auth service.
+package model
+
+import "time"
+
+// Statuses of a device authorization.
+const (
+	DeviceAuthorizationPending  = "pending"
+	DeviceAuthorizationApproved = "approved"
+	DeviceAuthorizationDenied   = "denied"
+)
+
+// DeviceCodeHash is the hash of a device code; the raw code is never stored.
+type DeviceCodeHash string
+
+// DeviceDecision is the decision a member signs in to make on a device authorization.
+type DeviceDecision struct {
+	DeviceCodeHash DeviceCodeHash
+	Status         string // DeviceAuthorizationApproved or DeviceAuthorizationDenied
+}
+
+// DeviceAuthorization is a device authorization request (RFC 8628) waiting for a member to approve it on another
+// device, and for its client to poll for the tokens once approved.
+type DeviceAuthorization struct {
+	DeviceCodeHash DeviceCodeHash
+	UserCode       string // normalized, without separator
+	ClientID       string
+	Scopes         []string // requested
+	Status         string
+	MemberID       string           // set once approved or denied
+	Grant          AccessTokenGrant // set once approved
+	Interval       time.Duration    // the client must wait between polls
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// IsExpired reports whether the device authorization is expired at the given time.
+func (a *DeviceAuthorization) IsExpired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
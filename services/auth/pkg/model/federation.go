@@ -0,0 +1,33 @@
+// Package model defines the federated login models for the auth service.
+package model
+
+import "time"
+
+// FederatedLoginHash is the hash of the state of a federated login; the raw state is only ever sent to the
+// client that started the login and through the upstream identity provider.
+type FederatedLoginHash string
+
+// FederatedLogin is a login through an upstream identity provider, waiting for the member to come back from it
+// with an authorization code.
+type FederatedLogin struct {
+	StateHash    FederatedLoginHash
+	Provider     string // ID of the upstream identity provider
+	BindingHash  string // hash of the secret of the browser that started the login
+	Nonce        string // expected nonce claim of the ID token
+	CodeVerifier string // PKCE code verifier of the authorization code
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+// IsExpired reports whether the federated login is expired at the given time.
+func (l *FederatedLogin) IsExpired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// ExternalIdentity is the identity of a member asserted by the ID token of an upstream identity provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool // whether the identity provider verified that the member owns the email
+}
@@ -0,0 +1,9 @@
+// Package model defines the email models for the auth service.
+package model
+
+// Mail is a plain text email to a member.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
@@ -0,0 +1,53 @@
+// Package model defines the multi-factor authentication models for the auth service.
+package model
+
+import "time"
+
+// Authentication methods of the "amr" claim (RFC 8176).
+const (
+	// AuthMethodPassword is the amr value of a password.
+	AuthMethodPassword = "pwd"
+	// AuthMethodOTP is the amr value of a one-time password.
+	AuthMethodOTP = "otp"
+	// AuthMethodHardwareKey is the amr value of a proof-of-possession of a hardware-secured key, e.g. a passkey.
+	AuthMethodHardwareKey = "hwk"
+	// AuthMethodEmail is the amr value of a code or link emailed to the member. RFC 8176 registers no value for
+	// it, and "otp" is kept for the second factor.
+	AuthMethodEmail = "email"
+	// AuthMethodFederated is the amr value of a login through an upstream OpenID Connect identity provider. RFC 8176
+	// registers no value for it either.
+	AuthMethodFederated = "fed"
+)
+
+// TOTPCredential is the TOTP authenticator of a member.
+type TOTPCredential struct {
+	MemberID        string
+	EncryptedSecret string // encrypted with the member ID as additional data; the raw secret is never stored
+	CreatedAt       time.Time
+	ConfirmedAt     time.Time // zero until the member proves the authenticator works
+}
+
+// IsConfirmed reports whether the enrollment of the credential has been confirmed.
+func (c *TOTPCredential) IsConfirmed() bool {
+	return !c.ConfirmedAt.IsZero()
+}
+
+// MFAChallengeHash is the hash of an MFA challenge token; the raw token is never stored.
+type MFAChallengeHash string
+
+// MFAChallenge is a login that passed its first factor and waits for the second one.
+type MFAChallenge struct {
+	TokenHash MFAChallengeHash
+	MemberID  string
+	Email     string           // normalized login email, under which failed second factors are throttled
+	ClientID  string           // OAuth client the login authorizes; empty for first-party logins
+	Device    DeviceDecision   // device authorization the login decides; zero for other logins
+	Grant     AccessTokenGrant // issued once the second factor is verified
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsExpired reports whether the challenge is expired at the given time.
+func (c *MFAChallenge) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
@@ -0,0 +1,73 @@
+// Package model defines the OAuth 2.0 models for the auth service.
+package model
+
+import "time"
+
+// CodeChallengeMethodS256 is the only PKCE code challenge method accepted (RFC 7636).
+const CodeChallengeMethodS256 = "S256"
+
+// Token type hints of the introspection and revocation endpoints (RFC 7009 section 2.1).
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// OAuthClient is a client application registered with the OAuth 2.0 authorization server.
+// Confidential clients authenticate with a secret; public clients have none.
+type OAuthClient struct {
+	ID           string
+	Name         string   // shown on the login form
+	SecretHash   string   // hex encoded SHA-256 of the secret of a confidential client
+	RedirectURIs []string // matched exactly
+	Scopes       []string // the most the client may be granted
+	Audiences    []string // the most the tokens a client gets for itself may be issued for
+}
+
+// IsConfidential reports whether the client authenticates with a secret.
+func (c *OAuthClient) IsConfidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsRedirectURI reports whether redirectURI is registered for the client.
+func (c *OAuthClient) AllowsRedirectURI(redirectURI string) bool {
+	for _, uri := range c.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizationRequest is the authorization request of a client (RFC 6749 section 4.1.1) with PKCE (RFC 7636).
+type AuthorizationRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string // OpenID Connect nonce, echoed in the ID token
+}
+
+// AuthorizationCodeHash is the hash of an authorization code; the raw code is never stored.
+type AuthorizationCodeHash string
+
+// AuthorizationCode is an authorization code waiting to be exchanged for tokens by its client.
+type AuthorizationCode struct {
+	CodeHash      AuthorizationCodeHash
+	ClientID      string
+	RedirectURI   string
+	CodeChallenge string // S256
+	MemberID      string
+	Grant         AccessTokenGrant
+	Nonce         string
+	AuthTime      time.Time // when the member authenticated
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+}
+
+// IsExpired reports whether the code is expired at the given time.
+func (c *AuthorizationCode) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
@@ -0,0 +1,27 @@
+// Package model defines the OpenID Connect models for the auth service.
+package model
+
+import "time"
+
+// OpenID Connect scopes (OpenID Connect Core 1.0 section 5.4). They ask for an ID token and user info rather than
+// access to an API, so every client may request them.
+const (
+	ScopeOpenID = "openid"
+	ScopeEmail  = "email"
+)
+
+// IDToken is a string that represents an OpenID Connect ID token.
+type IDToken string
+
+// IDTokenClaims are the claims of an ID token issued to a client about the authentication of a member.
+type IDTokenClaims struct {
+	Subject       string
+	Audience      string // client ID
+	Nonce         string // of the authorization request, if any
+	AuthTime      time.Time
+	AuthMethods   []string // amr
+	Email         string   // only with the email scope
+	EmailVerified bool
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+}
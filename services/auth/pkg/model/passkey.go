@@ -0,0 +1,31 @@
+// Package model defines the passkey (WebAuthn) models for the auth service.
+package model
+
+import "time"
+
+// PasskeyCredential is a WebAuthn public key credential registered by a member.
+type PasskeyCredential struct {
+	ID              []byte // credential ID chosen by the authenticator
+	MemberID        string
+	UserHandle      []byte // opaque WebAuthn user handle of the member, shared by all their credentials
+	PublicKey       []byte // COSE encoded
+	AttestationType string // "none" or "packed"
+	AAGUID          []byte
+	SignCount       uint32
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      time.Time // zero until first used to log in
+}
+
+// WebAuthnSessionHash is the hash of a WebAuthn ceremony ID; the raw ID is never stored.
+type WebAuthnSessionHash string
+
+// WebAuthnSession is the server side state of a pending WebAuthn registration or login ceremony.
+type WebAuthnSession struct {
+	CeremonyHash WebAuthnSessionHash
+	MemberID     string // registering member; empty for logins, whose member is only known from the credential
+	Data         []byte // opaque state of the relying party, including the challenge
+	ExpiresAt    time.Time
+}
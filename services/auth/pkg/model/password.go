@@ -0,0 +1,20 @@
+// Package model defines the password reset models for the auth service.
+package model
+
+import "time"
+
+// PasswordResetTokenHash is the hash of a password reset token; the raw token is only ever sent to the member.
+type PasswordResetTokenHash string
+
+// PasswordReset is a password reset a member asked for and can complete, once, with the token emailed to them.
+type PasswordReset struct {
+	TokenHash PasswordResetTokenHash
+	Email     string // normalized email of the member
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsExpired reports whether the password reset is expired at the given time.
+func (r *PasswordReset) IsExpired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
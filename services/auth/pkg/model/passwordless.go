@@ -0,0 +1,39 @@
+// Package model defines the passwordless login models for the auth service.
+package model
+
+import "time"
+
+// Methods of delivering a passwordless login.
+const (
+	// PasswordlessMethodLink emails a link that completes the login.
+	PasswordlessMethodLink = "link"
+	// PasswordlessMethodCode emails a 6-digit code to type in.
+	PasswordlessMethodCode = "code"
+)
+
+// PasswordlessChallengeHash is the hash of the ID of a passwordless login; the raw ID is only ever sent to the
+// client that started the login and in login links.
+type PasswordlessChallengeHash string
+
+// PasswordlessChallenge is a passwordless login waiting for the code or link emailed to the member.
+type PasswordlessChallenge struct {
+	IDHash     PasswordlessChallengeHash
+	Email      string // normalized email of the member, under which failed codes are throttled
+	Method     string // PasswordlessMethodLink or PasswordlessMethodCode
+	SecretHash string // hash of the challenge ID and the emailed code; the raw code is never stored
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// IsExpired reports whether the challenge is expired at the given time.
+func (c *PasswordlessChallenge) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+// LoginNotification is the code or link of a passwordless login, to be delivered to the member.
+type LoginNotification struct {
+	To        string // email of the member
+	Code      string // 6-digit code, for PasswordlessMethodCode
+	Link      string // URL completing the login, for PasswordlessMethodLink
+	ExpiresAt time.Time
+}
@@ -0,0 +1,22 @@
+// Package model defines the login throttling models for the auth service.
+package model
+
+import "time"
+
+// LoginThrottlePolicy is the policy applied to the failed logins of one email or one client IP.
+type LoginThrottlePolicy struct {
+	MaxFailures   int           // failures within Window that trigger a lockout
+	Window        time.Duration // sliding window failures are counted in
+	LockoutBase   time.Duration // duration of the first lockout, doubled on every subsequent one
+	LockoutMax    time.Duration // upper bound of a lockout
+	LockoutMemory time.Duration // how long a lockout counts towards the duration of the next one
+}
+
+// LockoutDuration returns the duration of the n-th lockout within LockoutMemory, starting at 1.
+func (p LoginThrottlePolicy) LockoutDuration(n int64) time.Duration {
+	d := p.LockoutBase
+	for i := int64(1); i < n && d < p.LockoutMax; i++ {
+		d *= 2
+	}
+	return min(d, p.LockoutMax)
+}
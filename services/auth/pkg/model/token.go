@@ -6,17 +6,78 @@ import "time"
 // AccessToken is a string that represents an access token.
 type AccessToken string
 
+// Token types of access tokens (RFC 6749 section 7.1).
+const (
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP" // bound to the key of the client (RFC 9449)
+)
+
+// AccessTokenGrant is the authorization data an access token is issued with.
+type AccessTokenGrant struct {
+	Scopes      []string
+	Roles       []string
+	Audiences   []string // in addition to the audience of the auth service itself
+	AuthMethods []string // amr, the methods the member authenticated with
+	ClientID    string   // OAuth client the token was issued to; empty for first-party logins
+	// EmailVerified is whether the member had verified their email at login; nil for tokens of clients.
+	EmailVerified *bool
+	// JKT is the thumbprint of the DPoP key (RFC 9449) the token is bound to; empty for bearer tokens.
+	JKT string
+}
+
+// AccessTokenClaims are the claims of an access token the auth service relies on.
+type AccessTokenClaims struct {
+	ID          string // jti
+	Subject     string
+	Scopes      []string
+	Roles       []string
+	Audiences   []string
+	AuthMethods []string // amr
+	ClientID    string   // client_id
+	// EmailVerified is the email_verified claim; nil when the token has none.
+	EmailVerified *bool
+	// JKT is the cnf.jkt claim, the thumbprint of the DPoP key the token is bound to; empty for bearer tokens.
+	JKT       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
 // RefreshToken is a string that represents a refresh token.
 type RefreshToken string
 
+// RefreshTokenHash is the keyed hash of a refresh token; the raw token is never stored.
+type RefreshTokenHash string
+
 // RefreshTokenSession is a model for a refresh token session.
 type RefreshTokenSession struct {
 	ID        string
+	FamilyID  string // shared by every session rotated from the same login
 	MemberID  string
-	TokenHash RefreshToken
+	ClientID  string // OAuth client the session was issued to; empty for first-party logins
+	TokenHash RefreshTokenHash
 	ExpiresAt time.Time
 	CreatedAt time.Time
 	RevokedAt time.Time
+	RotatedAt time.Time // set once the token has been exchanged for a new one
 	UserAgent string
 	IPAddress string
+	Grant     AccessTokenGrant // carried over to the access tokens issued on refresh
+	// JKT is the thumbprint of the DPoP key the refresh token is bound to, carried over on rotation; empty when
+	// the session was started without a DPoP proof.
+	JKT string
+}
+
+// IsRevoked reports whether the session has been revoked.
+func (s *RefreshTokenSession) IsRevoked() bool {
+	return !s.RevokedAt.IsZero()
+}
+
+// IsRotated reports whether the session has already been exchanged for a new one.
+func (s *RefreshTokenSession) IsRotated() bool {
+	return !s.RotatedAt.IsZero()
+}
+
+// IsExpired reports whether the session is expired at the given time.
+func (s *RefreshTokenSession) IsExpired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
 }
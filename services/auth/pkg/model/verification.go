@@ -0,0 +1,21 @@
+// Package model defines the email verification models for the auth service.
+package model
+
+import "time"
+
+// EmailVerificationHash is the hash of the ID of an email verification; the ID is only ever sent to the member,
+// in a signed verification token.
+type EmailVerificationHash string
+
+// EmailVerification is a verification link emailed to a member, which proves they own their email once followed.
+type EmailVerification struct {
+	IDHash    EmailVerificationHash
+	Email     string // normalized email of the member
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsExpired reports whether the email verification is expired at the given time.
+func (v *EmailVerification) IsExpired(now time.Time) bool {
+	return !now.Before(v.ExpiresAt)
+}
@@ -0,0 +1,16 @@
+package verifier
+
+import "context"
+
+type claimsKey struct{}
+
+// WithClaims adds verified claims to the context.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext gets the verified claims from the context.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
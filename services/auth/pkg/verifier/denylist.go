@@ -0,0 +1,32 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisAccessTokenDenylistPrefix is the prefix of the keys of denied access token IDs (jti) in the auth service
+// Redis.
+const RedisAccessTokenDenylistPrefix = "access_token_denylist:"
+
+// RedisDenylist reads the access token denylist the auth service writes to Redis.
+type RedisDenylist struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+// NewRedisDenylist creates a Denylist backed by the auth service Redis.
+func NewRedisDenylist(rdb redis.UniversalClient) *RedisDenylist {
+	return &RedisDenylist{rdb: rdb, prefix: RedisAccessTokenDenylistPrefix}
+}
+
+// IsAccessTokenDenied reports whether a jti has been denied.
+func (d *RedisDenylist) IsAccessTokenDenied(ctx context.Context, jti string) (bool, error) {
+	n, err := d.rdb.Exists(ctx, d.prefix+jti).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis EXISTS error: %w", err)
+	}
+	return n > 0, nil
+}
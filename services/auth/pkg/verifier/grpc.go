@@ -0,0 +1,51 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns a gRPC unary interceptor that verifies the bearer access token
+// of the "authorization" metadata and adds its claims to the context. DPoP proofs are bound to HTTP requests,
+// so DPoP bound tokens are rejected.
+// Methods listed in skipMethods (full method names) are not verified.
+func UnaryServerInterceptor(v *Verifier, skipMethods ...string) grpc.UnaryServerInterceptor {
+	skip := make(map[string]struct{}, len(skipMethods))
+	for _, method := range skipMethods {
+		skip[method] = struct{}{}
+	}
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if _, ok := skip[info.FullMethod]; ok {
+			return handler(ctx, req)
+		}
+
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				token = BearerToken(values[0])
+			}
+		}
+
+		claims, err := v.Verify(ctx, token)
+		switch {
+		case errors.Is(err, ErrMissingToken):
+			return nil, status.Error(codes.Unauthenticated, ErrMissingToken.Error())
+		case errors.Is(err, ErrInvalidToken):
+			return nil, status.Error(codes.Unauthenticated, ErrInvalidToken.Error())
+		case err != nil:
+			return nil, status.Error(codes.Unavailable, "token verification unavailable")
+		}
+		return handler(WithClaims(ctx, claims), req)
+	}
+}
@@ -0,0 +1,209 @@
+package verifier
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// maxJWKSBytes bounds the size of a JWKS response.
+const maxJWKSBytes = 1 << 20
+
+// publicKey is a verification key of the JWKS.
+type publicKey struct {
+	alg       string
+	publicKey crypto.PublicKey
+}
+
+// keySet caches the JWKS and refreshes it when it is stale or a key ID is unknown.
+type keySet struct {
+	url                string
+	client             *http.Client
+	cacheTTL           time.Duration
+	minRefreshInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+
+	group singleflight.Group
+}
+
+func newKeySet(url string, client *http.Client, cacheTTL, minRefreshInterval time.Duration) *keySet {
+	return &keySet{
+		url:                url,
+		client:             client,
+		cacheTTL:           cacheTTL,
+		minRefreshInterval: minRefreshInterval,
+	}
+}
+
+// get returns the key with the given kid. The JWKS is fetched when the cache is stale,
+// or when kid is unknown and the last fetch is older than the minimum refresh interval.
+func (s *keySet) get(ctx context.Context, kid string) (publicKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	s.mu.RUnlock()
+
+	switch {
+	case ok && age < s.cacheTTL:
+		return key, nil
+	case !ok && age < s.minRefreshInterval:
+		return publicKey{}, ErrUnknownKeyID
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		if ok {
+			// Keep serving a stale but known key while the auth service is unreachable.
+			return key, nil
+		}
+		return publicKey{}, err
+	}
+
+	s.mu.RLock()
+	key, ok = s.keys[kid]
+	s.mu.RUnlock()
+	if !ok {
+		return publicKey{}, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// refresh fetches the JWKS, sharing a single request between concurrent callers.
+// The fetch is detached from the caller's cancellation so that one aborted request
+// does not fail the others waiting on it; the HTTP client timeout still applies.
+func (s *keySet) refresh(ctx context.Context) error {
+	_, err, _ := s.group.Do("jwks", func() (any, error) {
+		keys, err := s.fetch(context.WithoutCancel(ctx))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// A failed fetch also counts towards the rate limit so an unreachable
+		// auth service is not hammered by every request carrying an unknown kid.
+		s.fetchedAt = time.Now()
+		if err != nil {
+			return nil, err
+		}
+		s.keys = keys
+		return nil, nil
+	})
+	return err
+}
+
+func (s *keySet) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Skip keys this verifier does not understand rather than rejecting the whole set.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// jwk is a JSON Web Key as published by the auth service.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (publicKey, error) {
+	switch {
+	case k.Kty == "RSA" && k.Alg == "RS256":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return publicKey{}, fmt.Errorf("kid %q: invalid RSA exponent", k.Kid)
+		}
+		return publicKey{alg: k.Alg, publicKey: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+
+	case k.Kty == "EC" && k.Alg == "ES256" && k.Crv == "P-256":
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := pub.ECDH(); err != nil {
+			return publicKey{}, fmt.Errorf("kid %q: invalid EC point: %w", k.Kid, err)
+		}
+		return publicKey{alg: k.Alg, publicKey: pub}, nil
+
+	case k.Kty == "OKP" && k.Alg == "EdDSA" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return publicKey{}, fmt.Errorf("kid %q: invalid Ed25519 key size", k.Kid)
+		}
+		return publicKey{alg: k.Alg, publicKey: ed25519.PublicKey(x)}, nil
+
+	default:
+		return publicKey{}, fmt.Errorf("kid %q: unsupported key %s/%s", k.Kid, k.Kty, k.Alg)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
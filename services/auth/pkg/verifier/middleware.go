@@ -0,0 +1,98 @@
+package verifier
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+)
+
+const (
+	// bearerPrefix is the scheme prefix of the Authorization header.
+	bearerPrefix = "Bearer "
+	// dpopPrefix is the scheme prefix of the Authorization header for DPoP bound tokens.
+	dpopPrefix = dpop.Scheme + " "
+)
+
+// Middleware returns chi (net/http) middleware that verifies the bearer or DPoP access token
+// and adds its claims to the request context. Requests without a valid token, or with a DPoP bound token
+// without a matching proof, get a 401; a 503 is returned when the denylist cannot be checked.
+func Middleware(v *Verifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var claims *Claims
+			var err error
+			header := r.Header.Get("Authorization")
+			if token := DPoPToken(header); token != "" {
+				claims, err = v.VerifyDPoP(r.Context(), token, dpopProofOf(r), r.Method, v.requestURL(r))
+			} else {
+				claims, err = v.Verify(r.Context(), BearerToken(header))
+			}
+			if err != nil {
+				if !errors.Is(err, ErrMissingToken) && !errors.Is(err, ErrInvalidToken) {
+					writeError(w, http.StatusServiceUnavailable, "service_unavailable", "token verification unavailable")
+					return
+				}
+				writeUnauthorized(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// BearerToken extracts the token from an Authorization header value; it returns "" if there is none.
+func BearerToken(header string) string {
+	return tokenWithPrefix(header, bearerPrefix)
+}
+
+// DPoPToken extracts the token from an Authorization header value with the DPoP scheme; it returns "" if there
+// is none.
+func DPoPToken(header string) string {
+	return tokenWithPrefix(header, dpopPrefix)
+}
+
+func tokenWithPrefix(header, prefix string) string {
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// dpopProofOf returns the DPoP proof of a request; a request with several proofs has none (RFC 9449 section 4.3).
+func dpopProofOf(r *http.Request) string {
+	proofs := r.Header.Values(dpop.HeaderName)
+	if len(proofs) != 1 {
+		return ""
+	}
+	return proofs[0]
+}
+
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	challenge := `Bearer error="invalid_token"`
+	code := "invalid_token"
+	message := ErrInvalidToken.Error()
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		challenge = "Bearer"
+		message = ErrMissingToken.Error()
+	case errors.Is(err, dpop.ErrMissingProof), errors.Is(err, dpop.ErrInvalidProof):
+		// The client must retry with a (new) proof made with the key of the token.
+		challenge = fmt.Sprintf(`DPoP algs=%q, error="invalid_dpop_proof"`, strings.Join(dpop.SigningAlgorithms, " "))
+		code = "invalid_dpop_proof"
+		message = dpop.ErrInvalidProof.Error()
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeError(w, http.StatusUnauthorized, code, message)
+}
+
+// writeError writes an error in the format of the auth API error responses.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error_code": code, "message": message})
+}
@@ -0,0 +1,203 @@
+package verifier_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/verifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnitMiddleware(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+	v := newVerifier(t, srv.URL)
+
+	var gotSubject string
+	handler := verifier.Middleware(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := verifier.ClaimsFromContext(r.Context())
+		require.True(t, ok)
+		gotSubject = claims.Subject
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantSubject   string
+	}{
+		{name: "valid token", authorization: "Bearer " + sign(t, priv, "k1", validClaims()), wantStatus: http.StatusNoContent, wantSubject: "member-1"},
+		{name: "lowercase scheme", authorization: "bearer " + sign(t, priv, "k1", validClaims()), wantStatus: http.StatusNoContent, wantSubject: "member-1"},
+		{name: "missing header", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", authorization: "Bearer not-a-jwt", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSubject = ""
+			req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantSubject, gotSubject)
+			if tt.wantStatus == http.StatusUnauthorized {
+				assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+				assert.JSONEq(t, `"invalid_token"`, jsonField(t, rec.Body.Bytes(), "error_code"))
+			}
+		})
+	}
+}
+
+func TestUnitMiddleware_DPoP(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+	newHandler := func(trustForwardedHeaders bool) http.Handler {
+		v, err := verifier.New(verifier.Config{
+			JWKSURL:               srv.URL,
+			Issuer:                testIssuer,
+			Audience:              testAudience,
+			MinRefreshInterval:    time.Hour,
+			TrustForwardedHeaders: trustForwardedHeaders,
+		})
+		require.NoError(t, err)
+		return verifier.Middleware(v)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	}
+	behindProxy, direct := newHandler(true), newHandler(false)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jkt, err := dpop.Thumbprint(clientKey.Public())
+	require.NoError(t, err)
+	claims := validClaims()
+	claims["cnf"] = map[string]any{"jkt": jkt}
+	token := sign(t, priv, "k1", claims)
+
+	request := func(scheme string, withProof bool) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://orders.internal/orders?page=2", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "api.example.com")
+		req.Header.Set("Authorization", scheme+" "+token)
+		if withProof {
+			proof, err := dpop.NewProof(clientKey, http.MethodGet, "https://api.example.com/orders", token)
+			require.NoError(t, err)
+			req.Header.Set("DPoP", proof)
+		}
+		return req
+	}
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		req        *http.Request
+		wantStatus int
+	}{
+		{name: "DPoP scheme with proof", handler: behindProxy, req: request("DPoP", true), wantStatus: http.StatusNoContent},
+		{name: "DPoP scheme without proof", handler: behindProxy, req: request("DPoP", false), wantStatus: http.StatusUnauthorized},
+		{name: "bearer scheme with proof", handler: behindProxy, req: request("Bearer", true), wantStatus: http.StatusUnauthorized},
+		// Without a proxy overwriting them, the forwarded headers are the client's, and the proof is for another URL.
+		{name: "forwarded headers not trusted", handler: direct, req: request("DPoP", true), wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			tt.handler.ServeHTTP(rec, tt.req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusUnauthorized {
+				assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `DPoP algs="ES256 RS256 EdDSA", error="invalid_dpop_proof"`)
+				assert.JSONEq(t, `"invalid_dpop_proof"`, jsonField(t, rec.Body.Bytes(), "error_code"))
+			}
+		})
+	}
+}
+
+func jsonField(t *testing.T, body []byte, name string) string {
+	t.Helper()
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(body, &fields))
+	return string(fields[name])
+}
+
+func TestUnitUnaryServerInterceptor(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+	interceptor := verifier.UnaryServerInterceptor(newVerifier(t, srv.URL), "/grpc.health.v1.Health/Check")
+
+	handler := func(ctx context.Context, _ any) (any, error) {
+		claims, ok := verifier.ClaimsFromContext(ctx)
+		if !ok {
+			return "anonymous", nil
+		}
+		return claims.Subject, nil
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		md       metadata.MD
+		wantResp any
+		wantCode codes.Code
+	}{
+		{
+			name:     "valid token",
+			method:   "/user.v1.UserService/GetUser",
+			md:       metadata.Pairs("authorization", "Bearer "+sign(t, priv, "k1", validClaims())),
+			wantResp: "member-1",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "missing token",
+			method:   "/user.v1.UserService/GetUser",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "invalid token",
+			method:   "/user.v1.UserService/GetUser",
+			md:       metadata.Pairs("authorization", "Bearer not-a-jwt"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "skipped method",
+			method:   "/grpc.health.v1.Health/Check",
+			wantResp: "anonymous",
+			wantCode: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+
+			resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantResp, resp)
+		})
+	}
+}
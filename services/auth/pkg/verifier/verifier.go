@@ -0,0 +1,275 @@
+// Package verifier verifies access tokens issued by the auth service against its JWKS.
+package verifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+)
+
+const (
+	// DefaultCacheTTL is how long a fetched JWKS is used before it is fetched again.
+	DefaultCacheTTL = 5 * time.Minute
+	// DefaultMinRefreshInterval is the minimum time between two fetches triggered by unknown key IDs.
+	DefaultMinRefreshInterval = 30 * time.Second
+	// DefaultClockSkew is the leeway applied to exp and nbf.
+	DefaultClockSkew = 30 * time.Second
+)
+
+var (
+	// ErrMissingToken is returned when a request carries no bearer token.
+	ErrMissingToken = errors.New("missing bearer token")
+	// ErrInvalidToken is returned when a token fails verification.
+	ErrInvalidToken = errors.New("invalid access token")
+	// ErrUnknownKeyID is returned when a token's kid is not in the JWKS, even after a refresh.
+	ErrUnknownKeyID = errors.New("unknown key ID")
+	// ErrRevokedToken is returned (wrapped in ErrInvalidToken) when a token's jti is on the denylist.
+	ErrRevokedToken = errors.New("access token revoked")
+	// ErrNotDPoPBound is returned (wrapped in ErrInvalidToken) when a bearer token is presented with the DPoP scheme.
+	ErrNotDPoPBound = errors.New("access token is not DPoP bound")
+)
+
+// Denylist reports whether an access token has been revoked before its expiry.
+type Denylist interface {
+	IsAccessTokenDenied(ctx context.Context, jti string) (bool, error)
+}
+
+// validMethods are the signing algorithms the auth service issues tokens with.
+var validMethods = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// Config is the configuration for a Verifier.
+type Config struct {
+	// JWKSURL is the URL of the auth service JWKS, e.g. http://auth:8080/.well-known/jwks.json.
+	JWKSURL string
+	// Issuer is the expected iss claim.
+	Issuer string
+	// Audience is the expected aud claim.
+	Audience string
+	// ClockSkew is the leeway applied to exp and nbf. Defaults to DefaultClockSkew.
+	ClockSkew time.Duration
+	// CacheTTL defaults to DefaultCacheTTL.
+	CacheTTL time.Duration
+	// MinRefreshInterval defaults to DefaultMinRefreshInterval.
+	MinRefreshInterval time.Duration
+	// HTTPClient defaults to a client with a 5 second timeout.
+	HTTPClient *http.Client
+	// Denylist is optional; when set, revoked tokens are rejected before they expire.
+	Denylist Denylist
+	// DPoPReplayCache records the DPoP proofs already presented. Defaults to an in-memory cache, which only
+	// detects proofs replayed to the same instance; use dpop.NewRedisReplayCache when running several.
+	DPoPReplayCache dpop.ReplayCache
+	// TrustForwardedHeaders makes Middleware check DPoP proofs against the scheme and host of the X-Forwarded-Proto
+	// and X-Forwarded-Host headers (see dpop.ForwardedRequestURL). Only set it behind a proxy that overwrites them.
+	TrustForwardedHeaders bool
+}
+
+// Claims are the verified claims of an access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	// Scope is the space separated list of scopes granted to the token.
+	Scope string `json:"scope,omitempty"`
+	// Roles are the roles of the member the token was issued to.
+	Roles []string `json:"roles,omitempty"`
+	// AMR are the methods the member authenticated with, e.g. "pwd" and "otp" (RFC 8176).
+	AMR []string `json:"amr,omitempty"`
+	// ClientID is the OAuth client the token was issued to (RFC 9068). When it equals the subject,
+	// the token was issued to the client itself with the client_credentials grant rather than to a member.
+	ClientID string `json:"client_id,omitempty"`
+	// EmailVerified is whether the member had verified their email when they logged in. It is nil for tokens of
+	// clients and for tokens issued before the claim was added.
+	EmailVerified *bool `json:"email_verified,omitempty"`
+	// Confirmation is set on tokens bound to the DPoP key of a client (RFC 9449).
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation is the cnf claim of a DPoP bound token.
+type Confirmation struct {
+	// JKT is the JWK SHA-256 thumbprint of the key the token is bound to.
+	JKT string `json:"jkt"`
+}
+
+// Scopes returns the scopes granted to the token.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// HasRole reports whether the member the token was issued to has role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// IsClient reports whether the token was issued to a client for itself rather than to a member.
+func (c *Claims) IsClient() bool {
+	return c.ClientID != "" && c.ClientID == c.Subject
+}
+
+// HasAuthMethod reports whether the member authenticated with method, e.g. "otp" for a second factor.
+func (c *Claims) HasAuthMethod(method string) bool {
+	return slices.Contains(c.AMR, method)
+}
+
+// HasVerifiedEmail reports whether the member the token was issued to had verified their email.
+func (c *Claims) HasVerifiedEmail() bool {
+	return c.EmailVerified != nil && *c.EmailVerified
+}
+
+// KeyThumbprint returns the thumbprint of the DPoP key the token is bound to, or "" for a bearer token.
+func (c *Claims) KeyThumbprint() string {
+	if c.Confirmation == nil {
+		return ""
+	}
+	return c.Confirmation.JKT
+}
+
+// Verifier verifies access tokens.
+type Verifier struct {
+	keys     *keySet
+	parser   *jwt.Parser
+	denylist Denylist
+	dpop     *dpop.Validator
+	// requestURL returns the URL the DPoP proofs of a request must be made for.
+	requestURL func(r *http.Request) string
+}
+
+// New creates a new Verifier.
+func New(cfg Config) (*Verifier, error) {
+	if cfg.JWKSURL == "" {
+		return nil, errors.New("JWKS URL is empty")
+	}
+	if cfg.Issuer == "" {
+		return nil, errors.New("issuer is empty")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("audience is empty")
+	}
+	if cfg.ClockSkew == 0 {
+		cfg.ClockSkew = DefaultClockSkew
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultCacheTTL
+	}
+	if cfg.MinRefreshInterval == 0 {
+		cfg.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if cfg.DPoPReplayCache == nil {
+		cfg.DPoPReplayCache = dpop.NewMemoryReplayCache()
+	}
+	requestURL := dpop.RequestURL
+	if cfg.TrustForwardedHeaders {
+		requestURL = dpop.ForwardedRequestURL
+	}
+
+	return &Verifier{
+		keys: newKeySet(cfg.JWKSURL, cfg.HTTPClient, cfg.CacheTTL, cfg.MinRefreshInterval),
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(validMethods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(cfg.ClockSkew),
+		),
+		denylist:   cfg.Denylist,
+		dpop:       dpop.NewValidator(cfg.DPoPReplayCache),
+		requestURL: requestURL,
+	}, nil
+}
+
+// Verify verifies the signature and claims of a bearer access token and, with a denylist, that it has not been
+// revoked. Tokens bound to a DPoP key are rejected, as they must come with a proof; see VerifyDPoP.
+// Errors other than ErrMissingToken and ErrInvalidToken mean the denylist could not be checked.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.KeyThumbprint() != "" {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, dpop.ErrMissingProof)
+	}
+	return claims, nil
+}
+
+// VerifyDPoP verifies a DPoP bound access token like Verify, and the DPoP proof presented with it in a request of
+// method to requestURL: it must be valid, made with the key the token is bound to and for that very token.
+// Errors other than ErrMissingToken and ErrInvalidToken mean the denylist or the DPoP replay cache could not be
+// checked.
+func (v *Verifier) VerifyDPoP(ctx context.Context, token, proof, method, requestURL string) (*Claims, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	jkt := claims.KeyThumbprint()
+	if jkt == "" {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, ErrNotDPoPBound)
+	}
+
+	p, err := v.dpop.Validate(ctx, proof, method, requestURL)
+	switch {
+	case errors.Is(err, dpop.ErrMissingProof), errors.Is(err, dpop.ErrInvalidProof):
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	case err != nil:
+		return nil, err
+	}
+	if err := p.CheckAccessToken(token, jkt); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+// verify verifies an access token, bound or not.
+func (v *Verifier) verify(ctx context.Context, token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("kid is empty")
+		}
+		key, err := v.keys.get(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("alg %s does not match kid %q", t.Method.Alg(), kid)
+		}
+		return key.publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: sub is empty", ErrInvalidToken)
+	}
+
+	if v.denylist != nil && claims.ID != "" {
+		denied, err := v.denylist.IsAccessTokenDenied(ctx, claims.ID)
+		if err != nil {
+			return nil, fmt.Errorf("check access token denylist: %w", err)
+		}
+		if denied {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidToken, ErrRevokedToken)
+		}
+	}
+	return claims, nil
+}
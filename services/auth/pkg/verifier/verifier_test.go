@@ -0,0 +1,375 @@
+package verifier_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/incheat/go-production-backend/services/auth/internal/token"
+	"github.com/incheat/go-production-backend/services/auth/pkg/dpop"
+	"github.com/incheat/go-production-backend/services/auth/pkg/model"
+	"github.com/incheat/go-production-backend/services/auth/pkg/verifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testIssuer   = "auth-service"
+	testAudience = "auth-api"
+)
+
+// jwksServer serves a mutable RSA JWKS and counts fetches.
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		s.fetches.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		keys := make([]map[string]string, 0, len(s.keys))
+		for kid, priv := range s.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	s.mu.Lock()
+	s.keys[kid] = priv
+	s.mu.Unlock()
+	return priv
+}
+
+func sign(t *testing.T, priv *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(priv)
+	require.NoError(t, err)
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": "member-1",
+		"iss": testIssuer,
+		"aud": testAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+}
+
+func newVerifier(t *testing.T, url string) *verifier.Verifier {
+	t.Helper()
+	v, err := verifier.New(verifier.Config{
+		JWKSURL:            url,
+		Issuer:             testIssuer,
+		Audience:           testAudience,
+		ClockSkew:          30 * time.Second,
+		MinRefreshInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	return v
+}
+
+func TestUnitVerifier_Claims(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		modify  func(c jwt.MapClaims)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(jwt.MapClaims) {}},
+		{name: "expired within skew", modify: func(c jwt.MapClaims) { c["exp"] = now.Add(-10 * time.Second).Unix() }},
+		{name: "expired beyond skew", modify: func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }, wantErr: true},
+		{name: "nbf within skew", modify: func(c jwt.MapClaims) { c["nbf"] = now.Add(10 * time.Second).Unix() }},
+		{name: "nbf beyond skew", modify: func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() }, wantErr: true},
+		{name: "missing exp", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "someone-else" }, wantErr: true},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "order-api" }, wantErr: true},
+		{name: "audience list", modify: func(c jwt.MapClaims) { c["aud"] = []string{"order-api", testAudience} }},
+		{name: "missing subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+	}
+
+	v := newVerifier(t, srv.URL)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+
+			got, err := v.Verify(context.Background(), sign(t, priv, "k1", claims))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "member-1", got.Subject)
+		})
+	}
+}
+
+func TestUnitVerifier_Signature(t *testing.T) {
+	srv := newJWKSServer(t)
+	srv.addKey(t, "k1")
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	v := newVerifier(t, srv.URL)
+
+	_, err = v.Verify(context.Background(), sign(t, other, "k1", validClaims()))
+	assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+
+	_, err = v.Verify(context.Background(), "")
+	assert.ErrorIs(t, err, verifier.ErrMissingToken)
+}
+
+func TestUnitVerifier_UnknownKeyIDRefresh(t *testing.T) {
+	srv := newJWKSServer(t)
+	k1 := srv.addKey(t, "k1")
+
+	v, err := verifier.New(verifier.Config{
+		JWKSURL:            srv.URL,
+		Issuer:             testIssuer,
+		Audience:           testAudience,
+		MinRefreshInterval: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = v.Verify(ctx, sign(t, k1, "k1", validClaims()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, srv.fetches.Load())
+
+	// Cached: no fetch for a known kid.
+	_, err = v.Verify(ctx, sign(t, k1, "k1", validClaims()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, srv.fetches.Load())
+
+	// Unknown kids within the refresh interval are rejected without fetching.
+	k2 := srv.addKey(t, "k2")
+	for range 5 {
+		_, err = v.Verify(ctx, sign(t, k2, "k2", validClaims()))
+		assert.ErrorIs(t, err, verifier.ErrUnknownKeyID)
+	}
+	assert.EqualValues(t, 1, srv.fetches.Load())
+
+	// After the interval, an unknown kid triggers a single refresh.
+	time.Sleep(250 * time.Millisecond)
+	_, err = v.Verify(ctx, sign(t, k2, "k2", validClaims()))
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, srv.fetches.Load())
+}
+
+func TestUnitVerifier_AuthServiceJWKS(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	keys, err := token.NewStaticKeyring(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), "ed-1")
+	require.NoError(t, err)
+	maker, err := token.New(keys, testIssuer, testAudience, time.Minute)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(maker.JWKSHandler))
+	t.Cleanup(srv.Close)
+
+	emailVerified := true
+	accessToken, _, err := maker.CreateToken("member-1", model.AccessTokenGrant{
+		Scopes:        []string{"auth:read", "order:read"},
+		Roles:         []string{"member"},
+		Audiences:     []string{"order-api"},
+		AuthMethods:   []string{model.AuthMethodPassword, model.AuthMethodOTP},
+		EmailVerified: &emailVerified,
+	})
+	require.NoError(t, err)
+
+	claims, err := newVerifier(t, srv.URL).Verify(context.Background(), string(accessToken))
+	require.NoError(t, err)
+	assert.Equal(t, "member-1", claims.Subject)
+	assert.Equal(t, []string{"auth:read", "order:read"}, claims.Scopes())
+	assert.True(t, claims.HasScope("order:read"))
+	assert.False(t, claims.HasScope("order:write"))
+	assert.True(t, claims.HasRole("member"))
+	assert.False(t, claims.HasRole("admin"))
+	assert.True(t, claims.HasAuthMethod("otp"))
+	assert.ElementsMatch(t, jwt.ClaimStrings{testAudience, "order-api"}, claims.Audience)
+	assert.False(t, claims.IsClient())
+	assert.True(t, claims.HasVerifiedEmail())
+
+	clientToken, _, err := maker.CreateToken("billing-job", model.AccessTokenGrant{ClientID: "billing-job"})
+	require.NoError(t, err)
+	claims, err = newVerifier(t, srv.URL).Verify(context.Background(), string(clientToken))
+	require.NoError(t, err)
+	assert.Equal(t, "billing-job", claims.ClientID)
+	assert.True(t, claims.IsClient())
+	assert.False(t, claims.HasVerifiedEmail())
+}
+
+type fakeDenylist struct {
+	denied map[string]bool
+	err    error
+}
+
+func (d fakeDenylist) IsAccessTokenDenied(_ context.Context, jti string) (bool, error) {
+	return d.denied[jti], d.err
+}
+
+func TestUnitVerifier_Denylist(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+
+	withJTI := func(jti string) string {
+		claims := validClaims()
+		claims["jti"] = jti
+		return sign(t, priv, "k1", claims)
+	}
+
+	tests := []struct {
+		name     string
+		denylist fakeDenylist
+		token    string
+		wantErr  error
+	}{
+		{name: "not denied", denylist: fakeDenylist{denied: map[string]bool{"other": true}}, token: withJTI("jti-1")},
+		{name: "denied", denylist: fakeDenylist{denied: map[string]bool{"jti-1": true}}, token: withJTI("jti-1"), wantErr: verifier.ErrRevokedToken},
+		{name: "token without jti", denylist: fakeDenylist{err: errors.New("must not be called")}, token: sign(t, priv, "k1", validClaims())},
+		{name: "denylist unavailable", denylist: fakeDenylist{err: errors.New("connection refused")}, token: withJTI("jti-1"), wantErr: errDenylistUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := verifier.New(verifier.Config{
+				JWKSURL:  srv.URL,
+				Issuer:   testIssuer,
+				Audience: testAudience,
+				Denylist: tt.denylist,
+			})
+			require.NoError(t, err)
+
+			_, err = v.Verify(context.Background(), tt.token)
+			switch {
+			case tt.wantErr == nil:
+				require.NoError(t, err)
+			case errors.Is(tt.wantErr, errDenylistUnavailable):
+				require.Error(t, err)
+				assert.NotErrorIs(t, err, verifier.ErrInvalidToken)
+			default:
+				assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// errDenylistUnavailable marks cases where the verifier must not treat the token as invalid.
+var errDenylistUnavailable = errors.New("denylist unavailable")
+
+func TestUnitVerifier_DPoP(t *testing.T) {
+	srv := newJWKSServer(t)
+	priv := srv.addKey(t, "k1")
+	v := newVerifier(t, srv.URL)
+	const url = "https://orders.example.com/orders"
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jkt, err := dpop.Thumbprint(clientKey.Public())
+	require.NoError(t, err)
+
+	claims := validClaims()
+	claims["cnf"] = map[string]any{"jkt": jkt}
+	boundToken := sign(t, priv, "k1", claims)
+	bearerToken := sign(t, priv, "k1", validClaims())
+	newProof := func(key *ecdsa.PrivateKey, method, accessToken string) string {
+		proof, err := dpop.NewProof(key, method, url, accessToken)
+		require.NoError(t, err)
+		return proof
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		proof   string
+		wantErr error
+	}{
+		{name: "valid proof", token: boundToken, proof: newProof(clientKey, "GET", boundToken)},
+		{name: "missing proof", token: boundToken, wantErr: dpop.ErrMissingProof},
+		{name: "proof of another key", token: boundToken, proof: newProof(otherKey, "GET", boundToken), wantErr: dpop.ErrInvalidProof},
+		{name: "proof for another token", token: boundToken, proof: newProof(clientKey, "GET", bearerToken), wantErr: dpop.ErrInvalidProof},
+		{name: "proof without token hash", token: boundToken, proof: newProof(clientKey, "GET", ""), wantErr: dpop.ErrInvalidProof},
+		{name: "proof for another method", token: boundToken, proof: newProof(clientKey, "POST", boundToken), wantErr: dpop.ErrInvalidProof},
+		{name: "bearer token", token: bearerToken, proof: newProof(clientKey, "GET", bearerToken), wantErr: verifier.ErrNotDPoPBound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.VerifyDPoP(context.Background(), tt.token, tt.proof, "GET", url)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, jkt, got.KeyThumbprint())
+		})
+	}
+
+	t.Run("replayed proof", func(t *testing.T) {
+		proof := newProof(clientKey, "GET", boundToken)
+		_, err := v.VerifyDPoP(context.Background(), boundToken, proof, "GET", url)
+		require.NoError(t, err)
+
+		_, err = v.VerifyDPoP(context.Background(), boundToken, proof, "GET", url)
+		assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+		assert.ErrorIs(t, err, dpop.ErrReplayedProof)
+	})
+
+	t.Run("bound token presented as bearer", func(t *testing.T) {
+		_, err := v.Verify(context.Background(), boundToken)
+		assert.ErrorIs(t, err, verifier.ErrInvalidToken)
+		assert.ErrorIs(t, err, dpop.ErrMissingProof)
+	})
+}
@@ -0,0 +1,4 @@
+ALTER TABLE users
+  DROP COLUMN audiences,
+  DROP COLUMN scopes,
+  DROP COLUMN roles;
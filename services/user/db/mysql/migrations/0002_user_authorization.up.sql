@@ -0,0 +1,5 @@
+-- Space separated lists, in the format of the OAuth2 scope parameter.
+ALTER TABLE users
+  ADD COLUMN roles VARCHAR(1024) NOT NULL DEFAULT '' AFTER password_hash,
+  ADD COLUMN scopes VARCHAR(1024) NOT NULL DEFAULT '' AFTER roles,
+  ADD COLUMN audiences VARCHAR(1024) NOT NULL DEFAULT '' AFTER scopes;
@@ -0,0 +1,3 @@
+-- Whether the user proved they own their email address, reported as the email_verified claim.
+ALTER TABLE users
+  ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE AFTER email;
@@ -0,0 +1,4 @@
+-- PENDING_VERIFICATION until the user proves they own their email, then ACTIVE; or DISABLED or LOCKED.
+-- Existing users stay active.
+ALTER TABLE users
+  ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'ACTIVE' AFTER password_hash;
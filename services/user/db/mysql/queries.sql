@@ -1,9 +1,20 @@
 -- name: CreateUser :execresult
-INSERT INTO users (email, password_hash)
-VALUES (?, ?);
+INSERT INTO users (email, password_hash, status)
+VALUES (?, ?, ?);
+
+-- name: UpdateUserPasswordHash :execrows
+UPDATE users
+SET password_hash = ?
+WHERE email = ?;
+
+-- name: VerifyUserEmail :exec
+UPDATE users
+SET email_verified = TRUE,
+    status = IF(status = 'PENDING_VERIFICATION', 'ACTIVE', status)
+WHERE email = ?;
 
 -- name: GetUserByEmail :one
-SELECT id, email, password_hash, created_at
+SELECT id, email, email_verified, password_hash, status, roles, scopes, audiences, created_at
 FROM users
 WHERE email = ?;
 
@@ -1,7 +1,12 @@
 CREATE TABLE users (
-    id            CHAR(36) NOT NULL PRIMARY KEY,
-    email         VARCHAR(255) NOT NULL UNIQUE,
-    password_hash VARCHAR(255) NOT NULL,
-    name          VARCHAR(255) NOT NULL,
-    created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    id             CHAR(36) NOT NULL PRIMARY KEY,
+    email          VARCHAR(255) NOT NULL UNIQUE,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    password_hash  VARCHAR(255) NOT NULL,
+    status         VARCHAR(32) NOT NULL DEFAULT 'ACTIVE',
+    roles          VARCHAR(1024) NOT NULL DEFAULT '',
+    scopes         VARCHAR(1024) NOT NULL DEFAULT '',
+    audiences      VARCHAR(1024) NOT NULL DEFAULT '',
+    name           VARCHAR(255) NOT NULL,
+    created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
\ No newline at end of file
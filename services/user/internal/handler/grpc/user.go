@@ -3,6 +3,7 @@ package userhandler
 
 import (
 	"context"
+	"errors"
 
 	userpb "github.com/incheat/go-production-backend/api/user/grpc/gen"
 	userservice "github.com/incheat/go-production-backend/services/user/internal/service/user"
@@ -31,13 +32,104 @@ func (s *Server) VerifyUserCredentials(
 	password := req.Password
 
 	user, err := s.service.VerifyUserCredentials(ctx, email, password)
-	if err != nil {
+	switch {
+	case errors.Is(err, userservice.ErrInvalidCredentials):
 		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, userservice.ErrUserDisabled):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, userservice.ErrUserLocked):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, "internal error")
 	}
 
 	return &userpb.VerifyUserCredentialsResponse{
-		Id:     user.ID,
-		Email:  user.Email,
-		Status: user.Status,
+		Id:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
+		Roles:         user.Roles,
+		Scopes:        user.Scopes,
+		Audiences:     user.Audiences,
+	}, nil
+}
+
+// GetUser is the server for the GetUser endpoint.
+func (s *Server) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	user, err := s.service.GetUser(ctx, req.Email)
+	switch {
+	case errors.Is(err, userservice.ErrUserNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &userpb.GetUserResponse{
+		Id:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
+		Roles:         user.Roles,
+		Scopes:        user.Scopes,
+		Audiences:     user.Audiences,
+	}, nil
+}
+
+// CreateUser is the server for the CreateUser endpoint.
+func (s *Server) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
+	user, err := s.service.CreateUser(ctx, req.Email, req.Password)
+	switch {
+	case errors.Is(err, userservice.ErrUserAlreadyExists):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, userservice.ErrInvalidEmail), errors.Is(err, userservice.ErrInvalidPassword):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &userpb.CreateUserResponse{
+		Id:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
+		Roles:         user.Roles,
+		Scopes:        user.Scopes,
+		Audiences:     user.Audiences,
+	}, nil
+}
+
+// SetPassword is the server for the SetPassword endpoint.
+func (s *Server) SetPassword(ctx context.Context, req *userpb.SetPasswordRequest) (*userpb.SetPasswordResponse, error) {
+	err := s.service.SetPassword(ctx, req.Email, req.Password)
+	switch {
+	case errors.Is(err, userservice.ErrUserNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, userservice.ErrInvalidPassword):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &userpb.SetPasswordResponse{}, nil
+}
+
+// VerifyEmail is the server for the VerifyEmail endpoint.
+func (s *Server) VerifyEmail(ctx context.Context, req *userpb.VerifyEmailRequest) (*userpb.VerifyEmailResponse, error) {
+	user, err := s.service.VerifyEmail(ctx, req.Email)
+	switch {
+	case errors.Is(err, userservice.ErrUserNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &userpb.VerifyEmailResponse{
+		Id:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
+		Roles:         user.Roles,
+		Scopes:        user.Scopes,
+		Audiences:     user.Audiences,
 	}, nil
 }
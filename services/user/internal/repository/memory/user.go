@@ -3,6 +3,7 @@ package userrepo
 
 import (
 	"context"
+	"strconv"
 	"sync"
 
 	"github.com/incheat/go-production-backend/services/user/internal/repository"
@@ -18,9 +19,13 @@ type UserRepository struct {
 // NewUserRepository creates a new memory user repository.
 func NewUserRepository() *UserRepository {
 	user := &model.User{
-		ID:           "1",
-		Email:        "test@example.com",
-		PasswordHash: "password",
+		ID:            "1",
+		Email:         "test@example.com",
+		EmailVerified: true,
+		PasswordHash:  "password",
+		Roles:         []string{"member"},
+		Scopes:        []string{"auth:read", "user:read", "order:read"},
+		Audiences:     []string{"auth-api", "user-api", "order-api"},
 	}
 	return &UserRepository{
 		data: map[string]*model.User{
@@ -40,7 +45,7 @@ func (r *UserRepository) GetUserByEmail(_ context.Context, email string) (*model
 	return user, nil
 }
 
-// CreateUser creates a new user.
+// CreateUser creates a new user, numbering it after the existing ones.
 func (r *UserRepository) CreateUser(_ context.Context, email string, user *model.User) error {
 	r.Lock()
 	defer r.Unlock()
@@ -49,6 +54,40 @@ func (r *UserRepository) CreateUser(_ context.Context, email string, user *model
 		return repository.ErrUserAlreadyExists
 	}
 
+	user.ID = strconv.Itoa(len(r.data) + 1)
 	r.data[email] = user
 	return nil
 }
+
+// UpdatePasswordHash replaces the password hash of a user.
+func (r *UserRepository) UpdatePasswordHash(_ context.Context, email string, passwordHash string) error {
+	r.Lock()
+	defer r.Unlock()
+	user, ok := r.data[email]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	updated := *user
+	updated.PasswordHash = passwordHash
+	r.data[email] = &updated
+	return nil
+}
+
+// VerifyEmail marks the email of a user as verified, activating the user if they were pending verification.
+func (r *UserRepository) VerifyEmail(_ context.Context, email string) error {
+	r.Lock()
+	defer r.Unlock()
+	user, ok := r.data[email]
+	if !ok {
+		return repository.ErrUserNotFound
+	}
+
+	updated := *user
+	updated.EmailVerified = true
+	if updated.Status == model.StatusPendingVerification {
+		updated.Status = model.StatusActive
+	}
+	r.data[email] = &updated
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
 	db "github.com/incheat/go-production-backend/services/user/internal/db/mysql/gen"
@@ -40,9 +41,14 @@ func (r *UserRepository) GetUserByEmail(
 	}
 
 	return &model.User{
-		ID:           strconv.FormatInt(u.ID, 10),
-		Email:        u.Email,
-		PasswordHash: u.PasswordHash,
+		ID:            strconv.FormatInt(u.ID, 10),
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		PasswordHash:  u.PasswordHash,
+		Status:        u.Status,
+		Roles:         strings.Fields(u.Roles),
+		Scopes:        strings.Fields(u.Scopes),
+		Audiences:     strings.Fields(u.Audiences),
 	}, nil
 }
 
@@ -53,9 +59,10 @@ func (r *UserRepository) CreateUser(
 	user *model.User,
 ) error {
 
-	_, err := r.queries.CreateUser(ctx, db.CreateUserParams{
+	res, err := r.queries.CreateUser(ctx, db.CreateUserParams{
 		Email:        email,
 		PasswordHash: user.PasswordHash,
+		Status:       user.Status,
 	})
 	if err != nil {
 		if isDuplicateKeyError(err) {
@@ -64,9 +71,45 @@ func (r *UserRepository) CreateUser(
 		return err
 	}
 
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = strconv.FormatInt(id, 10)
+	return nil
+}
+
+// UpdatePasswordHash replaces the password hash of a user. Password hashes are salted, so a new hash always
+// changes the row and no affected row means that the user does not exist.
+func (r *UserRepository) UpdatePasswordHash(
+	ctx context.Context,
+	email string,
+	passwordHash string,
+) error {
+
+	rows, err := r.queries.UpdateUserPasswordHash(ctx, db.UpdateUserPasswordHashParams{
+		PasswordHash: passwordHash,
+		Email:        email,
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return repository.ErrUserNotFound
+	}
 	return nil
 }
 
+// VerifyEmail marks the email of a user as verified, activating the user if they were pending verification.
+// Verifying an email twice leaves the row unchanged, so the existence of the user is not checked.
+func (r *UserRepository) VerifyEmail(
+	ctx context.Context,
+	email string,
+) error {
+
+	return r.queries.VerifyUserEmail(ctx, email)
+}
+
 func isDuplicateKeyError(err error) bool {
 	var mysqlErr *mysql.MySQLError
 	if errors.As(err, &mysqlErr) {
@@ -3,17 +3,44 @@ package userservice
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/incheat/go-production-backend/services/user/internal/repository"
 	"github.com/incheat/go-production-backend/services/user/pkg/model"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// passwordHashCost is the bcrypt cost of password hashes.
+const passwordHashCost = bcrypt.DefaultCost
+
+// dummyPasswordHash is a bcrypt hash of passwordHashCost that passwords are compared against when the email is
+// unknown, so that verifying credentials takes as long whether the email belongs to a user or not.
+const dummyPasswordHash = "$2a$10$Rf/L0JxmE43G0JuSbJ58kuDLLr9vRkUFFRgAC49yOx6ypSea86N0y"
+
 // ErrUserNotFound is returned when a user is not found.
 var ErrUserNotFound = errors.New("user not found")
 
 // ErrUserAlreadyExists is returned when a user already exists.
 var ErrUserAlreadyExists = errors.New("user already exists")
 
+// ErrInvalidCredentials is returned when the email is unknown or the password does not match.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrUserDisabled is returned when the credentials are valid but the user is disabled.
+var ErrUserDisabled = errors.New("user disabled")
+
+// ErrUserLocked is returned when the credentials are valid but the user is locked.
+var ErrUserLocked = errors.New("user locked")
+
+// ErrInvalidEmail is returned when creating a user without an email.
+var ErrInvalidEmail = errors.New("invalid email")
+
+// ErrInvalidPassword is returned when a password is empty or too long to be hashed.
+var ErrInvalidPassword = errors.New("invalid password")
+
 // Service is the controller for the auth API.
 type Service struct {
 	userRepo Repository
@@ -23,6 +50,8 @@ type Service struct {
 type Repository interface {
 	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
 	CreateUser(ctx context.Context, email string, user *model.User) error
+	UpdatePasswordHash(ctx context.Context, email string, passwordHash string) error
+	VerifyEmail(ctx context.Context, email string) error
 }
 
 // New creates a new Service.
@@ -34,10 +63,122 @@ func New(userRepo Repository) *Service {
 func (s *Service) VerifyUserCredentials(ctx context.Context, email string, password string) (*model.User, error) {
 	user, err := s.userRepo.GetUserByEmail(ctx, email)
 	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			passwordMatches(dummyPasswordHash, password)
+			return nil, ErrInvalidCredentials
+		}
 		return nil, err
 	}
-	if user.PasswordHash != password {
-		return nil, errors.New("invalid credentials")
+	if !passwordMatches(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	// The status is only revealed to callers that know the password.
+	switch user.Status {
+	case model.StatusDisabled:
+		return nil, ErrUserDisabled
+	case model.StatusLocked:
+		return nil, ErrUserLocked
+	}
+	return user, nil
+}
+
+// GetUser gets a user by email, whatever its status.
+func (s *Service) GetUser(ctx context.Context, email string) (*model.User, error) {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// CreateUser creates a user with a password, pending verification of their email. The password is hashed before the
+// email is checked for an existing user, so that creating a user takes as long whether the email is taken or not.
+func (s *Service) CreateUser(ctx context.Context, email string, password string) (*model.User, error) {
+	if email == "" {
+		return nil, ErrInvalidEmail
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		Email:        email,
+		PasswordHash: passwordHash,
+		Status:       model.StatusPendingVerification,
+	}
+	err = s.userRepo.CreateUser(ctx, email, user)
+	if errors.Is(err, repository.ErrUserAlreadyExists) {
+		return nil, ErrUserAlreadyExists
+	}
+	if err != nil {
+		return nil, err
 	}
 	return user, nil
 }
+
+// SetPassword replaces the password of a user, e.g. once they proved they own their email to recover their
+// account. Users seeded with a plaintext password get it hashed from then on.
+func (s *Service) SetPassword(ctx context.Context, email string, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = s.userRepo.UpdatePasswordHash(ctx, email, passwordHash)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// VerifyEmail records that a user proved they own their email, e.g. by following a link sent to it, and
+// activates the user if they were pending verification. Disabled and locked users stay so.
+func (s *Service) VerifyEmail(ctx context.Context, email string) (*model.User, error) {
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userRepo.VerifyEmail(ctx, email); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	verified := *user
+	verified.EmailVerified = true
+	if verified.Status == model.StatusPendingVerification {
+		verified.Status = model.StatusActive
+	}
+	return &verified, nil
+}
+
+// hashPassword hashes a password for storage.
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", ErrInvalidPassword
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", ErrInvalidPassword
+	}
+	if err != nil {
+		return "", fmt.Errorf("hash password: %w", err)
+	}
+	return string(passwordHash), nil
+}
+
+// passwordMatches reports whether password matches the stored hash of a user. Users seeded before passwords were
+// hashed have them stored as is; they are compared in constant time until they set a new password.
+func passwordMatches(passwordHash, password string) bool {
+	if !strings.HasPrefix(passwordHash, "$2") {
+		return subtle.ConstantTimeCompare([]byte(passwordHash), []byte(password)) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+}
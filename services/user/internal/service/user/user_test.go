@@ -3,13 +3,17 @@ package userservice_test
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/incheat/go-production-backend/services/user/internal/repository"
 	userservice "github.com/incheat/go-production-backend/services/user/internal/service/user"
 	"github.com/incheat/go-production-backend/services/user/pkg/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // --- Testify mocks ---
@@ -29,6 +33,16 @@ func (m *MockUserRepository) CreateUser(ctx context.Context, email string, user
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) UpdatePasswordHash(ctx context.Context, email string, passwordHash string) error {
+	args := m.Called(ctx, email, passwordHash)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) VerifyEmail(ctx context.Context, email string) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
 // TestUnitVerifyUserCredentials_Success tests the happy path for VerifyUserCredentials.
 func TestUnitVerifyUserCredentials_Success(t *testing.T) {
 	ctx := context.Background()
@@ -91,6 +105,46 @@ func TestUnitVerifyUserCredentials_Errors(t *testing.T) {
 			},
 			wantErr: "invalid credentials",
 		},
+		{
+			name: "unknown email",
+			setupMocks: func(repo *MockUserRepository) {
+				repo.
+					On("GetUserByEmail", mock.Anything, email).
+					Return((*model.User)(nil), repository.ErrUserNotFound).
+					Once()
+			},
+			wantErr: "invalid credentials",
+		},
+		{
+			name: "disabled user",
+			setupMocks: func(repo *MockUserRepository) {
+				repo.
+					On("GetUserByEmail", mock.Anything, email).
+					Return(&model.User{Email: email, PasswordHash: password, Status: model.StatusDisabled}, nil).
+					Once()
+			},
+			wantErr: "user disabled",
+		},
+		{
+			name: "locked user",
+			setupMocks: func(repo *MockUserRepository) {
+				repo.
+					On("GetUserByEmail", mock.Anything, email).
+					Return(&model.User{Email: email, PasswordHash: password, Status: model.StatusLocked}, nil).
+					Once()
+			},
+			wantErr: "user locked",
+		},
+		{
+			name: "disabled user with wrong password",
+			setupMocks: func(repo *MockUserRepository) {
+				repo.
+					On("GetUserByEmail", mock.Anything, email).
+					Return(&model.User{Email: email, PasswordHash: "different-password", Status: model.StatusDisabled}, nil).
+					Once()
+			},
+			wantErr: "invalid credentials",
+		},
 	}
 
 	for _, tt := range tests {
@@ -109,3 +163,288 @@ func TestUnitVerifyUserCredentials_Errors(t *testing.T) {
 		})
 	}
 }
+
+// TestUnitVerifyUserCredentials_UnknownEmailTiming tests that an unknown email takes about as long to reject as a
+// wrong password, so that the response time does not tell whether the email belongs to a user.
+func TestUnitVerifyUserCredentials_UnknownEmailTiming(t *testing.T) {
+	ctx := context.Background()
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+
+	repoMock := new(MockUserRepository)
+	repoMock.
+		On("GetUserByEmail", mock.Anything, "user@example.com").
+		Return(&model.User{Email: "user@example.com", PasswordHash: string(passwordHash)}, nil).
+		Once()
+	repoMock.
+		On("GetUserByEmail", mock.Anything, "unknown@example.com").
+		Return((*model.User)(nil), repository.ErrUserNotFound).
+		Once()
+	svc := userservice.New(repoMock)
+
+	start := time.Now()
+	_, err = svc.VerifyUserCredentials(ctx, "user@example.com", "wrong")
+	require.ErrorIs(t, err, userservice.ErrInvalidCredentials)
+	wrongPassword := time.Since(start)
+
+	start = time.Now()
+	_, err = svc.VerifyUserCredentials(ctx, "unknown@example.com", "wrong")
+	require.ErrorIs(t, err, userservice.ErrInvalidCredentials)
+	assert.Greater(t, time.Since(start), wrongPassword/2, "an unknown email must be compared against a password hash")
+}
+
+// TestUnitGetUser tests GetUser.
+func TestUnitGetUser(t *testing.T) {
+	ctx := context.Background()
+	email := "user@example.com"
+
+	tests := []struct {
+		name     string
+		repoUser *model.User
+		repoErr  error
+		wantErr  error
+	}{
+		{
+			name:     "active user",
+			repoUser: &model.User{ID: "u-1", Email: email, Status: model.StatusActive},
+		},
+		{
+			name:     "disabled user is returned as is",
+			repoUser: &model.User{ID: "u-1", Email: email, Status: model.StatusDisabled},
+		},
+		{
+			name:    "unknown email",
+			repoErr: repository.ErrUserNotFound,
+			wantErr: userservice.ErrUserNotFound,
+		},
+		{
+			name:    "repo error",
+			repoErr: errors.New("db error"),
+			wantErr: errors.New("db error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoMock := new(MockUserRepository)
+			repoMock.
+				On("GetUserByEmail", mock.Anything, email).
+				Return(tt.repoUser, tt.repoErr).
+				Once()
+
+			svc := userservice.New(repoMock)
+
+			got, err := svc.GetUser(ctx, email)
+			if tt.wantErr != nil {
+				assert.EqualError(t, err, tt.wantErr.Error())
+				assert.Nil(t, got)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.repoUser, got)
+			}
+
+			repoMock.AssertExpectations(t)
+		})
+	}
+}
+
+// TestUnitCreateUser tests that users are created pending verification with a bcrypt hash of their password, which
+// they can then log in with.
+func TestUnitCreateUser(t *testing.T) {
+	ctx := context.Background()
+	email := "new@example.com"
+	password := "correct horse battery staple"
+
+	t.Run("success", func(t *testing.T) {
+		repoMock := new(MockUserRepository)
+		var created *model.User
+		repoMock.
+			On("CreateUser", mock.Anything, email, mock.AnythingOfType("*model.User")).
+			Run(func(args mock.Arguments) { created = args.Get(2).(*model.User) }).
+			Return(nil).
+			Once()
+
+		svc := userservice.New(repoMock)
+
+		got, err := svc.CreateUser(ctx, email, password)
+		require.NoError(t, err)
+		assert.Same(t, created, got)
+		assert.Equal(t, email, got.Email)
+		assert.Equal(t, model.StatusPendingVerification, got.Status)
+		assert.False(t, got.EmailVerified)
+		assert.NotEqual(t, password, got.PasswordHash, "the password must not be stored as is")
+
+		repoMock.On("GetUserByEmail", mock.Anything, email).Return(got, nil)
+		_, err = svc.VerifyUserCredentials(ctx, email, password)
+		require.NoError(t, err)
+		_, err = svc.VerifyUserCredentials(ctx, email, "wrong password")
+		assert.ErrorIs(t, err, userservice.ErrInvalidCredentials)
+	})
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		repoErr  error
+		wantErr  error
+	}{
+		{
+			name:     "email taken",
+			email:    email,
+			password: password,
+			repoErr:  repository.ErrUserAlreadyExists,
+			wantErr:  userservice.ErrUserAlreadyExists,
+		},
+		{
+			name:     "repo error",
+			email:    email,
+			password: password,
+			repoErr:  errors.New("db error"),
+			wantErr:  errors.New("db error"),
+		},
+		{
+			name:     "empty email",
+			password: password,
+			wantErr:  userservice.ErrInvalidEmail,
+		},
+		{
+			name:    "empty password",
+			email:   email,
+			wantErr: userservice.ErrInvalidPassword,
+		},
+		{
+			name:     "password too long to hash",
+			email:    email,
+			password: strings.Repeat("a", 73),
+			wantErr:  userservice.ErrInvalidPassword,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoMock := new(MockUserRepository)
+			repoMock.
+				On("CreateUser", mock.Anything, tt.email, mock.AnythingOfType("*model.User")).
+				Return(tt.repoErr).
+				Maybe()
+
+			svc := userservice.New(repoMock)
+
+			got, err := svc.CreateUser(ctx, tt.email, tt.password)
+			assert.EqualError(t, err, tt.wantErr.Error())
+			assert.Nil(t, got)
+		})
+	}
+}
+
+// TestUnitSetPassword tests that a new password is hashed before it replaces the stored one.
+func TestUnitSetPassword(t *testing.T) {
+	ctx := context.Background()
+	email := "user@example.com"
+	password := "correct horse battery staple"
+
+	t.Run("success", func(t *testing.T) {
+		repoMock := new(MockUserRepository)
+		var passwordHash string
+		repoMock.
+			On("UpdatePasswordHash", mock.Anything, email, mock.AnythingOfType("string")).
+			Run(func(args mock.Arguments) { passwordHash = args.String(2) }).
+			Return(nil).
+			Once()
+
+		svc := userservice.New(repoMock)
+
+		require.NoError(t, svc.SetPassword(ctx, email, password))
+		assert.NotEqual(t, password, passwordHash, "the password must not be stored as is")
+
+		repoMock.On("GetUserByEmail", mock.Anything, email).Return(&model.User{Email: email, PasswordHash: passwordHash}, nil)
+		_, err := svc.VerifyUserCredentials(ctx, email, password)
+		require.NoError(t, err)
+	})
+
+	tests := []struct {
+		name     string
+		password string
+		repoErr  error
+		wantErr  error
+	}{
+		{
+			name:     "unknown email",
+			password: password,
+			repoErr:  repository.ErrUserNotFound,
+			wantErr:  userservice.ErrUserNotFound,
+		},
+		{
+			name:     "repo error",
+			password: password,
+			repoErr:  errors.New("db error"),
+			wantErr:  errors.New("db error"),
+		},
+		{
+			name:    "empty password",
+			wantErr: userservice.ErrInvalidPassword,
+		},
+		{
+			name:     "password too long to hash",
+			password: strings.Repeat("a", 73),
+			wantErr:  userservice.ErrInvalidPassword,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoMock := new(MockUserRepository)
+			repoMock.
+				On("UpdatePasswordHash", mock.Anything, email, mock.AnythingOfType("string")).
+				Return(tt.repoErr).
+				Maybe()
+
+			svc := userservice.New(repoMock)
+
+			err := svc.SetPassword(ctx, email, tt.password)
+			assert.EqualError(t, err, tt.wantErr.Error())
+		})
+	}
+}
+
+// TestUnitVerifyEmail tests that verifying an email activates users pending verification, and only them.
+func TestUnitVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	email := "user@example.com"
+
+	tests := []struct {
+		name       string
+		status     string
+		wantStatus string
+	}{
+		{name: "pending verification", status: model.StatusPendingVerification, wantStatus: model.StatusActive},
+		{name: "already active", status: model.StatusActive, wantStatus: model.StatusActive},
+		{name: "disabled", status: model.StatusDisabled, wantStatus: model.StatusDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoMock := new(MockUserRepository)
+			repoMock.On("GetUserByEmail", mock.Anything, email).Return(&model.User{Email: email, Status: tt.status}, nil).Once()
+			repoMock.On("VerifyEmail", mock.Anything, email).Return(nil).Once()
+
+			svc := userservice.New(repoMock)
+
+			got, err := svc.VerifyEmail(ctx, email)
+			require.NoError(t, err)
+			assert.True(t, got.EmailVerified)
+			assert.Equal(t, tt.wantStatus, got.Status)
+			repoMock.AssertExpectations(t)
+		})
+	}
+
+	t.Run("unknown email", func(t *testing.T) {
+		repoMock := new(MockUserRepository)
+		repoMock.On("GetUserByEmail", mock.Anything, email).Return(nil, repository.ErrUserNotFound).Once()
+
+		svc := userservice.New(repoMock)
+
+		_, err := svc.VerifyEmail(ctx, email)
+		assert.ErrorIs(t, err, userservice.ErrUserNotFound)
+		repoMock.AssertNotCalled(t, "VerifyEmail", mock.Anything, mock.Anything)
+	})
+}
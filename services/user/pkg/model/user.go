@@ -3,12 +3,25 @@ package model
 
 import "time"
 
+// User statuses; an empty status is treated as active.
+const (
+	// StatusPendingVerification is the status of a new user until they prove they own their email.
+	StatusPendingVerification = "PENDING_VERIFICATION"
+	StatusActive              = "ACTIVE"
+	StatusDisabled            = "DISABLED"
+	StatusLocked              = "LOCKED"
+)
+
 // User is a model for a user.
 type User struct {
-	ID           string
-	Email        string
-	PasswordHash string
-	Status       string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID            string
+	Email         string
+	EmailVerified bool // the user proved they own Email
+	PasswordHash  string
+	Status        string
+	Roles         []string
+	Scopes        []string // scopes the user may be granted in access tokens
+	Audiences     []string // APIs the user's access tokens are issued for
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
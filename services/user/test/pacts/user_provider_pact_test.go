@@ -47,6 +47,14 @@ func (f *fakeUserRepo) CreateUser(_ context.Context, _ string, _ *model.User) er
 	return nil
 }
 
+func (f *fakeUserRepo) UpdatePasswordHash(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func (f *fakeUserRepo) VerifyEmail(_ context.Context, _ string) error {
+	return nil
+}
+
 // -------------------------------------------------------------------
 // Provider Pact Test (matches consumer pact with 200 + 401 interactions)
 // -------------------------------------------------------------------